@@ -0,0 +1,150 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connection
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/things-go/meter"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/thinkgos/jocasta/connection/ccodec"
+	"github.com/thinkgos/jocasta/connection/ciol"
+	"github.com/thinkgos/jocasta/connection/cobfs"
+	"github.com/thinkgos/jocasta/connection/czstd"
+)
+
+func init() {
+	RegisterAdorn("snappy", func(*AdornArgs, bool) (AdornConn, error) {
+		return AdornSnappy(true), nil
+	})
+	RegisterAdorn("gzip", func(args *AdornArgs, _ bool) (AdornConn, error) {
+		level, err := args.Int("level", gzip.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		return AdornGzipLevel(true, level), nil
+	})
+	RegisterAdorn("zlib", func(args *AdornArgs, _ bool) (AdornConn, error) {
+		level, err := args.Int("level", zlib.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		dict, err := loadDict(args)
+		if err != nil {
+			return nil, err
+		}
+		return AdornZlibLevelDict(true, level, dict), nil
+	})
+	RegisterAdorn("zstd", func(args *AdornArgs, _ bool) (AdornConn, error) {
+		level, err := args.Int("level", czstd.DefaultLevel)
+		if err != nil {
+			return nil, err
+		}
+		dict, err := loadDict(args)
+		if err != nil {
+			return nil, err
+		}
+		return AdornZstdLevelDict(true, level, dict), nil
+	})
+	RegisterAdorn("lz4", func(*AdornArgs, bool) (AdornConn, error) {
+		return AdornLz4(true), nil
+	})
+	RegisterAdorn("codec", func(args *AdornArgs, _ bool) (AdornConn, error) {
+		var codecs []string
+		if v := args.String("codecs", ""); v != "" {
+			codecs = strings.Split(v, "|")
+		}
+		for _, c := range codecs {
+			if !ccodec.HasCodec(c) {
+				return nil, fmt.Errorf("codec %s not support, should be one of <%s>", c, strings.Join(ccodec.Codecs(), "|"))
+			}
+		}
+		return AdornCodec(codecs...), nil
+	})
+	RegisterBaseAdorn("obfs", func(args *AdornArgs, server bool) (AdornConn, error) {
+		mode := args.String("mode", cobfs.ModeHTTP)
+		if !cobfs.HasMode(mode) {
+			return nil, fmt.Errorf("obfs mode should be one of <%s>", strings.Join(cobfs.Modes(), "|"))
+		}
+		host := args.String("host", "")
+		if server {
+			return AdornObfsServer(mode), nil
+		}
+		return AdornObfsClient(mode, host), nil
+	})
+	RegisterBaseAdorn("proxyproto", func(_ *AdornArgs, server bool) (AdornConn, error) {
+		if !server {
+			return nil, errors.New("only for server")
+		}
+		return AdornProxyProtocol(), nil
+	})
+	RegisterAdorn("flow", func(args *AdornArgs, _ bool) (AdornConn, error) {
+		st := FlowStatsOf(args.String("name", "default"))
+		return AdornFlow(&st.Wc, &st.Rc, &st.Tc), nil
+	})
+	RegisterAdorn("iol", func(args *AdornArgs, _ bool) (AdornConn, error) {
+		var opts []ciol.Options
+		for _, v := range []struct {
+			key string
+			opt func(rate.Limit, ...int) ciol.Options
+		}{
+			{"read", ciol.WithReadLimiter},
+			{"write", ciol.WithWriteLimiter},
+		} {
+			s := args.String(v.key, "")
+			if s == "" || s == "0" {
+				continue
+			}
+			size, err := meter.ParseBytes(s)
+			if err != nil {
+				return nil, fmt.Errorf("argument %s: %v", v.key, err)
+			}
+			opts = append(opts, v.opt(rate.Limit(size)))
+		}
+		return AdornIol(opts...), nil
+	})
+}
+
+// loadDict 从参数dict指定的文件加载字典
+func loadDict(args *AdornArgs) ([]byte, error) {
+	filename := args.String("dict", "")
+	if filename == "" {
+		return nil, nil
+	}
+	return os.ReadFile(filename)
+}
+
+// FlowStats flow装饰的统计计数
+type FlowStats struct {
+	Wc atomic.Uint64 // 写统计
+	Rc atomic.Uint64 // 读统计
+	Tc atomic.Uint64 // 读写统计
+}
+
+var flowStats sync.Map // name -> *FlowStats
+
+// FlowStatsOf 获取flow(name=xxx)装饰的统计计数, 同名装饰共享
+func FlowStatsOf(name string) *FlowStats {
+	v, _ := flowStats.LoadOrStore(name, new(FlowStats))
+	return v.(*FlowStats)
+}
@@ -0,0 +1,305 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package connection
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AdornFactory 根据参数创建装饰, server为true时用于服务端(监听), 否则用于客户端(连接)
+type AdornFactory func(args *AdornArgs, server bool) (AdornConn, error)
+
+var adornRegistry = struct {
+	sync.RWMutex
+	factories map[string]AdornFactory
+	bases     map[string]bool
+}{
+	factories: make(map[string]AdornFactory),
+	bases:     make(map[string]bool),
+}
+
+// RegisterAdorn 注册装饰, 名称重复或factory为nil时panic
+func RegisterAdorn(name string, factory AdornFactory) {
+	registerAdorn(name, factory, false)
+}
+
+// RegisterBaseAdorn 注册需紧贴原始连接(位于装饰链底层)的装饰, 如 obfs, proxyproto,
+// 见 ParseUpperAdornChain
+func RegisterBaseAdorn(name string, factory AdornFactory) {
+	registerAdorn(name, factory, true)
+}
+
+func registerAdorn(name string, factory AdornFactory, base bool) {
+	adornRegistry.Lock()
+	defer adornRegistry.Unlock()
+	if factory == nil {
+		panic("connection: register adorn factory is nil")
+	}
+	if _, dup := adornRegistry.factories[name]; dup {
+		panic("connection: register adorn called twice for " + name)
+	}
+	adornRegistry.factories[name] = factory
+	if base {
+		adornRegistry.bases[name] = true
+	}
+}
+
+// Adorns 已注册的装饰名称
+func Adorns() []string {
+	adornRegistry.RLock()
+	defer adornRegistry.RUnlock()
+	names := make([]string, 0, len(adornRegistry.factories))
+	for name := range adornRegistry.factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasAdorn 是否已注册
+func HasAdorn(name string) bool {
+	adornRegistry.RLock()
+	defer adornRegistry.RUnlock()
+	_, ok := adornRegistry.factories[name]
+	return ok
+}
+
+// AdornSpec 装饰描述, 如 zstd(level=3) 对应 {Name: "zstd", Args: {"level": "3"}}
+type AdornSpec struct {
+	Name string            `json:"name" yaml:"name"`
+	Args map[string]string `json:"args,omitempty" yaml:"args,omitempty"`
+}
+
+// String 返回描述字符串, 参数按名称排序
+func (sf AdornSpec) String() string {
+	if len(sf.Args) == 0 {
+		return sf.Name
+	}
+	keys := make([]string, 0, len(sf.Args))
+	for k := range sf.Args {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		keys[i] = k + "=" + sf.Args[k]
+	}
+	return sf.Name + "(" + strings.Join(keys, ",") + ")"
+}
+
+// ParseAdornSpecs 解析装饰链描述, 如 tls(ca=ca.crt,cert=a.crt,key=a.key),zstd,flow
+// 装饰之间以逗号分隔, 参数为括号内逗号分隔的 key=value, 只有key时值为 true
+func ParseAdornSpecs(s string) ([]AdornSpec, error) {
+	var specs []AdornSpec
+	var items []string
+
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			if depth++; depth > 1 {
+				return nil, fmt.Errorf("adorn spec %q: nested parentheses at %d", s, i)
+			}
+		case ')':
+			if depth--; depth < 0 {
+				return nil, fmt.Errorf("adorn spec %q: unexpected ')' at %d", s, i)
+			}
+		case ',':
+			if depth == 0 {
+				items = append(items, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("adorn spec %q: missing ')'", s)
+	}
+	items = append(items, s[start:])
+
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			if len(items) == 1 {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("adorn spec %q: empty adorn", s)
+		}
+		spec, err := parseAdornSpec(item)
+		if err != nil {
+			return nil, fmt.Errorf("adorn spec %q: %v", s, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func parseAdornSpec(item string) (AdornSpec, error) {
+	idx := strings.IndexByte(item, '(')
+	if idx < 0 {
+		return AdornSpec{Name: item}, nil
+	}
+	if !strings.HasSuffix(item, ")") {
+		return AdornSpec{}, fmt.Errorf("unexpected characters after ')' in %q", item)
+	}
+	spec := AdornSpec{
+		Name: strings.TrimSpace(item[:idx]),
+		Args: make(map[string]string),
+	}
+	if spec.Name == "" {
+		return AdornSpec{}, fmt.Errorf("empty adorn name in %q", item)
+	}
+	body := strings.TrimSpace(item[idx+1 : len(item)-1])
+	if body == "" {
+		return spec, nil
+	}
+	for _, arg := range strings.Split(body, ",") {
+		kv := strings.SplitN(arg, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if key == "" {
+			return AdornSpec{}, fmt.Errorf("empty argument name in %q", item)
+		}
+		if _, dup := spec.Args[key]; dup {
+			return AdornSpec{}, fmt.Errorf("duplicate argument %s in %q", key, item)
+		}
+		value := "true"
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		spec.Args[key] = value
+	}
+	return spec, nil
+}
+
+// BuildAdornChain 根据装饰描述创建装饰链, server为true时用于服务端(监听), 否则用于客户端(连接)
+func BuildAdornChain(specs []AdornSpec, server bool) (AdornConnsChain, error) {
+	chain := make(AdornConnsChain, 0, len(specs))
+	for _, spec := range specs {
+		adornRegistry.RLock()
+		factory, ok := adornRegistry.factories[spec.Name]
+		adornRegistry.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("adorn %s not registered, should be one of <%s>", spec.Name, strings.Join(Adorns(), "|"))
+		}
+		args := &AdornArgs{values: spec.Args, used: make(map[string]bool)}
+		adorn, err := factory(args, server)
+		if err != nil {
+			return nil, fmt.Errorf("adorn %s, %v", spec, err)
+		}
+		if unused := args.unused(); len(unused) > 0 {
+			return nil, fmt.Errorf("adorn %s, unknown arguments <%s>", spec, strings.Join(unused, "|"))
+		}
+		chain = append(chain, adorn)
+	}
+	return chain, nil
+}
+
+// ParseAdornChain 解析装饰链描述并创建装饰链, 见 ParseAdornSpecs, BuildAdornChain
+func ParseAdornChain(s string, server bool) (AdornConnsChain, error) {
+	specs, err := ParseAdornSpecs(s)
+	if err != nil {
+		return nil, err
+	}
+	return BuildAdornChain(specs, server)
+}
+
+// ParseUpperAdornChain 同 ParseAdornChain, 但链用于压缩,加密等装饰之上, 不允许需紧贴原始连接的装饰,
+// 见 RegisterBaseAdorn
+func ParseUpperAdornChain(s string, server bool) (AdornConnsChain, error) {
+	specs, err := ParseAdornSpecs(s)
+	if err != nil {
+		return nil, err
+	}
+	adornRegistry.RLock()
+	for _, spec := range specs {
+		if adornRegistry.bases[spec.Name] {
+			adornRegistry.RUnlock()
+			return nil, fmt.Errorf("adorn %s must be at the base of the chain, not allowed here", spec.Name)
+		}
+	}
+	adornRegistry.RUnlock()
+	return BuildAdornChain(specs, server)
+}
+
+// Adorn 按顺序对conn应用装饰链
+func (sf AdornConnsChain) Adorn(conn net.Conn) net.Conn {
+	for _, chain := range sf {
+		conn = chain(conn)
+	}
+	return conn
+}
+
+// AdornArgs 装饰参数, 未被读取的参数视为未知参数
+type AdornArgs struct {
+	values map[string]string
+	used   map[string]bool
+}
+
+// Has 是否有参数key
+func (sf *AdornArgs) Has(key string) bool {
+	_, ok := sf.values[key]
+	return ok
+}
+
+// String 字符串参数, 不存在时返回def
+func (sf *AdornArgs) String(key, def string) string {
+	sf.used[key] = true
+	if v, ok := sf.values[key]; ok {
+		return v
+	}
+	return def
+}
+
+// Int 整数参数, 不存在时返回def
+func (sf *AdornArgs) Int(key string, def int) (int, error) {
+	sf.used[key] = true
+	v, ok := sf.values[key]
+	if !ok {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("argument %s: invalid integer %q", key, v)
+	}
+	return i, nil
+}
+
+// Bool 布尔参数, 不存在时返回def
+func (sf *AdornArgs) Bool(key string, def bool) (bool, error) {
+	sf.used[key] = true
+	v, ok := sf.values[key]
+	if !ok {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("argument %s: invalid bool %q", key, v)
+	}
+	return b, nil
+}
+
+func (sf *AdornArgs) unused() []string {
+	var keys []string
+	for k := range sf.values {
+		if !sf.used[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
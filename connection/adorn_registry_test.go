@@ -0,0 +1,176 @@
+package connection
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/connection/czstd"
+)
+
+func TestParseAdornSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []AdornSpec
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"blank", "  ", nil, false},
+		{"single", "snappy", []AdornSpec{{Name: "snappy"}}, false},
+		{
+			"args",
+			" tls(ca=ca.crt, cert=a.crt,key=base64://YQ==) ,zstd(level=3),flow ",
+			[]AdornSpec{
+				{Name: "tls", Args: map[string]string{"ca": "ca.crt", "cert": "a.crt", "key": "base64://YQ=="}},
+				{Name: "zstd", Args: map[string]string{"level": "3"}},
+				{Name: "flow"},
+			},
+			false,
+		},
+		{"flag", "stcp(password=x,forward_secret)", []AdornSpec{{Name: "stcp", Args: map[string]string{"password": "x", "forward_secret": "true"}}}, false},
+		{"empty args", "lz4()", []AdornSpec{{Name: "lz4", Args: map[string]string{}}}, false},
+		{"missing )", "zstd(level=3", nil, true},
+		{"unexpected )", "zstd)", nil, true},
+		{"nested", "zstd(a=(b))", nil, true},
+		{"trailing", "zstd(level=3)x", nil, true},
+		{"empty item", "zstd,,flow", nil, true},
+		{"empty name", "(level=3)", nil, true},
+		{"empty arg name", "zstd(=3)", nil, true},
+		{"duplicate arg", "zstd(level=1,level=2)", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAdornSpecs(tt.spec)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAdornSpec_String(t *testing.T) {
+	assert.Equal(t, "flow", AdornSpec{Name: "flow"}.String())
+	assert.Equal(t, "zstd(dict=d,level=3)", AdornSpec{Name: "zstd", Args: map[string]string{"level": "3", "dict": "d"}}.String())
+}
+
+func TestBuildAdornChain(t *testing.T) {
+	for _, name := range []string{"snappy", "gzip", "zlib", "zstd", "lz4", "codec", "obfs", "proxyproto", "flow", "iol"} {
+		assert.True(t, HasAdorn(name), name)
+	}
+
+	_, err := ParseAdornChain("unknown", false)
+	assert.Error(t, err)
+	_, err = ParseAdornChain("zstd(levle=3)", false)
+	assert.Error(t, err, "unknown argument")
+	_, err = ParseAdornChain("zstd(level=x)", false)
+	assert.Error(t, err)
+	_, err = ParseAdornChain("codec(codecs=zstd|xxx)", false)
+	assert.Error(t, err)
+	_, err = ParseAdornChain("obfs(mode=xxx)", false)
+	assert.Error(t, err)
+	_, err = ParseAdornChain("proxyproto", false)
+	assert.Error(t, err, "only for server")
+	_, err = ParseAdornChain("iol(read=xxx)", false)
+	assert.Error(t, err)
+
+	chain, err := ParseAdornChain("", false)
+	require.NoError(t, err)
+	assert.Empty(t, chain)
+}
+
+func TestAdornChainStream(t *testing.T) {
+	const spec = "obfs(mode=tls),zstd(level=1),codec(codecs=lz4|snappy),iol(read=10m,write=10m),flow(name=test)"
+
+	cli, err := ParseAdornChain(spec, false)
+	require.NoError(t, err)
+	srv, err := ParseAdornChain(spec, true)
+	require.NoError(t, err)
+
+	rc := FlowStatsOf("test").Rc.Load()
+	c1, c2 := net.Pipe()
+	client, server := cli.Adorn(c1), srv.Adorn(c2)
+	defer client.Close()       // nolint: errcheck
+	defer server.Close()       // nolint: errcheck
+	go io.Copy(server, server) // nolint: errcheck
+
+	want := make([]byte, 100000)
+	for i := range want {
+		want[i] = byte(i % 7)
+	}
+	go client.Write(want) // nolint: errcheck
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(client, got)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	st := FlowStatsOf("test")
+	assert.Equal(t, uint64(2*len(want)), st.Rc.Load()-rc) // 两端均读取
+	assert.GreaterOrEqual(t, st.Tc.Load(), st.Rc.Load())
+}
+
+func TestParseUpperAdornChain(t *testing.T) {
+	for _, spec := range []string{"obfs", "zstd,obfs(mode=tls)", "proxyproto", "flow,proxyproto"} {
+		_, err := ParseUpperAdornChain(spec, true)
+		assert.Error(t, err, spec)
+	}
+
+	// 同服务端的装饰链: 压缩之后为附加装饰
+	const spec = "zstd(level=1),flow(name=upper)"
+	upperCli, err := ParseUpperAdornChain(spec, false)
+	require.NoError(t, err)
+	upperSrv, err := ParseUpperAdornChain(spec, true)
+	require.NoError(t, err)
+	cli := append(AdornConnsChain{AdornSnappy(true)}, upperCli...)
+	srv := append(AdornConnsChain{AdornSnappy(true)}, upperSrv...)
+
+	c1, c2 := net.Pipe()
+	client, server := cli.Adorn(c1), srv.Adorn(c2)
+	defer client.Close()       // nolint: errcheck
+	defer server.Close()       // nolint: errcheck
+	go io.Copy(server, server) // nolint: errcheck
+
+	want := []byte("hello upper adorn chain")
+	go client.Write(want) // nolint: errcheck
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(client, got)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+// auditServer 记录test_audit装饰最近一次创建时的server参数
+var auditServer bool
+
+func TestRegisterAdorn(t *testing.T) {
+	if !HasAdorn("test_audit") {
+		RegisterAdorn("test_audit", func(args *AdornArgs, server bool) (AdornConn, error) {
+			level, err := args.Int("level", czstd.DefaultLevel)
+			if err != nil {
+				return nil, err
+			}
+			if level != 5 {
+				return nil, errors.New("level should be 5")
+			}
+			auditServer = server
+			return func(conn net.Conn) net.Conn { return conn }, nil
+		})
+	}
+	assert.Contains(t, Adorns(), "test_audit")
+
+	chain, err := ParseAdornChain("test_audit(level=5)", true)
+	require.NoError(t, err)
+	assert.Len(t, chain, 1)
+	assert.True(t, auditServer)
+	_, err = ParseAdornChain("test_audit(level=3)", false)
+	assert.Error(t, err)
+
+	assert.Panics(t, func() { RegisterAdorn("test_audit", func(*AdornArgs, bool) (AdornConn, error) { return nil, nil }) })
+	assert.Panics(t, func() { RegisterAdorn("test_nil", nil) })
+}
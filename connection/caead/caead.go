@@ -0,0 +1,387 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package caead 实现net.conn的AEAD加密连接, 支持aes-gcm和chacha20-poly1305.
+// 每个方向首先发送版本号和随机salt, 由密钥, salt和方向(c2s/s2c)通过hkdf派生子密钥,
+// 数据按块加密: [加密的长度(2字节)+tag][加密的数据+tag], 认证失败时关闭连接.
+// 由密码生成的主密钥会记录收到的salt, 拒绝重放的连接.
+// 版本号仅用于caead自身的升级, 无法与ccrypt(cfb)互通, 也无法自动识别, 与旧版本对接时两端须都使用cfb
+package caead
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// 支持的加密方法
+const (
+	MethodAes128Gcm        = "aes-128-gcm"
+	MethodAes256Gcm        = "aes-256-gcm"
+	MethodChacha20Poly1305 = "chacha20-poly1305"
+)
+
+// Version1 当前协议版本
+const Version1 byte = 0x01
+
+const (
+	maxPayloadSize = 0x3fff // 每块最大数据长度
+	lengthSize     = 2      // 长度字段大小
+	iterations     = 4096
+
+	saltFilterCapacity = 1 << 17 // 重放过滤器容量
+)
+
+var (
+	keySalt = []byte("jocasta-caead-pbkdf2")
+	subInfo = []byte("jocasta-caead-subkey")
+
+	// 子密钥的方向标识, 防止将一个方向的数据反射回发送方
+	infoC2S = []byte("c2s")
+	infoS2C = []byte("s2c")
+)
+
+// 错误定义
+var (
+	ErrAuthFailed         = errors.New("caead: message authentication failed")
+	ErrUnsupportedVersion = errors.New("caead: unsupported version")
+	ErrReplayedSalt       = errors.New("caead: replayed salt")
+)
+
+type method struct {
+	keySize int
+	new     func(key []byte) (cipher.AEAD, error)
+}
+
+var methods = map[string]method{
+	MethodAes128Gcm:        {16, newAesGcm},
+	MethodAes256Gcm:        {32, newAesGcm},
+	MethodChacha20Poly1305: {chacha20poly1305.KeySize, chacha20poly1305.New},
+}
+
+func newAesGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// HasMethod 是否支持此加密方法
+func HasMethod(name string) bool {
+	_, ok := methods[name]
+	return ok
+}
+
+// Cipher 加密方法及由密码生成的主密钥, 可在多个连接间共享
+type Cipher struct {
+	method
+	key    []byte
+	filter *saltFilter // 重放过滤器, 仅由密码生成的主密钥使用
+}
+
+// NewCipher 创建加密方法, 使用pbkdf2由password生成主密钥, 并拒绝重放的salt
+func NewCipher(name, password string) (*Cipher, error) {
+	m, ok := methods[name]
+	if !ok {
+		return nil, fmt.Errorf("caead: unsupported method %s", name)
+	}
+	return &Cipher{
+		m,
+		pbkdf2.Key([]byte(password), keySalt, iterations, m.keySize, sha256.New),
+		newSaltFilter(),
+	}, nil
+}
+
+// NewCipherWithKey 使用指定的主密钥创建加密方法, 密钥长度须与加密方法一致,
+// 用于每个连接协商的一次性密钥, 不过滤重放的salt
+func NewCipherWithKey(name string, key []byte) (*Cipher, error) {
+	m, ok := methods[name]
+	if !ok {
+		return nil, fmt.Errorf("caead: unsupported method %s", name)
+	}
+	if len(key) != m.keySize {
+		return nil, fmt.Errorf("caead: invalid key size %d for method %s", len(key), name)
+	}
+	return &Cipher{m, key, nil}, nil
+}
+
+// saltSize salt长度与密钥长度相同
+func (sf *Cipher) saltSize() int { return sf.keySize }
+
+// aead 由主密钥, salt和方向派生子密钥
+func (sf *Cipher) aead(salt, direction []byte) (cipher.AEAD, error) {
+	info := append(append([]byte{}, subInfo...), direction...)
+	subKey := make([]byte, sf.keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, sf.key, salt, info), subKey); err != nil {
+		return nil, err
+	}
+	return sf.new(subKey)
+}
+
+// saltFilter 重放过滤器, 记录最近出现过的salt, 超出容量时淘汰最早的
+type saltFilter struct {
+	mu    sync.Mutex
+	m     map[string]struct{}
+	queue []string
+}
+
+func newSaltFilter() *saltFilter {
+	return &saltFilter{m: make(map[string]struct{})}
+}
+
+// Check 记录salt, 已经出现过时返回false
+func (sf *saltFilter) Check(salt []byte) bool {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.m[string(salt)]; ok {
+		return false
+	}
+	if len(sf.queue) >= saltFilterCapacity {
+		delete(sf.m, sf.queue[0])
+		sf.queue = sf.queue[1:]
+	}
+	sf.m[string(salt)] = struct{}{}
+	sf.queue = append(sf.queue, string(salt))
+	return true
+}
+
+// Conn AEAD加密的连接
+type Conn struct {
+	net.Conn
+	rcipher *Cipher
+	wcipher *Cipher
+	server  bool
+
+	rmu    sync.Mutex
+	r      cipher.AEAD
+	rnonce []byte
+	rbuf   []byte // 读缓存
+	rleft  []byte // 已解密未读取的数据
+	rerr   error
+
+	wmu    sync.Mutex
+	w      cipher.AEAD
+	wnonce []byte
+	wbuf   []byte
+}
+
+// New 创建一个AEAD加密的连接, server 表示本端是否为服务端, 两端须不同
+func New(c net.Conn, cip *Cipher, server bool) *Conn {
+	return NewPair(c, cip, cip, server)
+}
+
+// NewPair 创建一个读写使用不同主密钥的AEAD加密连接, 对端的read, write与本端相反
+func NewPair(c net.Conn, read, write *Cipher, server bool) *Conn {
+	return &Conn{Conn: c, rcipher: read, wcipher: write, server: server}
+}
+
+// direction 读写方向的子密钥标识
+func (sf *Conn) direction() (read, write []byte) {
+	if sf.server {
+		return infoC2S, infoS2C
+	}
+	return infoS2C, infoC2S
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(b []byte) (int, error) {
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if len(sf.rleft) > 0 {
+		n := copy(b, sf.rleft)
+		sf.rleft = sf.rleft[n:]
+		return n, nil
+	}
+	if sf.rerr != nil {
+		return 0, sf.rerr
+	}
+	if sf.r == nil {
+		if n, err := sf.readHeader(); err != nil {
+			// 未读到任何数据时的超时(如SetReadDeadline中断读)不影响后续的读
+			if n == 0 && isTimeout(err) {
+				return 0, err
+			}
+			return 0, sf.fail(err)
+		}
+	}
+
+	overhead := sf.r.Overhead()
+	lb := sf.rbuf[:lengthSize+overhead]
+	if _, err := io.ReadFull(sf.Conn, lb); err != nil {
+		return 0, err
+	}
+	if _, err := sf.r.Open(lb[:0], sf.rnonce, lb, nil); err != nil {
+		return 0, sf.fail(ErrAuthFailed)
+	}
+	increment(sf.rnonce)
+	size := int(binary.BigEndian.Uint16(lb) & maxPayloadSize)
+
+	pb := sf.rbuf[:size+overhead]
+	if _, err := io.ReadFull(sf.Conn, pb); err != nil {
+		return 0, sf.fail(io.ErrUnexpectedEOF)
+	}
+	payload, err := sf.r.Open(pb[:0], sf.rnonce, pb, nil)
+	if err != nil {
+		return 0, sf.fail(ErrAuthFailed)
+	}
+	increment(sf.rnonce)
+
+	n := copy(b, payload)
+	sf.rleft = payload[n:]
+	return n, nil
+}
+
+// readHeader 读取对方的版本号和salt, 返回已读取的字节数
+func (sf *Conn) readHeader() (int, error) {
+	header := make([]byte, 1+sf.rcipher.saltSize())
+	if n, err := io.ReadFull(sf.Conn, header); err != nil {
+		return n, err
+	}
+	if header[0] != Version1 {
+		return len(header), ErrUnsupportedVersion
+	}
+	if sf.rcipher.filter != nil && !sf.rcipher.filter.Check(header[1:]) {
+		return len(header), ErrReplayedSalt
+	}
+	read, _ := sf.direction()
+	r, err := sf.rcipher.aead(header[1:], read)
+	if err != nil {
+		return len(header), err
+	}
+	sf.r = r
+	sf.rnonce = make([]byte, r.NonceSize())
+	sf.rbuf = make([]byte, maxPayloadSize+r.Overhead())
+	return len(header), nil
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// fail 读失败, 关闭连接, 后续的读均返回此错误
+func (sf *Conn) fail(err error) error {
+	sf.rerr = err
+	sf.Conn.Close() // nolint: errcheck
+	return err
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	var header []byte
+	if sf.w == nil {
+		var err error
+		if header, err = sf.newHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for {
+		size := len(b) - n
+		if size > maxPayloadSize {
+			size = maxPayloadSize
+		}
+		buf := append(sf.wbuf[:0], header...)
+		header = nil
+
+		var lb [lengthSize]byte
+		binary.BigEndian.PutUint16(lb[:], uint16(size))
+		buf = sf.w.Seal(buf, sf.wnonce, lb[:], nil)
+		increment(sf.wnonce)
+		buf = sf.w.Seal(buf, sf.wnonce, b[n:n+size], nil)
+		increment(sf.wnonce)
+
+		if _, err := sf.Conn.Write(buf); err != nil {
+			return n, err
+		}
+		n += size
+		if n >= len(b) {
+			return n, nil
+		}
+	}
+}
+
+// Handshake 未发送过数据时立即发送版本号和salt, 之后的写不再携带
+func (sf *Conn) Handshake() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if sf.w != nil {
+		return nil
+	}
+	header, err := sf.newHeader()
+	if err != nil {
+		return err
+	}
+	_, err = sf.Conn.Write(header)
+	return err
+}
+
+// newHeader 生成随机salt并派生写方向的子密钥, 返回待发送的版本号和salt
+func (sf *Conn) newHeader() ([]byte, error) {
+	header := make([]byte, 1+sf.wcipher.saltSize())
+	header[0] = Version1
+	if _, err := io.ReadFull(rand.Reader, header[1:]); err != nil {
+		return nil, err
+	}
+	_, write := sf.direction()
+	w, err := sf.wcipher.aead(header[1:], write)
+	if err != nil {
+		return nil, err
+	}
+	sf.w = w
+	sf.wnonce = make([]byte, w.NonceSize())
+	sf.wbuf = make([]byte, 1+sf.wcipher.saltSize()+lengthSize+maxPayloadSize+2*w.Overhead())
+	return header, nil
+}
+
+// increment 小端递增nonce
+func increment(b []byte) {
+	for i := range b {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+// CloseWrite 等待当前写入完成后半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	return enet.CloseWrite(sf.Conn)
+}
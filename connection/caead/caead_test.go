@@ -0,0 +1,140 @@
+package caead
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/internal/mock"
+)
+
+func TestConn(t *testing.T) {
+	data := make([]byte, maxPayloadSize*2+100)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	for _, method := range []string{MethodAes128Gcm, MethodAes256Gcm, MethodChacha20Poly1305} {
+		t.Run(method, func(t *testing.T) {
+			cip, err := NewCipher(method, "password")
+			require.NoError(t, err)
+			mconn := mock.New(new(bytes.Buffer))
+
+			n, err := New(mconn, cip, false).Write(data)
+			require.NoError(t, err)
+			require.Equal(t, len(data), n)
+
+			rd := make([]byte, len(data))
+			_, err = io.ReadFull(New(mconn, cip, true), rd)
+			require.NoError(t, err)
+			require.Equal(t, data, rd)
+		})
+	}
+
+	_, err = NewCipher("invalid", "password")
+	require.Error(t, err)
+}
+
+func TestConnRandomSalt(t *testing.T) {
+	cip, err := NewCipher(MethodAes256Gcm, "password")
+	require.NoError(t, err)
+
+	b1, b2 := new(bytes.Buffer), new(bytes.Buffer)
+	_, err = New(mock.New(b1), cip, false).Write([]byte("hello world"))
+	require.NoError(t, err)
+	_, err = New(mock.New(b2), cip, false).Write([]byte("hello world"))
+	require.NoError(t, err)
+	require.NotEqual(t, b1.Bytes(), b2.Bytes())
+}
+
+func TestConnAuthFailed(t *testing.T) {
+	cip, err := NewCipher(MethodChacha20Poly1305, "password")
+	require.NoError(t, err)
+	wrong, err := NewCipher(MethodChacha20Poly1305, "wrong")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		cipher  *Cipher
+		tamper  func(b []byte)
+		wantErr error
+	}{
+		{"tamper", cip, func(b []byte) { b[len(b)-1] ^= 0xff }, ErrAuthFailed},
+		{"wrong password", wrong, func([]byte) {}, ErrAuthFailed},
+		{"version", cip, func(b []byte) { b[0] = 0 }, ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			_, err := New(mock.New(buf), cip, false).Write([]byte("hello world"))
+			require.NoError(t, err)
+			tt.tamper(buf.Bytes())
+
+			client, server := net.Pipe()
+			go func() {
+				client.Write(buf.Bytes()) // nolint: errcheck
+			}()
+			conn := New(server, tt.cipher, true)
+			_, err = conn.Read(make([]byte, 32))
+			assert.ErrorIs(t, err, tt.wantErr)
+			// 认证失败后连接已关闭
+			_, err = client.Write([]byte{0})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestConnReplay(t *testing.T) {
+	cip, err := NewCipher(MethodAes256Gcm, "password")
+	require.NoError(t, err)
+
+	record := func() []byte {
+		buf := new(bytes.Buffer)
+		_, err := New(mock.New(buf), cip, false).Write([]byte("hello world"))
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+	read := func(data []byte, server bool) error {
+		_, err := New(mock.New(bytes.NewBuffer(append([]byte{}, data...))), cip, server).Read(make([]byte, 32))
+		return err
+	}
+	// 反射回发送方的数据无法通过认证
+	require.ErrorIs(t, read(record(), false), ErrAuthFailed)
+	// 重放的数据被拒绝
+	data := record()
+	require.NoError(t, read(data, true))
+	require.ErrorIs(t, read(data, true), ErrReplayedSalt)
+}
+
+func TestConnHandshake(t *testing.T) {
+	cip, err := NewCipher(MethodAes128Gcm, "password")
+	require.NoError(t, err)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	client, server := New(c1, cip, false), New(c2, cip, true)
+
+	// 读超时中断等待header的读, 连接仍可用
+	require.NoError(t, server.SetReadDeadline(time.Now().Add(time.Millisecond*50)))
+	_, err = server.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.NoError(t, server.SetReadDeadline(time.Time{}))
+
+	// 握手只发送header, 之后的写不再携带header
+	go func() {
+		assert.NoError(t, client.Handshake())
+		assert.NoError(t, client.Handshake())
+		_, err := client.Write([]byte("hello"))
+		assert.NoError(t, err)
+	}()
+	rd := make([]byte, 5)
+	_, err = io.ReadFull(server, rd)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), rd)
+}
@@ -18,6 +18,8 @@ package cbuffered
 import (
 	"bufio"
 	"net"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn conn with bufio.Reader
@@ -61,3 +63,8 @@ func (sf *Conn) UnreadByte() error {
 func (sf *Conn) Buffered() int {
 	return sf.reader.Buffered()
 }
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
@@ -0,0 +1,205 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ccodec 实现压缩算法协商的net.conn接口.
+// 双方同时发送hello: [版本(1)][个数(1)][支持的压缩算法ID...],
+// 然后按固定的优先级(zstd > lz4 > snappy > none)选择双方都支持的压缩算法, 双方结果一致, 无需区分客户端和服务端
+package ccodec
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/connection/clz4"
+	"github.com/thinkgos/jocasta/connection/csnappy"
+	"github.com/thinkgos/jocasta/connection/czstd"
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// Version1 当前协议版本
+const Version1 byte = 0x01
+
+// 支持的压缩算法
+const (
+	None   = "none"
+	Zstd   = "zstd"
+	Lz4    = "lz4"
+	Snappy = "snappy"
+)
+
+// handshakeTimeout 协商超时, 防止对端不发送hello时一直阻塞
+var handshakeTimeout = 10 * time.Second
+
+// 错误定义
+var (
+	ErrUnsupportedVersion = errors.New("ccodec: unsupported version")
+	ErrNoCommonCodec      = errors.New("ccodec: no common codec")
+)
+
+type codec struct {
+	id    byte
+	name  string
+	adorn func(net.Conn) net.Conn
+}
+
+// codecs 按优先级从高到低排列
+var codecs = []codec{
+	{3, Zstd, func(c net.Conn) net.Conn { return czstd.New(c) }},
+	{2, Lz4, func(c net.Conn) net.Conn { return clz4.New(c) }},
+	{1, Snappy, func(c net.Conn) net.Conn { return csnappy.New(c) }},
+	{0, None, func(c net.Conn) net.Conn { return c }},
+}
+
+// Codecs 支持的压缩算法, 按优先级从高到低排列
+func Codecs() []string {
+	names := make([]string, 0, len(codecs))
+	for _, v := range codecs {
+		names = append(names, v.name)
+	}
+	return names
+}
+
+// HasCodec 是否支持此压缩算法
+func HasCodec(name string) bool {
+	for _, v := range codecs {
+		if v.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn 协商压缩算法的连接, 首次读写时进行协商
+type Conn struct {
+	net.Conn
+	ids []byte
+
+	once  sync.Once
+	err   error
+	codec string
+	conn  net.Conn
+}
+
+// New 创建一个协商压缩算法的连接, names 为本端支持的压缩算法, 为空时支持全部.
+// 顺序无关, 总是按固定的优先级选择, 不支持的算法将被忽略
+func New(c net.Conn, names ...string) *Conn {
+	if len(names) == 0 {
+		names = Codecs()
+	}
+	ids := make([]byte, 0, len(codecs))
+	for _, v := range codecs {
+		for _, name := range names {
+			if v.name == name {
+				ids = append(ids, v.id)
+				break
+			}
+		}
+	}
+	return &Conn{Conn: c, ids: ids}
+}
+
+// Handshake 进行协商, 未协商时读写将自动协商, 协商失败将关闭连接
+func (sf *Conn) Handshake() error {
+	sf.once.Do(func() {
+		if sf.err = sf.handshake(); sf.err != nil {
+			sf.Conn.Close() // nolint: errcheck
+		}
+	})
+	return sf.err
+}
+
+// Codec 协商的压缩算法, 未协商时将进行协商, 协商失败返回空
+func (sf *Conn) Codec() string {
+	if sf.Handshake() != nil {
+		return ""
+	}
+	return sf.codec
+}
+
+func (sf *Conn) handshake() error {
+	hello := make([]byte, 0, 2+len(sf.ids))
+	hello = append(hello, Version1, byte(len(sf.ids)))
+	hello = append(hello, sf.ids...)
+
+	sf.Conn.SetDeadline(time.Now().Add(handshakeTimeout)) // nolint: errcheck
+	defer sf.Conn.SetDeadline(time.Time{})                // nolint: errcheck
+
+	// 双方同时发送hello, 异步写避免互相等待
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sf.Conn.Write(hello)
+		errc <- err
+	}()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(sf.Conn, header); err != nil {
+		return err
+	}
+	if header[0] != Version1 {
+		return ErrUnsupportedVersion
+	}
+	peer := make([]byte, header[1])
+	if _, err := io.ReadFull(sf.Conn, peer); err != nil {
+		return err
+	}
+	if err := <-errc; err != nil {
+		return err
+	}
+
+	for _, v := range codecs {
+		if contains(sf.ids, v.id) && contains(peer, v.id) {
+			sf.codec = v.name
+			sf.conn = v.adorn(sf.Conn)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w, local %v, peer %v", ErrNoCommonCodec, sf.ids, peer)
+}
+
+func contains(ids []byte, id byte) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(b []byte) (int, error) {
+	if err := sf.Handshake(); err != nil {
+		return 0, err
+	}
+	return sf.conn.Read(b)
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(b []byte) (int, error) {
+	if err := sf.Handshake(); err != nil {
+		return 0, err
+	}
+	return sf.conn.Write(b)
+}
+
+// CloseWrite 半关闭协商后连接的写方向
+func (sf *Conn) CloseWrite() error {
+	if err := sf.Handshake(); err != nil {
+		return err
+	}
+	return enet.CloseWrite(sf.conn)
+}
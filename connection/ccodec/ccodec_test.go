@@ -0,0 +1,89 @@
+package ccodec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pipe(t *testing.T, clientCodecs, serverCodecs []string) (*Conn, *Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close() // nolint: errcheck
+		server.Close() // nolint: errcheck
+	})
+	return New(client, clientCodecs...), New(server, serverCodecs...)
+}
+
+func TestConn(t *testing.T) {
+	tests := []struct {
+		name   string
+		client []string
+		server []string
+		want   string
+	}{
+		{"all", nil, nil, Zstd},
+		{"order independent", []string{None, Snappy, Lz4}, []string{Lz4, Zstd, Snappy}, Lz4},
+		{"snappy", []string{Snappy, None}, nil, Snappy},
+		{"none", []string{None}, []string{Zstd, None}, None},
+		{"ignore unknown", []string{"brotli", Snappy}, nil, Snappy},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := pipe(t, tt.client, tt.server)
+
+			data := bytes.Repeat([]byte("hello world"), 10000)
+			go func() {
+				client.Write(data) // nolint: errcheck
+			}()
+			b := make([]byte, len(data))
+			_, err := io.ReadFull(server, b)
+			require.NoError(t, err)
+			assert.Equal(t, data, b)
+			assert.Equal(t, tt.want, server.Codec())
+			assert.Equal(t, tt.want, client.Codec())
+		})
+	}
+}
+
+func TestConnNoCommonCodec(t *testing.T) {
+	client, server := pipe(t, []string{Zstd}, []string{Lz4})
+
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	serverErr := server.Handshake()
+	clientErr := <-errc
+	// 先失败的一方关闭连接, 另一方可能读到EOF
+	assert.True(t, errors.Is(serverErr, ErrNoCommonCodec) || errors.Is(clientErr, ErrNoCommonCodec))
+	assert.Empty(t, server.Codec())
+
+	_, err := server.Write([]byte("hello"))
+	assert.Equal(t, serverErr, err)
+}
+
+func TestConnHandshakeTimeout(t *testing.T) {
+	old := handshakeTimeout
+	handshakeTimeout = 100 * time.Millisecond
+	defer func() { handshakeTimeout = old }()
+
+	client, server := net.Pipe()
+	defer client.Close()           // nolint: errcheck
+	go io.Copy(io.Discard, client) // nolint: errcheck
+	conn := New(server)
+	// 对端不发送hello
+	require.ErrorIs(t, conn.Handshake(), os.ErrDeadlineExceeded)
+}
+
+func TestHasCodec(t *testing.T) {
+	for _, name := range Codecs() {
+		assert.True(t, HasCodec(name))
+	}
+	assert.False(t, HasCodec("brotli"))
+}
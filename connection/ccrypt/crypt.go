@@ -25,6 +25,8 @@ import (
 	"net"
 
 	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Config defaults
@@ -106,3 +108,8 @@ func (sf *Conn) Read(p []byte) (int, error) {
 func (sf *Conn) Write(p []byte) (int, error) {
 	return sf.w.Write(p)
 }
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
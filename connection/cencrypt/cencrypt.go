@@ -21,6 +21,8 @@ import (
 	"net"
 
 	"github.com/things-go/encrypt"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn conn with encrypt.Cipher
@@ -48,3 +50,8 @@ func (sf *Conn) Read(b []byte) (n int, err error) {
 func (sf *Conn) Write(b []byte) (n int, err error) {
 	return sf.w.Write(b)
 }
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
@@ -19,6 +19,8 @@ import (
 	"net"
 
 	"go.uber.org/atomic"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn conn with read,write,total count
@@ -32,29 +34,45 @@ type Conn struct {
 // Read reads data from the connection.
 func (sf *Conn) Read(p []byte) (int, error) {
 	n, err := sf.Conn.Read(p)
-	if n != 0 {
-		cnt := uint64(n)
-		if sf.Rc != nil {
-			sf.Rc.Add(cnt)
-		}
-		if sf.Tc != nil {
-			sf.Tc.Add(cnt)
-		}
-	}
+	sf.count(sf.Rc, n)
 	return n, err
 }
 
 // Write writes data to the connection.
 func (sf *Conn) Write(p []byte) (int, error) {
 	n, err := sf.Conn.Write(p)
+	sf.count(sf.Wc, n)
+	return n, err
+}
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
+
+// SpliceConn 被统计的连接, 见 enet.Splicer
+func (sf *Conn) SpliceConn() net.Conn { return sf.Conn }
+
+// SpliceRead 统计零拷贝读出的字节
+func (sf *Conn) SpliceRead(n int) error {
+	sf.count(sf.Rc, n)
+	return nil
+}
+
+// SpliceWrite 统计零拷贝写入的字节
+func (sf *Conn) SpliceWrite(n int) error {
+	sf.count(sf.Wc, n)
+	return nil
+}
+
+func (sf *Conn) count(c *atomic.Uint64, n int) {
 	if n != 0 {
 		cnt := uint64(n)
-		if sf.Wc != nil {
-			sf.Wc.Add(cnt)
+		if c != nil {
+			c.Add(cnt)
 		}
 		if sf.Tc != nil {
 			sf.Tc.Add(cnt)
 		}
 	}
-	return n, err
 }
@@ -12,18 +12,27 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package cgzip 采用gzip压缩实现的net.conn接口
+// Package cgzip 采用gzip压缩实现的net.conn接口, 每次写入后flush, 半关闭时写入gzip尾结束流
 package cgzip
 
 import (
 	"compress/gzip"
 	"net"
+	"sync"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn is a generic stream-oriented network connection with gzip
 type Conn struct {
 	net.Conn
 	level int
+
+	rmu sync.Mutex
+	r   *gzip.Reader
+
+	wmu sync.Mutex
+	w   *gzip.Writer
 }
 
 // New new a gzip compress
@@ -35,27 +44,56 @@ func New(conn net.Conn) *Conn {
 // level see gzip package
 func NewLevel(conn net.Conn, level int) *Conn {
 	return &Conn{
-		conn,
-		level,
+		Conn:  conn,
+		level: level,
 	}
 }
 
 // Read reads data from the connection.
 func (sf *Conn) Read(p []byte) (int, error) {
-	r, err := gzip.NewReader(sf.Conn)
-	if err != nil {
-		return 0, err
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if sf.r == nil {
+		r, err := gzip.NewReader(sf.Conn)
+		if err != nil {
+			return 0, err
+		}
+		sf.r = r
 	}
-	return r.Read(p)
+	return sf.r.Read(p)
 }
 
 // Write writes data to the connection.
 func (sf *Conn) Write(p []byte) (int, error) {
-	w, err := gzip.NewWriterLevel(sf.Conn, sf.level)
-	if err != nil {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if err := sf.initWriter(); err != nil {
 		return 0, err
 	}
-	n, _ := w.Write(p)
-	err = w.Flush()
+	n, _ := sf.w.Write(p)
+	err := sf.w.Flush()
 	return n, err
 }
+
+// CloseWrite 写入gzip尾后半关闭底层连接
+func (sf *Conn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if err := sf.initWriter(); err != nil {
+		return err
+	}
+	if err := sf.w.Close(); err != nil {
+		return err
+	}
+	return enet.CloseWrite(sf.Conn)
+}
+
+func (sf *Conn) initWriter() (err error) {
+	if sf.w == nil {
+		sf.w, err = gzip.NewWriterLevel(sf.Conn, sf.level)
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package chalf 为不支持半关闭的流(如smux stream)实现半关闭的net.conn接口.
+// 写入的数据封装为帧: [数据长度(2字节,大端)][数据], 长度为0的帧表示写结束, 对端读取返回io.EOF
+package chalf
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+)
+
+const (
+	maxChunkSize = 0xffff // 每帧最大数据长度
+	headerSize   = 2
+)
+
+// Conn 支持半关闭的连接
+type Conn struct {
+	net.Conn
+
+	rmu    sync.Mutex
+	remain int  // 当前帧未读取的数据长度
+	eof    bool // 已读取到结束帧
+
+	wmu    sync.Mutex
+	wbuf   []byte
+	closed bool // 已写入结束帧
+}
+
+// New new a half close conn
+func New(c net.Conn) *Conn {
+	return &Conn{Conn: c}
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(b []byte) (int, error) {
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if sf.eof {
+		return 0, io.EOF
+	}
+	for sf.remain == 0 {
+		var header [headerSize]byte
+		if _, err := io.ReadFull(sf.Conn, header[:]); err != nil {
+			return 0, err
+		}
+		sf.remain = int(binary.BigEndian.Uint16(header[:]))
+		if sf.remain == 0 {
+			sf.eof = true
+			return 0, io.EOF
+		}
+	}
+	if len(b) > sf.remain {
+		b = b[:sf.remain]
+	}
+	n, err := sf.Conn.Read(b)
+	sf.remain -= n
+	if err == io.EOF && sf.remain > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(b []byte) (int, error) {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if sf.closed {
+		return 0, net.ErrClosed
+	}
+	n := 0
+	for n < len(b) {
+		size := len(b) - n
+		if size > maxChunkSize {
+			size = maxChunkSize
+		}
+		buf := append(sf.wbuf[:0], byte(size>>8), byte(size))
+		buf = append(buf, b[n:n+size]...)
+		sf.wbuf = buf
+		if _, err := sf.Conn.Write(buf); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// CloseWrite 写入结束帧, 之后不可再写, 仍可读取
+func (sf *Conn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if sf.closed {
+		return nil
+	}
+	sf.closed = true
+	_, err := sf.Conn.Write([]byte{0, 0})
+	return err
+}
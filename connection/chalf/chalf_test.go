@@ -0,0 +1,58 @@
+package chalf
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close() // nolint: errcheck
+	defer server.Close() // nolint: errcheck
+
+	c, s := New(client), New(server)
+
+	// server读取完整请求后再响应
+	go func() {
+		req, err := io.ReadAll(s)
+		if err != nil {
+			return
+		}
+		s.Write(req)   // nolint: errcheck
+		s.CloseWrite() // nolint: errcheck
+	}()
+
+	want := bytes.Repeat([]byte("hello world"), 10000)
+	go func() {
+		c.Write(want)  // nolint: errcheck
+		c.CloseWrite() // nolint: errcheck
+	}()
+
+	got, err := io.ReadAll(c)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// 结束后读写
+	_, err = c.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	_, err = c.Write([]byte("a"))
+	assert.Error(t, err)
+	assert.NoError(t, c.CloseWrite())
+}
+
+func TestConnUnexpectedEOF(t *testing.T) {
+	client, server := net.Pipe()
+	c := New(client)
+
+	go func() {
+		server.Write([]byte{0, 10, 'a'}) // nolint: errcheck
+		server.Close()                   // nolint: errcheck
+	}()
+	_, err := io.ReadAll(c)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
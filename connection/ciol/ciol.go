@@ -21,6 +21,8 @@ import (
 	"time"
 
 	"golang.org/x/time/rate"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn limiter conn
@@ -138,3 +140,8 @@ func (sf *Conn) SetWriteBurstAt(now time.Time, newBurst int) {
 		sf.wLimiter.SetBurstAt(now, newBurst)
 	}
 }
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
@@ -0,0 +1,199 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ckex 实现由预共享密钥认证的X25519密钥交换连接, 每个连接使用临时的会话密钥, 具有前向安全性.
+// 双方同时发送hello: [版本(1)][临时公钥(32)][HMAC-SHA256(psk, 版本+临时公钥)(32)],
+// 校验对方的hello后, 由X25519共享密钥, psk和双方的临时公钥通过hkdf派生两个方向各自的会话密钥, 数据使用caead加密
+package ckex
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+
+	"github.com/thinkgos/jocasta/connection/caead"
+)
+
+// Version1 当前协议版本
+const Version1 byte = 0x01
+
+// Method 会话数据加密方法
+const Method = caead.MethodChacha20Poly1305
+
+const (
+	publicKeySize = 32
+	macSize       = sha256.Size
+	helloSize     = 1 + publicKeySize + macSize
+	sessionKeyLen = 32
+	iterations    = 4096
+)
+
+// handshakeTimeout 握手超时, 防止对端不发送hello时一直阻塞
+var handshakeTimeout = 10 * time.Second
+
+var (
+	keySalt     = []byte("jocasta-ckex-pbkdf2")
+	sessionInfo = []byte("jocasta-ckex-session")
+)
+
+// 错误定义
+var (
+	ErrAuthFailed         = errors.New("ckex: handshake authentication failed")
+	ErrUnsupportedVersion = errors.New("ckex: unsupported version")
+)
+
+// DeriveKey 使用pbkdf2由password生成预共享密钥, 可在多个连接间共享
+func DeriveKey(password string) []byte {
+	return pbkdf2.Key([]byte(password), keySalt, iterations, sha256.Size, sha256.New)
+}
+
+// Conn 密钥交换后加密的连接, 首次读写时进行握手
+type Conn struct {
+	net.Conn
+	psk []byte
+
+	once sync.Once
+	err  error
+	conn *caead.Conn
+}
+
+// New 创建一个由预共享密钥psk认证的密钥交换连接, psk 见 DeriveKey
+func New(c net.Conn, psk []byte) *Conn {
+	return &Conn{Conn: c, psk: psk}
+}
+
+// Handshake 进行握手, 未握手时读写将自动握手, 握手失败将关闭连接
+func (sf *Conn) Handshake() error {
+	sf.once.Do(func() {
+		if sf.err = sf.handshake(); sf.err != nil {
+			sf.Conn.Close() // nolint: errcheck
+		}
+	})
+	return sf.err
+}
+
+func (sf *Conn) handshake() error {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	pub := priv.PublicKey().Bytes()
+
+	sf.Conn.SetDeadline(time.Now().Add(handshakeTimeout)) // nolint: errcheck
+	defer sf.Conn.SetDeadline(time.Time{})                // nolint: errcheck
+
+	hello := make([]byte, 0, helloSize)
+	hello = append(hello, Version1)
+	hello = append(hello, pub...)
+	hello = append(hello, sf.mac(hello)...)
+
+	// 双方同时发送hello, 异步写避免互相等待
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sf.Conn.Write(hello)
+		errc <- err
+	}()
+
+	peer := make([]byte, helloSize)
+	if _, err = io.ReadFull(sf.Conn, peer); err != nil {
+		return err
+	}
+	if peer[0] != Version1 {
+		return ErrUnsupportedVersion
+	}
+	peerPub := peer[1 : 1+publicKeySize]
+	if !hmac.Equal(sf.mac(peer[:1+publicKeySize]), peer[1+publicKeySize:]) || bytes.Equal(pub, peerPub) {
+		return ErrAuthFailed
+	}
+	if err = <-errc; err != nil {
+		return err
+	}
+
+	peerKey, err := ecdh.X25519().NewPublicKey(peerPub)
+	if err != nil {
+		return err
+	}
+	shared, err := priv.ECDH(peerKey)
+	if err != nil {
+		return err
+	}
+
+	// 公钥按序拼接, 双方得到相同的会话密钥, 前半为公钥在前一方的发送密钥, 后半为另一方的发送密钥
+	info := append([]byte{}, sessionInfo...)
+	first := bytes.Compare(pub, peerPub) < 0
+	if first {
+		info = append(append(info, pub...), peerPub...)
+	} else {
+		info = append(append(info, peerPub...), pub...)
+	}
+	key := make([]byte, 2*sessionKeyLen)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared, sf.psk, info), key); err != nil {
+		return err
+	}
+	wkey, rkey := key[:sessionKeyLen], key[sessionKeyLen:]
+	if !first {
+		wkey, rkey = rkey, wkey
+	}
+	wcip, err := caead.NewCipherWithKey(Method, wkey)
+	if err != nil {
+		return err
+	}
+	rcip, err := caead.NewCipherWithKey(Method, rkey)
+	if err != nil {
+		return err
+	}
+	// 公钥在前的一方作为caead的客户端
+	sf.conn = caead.NewPair(sf.Conn, rcip, wcip, !first)
+	return nil
+}
+
+func (sf *Conn) mac(b []byte) []byte {
+	h := hmac.New(sha256.New, sf.psk)
+	h.Write(b) // nolint: errcheck
+	return h.Sum(nil)
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(b []byte) (int, error) {
+	if err := sf.Handshake(); err != nil {
+		return 0, err
+	}
+	return sf.conn.Read(b)
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(b []byte) (int, error) {
+	if err := sf.Handshake(); err != nil {
+		return 0, err
+	}
+	return sf.conn.Write(b)
+}
+
+// CloseWrite 半关闭加密连接的写方向
+func (sf *Conn) CloseWrite() error {
+	if err := sf.Handshake(); err != nil {
+		return err
+	}
+	return sf.conn.CloseWrite()
+}
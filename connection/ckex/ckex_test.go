@@ -0,0 +1,111 @@
+package ckex
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/connection/caead"
+)
+
+func pipe(t *testing.T, clientKey, serverKey []byte) (*Conn, *Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close() // nolint: errcheck
+		server.Close() // nolint: errcheck
+	})
+	return New(client, clientKey), New(server, serverKey)
+}
+
+func TestConn(t *testing.T) {
+	key := DeriveKey("password")
+	client, server := pipe(t, key, key)
+
+	data := make([]byte, 40000)
+	go func() {
+		client.Write(data)            // nolint: errcheck
+		client.Write([]byte("hello")) // nolint: errcheck
+	}()
+	b := make([]byte, len(data))
+	_, err := io.ReadFull(server, b)
+	require.NoError(t, err)
+	assert.Equal(t, data, b)
+
+	b = make([]byte, 5)
+	_, err = io.ReadFull(server, b)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+}
+
+func TestConnAuthFailed(t *testing.T) {
+	client, server := pipe(t, DeriveKey("password"), DeriveKey("wrong"))
+
+	errc := make(chan error, 1)
+	go func() { errc <- client.Handshake() }()
+	serverErr := server.Handshake()
+	clientErr := <-errc
+	require.Error(t, serverErr)
+	require.Error(t, clientErr)
+	// 先校验失败的一方关闭连接, 另一方可能读到EOF
+	assert.True(t, errors.Is(serverErr, ErrAuthFailed) || errors.Is(clientErr, ErrAuthFailed))
+
+	_, err := server.Write([]byte("hello"))
+	assert.Equal(t, serverErr, err)
+}
+
+func TestConnReflect(t *testing.T) {
+	key := DeriveKey("password")
+	c1, m1 := net.Pipe()
+	m2, s2 := net.Pipe()
+	defer c1.Close() // nolint: errcheck
+	defer s2.Close() // nolint: errcheck
+	client, server := New(c1, key), New(s2, key)
+
+	// 中间人转发hello
+	go func() {
+		hello := make([]byte, helloSize)
+		io.ReadFull(m1, hello) // nolint: errcheck
+		m2.Write(hello)        // nolint: errcheck
+	}()
+	go func() {
+		hello := make([]byte, helloSize)
+		io.ReadFull(m2, hello) // nolint: errcheck
+		m1.Write(hello)        // nolint: errcheck
+	}()
+	errc := make(chan error, 1)
+	go func() { errc <- server.Handshake() }()
+	require.NoError(t, client.Handshake())
+	require.NoError(t, <-errc)
+
+	// 中间人将客户端发出的数据反射回客户端
+	go func() {
+		client.Write([]byte("hello")) // nolint: errcheck
+	}()
+	b := make([]byte, 1024)
+	n, err := m1.Read(b)
+	require.NoError(t, err)
+	go func() {
+		m1.Write(b[:n]) // nolint: errcheck
+	}()
+	_, err = client.Read(make([]byte, 5))
+	require.ErrorIs(t, err, caead.ErrAuthFailed)
+}
+
+func TestConnHandshakeTimeout(t *testing.T) {
+	old := handshakeTimeout
+	handshakeTimeout = 100 * time.Millisecond
+	defer func() { handshakeTimeout = old }()
+
+	client, server := net.Pipe()
+	defer client.Close()           // nolint: errcheck
+	go io.Copy(io.Discard, client) // nolint: errcheck
+	conn := New(server, DeriveKey("password"))
+	// 对端不发送hello
+	require.ErrorIs(t, conn.Handshake(), os.ErrDeadlineExceeded)
+}
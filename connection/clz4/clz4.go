@@ -0,0 +1,91 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package clz4 采用lz4压缩实现的net.conn接口.
+// 使用lz4标准帧格式, 每次写入的数据压缩为一个或多个块(每块最大64KB)并立即发送,
+// 半关闭时写入帧结束标记
+package clz4
+
+import (
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pierrec/lz4/v4"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// Conn is a generic stream-oriented network connection with lz4
+type Conn struct {
+	net.Conn
+
+	ronce sync.Once
+	pr    *io.PipeReader
+	pw    *io.PipeWriter
+
+	wmu sync.Mutex
+	zw  *lz4.Writer
+}
+
+// New new with lz4
+func New(conn net.Conn) *Conn {
+	zw := lz4.NewWriter(conn)
+	zw.Apply(lz4.BlockSizeOption(lz4.Block64Kb)) // nolint: errcheck
+	pr, pw := io.Pipe()
+	return &Conn{Conn: conn, pr: pr, pw: pw, zw: zw}
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(p []byte) (int, error) {
+	sf.ronce.Do(sf.startRead)
+	return sf.pr.Read(p)
+}
+
+// startRead lz4.Reader 的Read会等待填满p, 不适合交互的连接,
+// 使用WriteTo在协程中解压, 每解压一块即可读取
+func (sf *Conn) startRead() {
+	go func() {
+		_, err := lz4.NewReader(sf.Conn).WriteTo(sf.pw)
+		sf.pw.CloseWithError(err) // nolint: errcheck
+	}()
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(p []byte) (int, error) {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	n, err := sf.zw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, sf.zw.Flush()
+}
+
+// CloseWrite 等待当前写入完成后写入帧结束标记, 并半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	if err := sf.zw.Close(); err != nil {
+		return err
+	}
+	return enet.CloseWrite(sf.Conn)
+}
+
+// Close closes the connection.
+func (sf *Conn) Close() error {
+	sf.pr.Close() // nolint: errcheck
+	return sf.Conn.Close()
+}
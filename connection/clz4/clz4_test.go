@@ -0,0 +1,91 @@
+package clz4
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/internal/mock"
+)
+
+func TestConn(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 100)
+
+	t.Run("invalid frame", func(t *testing.T) {
+		mconn := mock.New(new(bytes.Buffer))
+		_, err := mconn.Write([]byte("aaaaaaaaaa"))
+		require.NoError(t, err)
+
+		conn := New(mconn)
+		_, err = conn.Read(make([]byte, 10))
+		require.Error(t, err)
+	})
+
+	t.Run("lz4", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		conn := New(mock.New(buf))
+
+		n, err := conn.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+		require.Less(t, buf.Len(), len(data))
+
+		rd := make([]byte, len(data))
+		n, err = io.ReadFull(conn, rd)
+		require.NoError(t, err)
+		require.Equal(t, data, rd[:n])
+	})
+
+	t.Run("standard frame", func(t *testing.T) {
+		// 写入的数据可由标准的lz4帧解码
+		buf := new(bytes.Buffer)
+		conn := New(mock.New(buf))
+		_, err := conn.Write(data)
+		require.NoError(t, err)
+		conn.CloseWrite() // nolint: errcheck // mock不支持半关闭, 只需写入帧结束标记
+		got, err := io.ReadAll(lz4.NewReader(bytes.NewReader(buf.Bytes())))
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+
+		// 可读取标准的lz4帧
+		buf = new(bytes.Buffer)
+		zw := lz4.NewWriter(buf)
+		_, err = zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		got, err = io.ReadAll(New(mock.New(buf)))
+		require.NoError(t, err)
+		require.Equal(t, data, got)
+	})
+}
+
+func TestConnStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c, s := New(client), New(server)
+	for i := 0; i < 3; i++ {
+		want := bytes.Repeat([]byte{byte('a' + i)}, 50000*(i+1))
+		go func() {
+			c.Write(want) // nolint: errcheck
+		}()
+		got := make([]byte, len(want))
+		_, err := io.ReadFull(s, got)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+
+	// 读取不必等待填满缓冲区
+	go func() {
+		c.Write([]byte("ping")) // nolint: errcheck
+	}()
+	b := make([]byte, 1024)
+	n, err := s.Read(b)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b[:n]))
+}
@@ -0,0 +1,78 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cobfs 实现与simple-obfs兼容的连接混淆, 将连接的首个报文伪装为http升级请求或tls握手.
+//
+//	http: 客户端首个报文为websocket升级请求, 服务端首个报文为101响应, 之后为原始数据
+//	tls:  客户端首个报文为ClientHello(数据位于SessionTicket扩展), 服务端首个报文为ServerHello,
+//	      ChangeCipherSpec和加密的握手消息(数据), 之后的数据均封装为tls application data记录
+//
+// 混淆不提供任何加密或认证, 需配合stcp等加密使用
+package cobfs
+
+import (
+	"errors"
+	"net"
+)
+
+// 支持的混淆方式
+const (
+	ModeHTTP = "http"
+	ModeTLS  = "tls"
+)
+
+// DefaultHost 默认的混淆host
+const DefaultHost = "cloudfront.net"
+
+// 错误定义
+var (
+	ErrBadRequest  = errors.New("cobfs: bad obfs request")
+	ErrBadResponse = errors.New("cobfs: bad obfs response")
+	ErrBadRecord   = errors.New("cobfs: bad tls record")
+)
+
+// Modes 支持的混淆方式
+func Modes() []string {
+	return []string{ModeHTTP, ModeTLS}
+}
+
+// HasMode 是否支持此混淆方式, 空表示不混淆
+func HasMode(mode string) bool {
+	return mode == "" || mode == ModeHTTP || mode == ModeTLS
+}
+
+// NewClient 创建客户端混淆连接, mode 为空或不支持时返回原连接, host 为空时使用 DefaultHost
+func NewClient(c net.Conn, mode, host string) net.Conn {
+	if host == "" {
+		host = DefaultHost
+	}
+	switch mode {
+	case ModeHTTP:
+		return NewHTTPClient(c, host)
+	case ModeTLS:
+		return NewTLSClient(c, host)
+	}
+	return c
+}
+
+// NewServer 创建服务端混淆连接, mode 为空或不支持时返回原连接
+func NewServer(c net.Conn, mode string) net.Conn {
+	switch mode {
+	case ModeHTTP:
+		return NewHTTPServer(c)
+	case ModeTLS:
+		return NewTLSServer(c)
+	}
+	return c
+}
@@ -0,0 +1,102 @@
+package cobfs
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pipe(t *testing.T, mode string) (net.Conn, net.Conn) {
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close() // nolint: errcheck
+		server.Close() // nolint: errcheck
+	})
+	return NewClient(client, mode, "example.com"), NewServer(server, mode)
+}
+
+func exchange(t *testing.T, w, r net.Conn, data []byte) {
+	errc := make(chan error, 1)
+	go func() {
+		_, err := w.Write(data)
+		errc <- err
+	}()
+	got := make([]byte, len(data))
+	_, err := io.ReadFull(r, got)
+	require.NoError(t, err)
+	require.NoError(t, <-errc)
+	require.Equal(t, data, got)
+}
+
+func TestConn(t *testing.T) {
+	for _, mode := range append(Modes(), "") {
+		t.Run(mode, func(t *testing.T) {
+			client, server := pipe(t, mode)
+
+			exchange(t, client, server, []byte("hello"))
+			exchange(t, server, client, []byte("world"))
+			exchange(t, client, server, bytes.Repeat([]byte("a"), 3*maxChunkSize+1))
+			exchange(t, server, client, bytes.Repeat([]byte("b"), 3*maxChunkSize+1))
+		})
+	}
+}
+
+func TestHTTPRequest(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		NewHTTPClient(client, "example.com:8080").Write([]byte("hello")) // nolint: errcheck
+	}()
+	r := bufio.NewReader(server)
+	req, err := http.ReadRequest(r)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:8080", req.Host)
+	assert.Equal(t, "websocket", req.Header.Get("Upgrade"))
+	assert.Equal(t, int64(5), req.ContentLength)
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestHTTPBadRequest(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")) // nolint: errcheck
+	}()
+	_, err := NewHTTPServer(server).Read(make([]byte, 10))
+	assert.Equal(t, ErrBadRequest, err)
+}
+
+func TestTLSClientHello(t *testing.T) {
+	data := []byte("hello")
+	hello := NewTLSClient(nil, "example.com").clientHello(data)
+	require.Len(t, hello, recordHeaderSize+212+len(data)+len("example.com"))
+	assert.Contains(t, string(hello), "example.com")
+
+	// ServerHello 固定长度, 与simple-obfs一致
+	server := NewTLSServer(nil).serverHello(data)
+	require.Len(t, server, 96+6+recordHeaderSize+len(data))
+}
+
+func TestTLSBadRecord(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		client.Write([]byte{recordApplicationData, 0x03, 0x03, 0x00, 0x01, 0x00}) // nolint: errcheck
+	}()
+	_, err := NewTLSServer(server).Read(make([]byte, 10))
+	assert.Equal(t, ErrBadRecord, err)
+}
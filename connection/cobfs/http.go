@@ -0,0 +1,183 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobfs
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// websocketGUID 计算Sec-WebSocket-Accept, 见RFC6455
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// HTTPConn http混淆的连接, 首个报文伪装为websocket升级请求或响应
+type HTTPConn struct {
+	net.Conn
+	client bool
+	host   string
+
+	rmu  sync.Mutex
+	r    *bufio.Reader // 读取首个报文头后的剩余数据
+	rerr error
+
+	wmu   sync.Mutex
+	wrote bool
+
+	keyMu sync.Mutex
+	key   string // Sec-WebSocket-Key
+}
+
+// NewHTTPClient 创建http混淆的客户端连接, host 为请求的Host, 可带端口
+func NewHTTPClient(c net.Conn, host string) *HTTPConn {
+	return &HTTPConn{Conn: c, client: true, host: host}
+}
+
+// NewHTTPServer 创建http混淆的服务端连接
+func NewHTTPServer(c net.Conn) *HTTPConn {
+	return &HTTPConn{Conn: c}
+}
+
+// Read reads data from the connection.
+func (sf *HTTPConn) Read(b []byte) (int, error) {
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if sf.r == nil {
+		if sf.rerr != nil {
+			return 0, sf.rerr
+		}
+		r := bufio.NewReader(sf.Conn)
+		if sf.client {
+			sf.rerr = sf.readResponse(r)
+		} else {
+			sf.rerr = sf.readRequest(r)
+		}
+		if sf.rerr != nil {
+			return 0, sf.rerr
+		}
+		sf.r = r
+	}
+	return sf.r.Read(b)
+}
+
+func (sf *HTTPConn) readRequest(r *bufio.Reader) error {
+	req, err := http.ReadRequest(r)
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return ErrBadRequest
+	}
+	if !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		return ErrBadRequest
+	}
+	// 请求头之后均为原始数据, 不按Content-Length读取请求体
+	sf.keyMu.Lock()
+	sf.key = req.Header.Get("Sec-WebSocket-Key")
+	sf.keyMu.Unlock()
+	return nil
+}
+
+func (sf *HTTPConn) readResponse(r *bufio.Reader) error {
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return ErrBadResponse
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return ErrBadResponse
+	}
+	return nil
+}
+
+// Write writes data to the connection.
+func (sf *HTTPConn) Write(b []byte) (int, error) {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if sf.wrote {
+		return sf.Conn.Write(b)
+	}
+
+	var header string
+	if sf.client {
+		header = sf.requestHeader(len(b))
+	} else {
+		header = sf.responseHeader()
+	}
+	buf := make([]byte, 0, len(header)+len(b))
+	buf = append(append(buf, header...), b...)
+	if _, err := sf.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	sf.wrote = true
+	return len(b), nil
+}
+
+func (sf *HTTPConn) requestHeader(contentLength int) string {
+	key := make([]byte, 16)
+	rand.Read(key) // nolint: errcheck
+	return fmt.Sprintf("GET / HTTP/1.1\r\n"+
+		"Host: %s\r\n"+
+		"User-Agent: curl/7.%d.%d\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\n"+
+		"Content-Length: %d\r\n"+
+		"\r\n",
+		sf.host, mrand.Intn(54), mrand.Intn(2), base64.StdEncoding.EncodeToString(key), contentLength)
+}
+
+func (sf *HTTPConn) responseHeader() string {
+	sf.keyMu.Lock()
+	key := sf.key
+	sf.keyMu.Unlock()
+	if key == "" {
+		// 未读取请求时使用随机的key
+		b := make([]byte, 16)
+		rand.Read(b) // nolint: errcheck
+		key = base64.StdEncoding.EncodeToString(b)
+	}
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return fmt.Sprintf("HTTP/1.1 101 Switching Protocols\r\n"+
+		"Server: nginx/1.%d.%d\r\n"+
+		"Date: %s\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n"+
+		"\r\n",
+		mrand.Intn(11), mrand.Intn(12), time.Now().UTC().Format(http.TimeFormat), base64.StdEncoding.EncodeToString(h[:]))
+}
+
+// CloseWrite 等待当前写入完成后半关闭底层连接的写方向
+func (sf *HTTPConn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	return enet.CloseWrite(sf.Conn)
+}
@@ -0,0 +1,392 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cobfs
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// tls 记录类型
+const (
+	recordChangeCipherSpec = 0x14
+	recordHandshake        = 0x16
+	recordApplicationData  = 0x17
+
+	handshakeClientHello = 0x01
+	handshakeServerHello = 0x02
+
+	extSessionTicket = 0x0023
+
+	recordHeaderSize = 5
+	maxChunkSize     = 1 << 14 // 每个记录最大数据长度
+	sessionIDSize    = 32
+)
+
+// TLSConn tls混淆的连接, 首个报文伪装为tls握手, 之后的数据封装为application data记录
+type TLSConn struct {
+	net.Conn
+	client bool
+	host   string
+
+	rmu        sync.Mutex
+	handshaked bool   // 已读取对端的握手
+	rleft      []byte // 服务端已读取未返回的ClientHello中的数据
+	remain     int    // 当前记录未读取的数据长度
+	rerr       error
+
+	wmu   sync.Mutex
+	wrote bool
+
+	sidMu     sync.Mutex
+	sessionID []byte // 服务端收到的session id, ServerHello中回显
+}
+
+// NewTLSClient 创建tls混淆的客户端连接, host 为ClientHello中的SNI
+func NewTLSClient(c net.Conn, host string) *TLSConn {
+	return &TLSConn{Conn: c, client: true, host: host}
+}
+
+// NewTLSServer 创建tls混淆的服务端连接
+func NewTLSServer(c net.Conn) *TLSConn {
+	return &TLSConn{Conn: c}
+}
+
+// Read reads data from the connection.
+func (sf *TLSConn) Read(b []byte) (int, error) {
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if sf.rerr != nil {
+		return 0, sf.rerr
+	}
+	if !sf.handshaked {
+		if sf.client {
+			sf.rerr = sf.readServerHello()
+		} else {
+			sf.rerr = sf.readClientHello()
+		}
+		if sf.rerr != nil {
+			return 0, sf.rerr
+		}
+		sf.handshaked = true
+	}
+	if len(sf.rleft) > 0 {
+		n := copy(b, sf.rleft)
+		sf.rleft = sf.rleft[n:]
+		return n, nil
+	}
+	for sf.remain == 0 {
+		typ, length, err := sf.readRecordHeader()
+		if err != nil {
+			return 0, err
+		}
+		if typ != recordApplicationData {
+			sf.rerr = ErrBadRecord
+			return 0, sf.rerr
+		}
+		sf.remain = length
+	}
+	if len(b) > sf.remain {
+		b = b[:sf.remain]
+	}
+	n, err := sf.Conn.Read(b)
+	sf.remain -= n
+	return n, err
+}
+
+func (sf *TLSConn) readRecordHeader() (byte, int, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(sf.Conn, header[:]); err != nil {
+		return 0, 0, err
+	}
+	return header[0], int(binary.BigEndian.Uint16(header[3:])), nil
+}
+
+func (sf *TLSConn) readRecord(typ byte) ([]byte, error) {
+	t, length, err := sf.readRecordHeader()
+	if err != nil {
+		return nil, err
+	}
+	if t != typ {
+		return nil, ErrBadRecord
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(sf.Conn, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// readServerHello 读取ServerHello和ChangeCipherSpec, 之后的握手记录为数据
+func (sf *TLSConn) readServerHello() error {
+	hello, err := sf.readRecord(recordHandshake)
+	if err != nil {
+		return err
+	}
+	if len(hello) == 0 || hello[0] != handshakeServerHello {
+		return ErrBadResponse
+	}
+	if _, err = sf.readRecord(recordChangeCipherSpec); err != nil {
+		return err
+	}
+	typ, length, err := sf.readRecordHeader()
+	if err != nil {
+		return err
+	}
+	if typ != recordHandshake {
+		return ErrBadRecord
+	}
+	sf.remain = length
+	return nil
+}
+
+// readClientHello 读取ClientHello, SessionTicket扩展中为数据
+func (sf *TLSConn) readClientHello() error {
+	hello, err := sf.readRecord(recordHandshake)
+	if err != nil {
+		return err
+	}
+	s := cursor(hello)
+	if typ, ok := s.byte(); !ok || typ != handshakeClientHello {
+		return ErrBadRequest
+	}
+	// 握手长度(3), 版本(2), 随机数(32)
+	if !s.skip(3 + 2 + 32) {
+		return ErrBadRequest
+	}
+	sessionID, ok := s.bytes8()
+	if !ok {
+		return ErrBadRequest
+	}
+	if _, ok = s.bytes16(); !ok { // 加密套件
+		return ErrBadRequest
+	}
+	if _, ok = s.bytes8(); !ok { // 压缩方法
+		return ErrBadRequest
+	}
+	exts, ok := s.bytes16()
+	if !ok {
+		return ErrBadRequest
+	}
+	for e := cursor(exts); len(e) > 0; {
+		typ, ok1 := e.uint16()
+		data, ok2 := e.bytes16()
+		if !ok1 || !ok2 {
+			return ErrBadRequest
+		}
+		if typ == extSessionTicket {
+			sf.rleft = data
+		}
+	}
+
+	sf.sidMu.Lock()
+	sf.sessionID = sessionID
+	sf.sidMu.Unlock()
+	return nil
+}
+
+// Write writes data to the connection.
+func (sf *TLSConn) Write(b []byte) (int, error) {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	n := 0
+	for {
+		size := len(b) - n
+		if size > maxChunkSize {
+			size = maxChunkSize
+		}
+		var buf []byte
+		if !sf.wrote {
+			if sf.client {
+				buf = sf.clientHello(b[n : n+size])
+			} else {
+				buf = sf.serverHello(b[n : n+size])
+			}
+		} else {
+			buf = make([]byte, 0, recordHeaderSize+size)
+			buf = append(buf, recordApplicationData, 0x03, 0x03)
+			buf = binary.BigEndian.AppendUint16(buf, uint16(size))
+			buf = append(buf, b[n:n+size]...)
+		}
+		if _, err := sf.Conn.Write(buf); err != nil {
+			return n, err
+		}
+		sf.wrote = true
+		n += size
+		if n >= len(b) {
+			return n, nil
+		}
+	}
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b) // nolint: errcheck
+	return b
+}
+
+// clientHello 与simple-obfs一致的ClientHello, 数据位于SessionTicket扩展
+func (sf *TLSConn) clientHello(data []byte) []byte {
+	host := sf.host
+	buf := make([]byte, 0, recordHeaderSize+212+len(data)+len(host))
+
+	// 记录头, tls1.0
+	buf = append(buf, recordHandshake, 0x03, 0x01)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(212+len(data)+len(host)))
+	// ClientHello, tls1.2
+	buf = append(buf, handshakeClientHello, 0x00)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(208+len(data)+len(host)))
+	buf = append(buf, 0x03, 0x03)
+	// 随机数(时间戳+28字节随机数), session id
+	buf = binary.BigEndian.AppendUint32(buf, uint32(time.Now().Unix()))
+	buf = append(buf, randomBytes(28)...)
+	buf = append(buf, sessionIDSize)
+	buf = append(buf, randomBytes(sessionIDSize)...)
+	// 加密套件
+	buf = append(buf, 0x00, 0x38,
+		0xc0, 0x2c, 0xc0, 0x30, 0x00, 0x9f, 0xcc, 0xa9, 0xcc, 0xa8, 0xcc, 0xaa, 0xc0, 0x2b, 0xc0, 0x2f,
+		0x00, 0x9e, 0xc0, 0x24, 0xc0, 0x28, 0x00, 0x6b, 0xc0, 0x23, 0xc0, 0x27, 0x00, 0x67, 0xc0, 0x0a,
+		0xc0, 0x14, 0x00, 0x39, 0xc0, 0x09, 0xc0, 0x13, 0x00, 0x33, 0x00, 0x9d, 0x00, 0x9c, 0x00, 0x3d,
+		0x00, 0x3c, 0x00, 0x35, 0x00, 0x2f, 0x00, 0xff,
+	)
+	// 压缩方法
+	buf = append(buf, 0x01, 0x00)
+	// 扩展
+	buf = binary.BigEndian.AppendUint16(buf, uint16(79+len(data)+len(host)))
+	// session ticket
+	buf = append(buf, 0x00, 0x23)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+	buf = append(buf, data...)
+	// server name
+	buf = append(buf, 0x00, 0x00)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(host)+5))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(host)+3))
+	buf = append(buf, 0x00)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(host)))
+	buf = append(buf, host...)
+	// ec_point_formats
+	buf = append(buf, 0x00, 0x0b, 0x00, 0x04, 0x03, 0x01, 0x00, 0x02)
+	// supported_groups
+	buf = append(buf, 0x00, 0x0a, 0x00, 0x0a, 0x00, 0x08, 0x00, 0x1d, 0x00, 0x17, 0x00, 0x19, 0x00, 0x18)
+	// signature_algorithms
+	buf = append(buf,
+		0x00, 0x0d, 0x00, 0x20, 0x00, 0x1e, 0x06, 0x01, 0x06, 0x02, 0x06, 0x03, 0x05,
+		0x01, 0x05, 0x02, 0x05, 0x03, 0x04, 0x01, 0x04, 0x02, 0x04, 0x03, 0x03, 0x01,
+		0x03, 0x02, 0x03, 0x03, 0x02, 0x01, 0x02, 0x02, 0x02, 0x03,
+	)
+	// encrypt_then_mac
+	buf = append(buf, 0x00, 0x16, 0x00, 0x00)
+	// extended_master_secret
+	buf = append(buf, 0x00, 0x17, 0x00, 0x00)
+	return buf
+}
+
+// serverHello 与simple-obfs一致的ServerHello, ChangeCipherSpec, 数据位于之后的握手记录
+func (sf *TLSConn) serverHello(data []byte) []byte {
+	sf.sidMu.Lock()
+	sessionID := sf.sessionID
+	sf.sidMu.Unlock()
+	if len(sessionID) != sessionIDSize {
+		sessionID = randomBytes(sessionIDSize)
+	}
+
+	buf := make([]byte, 0, 96+6+recordHeaderSize+len(data))
+	// 记录头, tls1.0
+	buf = append(buf, recordHandshake, 0x03, 0x01, 0x00, 91)
+	// ServerHello, tls1.2
+	buf = append(buf, handshakeServerHello, 0x00, 0x00, 87, 0x03, 0x03)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(time.Now().Unix()))
+	buf = append(buf, randomBytes(28)...)
+	buf = append(buf, sessionIDSize)
+	buf = append(buf, sessionID...)
+	// 加密套件 TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256, 压缩方法
+	buf = append(buf, 0xcc, 0xa8, 0x00)
+	// 扩展: renegotiation_info, extended_master_secret, ec_point_formats
+	buf = append(buf, 0x00, 0x0f,
+		0xff, 0x01, 0x00, 0x01, 0x00,
+		0x00, 0x17, 0x00, 0x00,
+		0x00, 0x0b, 0x00, 0x02, 0x01, 0x00,
+	)
+	// ChangeCipherSpec
+	buf = append(buf, recordChangeCipherSpec, 0x03, 0x03, 0x00, 0x01, 0x01)
+	// 加密的握手消息
+	buf = append(buf, recordHandshake, 0x03, 0x03)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+	return append(buf, data...)
+}
+
+// cursor 解析ClientHello
+type cursor []byte
+
+func (c *cursor) skip(n int) bool {
+	if len(*c) < n {
+		return false
+	}
+	*c = (*c)[n:]
+	return true
+}
+
+func (c *cursor) byte() (byte, bool) {
+	if len(*c) < 1 {
+		return 0, false
+	}
+	v := (*c)[0]
+	*c = (*c)[1:]
+	return v, true
+}
+
+func (c *cursor) uint16() (uint16, bool) {
+	if len(*c) < 2 {
+		return 0, false
+	}
+	v := binary.BigEndian.Uint16(*c)
+	*c = (*c)[2:]
+	return v, true
+}
+
+func (c *cursor) bytes8() ([]byte, bool) {
+	n, ok := c.byte()
+	if !ok || len(*c) < int(n) {
+		return nil, false
+	}
+	v := (*c)[:n]
+	*c = (*c)[n:]
+	return v, true
+}
+
+func (c *cursor) bytes16() ([]byte, bool) {
+	n, ok := c.uint16()
+	if !ok || len(*c) < int(n) {
+		return nil, false
+	}
+	v := (*c)[:n]
+	*c = (*c)[n:]
+	return v, true
+}
+
+// CloseWrite 等待当前写入完成后半关闭底层连接的写方向
+func (sf *TLSConn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	return enet.CloseWrite(sf.Conn)
+}
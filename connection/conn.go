@@ -39,10 +39,12 @@ type AdornConn func(conn net.Conn) net.Conn
 type AdornConnsChain []AdornConn
 
 // Client tcp dialer
+// 未设置Forward时, 使用 Happy Eyeballs 连接域名地址
 type Client struct {
 	Timeout     time.Duration   // timeout for dial
 	AdornChains AdornConnsChain // adorn chains
 	Forward     Dialer          // if set it will use forward.
+	Resolver    Resolver        // resolver for happy eyeballs, default: net.DefaultResolver
 }
 
 // Dial connects to the address on the named network.
@@ -52,7 +54,7 @@ func (sf *Client) Dial(network, addr string) (net.Conn, error) {
 
 // DialContext connects to the address on the named network using the provided context.
 func (sf *Client) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
-	var d Dialer = &net.Dialer{Timeout: sf.Timeout}
+	var d Dialer = &HappyEyeballs{Timeout: sf.Timeout, Resolver: sf.Resolver}
 
 	if sf.Forward != nil {
 		d = sf.Forward
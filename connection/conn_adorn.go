@@ -23,12 +23,18 @@ import (
 	"github.com/things-go/encrypt"
 	"go.uber.org/atomic"
 
+	"github.com/thinkgos/jocasta/connection/ccodec"
 	"github.com/thinkgos/jocasta/connection/cencrypt"
 	"github.com/thinkgos/jocasta/connection/cflow"
 	"github.com/thinkgos/jocasta/connection/cgzip"
 	"github.com/thinkgos/jocasta/connection/ciol"
+	"github.com/thinkgos/jocasta/connection/ckex"
+	"github.com/thinkgos/jocasta/connection/clz4"
+	"github.com/thinkgos/jocasta/connection/cobfs"
 	"github.com/thinkgos/jocasta/connection/csnappy"
 	"github.com/thinkgos/jocasta/connection/czlib"
+	"github.com/thinkgos/jocasta/connection/czstd"
+	"github.com/thinkgos/jocasta/connection/proxyproto"
 )
 
 // BaseAdornTLSClient base adorn tls client
@@ -56,6 +62,29 @@ func BaseAdornStcp(method, password string) AdornConn {
 	}
 }
 
+// BaseAdornStcpForwardSecret base adorn X25519 key exchange authenticated by password
+func BaseAdornStcpForwardSecret(password string) AdornConn {
+	psk := ckex.DeriveKey(password)
+	return func(conn net.Conn) net.Conn {
+		return ckex.New(conn, psk)
+	}
+}
+
+// AdornObfsClient 客户端连接混淆, mode http|tls, 与simple-obfs兼容, mode为空时不混淆, 需在其它装饰之前
+// host 为伪装的host, 为空时使用 cobfs.DefaultHost
+func AdornObfsClient(mode, host string) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		return cobfs.NewClient(conn, mode, host)
+	}
+}
+
+// AdornObfsServer 服务端连接混淆, mode http|tls, 与simple-obfs兼容, mode为空时不混淆, 需在其它装饰之前
+func AdornObfsServer(mode string) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		return cobfs.NewServer(conn, mode)
+	}
+}
+
 // AdornSnappy snappy chain
 func AdornSnappy(compress bool) AdornConn {
 	if compress {
@@ -108,6 +137,40 @@ func AdornZlibLevelDict(compress bool, level int, dict []byte) AdornConn {
 	}
 }
 
+// AdornZstd zstd chain
+func AdornZstd(compress bool) AdornConn {
+	return AdornZstdLevelDict(compress, czstd.DefaultLevel, nil)
+}
+
+// AdornZstdLevelDict zstd chain with the level and dict
+// level see czstd package
+func AdornZstdLevelDict(compress bool, level int, dict []byte) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		if compress {
+			return czstd.NewLevelDict(conn, level, dict)
+		}
+		return conn
+	}
+}
+
+// AdornLz4 lz4 chain
+func AdornLz4(compress bool) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		if compress {
+			return clz4.New(conn)
+		}
+		return conn
+	}
+}
+
+// AdornCodec 协商压缩算法, 双方选择都支持的最优压缩算法, codecs 为空时支持全部
+// codecs see ccodec package
+func AdornCodec(codecs ...string) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		return ccodec.New(conn, codecs...)
+	}
+}
+
 // AdornFlow cflow chain
 func AdornFlow(wc, rc, tc *atomic.Uint64) AdornConn {
 	return func(conn net.Conn) net.Conn {
@@ -121,3 +184,17 @@ func AdornIol(opts ...ciol.Options) AdornConn {
 		return ciol.New(conn, opts...)
 	}
 }
+
+// AdornProxyProtocol 服务端解析 PROXY protocol 头, 需在其它装饰之前
+func AdornProxyProtocol(opts ...proxyproto.Options) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		return proxyproto.NewConn(conn, opts...)
+	}
+}
+
+// AdornProxyHeader 客户端连接后立即发送 PROXY protocol 头, 需在其它装饰之前
+func AdornProxyHeader(header *proxyproto.Header) AdornConn {
+	return func(conn net.Conn) net.Conn {
+		return proxyproto.NewClientConn(conn, header)
+	}
+}
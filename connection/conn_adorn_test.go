@@ -2,12 +2,15 @@ package connection
 
 import (
 	"bytes"
+	"io"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/atomic"
 
+	"github.com/thinkgos/jocasta/connection/ccodec"
 	"github.com/thinkgos/jocasta/internal/mock"
 )
 
@@ -30,6 +33,8 @@ this is a testing mock!this is a testing mock!this is a testing mock!this is a t
 		chains := AdornConnsChain{
 			AdornIol(),
 			AdornFlow(wc, rc, tc),
+			AdornZstd(!compress),
+			AdornLz4(compress),
 			AdornSnappy(compress),
 			AdornGzip(!compress),
 			AdornZlib(compress),
@@ -59,3 +64,21 @@ this is a testing mock!this is a testing mock!this is a testing mock!this is a t
 		assert.Equal(t, wc.Load()+rc.Load(), tc.Load())
 	}
 }
+
+func TestAdornCodec(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	want := []byte("this is a testing mock!")
+	client = AdornCodec()(client)
+	server = AdornCodec(ccodec.Lz4, ccodec.None)(server)
+	go func() {
+		client.Write(want) // nolint: errcheck
+	}()
+	got := make([]byte, len(want))
+	_, err := io.ReadFull(server, got)
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+	require.Equal(t, ccodec.Lz4, server.(*ccodec.Conn).Codec())
+}
@@ -19,6 +19,8 @@ import (
 	"net"
 
 	"github.com/golang/snappy"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn is a generic stream-oriented network connection with snappy
@@ -48,3 +50,11 @@ func (sf *Conn) Write(p []byte) (int, error) {
 	err := sf.w.Flush()
 	return n, err
 }
+
+// CloseWrite 刷新缓冲后半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	if err := sf.w.Flush(); err != nil {
+		return err
+	}
+	return enet.CloseWrite(sf.Conn)
+}
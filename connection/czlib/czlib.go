@@ -12,12 +12,16 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// Package czlib 采用zlib压缩实现的net.conn接口
+// Package czlib 采用zlib压缩实现的net.conn接口, 每次写入后flush, 半关闭时写入zlib尾结束流
 package czlib
 
 import (
 	"compress/zlib"
+	"io"
 	"net"
+	"sync"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Conn is a generic stream-oriented network connection with gzip
@@ -25,6 +29,12 @@ type Conn struct {
 	net.Conn
 	level int
 	dict  []byte
+
+	rmu sync.Mutex
+	r   io.ReadCloser
+
+	wmu sync.Mutex
+	w   *zlib.Writer
 }
 
 // New new a zlib compress
@@ -42,28 +52,57 @@ func NewLevel(conn net.Conn, level int) *Conn {
 // level see zlib package
 func NewLevelDict(conn net.Conn, level int, dict []byte) *Conn {
 	return &Conn{
-		conn,
-		level,
-		dict,
+		Conn:  conn,
+		level: level,
+		dict:  dict,
 	}
 }
 
 // Read reads data from the connection.
 func (sf *Conn) Read(p []byte) (int, error) {
-	r, err := zlib.NewReaderDict(sf.Conn, sf.dict)
-	if err != nil {
-		return 0, err
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if sf.r == nil {
+		r, err := zlib.NewReaderDict(sf.Conn, sf.dict)
+		if err != nil {
+			return 0, err
+		}
+		sf.r = r
 	}
-	return r.Read(p)
+	return sf.r.Read(p)
 }
 
 // Write writes data to the connection.
 func (sf *Conn) Write(p []byte) (int, error) {
-	w, err := zlib.NewWriterLevelDict(sf.Conn, sf.level, sf.dict)
-	if err != nil {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if err := sf.initWriter(); err != nil {
 		return 0, err
 	}
-	n, _ := w.Write(p)
-	err = w.Flush()
+	n, _ := sf.w.Write(p)
+	err := sf.w.Flush()
 	return n, err
 }
+
+// CloseWrite 写入zlib尾后半关闭底层连接
+func (sf *Conn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	if err := sf.initWriter(); err != nil {
+		return err
+	}
+	if err := sf.w.Close(); err != nil {
+		return err
+	}
+	return enet.CloseWrite(sf.Conn)
+}
+
+func (sf *Conn) initWriter() (err error) {
+	if sf.w == nil {
+		sf.w, err = zlib.NewWriterLevelDict(sf.Conn, sf.level, sf.dict)
+	}
+	return err
+}
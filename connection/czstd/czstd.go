@@ -0,0 +1,165 @@
+// Copyright [2020] [thinkgos] thinkgo@aliyun.com
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package czstd 采用zstd压缩实现的net.conn接口.
+// 每次写入的数据按块压缩为独立的zstd帧: [帧长度(4字节,小端)][zstd帧], 每块最大64KB
+package czstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// DefaultLevel 默认压缩等级, 与zstd的默认等级一致
+const DefaultLevel = 3
+
+const (
+	maxBlockSize = 64 << 10            // 每块最大数据长度
+	maxFrameSize = 2*maxBlockSize + 64 // 每帧最大长度, 防止对端声明过大的帧
+	lengthSize   = 4
+)
+
+// ErrFrameTooLarge 帧长度超出限制
+var ErrFrameTooLarge = errors.New("czstd: frame too large")
+
+// dictMagic zstd字典的magic, 不含此magic的字典作为原始内容字典
+var dictMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// Conn is a generic stream-oriented network connection with zstd
+type Conn struct {
+	net.Conn
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+	err error
+
+	rmu   sync.Mutex
+	rbuf  []byte
+	rleft []byte
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// New new a zstd compress with default level
+func New(conn net.Conn) *Conn {
+	return NewLevelDict(conn, DefaultLevel, nil)
+}
+
+// NewLevelDict new a zstd compress with the level and dict
+// level 1~22, 对应zstd的压缩等级, dict 可以是zstd格式的字典或原始内容, 两端须一致
+func NewLevelDict(conn net.Conn, level int, dict []byte) *Conn {
+	eopts := []zstd.EOption{
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithZeroFrames(true),
+	}
+	dopts := []zstd.DOption{
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxMemory(maxBlockSize),
+	}
+	if len(dict) > 0 {
+		if bytes.HasPrefix(dict, dictMagic) {
+			eopts = append(eopts, zstd.WithEncoderDict(dict))
+			dopts = append(dopts, zstd.WithDecoderDicts(dict))
+		} else {
+			eopts = append(eopts, zstd.WithEncoderDictRaw(0, dict))
+			dopts = append(dopts, zstd.WithDecoderDictRaw(0, dict))
+		}
+	}
+
+	sf := &Conn{Conn: conn}
+	if sf.enc, sf.err = zstd.NewWriter(nil, eopts...); sf.err != nil {
+		return sf
+	}
+	sf.dec, sf.err = zstd.NewReader(nil, dopts...)
+	return sf
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(p []byte) (int, error) {
+	if sf.err != nil {
+		return 0, sf.err
+	}
+
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if len(sf.rleft) == 0 {
+		var lb [lengthSize]byte
+		if _, err := io.ReadFull(sf.Conn, lb[:]); err != nil {
+			return 0, err
+		}
+		size := binary.LittleEndian.Uint32(lb[:])
+		if size > maxFrameSize {
+			return 0, ErrFrameTooLarge
+		}
+		frame := make([]byte, size)
+		if _, err := io.ReadFull(sf.Conn, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		data, err := sf.dec.DecodeAll(frame, sf.rbuf[:0])
+		if err != nil {
+			return 0, err
+		}
+		sf.rbuf, sf.rleft = data, data
+	}
+	n := copy(p, sf.rleft)
+	sf.rleft = sf.rleft[n:]
+	return n, nil
+}
+
+// Write writes data to the connection.
+func (sf *Conn) Write(p []byte) (int, error) {
+	if sf.err != nil {
+		return 0, sf.err
+	}
+
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	n := 0
+	for n < len(p) {
+		size := len(p) - n
+		if size > maxBlockSize {
+			size = maxBlockSize
+		}
+		buf := append(sf.wbuf[:0], 0, 0, 0, 0)
+		buf = sf.enc.EncodeAll(p[n:n+size], buf)
+		binary.LittleEndian.PutUint32(buf, uint32(len(buf)-lengthSize))
+		sf.wbuf = buf
+		if _, err := sf.Conn.Write(buf); err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+// CloseWrite 等待当前写入完成后半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	return enet.CloseWrite(sf.Conn)
+}
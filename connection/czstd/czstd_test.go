@@ -0,0 +1,67 @@
+package czstd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/internal/mock"
+)
+
+func TestConn(t *testing.T) {
+	data := bytes.Repeat([]byte("hello world"), 100)
+
+	t.Run("invalid zstd", func(t *testing.T) {
+		mconn := mock.New(new(bytes.Buffer))
+		_, err := mconn.Write([]byte("aaaaaaaaaa"))
+		require.NoError(t, err)
+
+		conn := New(mconn)
+		_, err = conn.Read(make([]byte, 10))
+		require.Error(t, err)
+	})
+
+	t.Run("invalid dict", func(t *testing.T) {
+		dict := append(append([]byte{}, dictMagic...), 0x01, 0x02)
+		conn := NewLevelDict(mock.New(new(bytes.Buffer)), DefaultLevel, dict)
+
+		_, err := conn.Write(data)
+		require.Error(t, err)
+	})
+
+	for _, dict := range [][]byte{nil, []byte("hello world, raw content dict")} {
+		buf := new(bytes.Buffer)
+		conn := NewLevelDict(mock.New(buf), DefaultLevel, dict)
+
+		n, err := conn.Write(data)
+		require.NoError(t, err)
+		require.Equal(t, len(data), n)
+		require.Less(t, buf.Len(), len(data))
+
+		rd := make([]byte, len(data))
+		n, err = io.ReadFull(conn, rd)
+		require.NoError(t, err)
+		require.Equal(t, data, rd[:n])
+	}
+}
+
+func TestConnStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	c, s := New(client), New(server)
+	for i := 0; i < 3; i++ {
+		want := bytes.Repeat([]byte{byte('a' + i)}, 50000*(i+1))
+		go func() {
+			c.Write(want) // nolint: errcheck
+		}()
+		got := make([]byte, len(want))
+		_, err := io.ReadFull(s, got)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	}
+}
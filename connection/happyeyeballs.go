@@ -0,0 +1,249 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// Happy Eyeballs 默认值, see RFC 8305
+const (
+	DefaultResolutionDelay = 50 * time.Millisecond  // 先得到非优先地址族的解析结果时, 等待优先地址族结果的时间
+	DefaultAttemptDelay    = 250 * time.Millisecond // 相邻两次连接尝试的间隔
+	familyCacheTTL         = 10 * time.Minute       // 地址族缓存时间
+	familyCacheSize        = 4096                   // 地址族缓存最大条目数
+)
+
+// Resolver 域名解析, network 为 ip4 或 ip6, net.Resolver 和 idns.Resolver 均实现了此接口
+type Resolver interface {
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// HappyEyeballs 实现 Happy Eyeballs v2(RFC 8305) 的tcp dialer
+// 同时解析A和AAAA记录, 两个地址族交替, 间隔AttemptDelay发起连接尝试, 使用最先建立的连接,
+// 并记录每个主机最近连接成功的地址族, 下次优先使用
+type HappyEyeballs struct {
+	Timeout         time.Duration // 每次连接尝试的超时时间
+	LocalAddr       net.Addr      // 本地地址, 为ip地址时仅使用对应的地址族
+	Resolver        Resolver      // 域名解析, default: net.DefaultResolver
+	ResolutionDelay time.Duration // default: DefaultResolutionDelay
+	AttemptDelay    time.Duration // default: DefaultAttemptDelay
+}
+
+type lookupResult struct {
+	family int
+	ips    []net.IP
+	err    error
+}
+
+type attemptResult struct {
+	family int
+	conn   net.Conn
+	err    error
+}
+
+// 地址族下标
+const (
+	familyIPv4 = iota
+	familyIPv6
+)
+
+// Dial connects to the address on the named network.
+func (sf *HappyEyeballs) Dial(network, address string) (net.Conn, error) {
+	return sf.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address on the named network using the provided context.
+// 仅network为tcp且主机为域名时使用 Happy Eyeballs, 其它直接连接
+func (sf *HappyEyeballs) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: sf.Timeout, LocalAddr: sf.LocalAddr}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || network != "tcp" || net.ParseIP(host) != nil {
+		return d.DialContext(ctx, network, address)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+
+	families := []int{familyIPv6, familyIPv4}
+	if defaultFamilyCache.get(host) == familyIPv4 {
+		families = []int{familyIPv4, familyIPv6}
+	}
+	if local, ok := sf.LocalAddr.(*net.TCPAddr); ok && local != nil && local.IP != nil && !local.IP.IsUnspecified() {
+		if local.IP.To4() != nil {
+			families = []int{familyIPv4}
+		} else {
+			families = []int{familyIPv6}
+		}
+	}
+	primary := families[0]
+
+	lookups := make(chan lookupResult, len(families))
+	for _, family := range families {
+		go func(family int) {
+			ips, err := sf.lookupIP(ctx, family, host)
+			lookups <- lookupResult{family, ips, err}
+		}(family)
+	}
+
+	var (
+		addrs       [2][]net.IP
+		next        = primary
+		pending     = len(families) // 未完成的解析数
+		inflight    int             // 进行中的连接尝试数
+		ready       bool            // 解析完成, 可以开始连接
+		fire        bool            // 到达连接尝试间隔, 可以开始下一次尝试
+		resolutionC <-chan time.Time
+		attemptC    <-chan time.Time
+		firstErr    error
+	)
+	attempts := make(chan attemptResult)
+
+	for {
+		if ready && (inflight == 0 || fire) && len(addrs[0])+len(addrs[1]) > 0 {
+			// 两个地址族交替
+			family := next
+			if len(addrs[family]) == 0 {
+				family = 1 - family
+			}
+			ip := addrs[family][0]
+			addrs[family] = addrs[family][1:]
+			next = 1 - family
+
+			inflight++
+			fire = false
+			attemptC = time.After(sf.attemptDelay())
+			go func(family int, addr string) {
+				conn, err := d.DialContext(ctx, network, addr)
+				select {
+				case attempts <- attemptResult{family, conn, err}:
+				case <-done:
+					if conn != nil {
+						conn.Close() // nolint: errcheck
+					}
+				}
+			}(family, net.JoinHostPort(ip.String(), port))
+		}
+		if pending == 0 && inflight == 0 && len(addrs[0])+len(addrs[1]) == 0 {
+			if firstErr == nil {
+				firstErr = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return nil, firstErr
+		}
+
+		select {
+		case r := <-lookups:
+			pending--
+			if r.err != nil {
+				if firstErr == nil {
+					firstErr = r.err
+				}
+			} else {
+				addrs[r.family] = append(addrs[r.family], r.ips...)
+			}
+			// 优先地址族的结果到达或全部解析完成时开始连接,
+			// 非优先地址族的结果先到达时, 等待优先地址族的结果 ResolutionDelay
+			if !ready {
+				if r.family == primary || pending == 0 {
+					ready = true
+				} else if len(r.ips) > 0 {
+					resolutionC = time.After(sf.resolutionDelay())
+				}
+			}
+		case <-resolutionC:
+			ready = true
+		case <-attemptC:
+			fire = true
+		case r := <-attempts:
+			inflight--
+			if r.err == nil {
+				defaultFamilyCache.set(host, r.family)
+				return r.conn, nil
+			}
+			if firstErr == nil || isDNSError(firstErr) {
+				firstErr = r.err
+			}
+			// 失败后立即开始下一次尝试
+			fire = true
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (sf *HappyEyeballs) lookupIP(ctx context.Context, family int, host string) ([]net.IP, error) {
+	var r Resolver = net.DefaultResolver
+
+	if sf.Resolver != nil {
+		r = sf.Resolver
+	}
+	network := "ip4"
+	if family == familyIPv6 {
+		network = "ip6"
+	}
+	return r.LookupIP(ctx, network, host)
+}
+
+func (sf *HappyEyeballs) resolutionDelay() time.Duration {
+	if sf.ResolutionDelay > 0 {
+		return sf.ResolutionDelay
+	}
+	return DefaultResolutionDelay
+}
+
+func (sf *HappyEyeballs) attemptDelay() time.Duration {
+	if sf.AttemptDelay > 0 {
+		return sf.AttemptDelay
+	}
+	return DefaultAttemptDelay
+}
+
+func isDNSError(err error) bool {
+	var e *net.DNSError
+	return errors.As(err, &e)
+}
+
+// defaultFamilyCache 每个主机最近连接成功的地址族
+var defaultFamilyCache = &familyCache{m: make(map[string]familyEntry)}
+
+type familyEntry struct {
+	family    int
+	expiredAt time.Time
+}
+
+type familyCache struct {
+	mu sync.Mutex
+	m  map[string]familyEntry
+}
+
+// get 返回host最近连接成功的地址族, 没有时返回-1
+func (sf *familyCache) get(host string) int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if e, ok := sf.m[host]; ok && time.Now().Before(e.expiredAt) {
+		return e.family
+	}
+	return -1
+}
+
+func (sf *familyCache) set(host string, family int) {
+	now := time.Now()
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if _, ok := sf.m[host]; !ok && len(sf.m) >= familyCacheSize {
+		for k, e := range sf.m {
+			if !now.Before(e.expiredAt) {
+				delete(sf.m, k)
+			}
+		}
+		if len(sf.m) >= familyCacheSize {
+			sf.m = make(map[string]familyEntry)
+		}
+	}
+	sf.m[host] = familyEntry{family, now.Add(familyCacheTTL)}
+}
@@ -0,0 +1,106 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testResolver struct {
+	ip4, ip6       []net.IP
+	delay4, delay6 time.Duration
+}
+
+func (sf testResolver) LookupIP(ctx context.Context, network, _ string) ([]net.IP, error) {
+	ips, delay := sf.ip4, sf.delay4
+	if network == "ip6" {
+		ips, delay = sf.ip6, sf.delay6
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no record")
+	}
+	return ips, nil
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() // nolint: errcheck
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	loopback4 := []net.IP{net.ParseIP("127.0.0.1")}
+	// 文档地址, 连接不可达或超时
+	unreachable6 := []net.IP{net.ParseIP("2001:db8::1")}
+
+	t.Run("fallback to ipv4", func(t *testing.T) {
+		d := &HappyEyeballs{
+			Timeout:      time.Second * 3,
+			Resolver:     testResolver{ip4: loopback4, ip6: unreachable6, delay4: time.Millisecond * 100},
+			AttemptDelay: time.Millisecond * 50,
+		}
+		start := time.Now()
+		c, err := d.Dial("tcp", net.JoinHostPort("fallback.test", port))
+		require.NoError(t, err)
+		c.Close()
+		assert.Less(t, time.Since(start), time.Second)
+		assert.Equal(t, familyIPv4, defaultFamilyCache.get("fallback.test"))
+	})
+
+	t.Run("ipv6 lookup failed", func(t *testing.T) {
+		d := &HappyEyeballs{Resolver: testResolver{ip4: loopback4}}
+		c, err := d.Dial("tcp", net.JoinHostPort("v4only.test", port))
+		require.NoError(t, err)
+		c.Close()
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		d := &HappyEyeballs{Resolver: testResolver{}}
+		_, err := d.Dial("tcp", net.JoinHostPort("none.test", port))
+		require.Error(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		d = &HappyEyeballs{Resolver: testResolver{ip4: loopback4, delay4: time.Second, delay6: time.Second}}
+		_, err = d.DialContext(ctx, "tcp", net.JoinHostPort("slow.test", port))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("ip address", func(t *testing.T) {
+		d := &HappyEyeballs{Resolver: testResolver{}}
+		c, err := d.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		c.Close()
+	})
+}
+
+func TestFamilyCache(t *testing.T) {
+	c := &familyCache{m: make(map[string]familyEntry)}
+	assert.Equal(t, -1, c.get("a"))
+	c.set("a", familyIPv6)
+	assert.Equal(t, familyIPv6, c.get("a"))
+	c.set("a", familyIPv4)
+	assert.Equal(t, familyIPv4, c.get("a"))
+
+	for i := 0; i < familyCacheSize+1; i++ {
+		c.set(string(rune(i+0x4e00)), familyIPv4)
+	}
+	assert.LessOrEqual(t, len(c.m), familyCacheSize)
+}
@@ -0,0 +1,154 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ListenConfig 监听配置
+type ListenConfig struct {
+	// 使用SO_REUSEPORT在同一地址上打开的socket数, 每个socket有独立的Accept协程,
+	// 由内核在socket间分配新连接, <= 1 表示不使用, 仅linux支持
+	ReusePort int
+	// 开启TCP Fast Open, 仅linux支持
+	FastOpen bool
+}
+
+// fastOpenQueueLen TCP Fast Open 未完成握手的请求队列长度
+const fastOpenQueueLen = 256
+
+// Listen announces on the local network address with config and afterChains
+func (sf ListenConfig) Listen(network, addr string, chains ...AdornConn) (net.Listener, error) {
+	if sf.ReusePort <= 1 && !sf.FastOpen {
+		return Listen(network, addr, chains...)
+	}
+
+	lc := net.ListenConfig{Control: sf.control}
+	first, err := lc.Listen(context.Background(), network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if sf.ReusePort <= 1 {
+		return NewListener(first, chains...), nil
+	}
+
+	listeners := []net.Listener{first}
+	// 端口为0时, 其余的socket使用第一个socket分配的端口
+	addr = first.Addr().String()
+	for i := 1; i < sf.ReusePort; i++ {
+		ln, err := lc.Listen(context.Background(), network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close() // nolint: errcheck
+			}
+			return nil, err
+		}
+		listeners = append(listeners, ln)
+	}
+	for i, ln := range listeners {
+		listeners[i] = NewListener(ln, chains...)
+	}
+	return newMultiListener(listeners), nil
+}
+
+// Listeners 返回ln的每个socket对应的listener, 用于每个socket独立的Accept及服务协程,
+// 见 ListenConfig.ReusePort, ln不是由多个socket组成时返回ln本身
+func Listeners(ln net.Listener) []net.Listener {
+	if ml, ok := ln.(*multiListener); ok {
+		return ml.listeners
+	}
+	return []net.Listener{ln}
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener 由多个socket组成的listener, 见 Listeners.
+// 直接调用Accept时才启动每个socket的Accept协程, 合并为一个
+type multiListener struct {
+	listeners []net.Listener
+	startOnce sync.Once
+	accepts   chan acceptResult
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	return &multiListener{
+		listeners: listeners,
+		accepts:   make(chan acceptResult),
+		die:       make(chan struct{}),
+	}
+}
+
+// acceptLoop 临时错误(如 EMFILE)时退避重试, 同 net/http, 其它错误交给Accept并退出
+func (sf *multiListener) acceptLoop(ln net.Listener) {
+	var tempDelay time.Duration
+	for {
+		conn, err := ln.Accept()
+		if ne, ok := err.(net.Error); ok && ne.Temporary() { // nolint: staticcheck
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if tempDelay > time.Second {
+				tempDelay = time.Second
+			}
+			select {
+			case <-time.After(tempDelay):
+				continue
+			case <-sf.die:
+				return
+			}
+		}
+		tempDelay = 0
+		select {
+		case sf.accepts <- acceptResult{conn, err}:
+		case <-sf.die:
+			if conn != nil {
+				conn.Close() // nolint: errcheck
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Accept waits for and returns the next connection from any listener.
+func (sf *multiListener) Accept() (net.Conn, error) {
+	sf.startOnce.Do(func() {
+		for _, ln := range sf.listeners {
+			go sf.acceptLoop(ln)
+		}
+	})
+	select {
+	case r := <-sf.accepts:
+		return r.conn, r.err
+	case <-sf.die:
+		return nil, &net.OpError{Op: "accept", Net: sf.Addr().Network(), Addr: sf.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Close closes all the listeners.
+func (sf *multiListener) Close() error {
+	var err error
+	sf.closeOnce.Do(func() {
+		close(sf.die)
+		for _, ln := range sf.listeners {
+			if e := ln.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the listener's network address.
+func (sf *multiListener) Addr() net.Addr { return sf.listeners[0].Addr() }
@@ -0,0 +1,27 @@
+package connection
+
+import (
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func (sf ListenConfig) control(network, _ string, c syscall.RawConn) error {
+	var err error
+
+	cerr := c.Control(func(fd uintptr) {
+		if sf.ReusePort > 1 {
+			if err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+				return
+			}
+		}
+		if sf.FastOpen && strings.HasPrefix(network, "tcp") {
+			err = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN, fastOpenQueueLen)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package connection
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenConfig(t *testing.T) {
+	for _, lc := range []ListenConfig{
+		{},
+		{FastOpen: true},
+		{ReusePort: 4},
+		{ReusePort: 4, FastOpen: true},
+	} {
+		// 装饰链在每个socket的Accept协程中执行
+		var adorned int32
+		ln, err := lc.Listen("tcp", "127.0.0.1:0", func(c net.Conn) net.Conn {
+			atomic.AddInt32(&adorned, 1)
+			return c
+		})
+		require.NoError(t, err)
+		require.NotZero(t, ln.Addr().(*net.TCPAddr).Port)
+
+		const count = 16
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < count; i++ {
+				c, err := ln.Accept()
+				if !assert.NoError(t, err) {
+					return
+				}
+				c.Close()
+			}
+		}()
+		for i := 0; i < count; i++ {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			require.NoError(t, err)
+			c.Close()
+		}
+		<-done
+		assert.Equal(t, int32(count), atomic.LoadInt32(&adorned))
+
+		require.NoError(t, ln.Close())
+		_, err = ln.Accept()
+		require.Error(t, err)
+	}
+}
+
+func TestListeners(t *testing.T) {
+	ln, err := ListenConfig{}.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, []net.Listener{ln}, Listeners(ln))
+	require.NoError(t, ln.Close())
+
+	var adorned int32
+	ln, err = ListenConfig{ReusePort: 4}.Listen("tcp", "127.0.0.1:0", func(c net.Conn) net.Conn {
+		atomic.AddInt32(&adorned, 1)
+		return c
+	})
+	require.NoError(t, err)
+	listeners := Listeners(ln)
+	require.Len(t, listeners, 4)
+
+	// 每个socket独立Accept
+	const count = 32
+	accepted := make(chan struct{}, count)
+	for _, l := range listeners {
+		assert.Equal(t, ln.Addr().String(), l.Addr().String())
+		go func(l net.Listener) {
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				c.Close()
+				accepted <- struct{}{}
+			}
+		}(l)
+	}
+	for i := 0; i < count; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		require.NoError(t, err)
+		c.Close()
+	}
+	for i := 0; i < count; i++ {
+		<-accepted
+	}
+	assert.Equal(t, int32(count), atomic.LoadInt32(&adorned))
+
+	require.NoError(t, ln.Close())
+	for _, l := range listeners {
+		_, err = l.Accept()
+		require.Error(t, err)
+	}
+}
+
+type tempErr struct{}
+
+func (tempErr) Error() string   { return "too many open files" }
+func (tempErr) Timeout() bool   { return false }
+func (tempErr) Temporary() bool { return true }
+
+// tempErrListener 前n次Accept返回临时错误
+type tempErrListener struct {
+	net.Listener
+	n int32
+}
+
+func (sf *tempErrListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&sf.n, -1) >= 0 {
+		return nil, tempErr{}
+	}
+	return sf.Listener.Accept()
+}
+
+func TestMultiListenerTemporaryError(t *testing.T) {
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l2, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := newMultiListener([]net.Listener{&tempErrListener{Listener: l1, n: 3}, l2})
+	defer ln.Close() // nolint: errcheck
+
+	for _, addr := range []string{l1.Addr().String(), l2.Addr().String()} {
+		c, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		defer c.Close() // nolint: errcheck
+	}
+	for i := 0; i < 2; i++ {
+		c, err := ln.Accept()
+		require.NoError(t, err)
+		c.Close()
+	}
+}
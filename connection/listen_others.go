@@ -0,0 +1,12 @@
+//go:build !linux
+
+package connection
+
+import (
+	"errors"
+	"syscall"
+)
+
+func (sf ListenConfig) control(string, string, syscall.RawConn) error {
+	return errors.New("reuse port and tcp fast open only supported on linux")
+}
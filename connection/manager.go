@@ -6,14 +6,28 @@ import (
 	"time"
 
 	cmap "github.com/orcaman/concurrent-map"
+	"go.uber.org/atomic"
 )
 
-// Manager 管理key
+// Manager 管理key, 仅通过其方法访问, 以保证超时淘汰的计时与key一致
 type Manager struct {
-	cmap.ConcurrentMap
+	m        cmap.ConcurrentMap
 	interval time.Duration
 	// gc回调,返回true将删除对应的key
 	gcIterCb func(key string, value interface{}, now time.Time) bool
+
+	// 超时淘汰, 见 NewExpiry
+	ttl       time.Duration
+	expiredCb func(key string, value interface{})
+	wheel     *wheel
+	timers    cmap.ConcurrentMap // key -> *expiry
+	expired   atomic.Uint64
+}
+
+// Stats manager统计
+type Stats struct {
+	Live    int    `json:"live"`    // 当前key数
+	Expired uint64 `json:"expired"` // 累计回收的key数
 }
 
 // New a manager
@@ -21,15 +35,118 @@ type Manager struct {
 // gcIterCb: 回收回调函数, 当间隔到后,检查所有的key,value,返回true将删除对应的key
 func New(gcInterval time.Duration, gcIterCb func(key string, value interface{}, now time.Time) bool) *Manager {
 	return &Manager{
-		cmap.New(),
-		gcInterval,
-		gcIterCb,
+		m:        cmap.New(),
+		interval: gcInterval,
+		gcIterCb: gcIterCb,
+	}
+}
+
+// NewExpiry 超时淘汰的manager, 使用时间轮管理key的过期, 每次回收只检查到期的key
+// tick: 时间轮精度, 即回收间隔, <=0将不启动
+// ttl: key超过ttl未Set或Touch将被删除
+// expiredCb: 过期回调, 可为nil
+// 只有通过Set加入的key才会过期, 删除key请使用Remove
+func NewExpiry(tick, ttl time.Duration, expiredCb func(key string, value interface{})) *Manager {
+	sf := &Manager{
+		m:         cmap.New(),
+		interval:  tick,
+		ttl:       ttl,
+		expiredCb: expiredCb,
+		timers:    cmap.New(),
+	}
+	if tick > 0 {
+		sf.wheel = newWheel(tick, ttl, time.Now())
+	}
+	return sf
+}
+
+// Set 设置key, 超时淘汰时重新计时
+func (sf *Manager) Set(key string, value interface{}) {
+	sf.Replace(key, value)
+}
+
+// Replace 设置key并返回被替换的旧值, 超时淘汰时重新计时
+func (sf *Manager) Replace(key string, value interface{}) (old interface{}, loaded bool) {
+	var e *expiry
+	var now time.Time
+	if sf.wheel != nil {
+		now = time.Now()
+		e = &expiry{key: key}
+		e.deadline.Store(now.Add(sf.ttl).UnixNano())
+	}
+	sf.m.Upsert(key, value, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
+		old, loaded = valueInMap, exist
+		if e != nil {
+			if t, ok := sf.timers.Pop(key); ok {
+				t.(*expiry).stale.Store(true)
+			}
+			sf.timers.Set(key, e)
+		}
+		return newValue
+	})
+	if e != nil {
+		sf.wheel.add(e, now)
+	}
+	return old, loaded
+}
+
+// Get 获取key对应的值
+func (sf *Manager) Get(key string) (interface{}, bool) {
+	return sf.m.Get(key)
+}
+
+// Has key是否存在
+func (sf *Manager) Has(key string) bool {
+	return sf.m.Has(key)
+}
+
+// Count key数
+func (sf *Manager) Count() int {
+	return sf.m.Count()
+}
+
+// Items 所有key及其值的快照
+func (sf *Manager) Items() map[string]interface{} {
+	return sf.m.Items()
+}
+
+// Remove 删除key
+func (sf *Manager) Remove(key string) {
+	if sf.wheel == nil {
+		sf.m.Remove(key)
+		return
+	}
+	sf.m.RemoveCb(key, func(key string, _ interface{}, exists bool) bool {
+		if old, ok := sf.timers.Pop(key); ok {
+			old.(*expiry).stale.Store(true)
+		}
+		return exists
+	})
+}
+
+// Touch 超时淘汰时重新计时, key不存在返回false
+func (sf *Manager) Touch(key string) bool {
+	if sf.wheel == nil {
+		return false
+	}
+	v, ok := sf.timers.Get(key)
+	if ok {
+		v.(*expiry).deadline.Store(time.Now().Add(sf.ttl).UnixNano())
+	}
+	return ok
+}
+
+// Stats 统计
+func (sf *Manager) Stats() Stats {
+	return Stats{
+		Live:    sf.Count(),
+		Expired: sf.expired.Load(),
 	}
 }
 
 // Watch watch conn interval,should run in a goroutine
 func (sf *Manager) Watch(ctx context.Context) {
-	if sf.interval <= 0 || sf.gcIterCb == nil {
+	if sf.interval <= 0 || (sf.gcIterCb == nil && sf.wheel == nil) {
 		return
 	}
 	var now time.Time
@@ -42,10 +159,42 @@ func (sf *Manager) Watch(ctx context.Context) {
 			return
 		case now = <-timer.C:
 		}
-		// TODO: 优化删除策略
+		if sf.wheel != nil {
+			sf.expire(now)
+			continue
+		}
 		for k, v := range sf.Items() {
 			if sf.gcIterCb(k, v, now) {
-				sf.Remove(k)
+				sf.m.Remove(k)
+				sf.expired.Inc()
+			}
+		}
+	}
+}
+
+// expire 删除时间轮中到期的key
+func (sf *Manager) expire(now time.Time) {
+	for _, e := range sf.wheel.advance(now) {
+		if e.stale.Load() {
+			continue
+		}
+		if e.deadline.Load() > now.UnixNano() {
+			sf.wheel.add(e, now)
+			continue
+		}
+
+		var value interface{}
+		removed := sf.m.RemoveCb(e.key, func(key string, v interface{}, exists bool) bool {
+			current := sf.timers.RemoveCb(key, func(_ string, t interface{}, ok bool) bool {
+				return ok && t == e
+			})
+			value = v
+			return current && exists
+		})
+		if removed {
+			sf.expired.Inc()
+			if sf.expiredCb != nil {
+				sf.expiredCb(e.key, value)
 			}
 		}
 	}
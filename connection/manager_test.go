@@ -44,3 +44,98 @@ func TestManager(t *testing.T) {
 		mag.Watch(context.Background())
 	})
 }
+
+func TestManagerExpiry(t *testing.T) {
+	t.Run("expire", func(t *testing.T) {
+		var expired []string
+
+		mag := NewExpiry(time.Millisecond*100, time.Second, func(key string, value interface{}) {
+			expired = append(expired, key+"="+value.(string))
+		})
+		now := mag.wheel.last
+		mag.Set("foo", "fooValue")
+		mag.Set("bar", "barValue")
+		mag.Set("car", "carValue")
+		mag.Set("car", "carValue2")
+		mag.Remove("bar")
+		assert.False(t, mag.Touch("bar"))
+
+		require.True(t, mag.Touch("foo"))
+		// 模拟在各个时刻Touch
+		touch := func(at time.Time) {
+			v, _ := mag.timers.Get("foo")
+			v.(*expiry).deadline.Store(at.Add(time.Second).UnixNano())
+		}
+		for i := 1; i <= 8; i++ {
+			at := now.Add(time.Millisecond * 100 * time.Duration(i))
+			touch(at)
+			mag.expire(at)
+		}
+		assert.Empty(t, expired)
+
+		mag.expire(now.Add(time.Millisecond * 1500))
+		assert.Equal(t, []string{"car=carValue2"}, expired)
+		assert.Equal(t, Stats{Live: 1, Expired: 1}, mag.Stats())
+
+		_, ok := mag.Get("foo")
+		assert.True(t, ok)
+		mag.expire(now.Add(time.Second * 3))
+		assert.Equal(t, []string{"car=carValue2", "foo=fooValue"}, expired)
+		assert.Equal(t, Stats{Live: 0, Expired: 2}, mag.Stats())
+		assert.Equal(t, 0, mag.timers.Count())
+	})
+
+	t.Run("watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		done := make(chan string, 1)
+		mag := NewExpiry(time.Millisecond*10, time.Millisecond*50, func(key string, value interface{}) {
+			done <- key
+		})
+		go mag.Watch(ctx)
+		mag.Set("foo", "fooValue")
+		select {
+		case key := <-done:
+			assert.Equal(t, "foo", key)
+		case <-time.After(time.Second):
+			t.Fatal("key not expired")
+		}
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		var expired []string
+		mag := NewExpiry(time.Millisecond*100, time.Second, func(key string, value interface{}) {
+			expired = append(expired, key+"="+value.(string))
+		})
+		now := mag.wheel.last
+		_, ok := mag.Replace("foo", "fooValue")
+		assert.False(t, ok)
+		old, ok := mag.Replace("foo", "fooValue2")
+		require.True(t, ok)
+		assert.Equal(t, "fooValue", old)
+		assert.Equal(t, 1, mag.timers.Count())
+
+		mag.expire(now.Add(time.Second * 3))
+		assert.Equal(t, []string{"foo=fooValue2"}, expired)
+		assert.Equal(t, 0, mag.timers.Count())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mag := NewExpiry(0, time.Second, nil)
+		mag.Set("foo", "fooValue")
+		assert.False(t, mag.Touch("foo"))
+		mag.Remove("foo")
+		assert.Equal(t, 0, mag.Count())
+		mag.Watch(context.Background())
+	})
+}
+
+func BenchmarkManagerTouch(b *testing.B) {
+	mag := NewExpiry(time.Second, time.Minute, nil)
+	mag.Set("foo", "fooValue")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mag.Touch("foo")
+	}
+}
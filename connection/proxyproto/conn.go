@@ -0,0 +1,231 @@
+package proxyproto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// DefaultReadHeaderTimeout 默认读取PROXY protocol头的超时时间
+const DefaultReadHeaderTimeout = 5 * time.Second
+
+// Options Conn options
+type Options func(*Conn)
+
+// WithReadHeaderTimeout 读取PROXY protocol头的超时时间, <=0 表示不超时
+func WithReadHeaderTimeout(t time.Duration) Options {
+	return func(c *Conn) {
+		c.timeout = t
+	}
+}
+
+// WithRequired 是否必须有PROXY protocol头, 默认false, 没有时使用连接的真实地址
+func WithRequired(required bool) Options {
+	return func(c *Conn) {
+		c.required = required
+	}
+}
+
+// WithTrusted 信任的上游网段, 设置后只接受来自这些网段的连接且必须有PROXY protocol头,
+// 其它连接返回 ErrUntrustedUpstream; 未设置时接受任何连接的头, 直连的客户端可伪造源地址.
+// unix domain socket的访问由文件权限控制, 总是信任
+func WithTrusted(trusted []*net.IPNet) Options {
+	return func(c *Conn) {
+		c.trusted = trusted
+	}
+}
+
+// ParseTrusted 解析信任的上游网段, 格式为CIDR或IP
+func ParseTrusted(cidrs []string) ([]*net.IPNet, error) {
+	trusted := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("proxyproto: invalid trusted ip %s", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("proxyproto: invalid trusted cidr %s", cidr)
+		}
+		trusted = append(trusted, ipNet)
+	}
+	return trusted, nil
+}
+
+// Conn 服务端连接, 在第一次Read, RemoteAddr, LocalAddr时解析PROXY protocol头,
+// 解析成功后 RemoteAddr, LocalAddr 返回头中的源地址和目的地址
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	timeout  time.Duration
+	required bool
+	trusted  []*net.IPNet
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+// NewConn new server conn with options
+func NewConn(c net.Conn, opts ...Options) *Conn {
+	conn := &Conn{
+		Conn:    c,
+		reader:  bufio.NewReader(c),
+		timeout: DefaultReadHeaderTimeout,
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+	return conn
+}
+
+func (sf *Conn) readHeader() {
+	sf.once.Do(func() {
+		if len(sf.trusted) > 0 && !sf.isTrusted() {
+			sf.err = ErrUntrustedUpstream
+			return
+		}
+		if sf.timeout > 0 {
+			sf.Conn.SetReadDeadline(time.Now().Add(sf.timeout)) // nolint: errcheck
+			defer sf.Conn.SetReadDeadline(time.Time{})          // nolint: errcheck
+		}
+		sf.header, sf.err = ReadHeader(sf.reader)
+		if errors.Is(sf.err, ErrNoProxyProtocol) && !sf.required && len(sf.trusted) == 0 {
+			sf.err = nil
+		}
+	})
+}
+
+// isTrusted 连接是否来自信任的上游
+func (sf *Conn) isTrusted() bool {
+	var ip net.IP
+	switch addr := sf.Conn.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UnixAddr:
+		return true
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	for _, ipNet := range sf.trusted {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Header 返回PROXY protocol头, 没有时返回nil
+func (sf *Conn) Header() (*Header, error) {
+	sf.readHeader()
+	return sf.header, sf.err
+}
+
+// Read reads data from the connection.
+func (sf *Conn) Read(p []byte) (int, error) {
+	sf.readHeader()
+	if sf.err != nil {
+		return 0, sf.err
+	}
+	return sf.reader.Read(p)
+}
+
+// RemoteAddr returns the remote network address.
+// 有PROXY protocol头时返回原始客户端的地址
+func (sf *Conn) RemoteAddr() net.Addr {
+	sf.readHeader()
+	if sf.header != nil && sf.header.Command == CommandProxy && sf.header.SourceAddr != nil {
+		return sf.header.SourceAddr
+	}
+	return sf.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the local network address.
+// 有PROXY protocol头时返回原始客户端请求的目的地址
+func (sf *Conn) LocalAddr() net.Addr {
+	sf.readHeader()
+	if sf.header != nil && sf.header.Command == CommandProxy && sf.header.DestinationAddr != nil {
+		return sf.header.DestinationAddr
+	}
+	return sf.Conn.LocalAddr()
+}
+
+// Listener 包装 net.Listener, 接受的连接均为 *Conn
+type Listener struct {
+	net.Listener
+	opts []Options
+}
+
+// NewListener new listener with options
+func NewListener(ln net.Listener, opts ...Options) *Listener {
+	return &Listener{ln, opts}
+}
+
+// Accept waits for and returns the next connection to the listener.
+// 不在Accept中读取PROXY protocol头, 避免慢连接阻塞Accept
+func (sf *Listener) Accept() (net.Conn, error) {
+	c, err := sf.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(c, sf.opts...), nil
+}
+
+// ClientConn 客户端连接, 创建时立即发送PROXY protocol头
+type ClientConn struct {
+	net.Conn
+	err error
+}
+
+// NewClientConn new client conn and write the header
+func NewClientConn(c net.Conn, header *Header) *ClientConn {
+	_, err := header.WriteTo(c)
+	return &ClientConn{c, err}
+}
+
+// Read reads data from the connection.
+func (sf *ClientConn) Read(p []byte) (int, error) {
+	if sf.err != nil {
+		return 0, sf.err
+	}
+	return sf.Conn.Read(p)
+}
+
+// Write writes data to the connection.
+func (sf *ClientConn) Write(p []byte) (int, error) {
+	if sf.err != nil {
+		return 0, sf.err
+	}
+	return sf.Conn.Write(p)
+}
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *ClientConn) CloseWrite() error {
+	if sf.err != nil {
+		return sf.err
+	}
+	return enet.CloseWrite(sf.Conn)
+}
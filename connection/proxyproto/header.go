@@ -0,0 +1,305 @@
+// Package proxyproto 实现 PROXY protocol v1/v2 协议头的解析和生成
+// see https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// 协议版本
+const (
+	Version1 byte = 1
+	Version2 byte = 2
+)
+
+// Command v2 命令
+type Command byte
+
+// v2 命令
+const (
+	// CommandLocal 连接由代理自身发起(如健康检查), 应使用连接的真实地址
+	CommandLocal Command = 0x00
+	// CommandProxy 连接由代理转发, 地址为原始客户端的地址
+	CommandProxy Command = 0x01
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107 // 包含 \r\n
+
+	v2HeaderSize = 16
+	// v2 地址族和传输协议
+	v2Unspec = 0x00
+	v2TCP4   = 0x11
+	v2UDP4   = 0x12
+	v2TCP6   = 0x21
+	v2UDP6   = 0x22
+	v2Unix   = 0x31
+	v2Unixgm = 0x32
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// 错误定义
+var (
+	// ErrNoProxyProtocol 数据不是以PROXY protocol头开始
+	ErrNoProxyProtocol = errors.New("proxyproto: no proxy protocol header")
+	// ErrUntrustedUpstream 连接不是来自信任的上游, 见 WithTrusted
+	ErrUntrustedUpstream = errors.New("proxyproto: connection not from trusted upstream")
+	// ErrInvalidHeader 无效的PROXY protocol头
+	ErrInvalidHeader = errors.New("proxyproto: invalid header")
+)
+
+// Header PROXY protocol 头
+// SourceAddr, DestinationAddr 为nil时表示地址未知(v1 UNKNOWN, v2 UNSPEC),应使用连接的真实地址
+type Header struct {
+	Version         byte
+	Command         Command
+	SourceAddr      net.Addr
+	DestinationAddr net.Addr
+}
+
+// ReadHeader 从r中读取PROXY protocol头
+// 如果数据不是以v1或v2的签名开始, 返回 ErrNoProxyProtocol, 且不消耗r的任何数据
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch b[0] {
+	case v1Prefix[0]:
+		if b, err = r.Peek(len(v1Prefix)); err != nil {
+			return nil, err
+		}
+		if string(b) != v1Prefix {
+			return nil, ErrNoProxyProtocol
+		}
+		return readV1(r)
+	case v2Signature[0]:
+		if b, err = r.Peek(len(v2Signature)); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(b, v2Signature) {
+			return nil, ErrNoProxyProtocol
+		}
+		return readV2(r)
+	default:
+		return nil, ErrNoProxyProtocol
+	}
+}
+
+// readV1 PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readV1(r *bufio.Reader) (*Header, error) {
+	line := make([]byte, 0, v1MaxLength)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= v1MaxLength {
+			return nil, ErrInvalidHeader
+		}
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, ErrInvalidHeader
+	}
+
+	fields := strings.Split(string(line[len(v1Prefix):len(line)-2]), " ")
+	h := &Header{Version: Version1, Command: CommandProxy}
+	switch fields[0] {
+	case "UNKNOWN":
+		return h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrInvalidHeader
+	}
+	if len(fields) != 5 {
+		return nil, ErrInvalidHeader
+	}
+	src, err := parseV1Addr(fields[0], fields[1], fields[3])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[0], fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	h.SourceAddr, h.DestinationAddr = src, dst
+	return h, nil
+}
+
+func parseV1Addr(protocol, host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil || (protocol == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrInvalidHeader
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, ErrInvalidHeader
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var hdr [v2HeaderSize]byte
+
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[12]>>4 != Version2 {
+		return nil, ErrInvalidHeader
+	}
+	h := &Header{Version: Version2, Command: Command(hdr[12] & 0x0f)}
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, ErrInvalidHeader
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if h.Command == CommandLocal {
+		return h, nil
+	}
+
+	// 地址之后的TLV忽略
+	switch hdr[13] {
+	case v2TCP4, v2UDP4:
+		if len(payload) < 12 {
+			return nil, ErrInvalidHeader
+		}
+		h.SourceAddr, h.DestinationAddr = v2InetAddr(hdr[13], payload[0:4], payload[4:8], payload[8:12])
+	case v2TCP6, v2UDP6:
+		if len(payload) < 36 {
+			return nil, ErrInvalidHeader
+		}
+		h.SourceAddr, h.DestinationAddr = v2InetAddr(hdr[13], payload[0:16], payload[16:32], payload[32:36])
+	case v2Unix, v2Unixgm:
+		if len(payload) < 216 {
+			return nil, ErrInvalidHeader
+		}
+		network := "unix"
+		if hdr[13] == v2Unixgm {
+			network = "unixgram"
+		}
+		h.SourceAddr = &net.UnixAddr{Name: string(bytes.TrimRight(payload[:108], "\x00")), Net: network}
+		h.DestinationAddr = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: network}
+	case v2Unspec:
+	default:
+		return nil, ErrInvalidHeader
+	}
+	return h, nil
+}
+
+func v2InetAddr(fam byte, src, dst, ports []byte) (net.Addr, net.Addr) {
+	srcIP, dstIP := append(net.IP{}, src...), append(net.IP{}, dst...)
+	srcPort, dstPort := int(binary.BigEndian.Uint16(ports)), int(binary.BigEndian.Uint16(ports[2:]))
+	if fam&0x0f == 0x02 {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+// Format 生成PROXY protocol头
+// 源地址和目的地址不是同一族的tcp(v2 也支持udp)地址时, v1生成 UNKNOWN, v2生成 UNSPEC
+func (sf *Header) Format() ([]byte, error) {
+	switch sf.Version {
+	case Version1:
+		return sf.formatV1(), nil
+	case Version2:
+		return sf.formatV2(), nil
+	default:
+		return nil, fmt.Errorf("proxyproto: unknown version %d", sf.Version)
+	}
+}
+
+// WriteTo 将PROXY protocol头写入w
+func (sf *Header) WriteTo(w io.Writer) (int64, error) {
+	b, err := sf.Format()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (sf *Header) formatV1() []byte {
+	src, ok1 := sf.SourceAddr.(*net.TCPAddr)
+	dst, ok2 := sf.DestinationAddr.(*net.TCPAddr)
+	if !ok1 || !ok2 || (src.IP.To4() != nil) != (dst.IP.To4() != nil) {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	protocol, srcIP, dstIP := "TCP6", src.IP.To16(), dst.IP.To16()
+	if ip := src.IP.To4(); ip != nil {
+		protocol, srcIP, dstIP = "TCP4", ip, dst.IP.To4()
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", protocol, srcIP, dstIP, src.Port, dst.Port))
+}
+
+func (sf *Header) formatV2() []byte {
+	var fam byte = v2Unspec
+	var payload []byte
+
+	if sf.Command == CommandProxy {
+		fam, payload = v2AddrPayload(sf.SourceAddr, sf.DestinationAddr)
+	}
+	b := make([]byte, v2HeaderSize, v2HeaderSize+len(payload))
+	copy(b, v2Signature)
+	b[12] = Version2<<4 | byte(sf.Command)
+	b[13] = fam
+	binary.BigEndian.PutUint16(b[14:], uint16(len(payload)))
+	return append(b, payload...)
+}
+
+func v2AddrPayload(src, dst net.Addr) (byte, []byte) {
+	var srcIP, dstIP net.IP
+	var srcPort, dstPort int
+	var fam byte
+
+	switch s := src.(type) {
+	case *net.TCPAddr:
+		d, ok := dst.(*net.TCPAddr)
+		if !ok {
+			return v2Unspec, nil
+		}
+		srcIP, dstIP, srcPort, dstPort, fam = s.IP, d.IP, s.Port, d.Port, 0x01
+	case *net.UDPAddr:
+		d, ok := dst.(*net.UDPAddr)
+		if !ok {
+			return v2Unspec, nil
+		}
+		srcIP, dstIP, srcPort, dstPort, fam = s.IP, d.IP, s.Port, d.Port, 0x02
+	default:
+		return v2Unspec, nil
+	}
+
+	var payload []byte
+	if srcIP.To4() != nil && dstIP.To4() != nil {
+		fam |= 0x10
+		payload = append(payload, srcIP.To4()...)
+		payload = append(payload, dstIP.To4()...)
+	} else if srcIP.To4() == nil && dstIP.To4() == nil && srcIP.To16() != nil && dstIP.To16() != nil {
+		fam |= 0x20
+		payload = append(payload, srcIP.To16()...)
+		payload = append(payload, dstIP.To16()...)
+	} else {
+		return v2Unspec, nil
+	}
+	payload = binary.BigEndian.AppendUint16(payload, uint16(srcPort))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(dstPort))
+	return fam, payload
+}
@@ -0,0 +1,213 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	tcp4Src := &net.TCPAddr{IP: net.ParseIP("192.168.1.1").To4(), Port: 56324}
+	tcp4Dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 443}
+	tcp6Src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	tcp6Dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+	udp4Src := &net.UDPAddr{IP: net.ParseIP("192.168.1.1").To4(), Port: 53}
+	udp4Dst := &net.UDPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 53}
+
+	tests := []struct {
+		name     string
+		header   Header
+		wantSrc  net.Addr
+		wantDst  net.Addr
+		wantText string
+	}{
+		{"v1 tcp4", Header{Version1, CommandProxy, tcp4Src, tcp4Dst}, tcp4Src, tcp4Dst, "PROXY TCP4 192.168.1.1 10.0.0.1 56324 443\r\n"},
+		{"v1 tcp6", Header{Version1, CommandProxy, tcp6Src, tcp6Dst}, tcp6Src, tcp6Dst, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"},
+		{"v1 unknown", Header{Version1, CommandProxy, tcp4Src, tcp6Dst}, nil, nil, "PROXY UNKNOWN\r\n"},
+		{"v2 tcp4", Header{Version2, CommandProxy, tcp4Src, tcp4Dst}, tcp4Src, tcp4Dst, ""},
+		{"v2 tcp6", Header{Version2, CommandProxy, tcp6Src, tcp6Dst}, tcp6Src, tcp6Dst, ""},
+		{"v2 udp4", Header{Version2, CommandProxy, udp4Src, udp4Dst}, udp4Src, udp4Dst, ""},
+		{"v2 unspec", Header{Version2, CommandProxy, &net.UnixAddr{Name: "a"}, tcp4Dst}, nil, nil, ""},
+		{"v2 local", Header{Version2, CommandLocal, tcp4Src, tcp4Dst}, nil, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.header.Format()
+			require.NoError(t, err)
+			if tt.wantText != "" {
+				require.Equal(t, tt.wantText, string(b))
+			}
+
+			r := bufio.NewReader(io.MultiReader(bytes.NewReader(b), bytes.NewReader([]byte("payload"))))
+			h, err := ReadHeader(r)
+			require.NoError(t, err)
+			assert.Equal(t, tt.header.Version, h.Version)
+			assert.Equal(t, tt.header.Command, h.Command)
+			assert.Equal(t, tt.wantSrc, h.SourceAddr)
+			assert.Equal(t, tt.wantDst, h.DestinationAddr)
+
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "payload", string(rest))
+		})
+	}
+
+	_, err := (&Header{Version: 3}).Format()
+	require.Error(t, err)
+}
+
+func TestReadHeaderInvalid(t *testing.T) {
+	for _, data := range []string{
+		"GET / HTTP/1.1\r\n\r\n",
+		"PUT /x HTTP/1.1\r\n\r\n",
+	} {
+		r := bufio.NewReader(bytes.NewReader([]byte(data)))
+		_, err := ReadHeader(r)
+		require.ErrorIs(t, err, ErrNoProxyProtocol)
+		// 不消耗数据
+		rest, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, data, string(rest))
+	}
+
+	for _, data := range []string{
+		"PROXY TCP4 192.168.1.1 10.0.0.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n",
+		"PROXY TCP4 192.168.1.1 10.0.0.1 65536 443\r\n",
+		"PROXY UDP4 192.168.1.1 10.0.0.1 53 53\r\n",
+		"PROXY TCP4 192.168.1.1 10.0.0.1 56324 443\n",
+		"PROXY " + string(bytes.Repeat([]byte("1"), 120)) + "\r\n",
+		"\r\n\r\n\x00\r\nQUIT\n\x11\x11\x00\x00",
+		"\r\n\r\n\x00\r\nQUIT\n\x21\x11\x00\x04\x00\x00\x00\x00",
+	} {
+		_, err := ReadHeader(bufio.NewReader(bytes.NewReader([]byte(data))))
+		require.Error(t, err, data)
+	}
+}
+
+func TestListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln := NewListener(inner, WithReadHeaderTimeout(time.Second))
+	defer ln.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("1.2.3.4").To4(), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("5.6.7.8").To4(), Port: 80}
+	for _, version := range []byte{Version1, Version2} {
+		cli, err := net.Dial("tcp", inner.Addr().String())
+		require.NoError(t, err)
+		cc := NewClientConn(cli, &Header{Version: version, Command: CommandProxy, SourceAddr: src, DestinationAddr: dst})
+		_, err = cc.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		conn, err := ln.Accept()
+		require.NoError(t, err)
+		assert.Equal(t, src.String(), conn.RemoteAddr().String())
+		assert.Equal(t, dst.String(), conn.LocalAddr().String())
+		b := make([]byte, 4)
+		_, err = io.ReadFull(conn, b)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(b))
+		conn.Close()
+		cc.Close()
+	}
+
+	// 无头时使用真实地址
+	cli, err := net.Dial("tcp", inner.Addr().String())
+	require.NoError(t, err)
+	defer cli.Close()
+	_, err = cli.Write([]byte("ping"))
+	require.NoError(t, err)
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, cli.LocalAddr().String(), conn.RemoteAddr().String())
+	b := make([]byte, 4)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+}
+
+func TestConnRequired(t *testing.T) {
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	conn := NewConn(srv, WithRequired(true), WithReadHeaderTimeout(time.Second))
+	defer conn.Close()
+
+	go cli.Write([]byte("GET / HTTP/1.1\r\n\r\n")) // nolint: errcheck
+	_, err := conn.Read(make([]byte, 10))
+	require.ErrorIs(t, err, ErrNoProxyProtocol)
+	_, err = conn.Header()
+	require.ErrorIs(t, err, ErrNoProxyProtocol)
+}
+
+func TestParseTrusted(t *testing.T) {
+	trusted, err := ParseTrusted([]string{"10.0.0.0/8", "127.0.0.1", "::1"})
+	require.NoError(t, err)
+	require.Len(t, trusted, 3)
+	assert.True(t, trusted[0].Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, trusted[1].Contains(net.ParseIP("127.0.0.1")))
+	assert.False(t, trusted[1].Contains(net.ParseIP("127.0.0.2")))
+	assert.True(t, trusted[2].Contains(net.ParseIP("::1")))
+
+	_, err = ParseTrusted([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+	_, err = ParseTrusted([]string{"localhost"})
+	require.Error(t, err)
+}
+
+func TestConnTrusted(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inner.Close()
+
+	src := &net.TCPAddr{IP: net.ParseIP("1.2.3.4").To4(), Port: 1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("5.6.7.8").To4(), Port: 80}
+	accept := func(trusted string, header bool) (net.Conn, net.Conn) {
+		nets, err := ParseTrusted([]string{trusted})
+		require.NoError(t, err)
+		cli, err := net.Dial("tcp", inner.Addr().String())
+		require.NoError(t, err)
+		if header {
+			cli = NewClientConn(cli, &Header{Version: Version2, Command: CommandProxy, SourceAddr: src, DestinationAddr: dst})
+		}
+		_, err = cli.Write([]byte("ping"))
+		require.NoError(t, err)
+		conn, err := inner.Accept()
+		require.NoError(t, err)
+		return cli, NewConn(conn, WithTrusted(nets), WithReadHeaderTimeout(time.Second))
+	}
+
+	t.Run("trusted upstream", func(t *testing.T) {
+		cli, conn := accept("127.0.0.0/8", true)
+		defer cli.Close()
+		defer conn.Close()
+		assert.Equal(t, src.String(), conn.RemoteAddr().String())
+		b := make([]byte, 4)
+		_, err := io.ReadFull(conn, b)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(b))
+	})
+	t.Run("trusted upstream without header", func(t *testing.T) {
+		cli, conn := accept("127.0.0.1", false)
+		defer cli.Close()
+		defer conn.Close()
+		_, err := conn.Read(make([]byte, 4))
+		require.ErrorIs(t, err, ErrNoProxyProtocol)
+	})
+	t.Run("untrusted peer spoof", func(t *testing.T) {
+		cli, conn := accept("10.0.0.0/8", true)
+		defer cli.Close()
+		defer conn.Close()
+		// 不信任的连接不解析头, 使用真实地址
+		assert.Equal(t, cli.LocalAddr().String(), conn.RemoteAddr().String())
+		_, err := conn.Read(make([]byte, 4))
+		require.ErrorIs(t, err, ErrUntrustedUpstream)
+	})
+}
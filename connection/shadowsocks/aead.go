@@ -0,0 +1,162 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/things-go/encrypt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"lukechampine.com/blake3"
+)
+
+// aead 相关常量
+const (
+	tagSize            = 16
+	maxPayloadSize     = 0x3fff // AEAD 每块最大数据长度
+	maxPayloadSize2022 = 0xffff // 2022 每块最大数据长度
+	maxPaddingLength   = 900    // 2022 最大填充长度
+	timestampTolerance = 30     // 2022 时间戳允许的偏差, 单位秒
+
+	headerTypeClient = 0 // 2022 客户端流
+	headerTypeServer = 1 // 2022 服务端流
+
+	saltFilterCapacity = 1 << 17 // 重放过滤器容量
+	saltFilterTTL2022  = time.Minute
+)
+
+// 错误定义
+var (
+	ErrShortPacket     = errors.New("shadowsocks: short packet")
+	ErrAuthFailed      = errors.New("shadowsocks: message authentication failed")
+	ErrReplay          = errors.New("shadowsocks: replay detected")
+	ErrBadTimestamp    = errors.New("shadowsocks: bad timestamp")
+	ErrBadHeader       = errors.New("shadowsocks: bad header")
+	ErrSessionRequired = errors.New("shadowsocks: 2022 method udp requires a packet session")
+	ErrNoRequest       = errors.New("shadowsocks: 2022 method server conn should read the request before writing")
+)
+
+// aeadMethod AEAD 加密方法, 包括 SIP004 和 SIP022(2022-blake3-*)
+type aeadMethod struct {
+	keySize int
+	is2022  bool
+	new     func(key []byte) (cipher.AEAD, error)
+}
+
+var aeadMethods = map[string]*aeadMethod{
+	"aes-128-gcm":                   {16, false, newGCM},
+	"aes-192-gcm":                   {24, false, newGCM},
+	"aes-256-gcm":                   {32, false, newGCM},
+	"chacha20-ietf-poly1305":        {chacha20poly1305.KeySize, false, chacha20poly1305.New},
+	"2022-blake3-aes-128-gcm":       {16, true, newGCM},
+	"2022-blake3-aes-256-gcm":       {32, true, newGCM},
+	"2022-blake3-chacha20-poly1305": {chacha20poly1305.KeySize, true, chacha20poly1305.New},
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// CipherMethods 支持的全部加密方法, 包括流加密和AEAD加密
+func CipherMethods() []string {
+	methods := append([]string{}, encrypt.CipherMethods()...)
+	for name := range aeadMethods {
+		methods = append(methods, name)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// aeadKey 生成主密钥, SIP004 使用 EVP_BytesToKey, 2022 使用base64编码的密钥
+func aeadKey(m *aeadMethod, method, password string) ([]byte, error) {
+	if !m.is2022 {
+		return encrypt.Evp2Key(password, m.keySize), nil
+	}
+	key, err := base64.StdEncoding.DecodeString(password)
+	if err != nil || len(key) != m.keySize {
+		return nil, fmt.Errorf("shadowsocks: %s requires a base64 encoded key of %d bytes", method, m.keySize)
+	}
+	return key, nil
+}
+
+// subKey 由主密钥和salt派生会话子密钥
+func (m *aeadMethod) subKey(key, salt []byte) []byte {
+	sub := make([]byte, m.keySize)
+	if m.is2022 {
+		material := make([]byte, 0, len(key)+len(salt))
+		material = append(append(material, key...), salt...)
+		blake3.DeriveKey(sub, "shadowsocks 2022 session subkey", material)
+		return sub
+	}
+	io.ReadFull(hkdf.New(sha1.New, key, salt, []byte("ss-subkey")), sub) // nolint: errcheck
+	return sub
+}
+
+func (m *aeadMethod) aead(key, salt []byte) (cipher.AEAD, error) {
+	return m.new(m.subKey(key, salt))
+}
+
+// saltFilter 重放过滤器, 记录最近出现过的salt
+// 超过容量或存活时间(ttl > 0时)的salt被淘汰
+type saltFilter struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	m     map[string]time.Time
+	queue []string
+}
+
+func newSaltFilter(ttl time.Duration) *saltFilter {
+	return &saltFilter{ttl: ttl, m: make(map[string]time.Time)}
+}
+
+// Check 记录salt, 已经出现过时返回false
+func (sf *saltFilter) Check(salt []byte) bool {
+	now := time.Now()
+
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	for len(sf.queue) > 0 {
+		oldest := sf.queue[0]
+		if len(sf.queue) < saltFilterCapacity && (sf.ttl <= 0 || now.Sub(sf.m[oldest]) < sf.ttl) {
+			break
+		}
+		delete(sf.m, oldest)
+		sf.queue = sf.queue[1:]
+	}
+	if _, ok := sf.m[string(salt)]; ok {
+		return false
+	}
+	sf.m[string(salt)] = now
+	sf.queue = append(sf.queue, string(salt))
+	return true
+}
+
+// increment 小端递增nonce
+func increment(b []byte) {
+	for i := range b {
+		b[i]++
+		if b[i] != 0 {
+			return
+		}
+	}
+}
+
+func checkTimestamp(ts uint64) error {
+	diff := time.Now().Unix() - int64(ts)
+	if diff > timestampTolerance || diff < -timestampTolerance {
+		return ErrBadTimestamp
+	}
+	return nil
+}
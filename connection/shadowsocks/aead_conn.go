@@ -0,0 +1,348 @@
+package shadowsocks
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+// aeadConn AEAD 加密的流
+// SIP004: [salt][加密的长度+tag][加密的数据+tag]...
+// SIP022 请求: [salt][加密的固定头+tag][加密的变长头+tag][加密的长度+tag][加密的数据+tag]...
+//
+//	固定头: 类型(1) + 时间戳(8) + 变长头长度(2)
+//	变长头: 地址 + 填充长度(2) + 填充 + 初始数据
+//
+// SIP022 响应: [salt][加密的固定头+tag][加密的首块数据+tag][加密的长度+tag][加密的数据+tag]...
+//
+//	固定头: 类型(1) + 时间戳(8) + 请求的salt + 首块数据长度(2)
+type aeadConn struct {
+	net.Conn
+	cipher *Cipher
+	client bool // 客户端, 2022时决定请求头和响应头的格式
+
+	rmu    sync.Mutex
+	r      cipher.AEAD
+	rnonce []byte
+	rleft  []byte // 已解密未读取的数据
+	rerr   error
+
+	wmu    sync.Mutex
+	w      cipher.AEAD
+	wnonce []byte
+	wbuf   []byte
+
+	saltMu      sync.Mutex
+	localSalt   []byte // 本端发送的salt
+	requestSalt []byte // 服务端收到的请求salt
+}
+
+func (sf *aeadConn) maxPayload() int {
+	if sf.cipher.aead.is2022 {
+		return maxPayloadSize2022
+	}
+	return maxPayloadSize
+}
+
+// Read reads data from the connection.
+func (sf *aeadConn) Read(b []byte) (int, error) {
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if len(sf.rleft) == 0 {
+		if sf.rerr != nil {
+			return 0, sf.rerr
+		}
+		var err error
+		if sf.r == nil {
+			err = sf.readHeader()
+		} else {
+			sf.rleft, err = sf.readChunk()
+		}
+		if err != nil {
+			sf.rerr = err
+			if err != io.EOF {
+				sf.Conn.Close() // nolint: errcheck
+			}
+			return 0, err
+		}
+	}
+	n := copy(b, sf.rleft)
+	sf.rleft = sf.rleft[n:]
+	return n, nil
+}
+
+// open 读取并解密size长度的数据
+func (sf *aeadConn) open(size int) ([]byte, error) {
+	buf := make([]byte, size+tagSize)
+	if _, err := io.ReadFull(sf.Conn, buf); err != nil {
+		return nil, err
+	}
+	plain, err := sf.r.Open(buf[:0], sf.rnonce, buf, nil)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	increment(sf.rnonce)
+	return plain, nil
+}
+
+func (sf *aeadConn) readChunk() ([]byte, error) {
+	lb, err := sf.open(2)
+	if err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(lb))
+	if !sf.cipher.aead.is2022 {
+		size &= maxPayloadSize
+	}
+	payload, err := sf.open(size)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return payload, err
+}
+
+func (sf *aeadConn) readHeader() error {
+	m := sf.cipher.aead
+	salt := make([]byte, m.keySize)
+	if _, err := io.ReadFull(sf.Conn, salt); err != nil {
+		return err
+	}
+	if !sf.cipher.filter.Check(salt) {
+		return ErrReplay
+	}
+	r, err := m.aead(sf.cipher.key, salt)
+	if err != nil {
+		return err
+	}
+	sf.r = r
+	sf.rnonce = make([]byte, r.NonceSize())
+	if !m.is2022 {
+		sf.rleft, err = sf.readChunk()
+		return err
+	}
+	if sf.client {
+		return sf.readResponseHeader()
+	}
+	return sf.readRequestHeader(salt)
+}
+
+func (sf *aeadConn) readRequestHeader(salt []byte) error {
+	fixed, err := sf.open(1 + 8 + 2)
+	if err != nil {
+		return err
+	}
+	if fixed[0] != headerTypeClient {
+		return ErrBadHeader
+	}
+	if err = checkTimestamp(binary.BigEndian.Uint64(fixed[1:])); err != nil {
+		return err
+	}
+	variable, err := sf.open(int(binary.BigEndian.Uint16(fixed[9:])))
+	if err != nil {
+		return err
+	}
+	n, err := addrLen(variable)
+	if err != nil || len(variable) < n+2 {
+		return ErrBadHeader
+	}
+	paddingLen := int(binary.BigEndian.Uint16(variable[n:]))
+	if len(variable) < n+2+paddingLen {
+		return ErrBadHeader
+	}
+	// 去掉填充, 上层读到的是地址和初始数据
+	sf.rleft = append(variable[:n:n], variable[n+2+paddingLen:]...)
+
+	sf.saltMu.Lock()
+	sf.requestSalt = salt
+	sf.saltMu.Unlock()
+	return nil
+}
+
+func (sf *aeadConn) readResponseHeader() error {
+	saltSize := sf.cipher.aead.keySize
+	fixed, err := sf.open(1 + 8 + saltSize + 2)
+	if err != nil {
+		return err
+	}
+	if fixed[0] != headerTypeServer {
+		return ErrBadHeader
+	}
+	if err = checkTimestamp(binary.BigEndian.Uint64(fixed[1:])); err != nil {
+		return err
+	}
+	sf.saltMu.Lock()
+	localSalt := sf.localSalt
+	sf.saltMu.Unlock()
+	if string(fixed[9:9+saltSize]) != string(localSalt) {
+		return ErrBadHeader
+	}
+	sf.rleft, err = sf.open(int(binary.BigEndian.Uint16(fixed[9+saltSize:])))
+	return err
+}
+
+// Write writes data to the connection.
+func (sf *aeadConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+
+	buf := sf.wbuf[:0]
+	n := 0
+	if sf.w == nil {
+		var err error
+		if buf, n, err = sf.writeHeader(buf, b); err != nil {
+			return 0, err
+		}
+	}
+	buf = sf.appendChunks(buf, b[n:])
+	sf.wbuf = buf[:0]
+	if _, err := sf.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (sf *aeadConn) seal(dst, plain []byte) []byte {
+	dst = sf.w.Seal(dst, sf.wnonce, plain, nil)
+	increment(sf.wnonce)
+	return dst
+}
+
+func (sf *aeadConn) appendChunks(buf, b []byte) []byte {
+	var lb [2]byte
+	for max := sf.maxPayload(); len(b) > 0; {
+		size := len(b)
+		if size > max {
+			size = max
+		}
+		binary.BigEndian.PutUint16(lb[:], uint16(size))
+		buf = sf.seal(buf, lb[:])
+		buf = sf.seal(buf, b[:size])
+		b = b[size:]
+	}
+	return buf
+}
+
+// writeHeader 写入salt及2022的请求头或响应头, 返回已包含在头中的数据长度
+func (sf *aeadConn) writeHeader(buf, b []byte) ([]byte, int, error) {
+	m := sf.cipher.aead
+	var requestSalt []byte
+	if m.is2022 && !sf.client {
+		sf.saltMu.Lock()
+		requestSalt = sf.requestSalt
+		sf.saltMu.Unlock()
+		if requestSalt == nil {
+			return nil, 0, ErrNoRequest
+		}
+	}
+
+	salt := make([]byte, m.keySize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, 0, err
+	}
+	// 记录本端的salt, 防止被反射回来
+	sf.cipher.filter.Check(salt)
+	w, err := m.aead(sf.cipher.key, salt)
+	if err != nil {
+		return nil, 0, err
+	}
+	sf.w = w
+	sf.wnonce = make([]byte, w.NonceSize())
+	sf.saltMu.Lock()
+	sf.localSalt = salt
+	sf.saltMu.Unlock()
+
+	buf = append(buf, salt...)
+	if !m.is2022 {
+		return buf, 0, nil
+	}
+
+	timestamp := uint64(time.Now().Unix())
+	if !sf.client {
+		// 响应头
+		n := len(b)
+		if n > maxPayloadSize2022 {
+			n = maxPayloadSize2022
+		}
+		fixed := make([]byte, 0, 1+8+len(requestSalt)+2)
+		fixed = append(fixed, headerTypeServer)
+		fixed = binary.BigEndian.AppendUint64(fixed, timestamp)
+		fixed = append(fixed, requestSalt...)
+		fixed = binary.BigEndian.AppendUint16(fixed, uint16(n))
+		buf = sf.seal(buf, fixed)
+		buf = sf.seal(buf, b[:n])
+		return buf, n, nil
+	}
+
+	// 请求头, 首次写入的数据须以地址开头
+	alen, err := addrLen(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := len(b)
+	if limit := maxPayloadSize2022 - 2 - maxPaddingLength; n > limit {
+		n = limit
+	}
+	paddingLen := 0
+	if n == alen {
+		// 没有初始数据时必须填充
+		v, err := rand.Int(rand.Reader, big.NewInt(maxPaddingLength))
+		if err != nil {
+			return nil, 0, err
+		}
+		paddingLen = int(v.Int64()) + 1
+	}
+	variable := make([]byte, 0, n+2+paddingLen)
+	variable = append(variable, b[:alen]...)
+	variable = binary.BigEndian.AppendUint16(variable, uint16(paddingLen))
+	variable = append(variable, make([]byte, paddingLen)...)
+	variable = append(variable, b[alen:n]...)
+
+	fixed := make([]byte, 0, 1+8+2)
+	fixed = append(fixed, headerTypeClient)
+	fixed = binary.BigEndian.AppendUint64(fixed, timestamp)
+	fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(variable)))
+	buf = sf.seal(buf, fixed)
+	buf = sf.seal(buf, variable)
+	return buf, n, nil
+}
+
+// addrLen 地址的长度
+//
+//	+------+----------+--------+
+//	| ATYP |   ADDR   |  PORT  |
+//	+------+----------+--------+
+//	|  1   | Variable |   2    |
+//	+------+----------+--------+
+func addrLen(b []byte) (int, error) {
+	if len(b) < 1 {
+		return 0, ErrShortPacket
+	}
+	n := 0
+	switch b[0] & AddrMask {
+	case typeIPv4:
+		n = 1 + net.IPv4len + 2
+	case typeIPv6:
+		n = 1 + net.IPv6len + 2
+	case typeDomain:
+		if len(b) < 2 {
+			return 0, ErrShortPacket
+		}
+		n = 1 + 1 + int(b[1]) + 2
+	default:
+		return 0, ErrBadHeader
+	}
+	if len(b) < n {
+		return 0, ErrShortPacket
+	}
+	return n, nil
+}
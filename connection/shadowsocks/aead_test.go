@@ -0,0 +1,232 @@
+package shadowsocks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/internal/mock"
+)
+
+type duplex struct {
+	io.Reader
+	io.Writer
+}
+
+func aeadPassword(t *testing.T, method string) string {
+	m := aeadMethods[method]
+	if !m.is2022 {
+		return "password"
+	}
+	key := make([]byte, m.keySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestAEADConn(t *testing.T) {
+	addr := "localhost:8080"
+	data := make([]byte, maxPayloadSize2022+100)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+	reply := []byte("i was a word")
+
+	for method := range aeadMethods {
+		t.Run(method, func(t *testing.T) {
+			password := aeadPassword(t, method)
+			// 客户端和服务端使用各自的重放过滤器
+			cliCipher, err := NewCipher(method, password)
+			require.NoError(t, err)
+			cip, err := NewCipher(method, password)
+			require.NoError(t, err)
+			rawAddr, err := ParseAddrSpec(addr)
+			require.NoError(t, err)
+
+			c2s, s2c := new(bytes.Buffer), new(bytes.Buffer)
+			client, err := NewConnWithRawAddr(mock.New(duplex{s2c, c2s}), rawAddr, cliCipher.Clone())
+			require.NoError(t, err)
+			_, err = client.Write(data)
+			require.NoError(t, err)
+			request := append([]byte{}, c2s.Bytes()...)
+
+			// server
+			server := New(mock.New(duplex{c2s, s2c}), cip.Clone())
+			gotAddr, err := ParseRequest(server)
+			require.NoError(t, err)
+			assert.Equal(t, addr, gotAddr)
+			got := make([]byte, len(data))
+			_, err = io.ReadFull(server, got)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+			_, err = server.Write(reply)
+			require.NoError(t, err)
+
+			got = make([]byte, len(reply))
+			_, err = io.ReadFull(client, got)
+			require.NoError(t, err)
+			assert.Equal(t, reply, got)
+
+			// 重放的请求被拒绝
+			_, err = ParseRequest(New(mock.New(duplex{bytes.NewReader(request), io.Discard}), cip.Clone()))
+			assert.ErrorIs(t, err, ErrReplay)
+
+			// 篡改的请求被拒绝
+			request[len(request)-1] ^= 0xff
+			cip2, err := NewCipher(method, "password")
+			if err == nil {
+				server = New(mock.New(duplex{bytes.NewReader(request), io.Discard}), cip2)
+				_, err = io.ReadAll(server)
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestAEADConn2022(t *testing.T) {
+	method := "2022-blake3-aes-128-gcm"
+	password := aeadPassword(t, method)
+	cliCipher, err := NewCipher(method, password)
+	require.NoError(t, err)
+	cip, err := NewCipher(method, password)
+	require.NoError(t, err)
+
+	_, err = NewCipher(method, "password")
+	require.Error(t, err)
+
+	// 服务端须先读取请求
+	server := New(mock.New(new(bytes.Buffer)), cip)
+	_, err = server.Write([]byte("hello"))
+	assert.ErrorIs(t, err, ErrNoRequest)
+
+	// 响应中的请求salt不匹配
+	rawAddr, err := ParseAddrSpec("localhost:8080")
+	require.NoError(t, err)
+	c2s, s2c := new(bytes.Buffer), new(bytes.Buffer)
+	_, err = NewConnWithRawAddr(mock.New(duplex{s2c, c2s}), rawAddr, cliCipher.Clone())
+	require.NoError(t, err)
+	server = New(mock.New(duplex{c2s, s2c}), cip.Clone())
+	_, err = ParseRequest(server)
+	require.NoError(t, err)
+	_, err = server.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	other, err := NewConnWithRawAddr(mock.New(duplex{s2c, io.Discard}), rawAddr, cliCipher.Clone())
+	require.NoError(t, err)
+	_, err = other.Read(make([]byte, 5))
+	assert.ErrorIs(t, err, ErrBadHeader)
+}
+
+func TestAEADPacket(t *testing.T) {
+	payload, err := ParseAddrSpec("127.0.0.1:53")
+	require.NoError(t, err)
+	payload = append(payload, "hello world"...)
+
+	for method := range aeadMethods {
+		t.Run(method, func(t *testing.T) {
+			password := aeadPassword(t, method)
+			cliCipher, err := NewCipher(method, password)
+			require.NoError(t, err)
+			cip, err := NewCipher(method, password)
+			require.NoError(t, err)
+
+			client, err := cliCipher.NewPacketSession(true)
+			require.NoError(t, err)
+			server, err := cip.NewPacketSession(false)
+			require.NoError(t, err)
+
+			for i := 0; i < 3; i++ {
+				packet, err := client.Pack(payload)
+				require.NoError(t, err)
+				got, err := server.Unpack(packet)
+				require.NoError(t, err)
+				assert.Equal(t, payload, got)
+
+				// 重放的报文被拒绝
+				_, err = server.Unpack(packet)
+				assert.ErrorIs(t, err, ErrReplay)
+
+				packet, err = server.Pack(payload)
+				require.NoError(t, err)
+				got, err = client.Unpack(packet)
+				require.NoError(t, err)
+				assert.Equal(t, payload, got)
+
+				packet[len(packet)-1] ^= 0xff
+				_, err = client.Unpack(packet)
+				assert.ErrorIs(t, err, ErrAuthFailed)
+			}
+
+			if aeadMethods[method].is2022 {
+				_, err = cip.Encrypt(payload)
+				assert.ErrorIs(t, err, ErrSessionRequired)
+			}
+		})
+	}
+}
+
+func TestAEADPacketSessionReplay(t *testing.T) {
+	payload, err := ParseAddrSpec("127.0.0.1:53")
+	require.NoError(t, err)
+	payload = append(payload, "hello world"...)
+
+	for method, m := range aeadMethods {
+		if !m.is2022 {
+			continue
+		}
+		t.Run(method, func(t *testing.T) {
+			password := aeadPassword(t, method)
+			cip, err := NewCipher(method, password)
+			require.NoError(t, err)
+			server, err := cip.NewPacketSession(false)
+			require.NoError(t, err)
+			oldClient, err := cip.NewPacketSession(true)
+			require.NoError(t, err)
+			newClient, err := cip.NewPacketSession(true)
+			require.NoError(t, err)
+
+			oldPacket, err := oldClient.Pack(payload)
+			require.NoError(t, err)
+			_, err = server.Unpack(oldPacket)
+			require.NoError(t, err)
+
+			// 客户端切换到新会话
+			packet, err := newClient.Pack(payload)
+			require.NoError(t, err)
+			_, err = server.Unpack(packet)
+			require.NoError(t, err)
+
+			// 重放旧会话的报文被拒绝
+			_, err = server.Unpack(oldPacket)
+			assert.ErrorIs(t, err, ErrReplay)
+
+			// 旧会话迟到的报文可以接收, 但不切回旧会话
+			packet, err = oldClient.Pack(payload)
+			require.NoError(t, err)
+			_, err = server.Unpack(packet)
+			require.NoError(t, err)
+			packet, err = server.Pack(payload)
+			require.NoError(t, err)
+			got, err := newClient.Unpack(packet)
+			require.NoError(t, err)
+			assert.Equal(t, payload, got)
+		})
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+
+	assert.True(t, w.Check(10))
+	assert.False(t, w.Check(10))
+	assert.True(t, w.Check(5))
+	assert.True(t, w.Check(2000))
+	assert.False(t, w.Check(5))
+	assert.True(t, w.Check(1999))
+	assert.False(t, w.Check(2000-replayWindowSize))
+	assert.True(t, w.Check(2000-replayWindowSize+1))
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 
 	"github.com/thinkgos/jocasta/pkg/bpool"
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 const bufferSize = 4108 // data.len(2) + hmacsha1(10) + data(4096)
@@ -16,14 +17,20 @@ var bufferPool = bpool.NewPool(bufferSize)
 type Conn struct {
 	net.Conn
 	*Cipher
+	aeadConn *aeadConn // AEAD方法时有效
 }
 
 // New new with a connection and cipher
+// AEAD 2022方法时作为服务端, 需先读取请求, 客户端见 NewConnWithRawAddr
 func New(c net.Conn, cipher *Cipher) *Conn {
-	return &Conn{
+	conn := &Conn{
 		Conn:   c,
 		Cipher: cipher,
 	}
+	if cipher.aead != nil {
+		conn.aeadConn = &aeadConn{Conn: c, cipher: cipher}
+	}
+	return conn
 }
 
 // Close implement closer interface.
@@ -36,6 +43,9 @@ func (sf *Conn) Close() error {
 // ATYP field. (Refer to rfc1928 for more information.)
 func NewConnWithRawAddr(rawConn net.Conn, rawaddr []byte, cipher *Cipher) (c *Conn, err error) {
 	c = New(rawConn, cipher)
+	if c.aeadConn != nil {
+		c.aeadConn.client = true
+	}
 	if _, err = c.Write(rawaddr); err != nil {
 		c.Close()
 		return nil, err
@@ -82,6 +92,9 @@ func (sf *Conn) Key() (key []byte) {
 
 // Read reads data from the connection.
 func (sf *Conn) Read(b []byte) (n int, err error) {
+	if sf.aeadConn != nil {
+		return sf.aeadConn.Read(b)
+	}
 	if sf.reader == nil {
 		iv := make([]byte, sf.ivLen)
 		if _, err = io.ReadFull(sf.Conn, iv); err != nil {
@@ -114,6 +127,10 @@ func (sf *Conn) Read(b []byte) (n int, err error) {
 func (sf *Conn) Write(b []byte) (n int, err error) {
 	var iv []byte
 
+	if sf.aeadConn != nil {
+		return sf.aeadConn.Write(b)
+	}
+
 	if sf.writer == nil {
 		if iv, err = sf.initEncrypt(); err != nil {
 			return 0, err
@@ -141,3 +158,12 @@ func (sf *Conn) Write(b []byte) (n int, err error) {
 	n, err = sf.Conn.Write(cipherData)
 	return n - len(iv), err
 }
+
+// CloseWrite 等待当前写入完成后半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	if sf.aeadConn != nil {
+		sf.aeadConn.wmu.Lock()
+		defer sf.aeadConn.wmu.Unlock()
+	}
+	return enet.CloseWrite(sf.Conn)
+}
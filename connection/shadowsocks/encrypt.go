@@ -5,6 +5,7 @@ import (
 	"crypto/rand"
 	"errors"
 	"io"
+	"time"
 
 	"github.com/things-go/encrypt"
 )
@@ -15,8 +16,10 @@ type Cipher struct {
 	reader cipher.Stream
 	method string
 	ivLen  int
-	key    []byte // hold key
-	iv     []byte // hold iv
+	key    []byte      // hold key
+	iv     []byte      // hold iv
+	aead   *aeadMethod // AEAD方法, 为nil时为流加密
+	filter *saltFilter // AEAD方法的重放过滤器
 }
 
 // NewCipher creates a cipher that can be used in Dial() etc.
@@ -26,6 +29,23 @@ func NewCipher(method, password string) (c *Cipher, err error) {
 	if password == "" {
 		return nil, errors.New("empty password")
 	}
+	if m, ok := aeadMethods[method]; ok {
+		key, err := aeadKey(m, method, password)
+		if err != nil {
+			return nil, err
+		}
+		var ttl time.Duration
+		if m.is2022 {
+			ttl = saltFilterTTL2022
+		}
+		return &Cipher{
+			method: method,
+			ivLen:  m.keySize,
+			key:    key,
+			aead:   m,
+			filter: newSaltFilter(ttl),
+		}, nil
+	}
 	kv, ok := encrypt.GetCipher(method)
 	if !ok {
 		return nil, errors.New("unsupported encryption method: " + method)
@@ -73,9 +93,17 @@ func (c *Cipher) decrypt(dst, src []byte) {
 }
 
 // Encrypt encrypt src data
+// 2022方法需使用 PacketSession
 func (c *Cipher) Encrypt(src []byte) (cipherData []byte, err error) {
 	var iv []byte
 
+	if c.aead != nil {
+		if c.aead.is2022 {
+			return nil, ErrSessionRequired
+		}
+		return c.encryptAEADPacket(src)
+	}
+
 	cip := c.Clone()
 	iv, err = cip.initEncrypt()
 	if err != nil {
@@ -89,7 +117,14 @@ func (c *Cipher) Encrypt(src []byte) (cipherData []byte, err error) {
 }
 
 // Decrypt decrypt input data
+// 2022方法需使用 PacketSession
 func (c *Cipher) Decrypt(input []byte) (data []byte, err error) {
+	if c.aead != nil {
+		if c.aead.is2022 {
+			return nil, ErrSessionRequired
+		}
+		return c.decryptAEADPacket(input)
+	}
 	cip := c.Clone()
 
 	if len(input) < c.ivLen {
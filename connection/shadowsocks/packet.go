@@ -0,0 +1,291 @@
+package shadowsocks
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const replayWindowSize = 1024 // 2022 udp 报文ID重放窗口大小
+
+// encryptAEADPacket SIP004 udp报文: [salt][加密的数据+tag], nonce为0
+func (c *Cipher) encryptAEADPacket(src []byte) ([]byte, error) {
+	m := c.aead
+	salt := make([]byte, m.keySize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	aead, err := m.aead(c.key, salt)
+	if err != nil {
+		return nil, err
+	}
+	dst := make([]byte, 0, len(salt)+len(src)+tagSize)
+	dst = append(dst, salt...)
+	return aead.Seal(dst, make([]byte, aead.NonceSize()), src, nil), nil
+}
+
+func (c *Cipher) decryptAEADPacket(input []byte) ([]byte, error) {
+	m := c.aead
+	if len(input) < m.keySize+tagSize {
+		return nil, ErrShortPacket
+	}
+	salt := input[:m.keySize]
+	aead, err := m.aead(c.key, salt)
+	if err != nil {
+		return nil, err
+	}
+	data, err := aead.Open(nil, make([]byte, aead.NonceSize()), input[m.keySize:], nil)
+	if err != nil {
+		return nil, ErrAuthFailed
+	}
+	if !c.filter.Check(salt) {
+		return nil, ErrReplay
+	}
+	return data, nil
+}
+
+// PacketSession udp会话, 同一个对端的报文应使用同一个会话.
+// 流加密和AEAD方法无状态, 等同于 Cipher.Encrypt 和 Cipher.Decrypt;
+// 2022方法记录双方的会话ID, 按报文ID检查重放:
+//
+//	aes: [aes加密的会话ID(8)+报文ID(8)][加密的数据+tag], 会话ID派生子密钥, 报文头后12字节作为nonce
+//	chacha20: [nonce(24)][xchacha20-poly1305加密的会话ID(8)+报文ID(8)+数据+tag]
+//	客户端数据: 类型(1) + 时间戳(8) + 填充长度(2) + 填充 + 地址 + 数据
+//	服务端数据: 类型(1) + 时间戳(8) + 客户端会话ID(8) + 填充长度(2) + 填充 + 地址 + 数据
+type PacketSession struct {
+	cipher *Cipher
+	client bool
+
+	block  cipher.Block // aes 加密报文头
+	xaead  cipher.AEAD  // chacha20 加密报文
+	sealMu sync.Mutex
+	id     []byte      // 本端会话ID
+	aead   cipher.AEAD // 本端会话的aes子密钥
+	pid    uint64      // 本端下一个报文ID
+
+	openMu sync.Mutex
+	peer   *peerSession            // 对端当前会话
+	peers  map[string]*peerSession // 对端所有未过期会话, 每个会话独立的重放窗口
+}
+
+// peerSession 对端会话
+type peerSession struct {
+	id       []byte
+	aead     cipher.AEAD // 对端会话的aes子密钥
+	window   replayWindow
+	lastSeen time.Time
+}
+
+// NewPacketSession 创建udp会话, client表示本端为客户端
+func (c *Cipher) NewPacketSession(client bool) (*PacketSession, error) {
+	s := &PacketSession{cipher: c, client: client, peers: make(map[string]*peerSession)}
+	if c.aead == nil || !c.aead.is2022 {
+		return s, nil
+	}
+
+	var err error
+	s.id = make([]byte, 8)
+	if _, err = io.ReadFull(rand.Reader, s.id); err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(c.method, "2022-blake3-chacha20") {
+		s.xaead, err = chacha20poly1305.NewX(c.key)
+		return s, err
+	}
+	if s.block, err = aes.NewCipher(c.key); err != nil {
+		return nil, err
+	}
+	if s.aead, err = c.aead.aead(c.key, s.id); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Pack 加密报文, src为地址+数据
+func (s *PacketSession) Pack(src []byte) ([]byte, error) {
+	if s.id == nil {
+		return s.cipher.Encrypt(src)
+	}
+
+	var peerID []byte
+	s.openMu.Lock()
+	if s.peer != nil {
+		peerID = s.peer.id
+	}
+	s.openMu.Unlock()
+	if !s.client && peerID == nil {
+		return nil, ErrNoRequest
+	}
+
+	s.sealMu.Lock()
+	defer s.sealMu.Unlock()
+
+	header := make([]byte, 16)
+	copy(header, s.id)
+	binary.BigEndian.PutUint64(header[8:], s.pid)
+	s.pid++
+
+	body := make([]byte, 0, 1+8+8+2+len(src))
+	if s.client {
+		body = append(body, headerTypeClient)
+		body = binary.BigEndian.AppendUint64(body, uint64(time.Now().Unix()))
+	} else {
+		body = append(body, headerTypeServer)
+		body = binary.BigEndian.AppendUint64(body, uint64(time.Now().Unix()))
+		body = append(body, peerID...)
+	}
+	body = binary.BigEndian.AppendUint16(body, 0) // 不填充
+	body = append(body, src...)
+
+	if s.xaead != nil {
+		nonce := make([]byte, chacha20poly1305.NonceSizeX)
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return nil, err
+		}
+		dst := make([]byte, 0, len(nonce)+len(header)+len(body)+tagSize)
+		dst = append(dst, nonce...)
+		return s.xaead.Seal(dst, nonce, append(header, body...), nil), nil
+	}
+	dst := make([]byte, 16, 16+len(body)+tagSize)
+	s.block.Encrypt(dst, header)
+	return s.aead.Seal(dst, header[4:], body, nil), nil
+}
+
+// Unpack 解密报文, 返回地址+数据
+func (s *PacketSession) Unpack(input []byte) ([]byte, error) {
+	if s.id == nil {
+		return s.cipher.Decrypt(input)
+	}
+
+	s.openMu.Lock()
+	defer s.openMu.Unlock()
+
+	var header, body []byte
+	var aead cipher.AEAD
+	if s.xaead != nil {
+		if len(input) < chacha20poly1305.NonceSizeX+16+tagSize {
+			return nil, ErrShortPacket
+		}
+		nonce := input[:chacha20poly1305.NonceSizeX]
+		plain, err := s.xaead.Open(nil, nonce, input[chacha20poly1305.NonceSizeX:], nil)
+		if err != nil {
+			return nil, ErrAuthFailed
+		}
+		header, body = plain[:16], plain[16:]
+	} else {
+		if len(input) < 16+tagSize {
+			return nil, ErrShortPacket
+		}
+		header = make([]byte, 16)
+		s.block.Decrypt(header, input[:16])
+		if peer := s.peers[string(header[:8])]; peer != nil {
+			aead = peer.aead
+		} else {
+			var err error
+			if aead, err = s.cipher.aead.aead(s.cipher.key, header[:8]); err != nil {
+				return nil, err
+			}
+		}
+		plain, err := aead.Open(nil, header[4:], input[16:], nil)
+		if err != nil {
+			return nil, ErrAuthFailed
+		}
+		body = plain
+	}
+
+	data, err := s.parseBody(body)
+	if err != nil {
+		return nil, err
+	}
+	// 认证通过后按会话检查重放, 只有未见过的会话才切换为当前会话,
+	// 旧会话的报文使用其自己的重放窗口且不会切回
+	now := time.Now()
+	peer := s.peers[string(header[:8])]
+	isNew := peer == nil
+	if isNew {
+		peer = &peerSession{id: append([]byte{}, header[:8]...), aead: aead}
+	}
+	if !peer.window.Check(binary.BigEndian.Uint64(header[8:])) {
+		return nil, ErrReplay
+	}
+	peer.lastSeen = now
+	if isNew {
+		s.expirePeers(now)
+		s.peers[string(peer.id)] = peer
+		s.peer = peer
+	}
+	return data, nil
+}
+
+// expirePeers 删除过期会话, 过期会话的报文时间戳检查不会通过
+func (s *PacketSession) expirePeers(now time.Time) {
+	for id, peer := range s.peers {
+		if peer != s.peer && now.Sub(peer.lastSeen) > timestampTolerance*time.Second {
+			delete(s.peers, id)
+		}
+	}
+}
+
+func (s *PacketSession) parseBody(body []byte) ([]byte, error) {
+	wantType, n := byte(headerTypeServer), 1+8+8+2
+	if !s.client {
+		wantType, n = headerTypeClient, 1+8+2
+	}
+	if len(body) < n {
+		return nil, ErrShortPacket
+	}
+	if body[0] != wantType {
+		return nil, ErrBadHeader
+	}
+	if err := checkTimestamp(binary.BigEndian.Uint64(body[1:])); err != nil {
+		return nil, err
+	}
+	if s.client && string(body[9:17]) != string(s.id) {
+		return nil, ErrBadHeader
+	}
+	paddingLen := int(binary.BigEndian.Uint16(body[n-2:]))
+	if len(body) < n+paddingLen {
+		return nil, ErrShortPacket
+	}
+	return body[n+paddingLen:], nil
+}
+
+// replayWindow 报文ID滑动窗口
+type replayWindow struct {
+	init bool
+	last uint64
+	bits [replayWindowSize / 64]uint64
+}
+
+// Check 记录报文ID, 重复或过旧时返回false
+func (w *replayWindow) Check(id uint64) bool {
+	if !w.init || id > w.last {
+		if !w.init || id-w.last >= replayWindowSize {
+			w.bits = [replayWindowSize / 64]uint64{}
+		} else {
+			for i := w.last + 1; i < id; i++ {
+				w.bits[i%replayWindowSize/64] &^= 1 << (i % 64)
+			}
+		}
+		w.init = true
+		w.last = id
+		w.bits[id%replayWindowSize/64] |= 1 << (id % 64)
+		return true
+	}
+	if w.last-id >= replayWindowSize {
+		return false
+	}
+	idx, bit := id%replayWindowSize/64, uint64(1)<<(id%64)
+	if w.bits[idx]&bit != 0 {
+		return false
+	}
+	w.bits[idx] |= bit
+	return true
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"io"
 	"net"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 var errMalformed = errors.New("SNI: malformed client hello")
@@ -159,3 +161,8 @@ func getServername(c net.Conn) (hostname string, all []byte, err error) {
 	}
 	return
 }
+
+// CloseWrite 半关闭底层连接的写方向
+func (b *bufferedConn) CloseWrite() error {
+	return enet.CloseWrite(b.Conn)
+}
@@ -0,0 +1,71 @@
+package connection
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// expiry 时间轮中的一个key
+type expiry struct {
+	key      string
+	deadline atomic.Int64 // 到期时间, unix nano
+	stale    atomic.Bool  // key已被删除或替换, 触发时丢弃
+}
+
+// wheel 哈希时间轮
+// 所有key的到期时间都不超过 now+ttl, 所以槽数覆盖ttl的单层时间轮即可, 无需分层.
+// 槽触发时才检查key的到期时间, 未到期(期间被Touch过)的重新放入对应的槽,
+// 因此Touch只需原子更新到期时间, 每个tick只处理当前槽内的key.
+type wheel struct {
+	mu     sync.Mutex
+	tick   time.Duration
+	slots  [][]*expiry
+	cursor int
+	last   time.Time // 上次转动的时间
+}
+
+func newWheel(tick, ttl time.Duration, now time.Time) *wheel {
+	return &wheel{
+		tick:  tick,
+		slots: make([][]*expiry, int((ttl+tick-1)/tick)+2),
+		last:  now,
+	}
+}
+
+// add 按到期时间放入对应的槽
+func (sf *wheel) add(e *expiry, now time.Time) {
+	n := int((e.deadline.Load() - now.UnixNano() + int64(sf.tick) - 1) / int64(sf.tick))
+	if n < 1 {
+		n = 1
+	} else if n >= len(sf.slots) {
+		n = len(sf.slots) - 1
+	}
+	sf.mu.Lock()
+	idx := (sf.cursor + n) % len(sf.slots)
+	sf.slots[idx] = append(sf.slots[idx], e)
+	sf.mu.Unlock()
+}
+
+// advance 转动到now, 返回经过的槽内的key
+func (sf *wheel) advance(now time.Time) []*expiry {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	steps := int(now.Sub(sf.last) / sf.tick)
+	if steps <= 0 {
+		return nil
+	}
+	sf.last = sf.last.Add(time.Duration(steps) * sf.tick)
+	if steps > len(sf.slots) {
+		steps = len(sf.slots)
+	}
+	var due []*expiry
+	for ; steps > 0; steps-- {
+		sf.cursor = (sf.cursor + 1) % len(sf.slots)
+		due = append(due, sf.slots[sf.cursor]...)
+		sf.slots[sf.cursor] = nil
+	}
+	return due
+}
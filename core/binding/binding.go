@@ -2,12 +2,15 @@
 package binding
 
 import (
+	"errors"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/things-go/x/extnet"
 	"github.com/thinkgos/jocasta/pkg/bpool"
+	"github.com/thinkgos/jocasta/pkg/enet"
 	"github.com/thinkgos/jocasta/pkg/gopool"
 )
 
@@ -38,17 +41,176 @@ func New(size int, opts ...Option) *Forward {
 	return c
 }
 
+// DefaultHalfCloseTimeout 默认的半关闭等待时长, 见 WithHalfCloseTimeout
+const DefaultHalfCloseTimeout = time.Second * 30
+
+// 会话被关闭的原因
+var (
+	ErrIdleTimeout      = errors.New("binding: idle timeout")
+	ErrMaxLifetime      = errors.New("binding: max session lifetime exceeded")
+	ErrHalfCloseTimeout = errors.New("binding: half close timeout")
+)
+
+// ProxyOption for Forward.Proxy
+type ProxyOption func(c *proxyConfig)
+
+type proxyConfig struct {
+	idleTimeout      time.Duration
+	maxLifetime      time.Duration
+	halfCloseTimeout time.Duration
+}
+
+// WithIdleTimeout 双向均无数据传输超过idle时关闭会话, <= 0 表示不限制
+func WithIdleTimeout(idle time.Duration) ProxyOption {
+	return func(c *proxyConfig) {
+		c.idleTimeout = idle
+	}
+}
+
+// WithMaxLifetime 会话时长超过lifetime时关闭会话, <= 0 表示不限制
+func WithMaxLifetime(lifetime time.Duration) ProxyOption {
+	return func(c *proxyConfig) {
+		c.maxLifetime = lifetime
+	}
+}
+
+// WithHalfCloseTimeout 一个方向半关闭后, 另一方向超过timeout仍未结束时关闭会话,
+// 防止对端一直不关闭时占用连接, <= 0 使用 DefaultHalfCloseTimeout
+func WithHalfCloseTimeout(timeout time.Duration) ProxyOption {
+	return func(c *proxyConfig) {
+		c.halfCloseTimeout = timeout
+	}
+}
+
 // Proxy proxy rw1 and rw2 with binding
-func (sf *Forward) Proxy(rw1, rw2 io.ReadWriter) (err error) {
-	ech1 := make(chan error, 1)
-	ech2 := make(chan error, 1)
-	gopool.Go(sf.gPool, func() { ech1 <- sf.Copy(rw1, rw2) })
-	gopool.Go(sf.gPool, func() { ech2 <- sf.Copy(rw2, rw1) })
-	select {
-	case err = <-ech1:
-	case err = <-ech2:
-	}
-	return
+// 两端均为未经adorn封装的tcp/unix连接(可有流量统计及限速, 见 enet.Splicer)时使用splice(2)零拷贝, 仅linux.
+// 一个方向读到EOF时, 若写端支持半关闭(见 enet.CloseWriter)则半关闭写端, 继续另一方向的传输, 两个方向都结束时返回;
+// 否则任一方向结束时返回. 空闲超时, 超过最大会话时长或半关闭后另一方向超时未结束时关闭rw1和rw2(实现了io.Closer时),
+// 并返回 ErrIdleTimeout, ErrMaxLifetime 或 ErrHalfCloseTimeout
+func (sf *Forward) Proxy(rw1, rw2 io.ReadWriter, opts ...ProxyOption) error {
+	c := proxyConfig{}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.halfCloseTimeout <= 0 {
+		c.halfCloseTimeout = DefaultHalfCloseTimeout
+	}
+
+	var active *atomic.Int64
+	var idleTimer *time.Timer
+	var idle, lifetime <-chan time.Time
+	if c.idleTimeout > 0 {
+		active = new(atomic.Int64)
+		active.Store(time.Now().UnixNano())
+		idleTimer = time.NewTimer(c.idleTimeout)
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+	if c.maxLifetime > 0 {
+		t := time.NewTimer(c.maxLifetime)
+		defer t.Stop()
+		lifetime = t.C
+	}
+
+	var linger <-chan time.Time
+	ech := make(chan error, 2)
+	gopool.Go(sf.gPool, func() { ech <- sf.halfCopy(rw1, rw2, active) })
+	gopool.Go(sf.gPool, func() { ech <- sf.halfCopy(rw2, rw1, active) })
+	for done := 0; ; {
+		select {
+		case err := <-ech:
+			if err != errHalfClosed {
+				return err
+			}
+			if done++; done == 2 {
+				return nil
+			}
+			t := time.NewTimer(c.halfCloseTimeout)
+			defer t.Stop()
+			linger = t.C
+		case <-linger:
+			closeAll(rw1, rw2)
+			return ErrHalfCloseTimeout
+		case <-lifetime:
+			closeAll(rw1, rw2)
+			return ErrMaxLifetime
+		case <-idle:
+			elapsed := time.Since(time.Unix(0, active.Load()))
+			if elapsed >= c.idleTimeout {
+				closeAll(rw1, rw2)
+				return ErrIdleTimeout
+			}
+			// 期间有数据传输, 按最后活跃时间重新计时
+			idleTimer.Reset(c.idleTimeout - elapsed)
+		}
+	}
+}
+
+// errHalfClosed 读到EOF并已半关闭写端
+var errHalfClosed = errors.New("binding: half closed")
+
+// halfCopy 复制src到dst, 读到EOF后半关闭dst, 成功时返回 errHalfClosed.
+// 两端均为未经adorn封装的tcp/unix连接时使用splice(2)零拷贝, 否则使用缓冲复制.
+// active不为nil时读到数据将记录活跃时间
+func (sf *Forward) halfCopy(dst io.Writer, src io.Reader, active *atomic.Int64) error {
+	var err error
+	if d, s, ok := splicePair(dst, src); ok {
+		err = spliceCopy(d, s, active)
+	} else {
+		if active != nil {
+			src = &activeReader{src, active}
+		}
+		err = sf.Copy(dst, src)
+	}
+	if err == nil && enet.CloseWrite(dst) == nil {
+		return errHalfClosed
+	}
+	return err
+}
+
+// IsExpired 是否因空闲超时, 超过最大会话时长或半关闭超时而关闭
+func IsExpired(err error) bool {
+	return errors.Is(err, ErrIdleTimeout) || errors.Is(err, ErrMaxLifetime) || errors.Is(err, ErrHalfCloseTimeout)
+}
+
+// Reason 会话结束的原因, 用于日志
+func Reason(err error) string {
+	switch {
+	case err == nil, errors.Is(err, io.EOF):
+		return "eof"
+	case errors.Is(err, ErrIdleTimeout):
+		return "idle timeout"
+	case errors.Is(err, ErrMaxLifetime):
+		return "max lifetime"
+	case errors.Is(err, ErrHalfCloseTimeout):
+		return "half close timeout"
+	case extnet.IsErrClosed(err):
+		return "closed"
+	default:
+		return err.Error()
+	}
+}
+
+// activeReader 读到数据时记录活跃时间
+type activeReader struct {
+	io.Reader
+	active *atomic.Int64
+}
+
+func (sf *activeReader) Read(p []byte) (int, error) {
+	n, err := sf.Reader.Read(p)
+	if n > 0 {
+		sf.active.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func closeAll(rws ...io.ReadWriter) {
+	for _, rw := range rws {
+		if c, ok := rw.(io.Closer); ok {
+			c.Close() // nolint: errcheck
+		}
+	}
 }
 
 // Copy stream src to dst
@@ -0,0 +1,248 @@
+package binding
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+
+	"github.com/thinkgos/jocasta/connection/cflow"
+)
+
+// pipes client <-> rw1 [Proxy] rw2 <-> server, server端丢弃收到的数据
+func pipes(t *testing.T) (client, rw1, rw2 net.Conn) {
+	client, rw1 = net.Pipe()
+	rw2, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close() // nolint: errcheck
+		server.Close() // nolint: errcheck
+	})
+	go io.Copy(io.Discard, server) // nolint: errcheck
+	return client, rw1, rw2
+}
+
+func TestProxy(t *testing.T) {
+	f := New(1024)
+
+	t.Run("eof", func(t *testing.T) {
+		client, rw1, rw2 := pipes(t)
+		go func() {
+			client.Write([]byte("hello")) // nolint: errcheck
+			client.Close()                // nolint: errcheck
+		}()
+		err := f.Proxy(rw1, rw2, WithIdleTimeout(time.Second))
+		assert.Equal(t, "eof", Reason(err))
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		client, rw1, rw2 := pipes(t)
+		go func() {
+			for i := 0; i < 4; i++ {
+				client.Write([]byte("hello")) // nolint: errcheck
+				time.Sleep(50 * time.Millisecond)
+			}
+		}()
+		start := time.Now()
+		err := f.Proxy(rw1, rw2, WithIdleTimeout(100*time.Millisecond))
+		require.ErrorIs(t, err, ErrIdleTimeout)
+		assert.Equal(t, "idle timeout", Reason(err))
+		// 有数据传输时不超时
+		assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+
+		// 两端均已关闭
+		_, err = rw2.Write([]byte("hello"))
+		assert.Error(t, err)
+	})
+
+	t.Run("max lifetime", func(t *testing.T) {
+		client, rw1, rw2 := pipes(t)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if _, err := client.Write([]byte("hello")); err != nil {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+		err := f.Proxy(rw1, rw2, WithIdleTimeout(time.Second), WithMaxLifetime(100*time.Millisecond))
+		require.ErrorIs(t, err, ErrMaxLifetime)
+		assert.Equal(t, "max lifetime", Reason(err))
+	})
+}
+
+// tcpPair 一对相连的tcp连接
+func tcpPair(t testing.TB) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() // nolint: errcheck
+
+	c1, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	c2, err := ln.Accept()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		c1.Close() // nolint: errcheck
+		c2.Close() // nolint: errcheck
+	})
+	return c1, c2
+}
+
+func TestProxyHalfClose(t *testing.T) {
+	f := New(1024)
+
+	// client <-> rw1 [Proxy] rw2 <-> server
+	client, rw1 := tcpPair(t)
+	rw2, server := tcpPair(t)
+
+	// server读取完整请求后再响应
+	go func() {
+		req, err := io.ReadAll(server)
+		if err != nil {
+			return
+		}
+		server.Write(append([]byte("echo "), req...)) // nolint: errcheck
+		server.Close()                                // nolint: errcheck
+	}()
+
+	ech := make(chan error, 1)
+	go func() { ech <- f.Proxy(rw1, rw2, WithIdleTimeout(time.Second)) }()
+
+	_, err := client.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+
+	resp, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, "echo hello", string(resp))
+
+	err = <-ech
+	assert.NoError(t, err)
+	assert.Equal(t, "eof", Reason(err))
+}
+
+func TestProxyHalfCloseTimeout(t *testing.T) {
+	f := New(1024)
+
+	client, rw1 := tcpPair(t)
+	rw2, server := tcpPair(t)
+	go io.Copy(io.Discard, server) // nolint: errcheck
+
+	ech := make(chan error, 1)
+	go func() { ech <- f.Proxy(rw1, rw2, WithHalfCloseTimeout(100*time.Millisecond)) }()
+
+	// client半关闭, server一直不关闭写端
+	require.NoError(t, client.(*net.TCPConn).CloseWrite())
+
+	select {
+	case err := <-ech:
+		require.ErrorIs(t, err, ErrHalfCloseTimeout)
+		assert.True(t, IsExpired(err))
+		assert.Equal(t, "half close timeout", Reason(err))
+	case <-time.After(time.Second * 3):
+		t.Fatal("proxy not closed after half close timeout")
+	}
+
+	// 两端均已关闭
+	_, err := io.ReadAll(client)
+	assert.NoError(t, err)
+	_, err = rw2.Write([]byte("hello"))
+	assert.Error(t, err)
+}
+
+func TestProxySplice(t *testing.T) {
+	f := New(1024)
+
+	client, rw1 := tcpPair(t)
+	rw2, server := tcpPair(t)
+	in, out := new(atomic.Uint64), new(atomic.Uint64)
+	tracked := &cflow.Conn{Conn: rw2, Wc: in, Rc: out}
+
+	d, s, ok := splicePair(tracked, rw1)
+	require.Equal(t, spliceSupported, ok)
+	if ok {
+		assert.Equal(t, rw2, d.Conn)
+		assert.Len(t, d.wrappers, 1)
+		assert.Equal(t, rw1, s.Conn)
+	}
+	// 含adorn时不使用零拷贝
+	_, _, ok = splicePair(struct{ net.Conn }{rw2}, rw1)
+	assert.False(t, ok)
+
+	go func() {
+		io.Copy(server, server)            // nolint: errcheck
+		server.(*net.TCPConn).CloseWrite() // nolint: errcheck
+	}()
+
+	ech := make(chan error, 1)
+	go func() { ech <- f.Proxy(rw1, tracked, WithIdleTimeout(time.Second)) }()
+
+	want := bytes.Repeat([]byte("hello splice"), 20000)
+	go func() {
+		client.Write(want)                 // nolint: errcheck
+		client.(*net.TCPConn).CloseWrite() // nolint: errcheck
+	}()
+	got, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.NoError(t, <-ech)
+	assert.Equal(t, uint64(len(want)), in.Load())
+	assert.Equal(t, uint64(len(want)), out.Load())
+}
+
+// BenchmarkProxy 回环地址上端到端的转发吞吐, 计时到对端收完所有数据,
+// splice为两端均为tcp连接, buffered为含adorn封装时的缓冲复制
+func BenchmarkProxy(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		wrap func(c net.Conn) net.Conn
+	}{
+		{"splice", func(c net.Conn) net.Conn { return c }},
+		{"buffered", func(c net.Conn) net.Conn { return struct{ net.Conn }{c} }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			f := New(32 * 1024)
+			client, rw1 := tcpPair(b)
+			rw2, server := tcpPair(b)
+			buf := make([]byte, 128*1024)
+			total := int64(b.N) * int64(len(buf))
+			received := make(chan error, 1)
+			go func() {
+				_, err := io.CopyN(io.Discard, server, total)
+				received <- err
+			}()
+			proxied := make(chan struct{})
+			go func() {
+				f.Proxy(bb.wrap(rw1), bb.wrap(rw2)) // nolint: errcheck
+				close(proxied)
+			}()
+
+			b.SetBytes(int64(len(buf)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Write(buf); err != nil {
+					b.Fatal(err)
+				}
+			}
+			// 等待对端收完
+			require.NoError(b, <-received)
+			b.StopTimer()
+
+			for _, c := range []net.Conn{client, rw1, rw2, server} {
+				c.Close() // nolint: errcheck
+			}
+			<-proxied
+		})
+	}
+}
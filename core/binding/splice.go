@@ -0,0 +1,90 @@
+package binding
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// spliceChunk 零拷贝时每段复制的最大字节数, 每段复制后统计, 限速及记录活跃时间
+const spliceChunk = 64 * 1024
+
+// spliceConn 可零拷贝复制的底层连接及其外层的 enet.Splicer 封装
+type spliceConn struct {
+	net.Conn
+	wrappers []enet.Splicer
+}
+
+// unwrapSplice 剥离 enet.Splicer 封装, 底层为*net.TCPConn或流式*net.UnixConn时返回
+func unwrapSplice(v interface{}) (*spliceConn, bool) {
+	sc := &spliceConn{}
+	for {
+		switch c := v.(type) {
+		case enet.Splicer:
+			sc.wrappers = append(sc.wrappers, c)
+			v = c.SpliceConn()
+		case *net.TCPConn:
+			sc.Conn = c
+			return sc, true
+		case *net.UnixConn:
+			if c.LocalAddr().Network() != "unix" {
+				return nil, false
+			}
+			sc.Conn = c
+			return sc, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// splicePair dst和src均可零拷贝时返回其底层连接, splice(2)只能写入tcp连接
+func splicePair(dst io.Writer, src io.Reader) (d, s *spliceConn, ok bool) {
+	if !spliceSupported {
+		return nil, nil, false
+	}
+	if d, ok = unwrapSplice(dst); !ok {
+		return nil, nil, false
+	}
+	if _, ok = d.Conn.(*net.TCPConn); !ok {
+		return nil, nil, false
+	}
+	if s, ok = unwrapSplice(src); !ok {
+		return nil, nil, false
+	}
+	return d, s, true
+}
+
+// spliceCopy 零拷贝复制src到dst直到EOF, 按 spliceChunk 分段
+func spliceCopy(dst, src *spliceConn, active *atomic.Int64) error {
+	rf := dst.Conn.(io.ReaderFrom)
+	lr := &io.LimitedReader{R: src.Conn}
+	for {
+		lr.N = spliceChunk
+		n, err := rf.ReadFrom(lr)
+		if n > 0 {
+			if active != nil {
+				active.Store(time.Now().UnixNano())
+			}
+			for _, w := range src.wrappers {
+				if er := w.SpliceRead(int(n)); er != nil && err == nil {
+					err = er
+				}
+			}
+			for _, w := range dst.wrappers {
+				if er := w.SpliceWrite(int(n)); er != nil && err == nil {
+					err = er
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if lr.N > 0 { // EOF
+			return nil
+		}
+	}
+}
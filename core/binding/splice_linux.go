@@ -0,0 +1,4 @@
+package binding
+
+// spliceSupported 是否支持splice(2)零拷贝
+const spliceSupported = true
@@ -0,0 +1,6 @@
+//go:build !linux
+
+package binding
+
+// spliceSupported 是否支持splice(2)零拷贝
+const spliceSupported = false
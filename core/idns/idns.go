@@ -2,6 +2,7 @@
 package idns
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
@@ -116,6 +117,72 @@ func (sf *Resolver) Resolve(domain string) (string, error) {
 	return "", fmt.Errorf("unknow answer")
 }
 
+// ipsItem LookupIP 缓存条目
+type ipsItem struct {
+	ips       []net.IP
+	expiredAt int64 // 过期时间,unix时间
+}
+
+// LookupIP 查询host的ip地址, network 为 ip4(A记录) 或 ip6(AAAA记录), 实现 connection.Resolver
+// sf 为nil或未配置公共dns地址时, 使用系统的解析
+func (sf *Resolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	if sf == nil || sf.publicDNSAddr == "" {
+		return net.DefaultResolver.LookupIP(ctx, network, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	var qtype uint16
+
+	switch network {
+	case "ip4":
+		qtype = dns.TypeA
+	case "ip6":
+		qtype = dns.TypeAAAA
+	default:
+		return nil, fmt.Errorf("unsupported network %s", network)
+	}
+
+	key := network + "/" + host
+	if v, ok := sf.cache.Get(key); ok {
+		if itm := v.(*ipsItem); itm.expiredAt > time.Now().Unix() {
+			return itm.ips, nil
+		}
+	}
+
+	cli := &dns.Client{
+		DialTimeout:  time.Millisecond * 5000,
+		ReadTimeout:  time.Millisecond * 5000,
+		WriteTimeout: time.Millisecond * 5000,
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), qtype)
+	msg.RecursionDesired = true
+	r, _, err := cli.ExchangeContext(ctx, msg, sf.publicDNSAddr)
+	if err != nil {
+		return nil, err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, &net.DNSError{Err: dns.RcodeToString[r.Rcode], Name: host, Server: sf.publicDNSAddr, IsNotFound: r.Rcode == dns.RcodeNameError}
+	}
+
+	var ips []net.IP
+	for _, answer := range r.Answer {
+		switch rr := answer.(type) {
+		case *dns.A:
+			ips = append(ips, rr.A)
+		case *dns.AAAA:
+			ips = append(ips, rr.AAAA)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: sf.publicDNSAddr, IsNotFound: true}
+	}
+	sf.cache.Set(key, &ipsItem{ips, time.Now().Unix() + int64(sf.ttl)})
+	return ips, nil
+}
+
 func joinIPPort(ip, port string) string {
 	if port != "" {
 		return net.JoinHostPort(ip, port)
@@ -1,6 +1,8 @@
 package idns
 
 import (
+	"context"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,3 +38,17 @@ func TestIdns(t *testing.T) {
 	require.NoError(t, err)
 	t.Logf("resolve domain: %s - %s", domainButIpPort, ip)
 }
+
+func TestLookupIP(t *testing.T) {
+	var nilResolver *Resolver
+	ips, err := nilResolver.LookupIP(context.Background(), "ip4", "localhost")
+	require.NoError(t, err)
+	require.NotEmpty(t, ips)
+
+	srv := New("127.0.0.1:53", 60)
+	ips, err = srv.LookupIP(context.Background(), "ip6", "::1")
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.ParseIP("::1")}, ips)
+	_, err = srv.LookupIP(context.Background(), "ip", "localhost")
+	require.Error(t, err)
+}
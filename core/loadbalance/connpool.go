@@ -0,0 +1,284 @@
+package loadbalance
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/gopool"
+	"github.com/thinkgos/jocasta/pkg/logger"
+)
+
+const (
+	poolMaintainInterval = time.Second      // 连接池维护间隔
+	poolRetryInterval    = time.Second * 5  // 预热失败后的重试间隔
+	poolHandshakeTimeout = time.Second * 10 // 预热时握手超时时间
+)
+
+// DialFunc 建立到后端addr的连接
+type DialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// PoolConfig 预热连接池配置
+// 每个后端保持MinIdle个空闲连接, 连接池取空时逐步增加到MaxIdle, 空闲连接过期时逐步回落到MinIdle
+type PoolConfig struct {
+	MinIdle int           // 最小空闲连接数, default: 0
+	MaxIdle int           // 最大空闲连接数, <= 0 表示不使用连接池, default: 0
+	IdleTTL time.Duration // 空闲连接最长存活时间, 应小于后端的空闲超时, default: 30s
+}
+
+func (sf PoolConfig) enabled() bool { return sf.MaxIdle > 0 }
+
+func (sf PoolConfig) withDefault() PoolConfig {
+	if sf.MinIdle < 0 {
+		sf.MinIdle = 0
+	}
+	if sf.MinIdle > sf.MaxIdle {
+		sf.MinIdle = sf.MaxIdle
+	}
+	if sf.IdleTTL <= 0 {
+		sf.IdleTTL = time.Second * 30
+	}
+	return sf
+}
+
+// idleConn 空闲连接, 在池中时由watch协程阻塞读dial返回的连接,
+// 空闲连接不应收到任何数据, 读返回即表示对端已关闭或连接异常
+type idleConn struct {
+	net.Conn
+	adorned net.Conn // 装饰后的连接, 取出时返回
+	since   time.Time
+	pooled  bool // 是否还在池中, 由connPool.mu保护
+
+	done chan struct{} // watch协程已退出
+	n    int
+	err  error
+}
+
+// take 中断watch协程的读, 连接仍然可用时返回true, 否则关闭连接
+func (sf *idleConn) take() bool {
+	sf.Conn.SetReadDeadline(time.Unix(1, 0)) // nolint: errcheck
+	<-sf.done
+	if sf.n > 0 || !isTimeout(sf.err) {
+		sf.Conn.Close() // nolint: errcheck
+		return false
+	}
+	if err := sf.Conn.SetReadDeadline(time.Time{}); err != nil {
+		sf.Conn.Close() // nolint: errcheck
+		return false
+	}
+	return true
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// connPool 单个后端的预热连接池
+type connPool struct {
+	addr   string
+	config PoolConfig
+	dial   DialFunc
+	adorn  func(conn net.Conn) net.Conn
+	goPool gopool.Pool
+	log    logger.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	idle    []*idleConn // 按入池时间排序, 最新的在尾部
+	want    int         // 期望保持的空闲连接数, [MinIdle, MaxIdle]
+	pending int         // 正在建立的连接数
+	retryAt time.Time   // 预热失败后, 在此时间之前不再预热
+	closed  bool
+}
+
+func newConnPool(addr string, config PoolConfig, dial DialFunc, adorn func(conn net.Conn) net.Conn,
+	goPool gopool.Pool, log logger.Logger) *connPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &connPool{
+		addr:   addr,
+		config: config,
+		dial:   dial,
+		adorn:  adorn,
+		goPool: goPool,
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+		want:   config.MinIdle,
+	}
+}
+
+// get 取出最新的可用空闲连接, 没有时返回nil, 并增加期望的空闲连接数
+func (sf *connPool) get() net.Conn {
+	for {
+		sf.mu.Lock()
+		if len(sf.idle) == 0 {
+			if sf.want < sf.config.MaxIdle {
+				sf.want++
+			}
+			sf.mu.Unlock()
+			sf.refill()
+			return nil
+		}
+		ic := sf.idle[len(sf.idle)-1]
+		sf.idle[len(sf.idle)-1] = nil
+		sf.idle = sf.idle[:len(sf.idle)-1]
+		ic.pooled = false
+		sf.mu.Unlock()
+
+		if ic.take() {
+			sf.refill()
+			return ic.adorned
+		}
+	}
+}
+
+// maintain 关闭过期的空闲连接并补充预热连接
+func (sf *connPool) maintain() {
+	var expired []*idleConn
+
+	sf.mu.Lock()
+	deadline := time.Now().Add(-sf.config.IdleTTL)
+	i := 0
+	for ; i < len(sf.idle) && sf.idle[i].since.Before(deadline); i++ {
+		sf.idle[i].pooled = false
+		expired = append(expired, sf.idle[i])
+	}
+	sf.idle = append(sf.idle[:0], sf.idle[i:]...)
+	// 过期说明连接有富余, 逐步减少期望的空闲连接数
+	if len(expired) > 0 && sf.want > sf.config.MinIdle {
+		sf.want--
+	}
+	sf.mu.Unlock()
+
+	for _, ic := range expired {
+		ic.Conn.Close() // nolint: errcheck
+	}
+	sf.fill()
+}
+
+func (sf *connPool) refill() {
+	gopool.Go(sf.goPool, sf.fill)
+}
+
+// fill 建立连接, 直到空闲连接数达到期望值
+func (sf *connPool) fill() {
+	for {
+		sf.mu.Lock()
+		if sf.closed || len(sf.idle)+sf.pending >= sf.want || time.Now().Before(sf.retryAt) {
+			sf.mu.Unlock()
+			return
+		}
+		sf.pending++
+		sf.mu.Unlock()
+
+		c, ac, err := sf.connect()
+
+		sf.mu.Lock()
+		sf.pending--
+		if err != nil {
+			sf.retryAt = time.Now().Add(poolRetryInterval)
+			sf.mu.Unlock()
+			sf.log.Debugf("conn pool prewarm %s, %v", sf.addr, err)
+			return
+		}
+		if sf.closed {
+			sf.mu.Unlock()
+			c.Close() // nolint: errcheck
+			return
+		}
+		ic := &idleConn{Conn: c, adorned: ac, since: time.Now(), pooled: true, done: make(chan struct{})}
+		sf.idle = append(sf.idle, ic)
+		sf.mu.Unlock()
+		go sf.watch(ic)
+	}
+}
+
+// connect 建立连接并装饰, 返回dial的连接和装饰后的连接,
+// 两者需要握手时(如tls, stcp的ckex, caead)立即完成握手
+func (sf *connPool) connect() (net.Conn, net.Conn, error) {
+	c, err := sf.dial(sf.ctx, sf.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = sf.handshake(c); err != nil {
+		c.Close() // nolint: errcheck
+		return nil, nil, err
+	}
+	ac := c
+	if sf.adorn != nil {
+		ac = sf.adorn(c)
+		if err = sf.handshake(ac); err != nil {
+			c.Close() // nolint: errcheck
+			return nil, nil, err
+		}
+	}
+	return c, ac, nil
+}
+
+// handshake 连接需要握手时完成握手
+func (sf *connPool) handshake(c net.Conn) error {
+	switch hs := c.(type) {
+	case interface{ HandshakeContext(context.Context) error }:
+		ctx, cancel := context.WithTimeout(sf.ctx, poolHandshakeTimeout)
+		defer cancel()
+		return hs.HandshakeContext(ctx)
+	case interface{ Handshake() error }:
+		c.SetDeadline(time.Now().Add(poolHandshakeTimeout)) // nolint: errcheck
+		defer c.SetDeadline(time.Time{})                    // nolint: errcheck
+		return hs.Handshake()
+	}
+	return nil
+}
+
+// watch 阻塞读空闲连接, 在池中时读返回说明连接已失效, 将其移出连接池
+func (sf *connPool) watch(ic *idleConn) {
+	var b [1]byte
+
+	ic.n, ic.err = ic.Conn.Read(b[:])
+
+	sf.mu.Lock()
+	dead := ic.pooled
+	if dead {
+		ic.pooled = false
+		for i, c := range sf.idle {
+			if c == ic {
+				sf.idle = append(sf.idle[:i], sf.idle[i+1:]...)
+				break
+			}
+		}
+	}
+	sf.mu.Unlock()
+	if dead {
+		ic.Conn.Close() // nolint: errcheck
+	}
+	close(ic.done)
+}
+
+// close 关闭连接池及所有空闲连接
+func (sf *connPool) close() {
+	sf.mu.Lock()
+	idle := sf.idle
+	sf.idle = nil
+	sf.closed = true
+	for _, ic := range idle {
+		ic.pooled = false
+	}
+	sf.mu.Unlock()
+
+	sf.cancel()
+	for _, ic := range idle {
+		ic.Conn.Close() // nolint: errcheck
+	}
+}
+
+// idleCount 空闲连接数
+func (sf *connPool) idleCount() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return len(sf.idle)
+}
@@ -0,0 +1,160 @@
+package loadbalance
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/connection/caead"
+	"github.com/thinkgos/jocasta/connection/ckex"
+)
+
+func TestConnPool(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() // nolint: errcheck
+
+	accepted := make(chan net.Conn, 16)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	var dials int32
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		atomic.AddInt32(&dials, 1)
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	addr := ln.Addr().String()
+	lb := New("roundrobin", []Config{{Addr: addr}},
+		WithInterval(0),
+		WithConnPool(PoolConfig{MinIdle: 1, MaxIdle: 2, IdleTTL: time.Second * 2}, dial),
+	)
+	defer lb.Close() // nolint: errcheck
+	pool := lb.pools[addr]
+	require.NotNil(t, pool)
+
+	// 预热
+	require.Eventually(t, func() bool { return pool.idleCount() == 1 }, time.Second*3, time.Millisecond*10)
+	srv := <-accepted
+
+	// 使用预热的连接
+	c, err := lb.Dial(context.Background(), addr)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&dials))
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	_, err = srv.Read(b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+	c.Close()
+	srv.Close()
+
+	// 对端关闭的连接被丢弃
+	require.Eventually(t, func() bool { return pool.idleCount() == 1 }, time.Second*3, time.Millisecond*10)
+	(<-accepted).Close()
+	require.Eventually(t, func() bool { return pool.idleCount() == 0 }, time.Second, time.Millisecond*10)
+
+	// 连接池为空时直接连接, 并增加期望的空闲连接数
+	pool.mu.Lock()
+	pool.retryAt = time.Now().Add(time.Hour)
+	pool.mu.Unlock()
+	c, err = lb.Dial(context.Background(), addr)
+	require.NoError(t, err)
+	c.Close()
+	(<-accepted).Close()
+	pool.mu.Lock()
+	assert.Equal(t, 2, pool.want)
+	pool.retryAt = time.Time{}
+	pool.mu.Unlock()
+	require.Eventually(t, func() bool { return pool.idleCount() == 2 }, time.Second*3, time.Millisecond*10)
+
+	// 过期的连接被关闭, 期望的空闲连接数回落
+	require.Eventually(t, func() bool {
+		pool.mu.Lock()
+		defer pool.mu.Unlock()
+		return pool.want == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// 更新后端后关闭原有的连接池
+	lb.Reset([]Config{{Addr: "127.0.0.1:1"}})
+	assert.Equal(t, 0, pool.idleCount())
+	assert.NotNil(t, lb.pools["127.0.0.1:1"])
+}
+
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (sf *prefixConn) Read(b []byte) (int, error) { return sf.r.Read(b) }
+
+func TestConnPoolHandshake(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() // nolint: errcheck
+
+	psk := ckex.DeriveKey("password")
+	cip, err := caead.NewCipher(caead.MethodAes128Gcm, "password")
+	require.NoError(t, err)
+
+	dial := func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		c, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return ckex.New(c, psk), nil
+	}
+	addr := ln.Addr().String()
+	lb := New("roundrobin", []Config{{Addr: addr}},
+		WithInterval(0),
+		WithConnPool(PoolConfig{MinIdle: 1, MaxIdle: 1}, dial),
+		WithConnAdorn(func(c net.Conn) net.Conn { return caead.New(c, cip, false) }),
+	)
+	defer lb.Close() // nolint: errcheck
+
+	raw, err := ln.Accept()
+	require.NoError(t, err)
+	defer raw.Close() // nolint: errcheck
+
+	// 预热时完成ckex握手, 并发送caead的salt
+	srv := ckex.New(raw, psk)
+	require.NoError(t, srv.Handshake())
+	header := make([]byte, 1+16)
+	_, err = io.ReadFull(srv, header)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return lb.pools[addr].idleCount() == 1 }, time.Second*3, time.Millisecond*10)
+
+	// 取出的连接仍然可用
+	c, err := lb.Dial(context.Background(), addr)
+	require.NoError(t, err)
+	defer c.Close() // nolint: errcheck
+	_, err = c.Write([]byte("ping"))
+	require.NoError(t, err)
+	sc := caead.New(&prefixConn{srv, io.MultiReader(bytes.NewReader(header), srv)}, cip, true)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(sc, b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+
+	_, err = sc.Write([]byte("pong"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(c, b)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(b))
+}
@@ -1,7 +1,10 @@
 package loadbalance
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"runtime/debug"
 	"strings"
 	"sync"
@@ -79,10 +82,15 @@ type Balanced struct {
 	goPool   gopool.Pool
 	log      logger.Logger
 
+	dial       DialFunc
+	adorn      func(conn net.Conn) net.Conn
+	poolConfig PoolConfig
+
 	rw        sync.RWMutex
 	closeChan chan struct{}
 	upstreams UpstreamPool
 	selector  Selector
+	pools     map[string]*connPool
 }
 
 // New new a load balance with method and upstream config
@@ -110,6 +118,11 @@ func New(method string, configs []Config, opts ...Option) *Balanced {
 	if lb.interval > 0 {
 		go lb.activeHealthChecker()
 	}
+	if lb.dial != nil && lb.poolConfig.enabled() {
+		lb.poolConfig = lb.poolConfig.withDefault()
+		lb.pools = lb.newConnPools(lb.upstreams, nil)
+		go lb.connPoolMaintainer()
+	}
 	return lb
 }
 
@@ -126,14 +139,41 @@ func (sf *Balanced) Select(srcAddr string) string {
 		sf.log.Infof("#########--> choose %s <--#########", b.Addr)
 		sf.log.Debugf("############ Load Balance start ############")
 		for _, ups := range sf.upstreams {
-			sf.log.Debugf("addr: %s,conns: %d,time: %d,weight: %d,health: %v\n",
-				ups.Addr, ups.ConnsCount(), ups.LeastTime(), ups.Weight, ups.Healthy())
+			idle := 0
+			if p := sf.pools[ups.Addr]; p != nil {
+				idle = p.idleCount()
+			}
+			sf.log.Debugf("addr: %s,conns: %d,idle: %d,time: %d,weight: %d,health: %v\n",
+				ups.Addr, ups.ConnsCount(), idle, ups.LeastTime(), ups.Weight, ups.Healthy())
 		}
 		sf.log.Debugf("############ Load Balance end ############")
 	}
 	return b.Addr
 }
 
+// Dial 连接后端addr, 使能连接池时优先使用预热的空闲连接
+func (sf *Balanced) Dial(ctx context.Context, addr string) (net.Conn, error) {
+	if sf.dial == nil {
+		return nil, errors.New("loadbalance: dial function not set")
+	}
+	sf.rw.RLock()
+	p := sf.pools[addr]
+	sf.rw.RUnlock()
+	if p != nil {
+		if c := p.get(); c != nil {
+			return c, nil
+		}
+	}
+	c, err := sf.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if sf.adorn != nil {
+		c = sf.adorn(c)
+	}
+	return c, nil
+}
+
 // ConnsIncrease increase the addr conns count
 func (sf *Balanced) ConnsIncrease(addr string) {
 	sf.rw.Lock()
@@ -156,6 +196,9 @@ func (sf *Balanced) Close() error {
 	case <-sf.closeChan:
 	default:
 		close(sf.closeChan)
+		for _, p := range sf.pools {
+			p.close()
+		}
 	}
 	return nil
 }
@@ -180,6 +223,26 @@ func (sf *Balanced) Reset(configs []Config) {
 	defer sf.rw.Unlock()
 	sf.upstreams = NewUpstreamPool(configs)
 	sf.selector = getNewSelectorFunction(sf.method)()
+	if sf.pools != nil {
+		sf.pools = sf.newConnPools(sf.upstreams, sf.pools)
+	}
+}
+
+// newConnPools 为每个后端创建连接池, 复用old中仍存在的后端的连接池, 关闭其余的
+func (sf *Balanced) newConnPools(upstreams UpstreamPool, old map[string]*connPool) map[string]*connPool {
+	pools := make(map[string]*connPool, len(upstreams))
+	for _, ups := range upstreams {
+		if p, ok := old[ups.Addr]; ok {
+			pools[ups.Addr] = p
+			delete(old, ups.Addr)
+		} else {
+			pools[ups.Addr] = newConnPool(ups.Addr, sf.poolConfig, sf.dial, sf.adorn, sf.goPool, sf.log)
+		}
+	}
+	for _, p := range old {
+		p.close()
+	}
+	return pools
 }
 
 // resolve resolve the addr to ip:port
@@ -216,3 +279,23 @@ func (sf *Balanced) activeHealthChecker() {
 		sf.rw.Unlock()
 	}
 }
+
+// connPoolMaintainer 定时维护连接池
+// it must be run in a goroutine
+func (sf *Balanced) connPoolMaintainer() {
+	ticker := time.NewTicker(poolMaintainInterval)
+	defer ticker.Stop()
+	for {
+		sf.rw.RLock()
+		for _, p := range sf.pools {
+			gopool.Go(sf.goPool, p.maintain)
+		}
+		sf.rw.RUnlock()
+
+		select {
+		case <-ticker.C:
+		case <-sf.closeChan:
+			return
+		}
+	}
+}
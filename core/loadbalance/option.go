@@ -1,6 +1,7 @@
 package loadbalance
 
 import (
+	"net"
 	"time"
 
 	"github.com/thinkgos/jocasta/core/idns"
@@ -45,3 +46,20 @@ func WithInterval(interval time.Duration) Option {
 		g.interval = interval
 	}
 }
+
+// WithConnPool 使用dial连接后端, config.MaxIdle > 0 时为每个后端维护预热的空闲连接池,
+// dial 返回的连接如需握手(如tls, stcp), 预热时将立即完成握手
+func WithConnPool(config PoolConfig, dial DialFunc) Option {
+	return func(g *Balanced) {
+		g.poolConfig = config
+		g.dial = dial
+	}
+}
+
+// WithConnAdorn Dial 返回前使用adorn装饰连接(如压缩, 加密),
+// 连接池预热时即完成装饰, 装饰后的连接如需握手(如caead), 预热时将立即完成握手
+func WithConnAdorn(adorn func(conn net.Conn) net.Conn) Option {
+	return func(g *Balanced) {
+		g.adorn = adorn
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"errors"
 	"net"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
 )
 
 // Config 后端配置
@@ -32,9 +36,10 @@ type Upstream struct {
 
 // NewUpstream new a upstream
 func NewUpstream(config Config) (*Upstream, error) {
-	_, _, err := net.SplitHostPort(config.Addr)
-	if err != nil {
-		return nil, errors.New("address required like host:port")
+	if !enet.IsUnixAddr(config.Addr) {
+		if _, _, err := net.SplitHostPort(config.Addr); err != nil {
+			return nil, errors.New("address required like host:port or unix:///path")
+		}
 	}
 	if config.SuccessThreshold == 0 {
 		config.SuccessThreshold = 3
@@ -105,7 +110,11 @@ func (sf *Upstream) healthyCheck(addr string) {
 }
 
 func tcpLivenessProbe(_ context.Context, addr string, timeout time.Duration) error {
-	c, err := net.DialTimeout("tcp", addr, timeout)
+	network := "tcp"
+	if path, ok := enet.UnixPath(addr); ok {
+		network, addr = "unix", path
+	}
+	c, err := net.DialTimeout(network, addr, timeout)
 	if err != nil {
 		return err
 	}
@@ -113,6 +122,21 @@ func tcpLivenessProbe(_ context.Context, addr string, timeout time.Duration) err
 	return nil
 }
 
+// SplitAddrWeight 解析 addr@weight 格式的后端地址, weight缺省或无效时为1
+// 支持 unix:///path@weight 和 unix://@name@weight
+func SplitAddrWeight(s string) (addr string, weight int) {
+	addr, weight = s, 1
+	i := strings.LastIndex(s, "@")
+	if i < 0 || s[:i] == enet.UnixScheme { // unix://@name 抽象命名空间
+		return
+	}
+	addr = s[:i]
+	if w, _ := strconv.Atoi(s[i+1:]); w > 0 {
+		weight = w
+	}
+	return
+}
+
 /******************************************************************************/
 
 // UpstreamPool upstream pool
@@ -127,3 +127,23 @@ func TestUpstreamPool(t *testing.T) {
 
 	assert.Equal(t, 2, len(pool))
 }
+
+func TestSplitAddrWeight(t *testing.T) {
+	tests := []struct {
+		s      string
+		addr   string
+		weight int
+	}{
+		{"127.0.0.1:8080", "127.0.0.1:8080", 1},
+		{"127.0.0.1:8080@3", "127.0.0.1:8080", 3},
+		{"127.0.0.1:8080@invalid", "127.0.0.1:8080", 1},
+		{"unix:///run/jocasta.sock@2", "unix:///run/jocasta.sock", 2},
+		{"unix://@jocasta", "unix://@jocasta", 1},
+		{"unix://@jocasta@5", "unix://@jocasta", 5},
+	}
+	for _, tt := range tests {
+		addr, weight := SplitAddrWeight(tt.s)
+		assert.Equal(t, tt.addr, addr)
+		assert.Equal(t, tt.weight, weight)
+	}
+}
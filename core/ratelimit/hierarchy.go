@@ -0,0 +1,180 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// Limits 各层级速率, 0 表示不限速
+type Limits struct {
+	Global  rate.Limit `json:"global"`
+	Service rate.Limit `json:"service"`
+	User    rate.Limit `json:"user"`
+	Conn    rate.Limit `json:"conn"`
+}
+
+// Hierarchy 分层限速: 全局 -> 服务 -> 用户或源IP -> 连接, 一个连接需同时满足所有层级
+type Hierarchy struct {
+	global  *Limiter // 多个服务共享, 可为nil
+	service *Limiter
+	user    *Group
+	conn    *Group // 每个连接独立, 以连接序号为key
+	seq     atomic.Uint64
+}
+
+// New new a hierarchy, global 为多个服务共享的全局限速器, 可为nil
+func New(global *Limiter, service, user, conn rate.Limit) *Hierarchy {
+	return &Hierarchy{
+		global:  global,
+		service: NewLimiter(service),
+		user:    NewGroup(user),
+		conn:    NewGroup(conn),
+	}
+}
+
+// Build 解析各层级速率并创建, 速率如 2m, 100k, "" 或 "0" 表示不限速
+func Build(global *Limiter, service, user, conn string) (*Hierarchy, error) {
+	var limits [3]rate.Limit
+	for i, s := range [...]struct{ name, value string }{
+		{"service", service},
+		{"user", user},
+		{"conn", conn},
+	} {
+		limit, err := Parse(s.value)
+		if err != nil {
+			return nil, fmt.Errorf("parse %s rate limit, %v", s.name, err)
+		}
+		limits[i] = limit
+	}
+	return New(global, limits[0], limits[1], limits[2]), nil
+}
+
+// Key 用户或源IP层级的key, 有认证用户时为用户名, 否则为源IP
+func Key(user, srcAddr string) string {
+	if user != "" {
+		return user
+	}
+	if host, _, err := net.SplitHostPort(srcAddr); err == nil {
+		return host
+	}
+	return srcAddr
+}
+
+// Limits 当前各层级速率
+func (sf *Hierarchy) Limits() Limits {
+	var global rate.Limit
+	if sf.global != nil {
+		global = sf.global.Limit()
+	}
+	return Limits{
+		Global:  global,
+		Service: sf.service.Limit(),
+		User:    sf.user.Limit(),
+		Conn:    sf.conn.Limit(),
+	}
+}
+
+// SetGlobalLimit 运行时修改全局速率, 影响共享此全局限速器的所有服务
+func (sf *Hierarchy) SetGlobalLimit(limit rate.Limit) {
+	if sf.global != nil {
+		sf.global.SetLimit(limit)
+	}
+}
+
+// SetServiceLimit 运行时修改服务速率
+func (sf *Hierarchy) SetServiceLimit(limit rate.Limit) { sf.service.SetLimit(limit) }
+
+// SetUserLimit 运行时修改每个用户或源IP的速率
+func (sf *Hierarchy) SetUserLimit(limit rate.Limit) { sf.user.SetLimit(limit) }
+
+// SetConnLimit 运行时修改每个连接的速率
+func (sf *Hierarchy) SetConnLimit(limit rate.Limit) { sf.conn.SetLimit(limit) }
+
+// Conn 对目标端连接c限速, 读为下行, 写为上行, key为认证用户或源IP
+func (sf *Hierarchy) Conn(c net.Conn, key string) net.Conn {
+	connKey := strconv.FormatUint(sf.seq.Inc(), 10)
+	user := sf.user.Acquire(key)
+	conn := sf.conn.Acquire(connKey)
+	limiters := make([]*Limiter, 0, 4)
+	if sf.global != nil {
+		limiters = append(limiters, sf.global)
+	}
+	limiters = append(limiters, sf.service, user, conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lc := &Conn{
+		Conn:   c,
+		ctx:    ctx,
+		cancel: cancel,
+		release: func() {
+			sf.user.Release(key)
+			sf.conn.Release(connKey)
+		},
+	}
+	for _, l := range limiters {
+		lc.up = append(lc.up, l.up)
+		lc.down = append(lc.down, l.down)
+	}
+	return lc
+}
+
+// Conn 分层限速连接
+type Conn struct {
+	net.Conn
+	ctx     context.Context
+	cancel  context.CancelFunc
+	up      []*rate.Limiter
+	down    []*rate.Limiter
+	release func()
+	once    sync.Once
+}
+
+// Read reads data from the connection, 下行限速
+func (sf *Conn) Read(p []byte) (int, error) {
+	n, err := sf.Conn.Read(p)
+	if n > 0 {
+		if er := waitN(sf.ctx, n, sf.down); er != nil && err == nil {
+			err = er
+		}
+	}
+	return n, err
+}
+
+// Write writes data to the connection, 上行限速
+func (sf *Conn) Write(p []byte) (int, error) {
+	if err := waitN(sf.ctx, len(p), sf.up); err != nil {
+		return 0, err
+	}
+	return sf.Conn.Write(p)
+}
+
+// Close close the connection, 释放限速器引用
+func (sf *Conn) Close() error {
+	sf.once.Do(func() {
+		sf.cancel()
+		sf.release()
+	})
+	return sf.Conn.Close()
+}
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *Conn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
+
+// SpliceConn 被限速的连接, 见 enet.Splicer
+func (sf *Conn) SpliceConn() net.Conn { return sf.Conn }
+
+// SpliceRead 零拷贝读出n字节后下行限速
+func (sf *Conn) SpliceRead(n int) error { return waitN(sf.ctx, n, sf.down) }
+
+// SpliceWrite 零拷贝写入n字节后上行限速
+func (sf *Conn) SpliceWrite(n int) error { return waitN(sf.ctx, n, sf.up) }
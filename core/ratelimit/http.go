@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/time/rate"
+)
+
+// ServeHTTP 运维接口
+// GET 获取各层级速率, PUT 修改速率, 通过query指定: global, service, user, conn, 如 2m, 100k, 0 表示不限速
+func (sf *Hierarchy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		q := r.URL.Query()
+		setters := []struct {
+			key string
+			set func(rate.Limit)
+		}{
+			{"global", sf.SetGlobalLimit},
+			{"service", sf.SetServiceLimit},
+			{"user", sf.SetUserLimit},
+			{"conn", sf.SetConnLimit},
+		}
+		limits := make([]rate.Limit, len(setters))
+		// 先全部解析, 避免部分生效
+		for i, s := range setters {
+			if !q.Has(s.key) {
+				continue
+			}
+			limit, err := Parse(q.Get(s.key))
+			if err != nil {
+				http.Error(w, s.key+": "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			limits[i] = limit
+		}
+		for i, s := range setters {
+			if q.Has(s.key) {
+				s.set(limits[i])
+			}
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sf.Limits()) // nolint: errcheck
+}
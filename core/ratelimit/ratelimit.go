@@ -0,0 +1,157 @@
+// Package ratelimit 分层共享限速, 全局 -> 服务 -> 用户或源IP -> 连接, 上下行均限速
+package ratelimit
+
+import (
+	"context"
+	"sync"
+
+	"github.com/things-go/meter"
+	"golang.org/x/time/rate"
+)
+
+// minBurst 令牌桶最小容量, 也是单次等待的最大字节数
+const minBurst = 64 * 1024
+
+// Parse 解析速率, 如 2m, 100k, "" 或 "0" 表示不限速
+func Parse(s string) (rate.Limit, error) {
+	if s == "" || s == "0" {
+		return 0, nil
+	}
+	size, err := meter.ParseBytes(s)
+	if err != nil {
+		return 0, err
+	}
+	return rate.Limit(size), nil
+}
+
+// Limiter 上下行各一个令牌桶, 单位 bytes/s
+type Limiter struct {
+	up   *rate.Limiter
+	down *rate.Limiter
+}
+
+// NewLimiter new a limiter, limit <= 0 表示不限速
+func NewLimiter(limit rate.Limit) *Limiter {
+	limit, burst := limitBurst(limit)
+	return &Limiter{
+		up:   rate.NewLimiter(limit, burst),
+		down: rate.NewLimiter(limit, burst),
+	}
+}
+
+// SetLimit 运行时修改速率, limit <= 0 表示不限速
+func (sf *Limiter) SetLimit(limit rate.Limit) {
+	limit, burst := limitBurst(limit)
+	for _, l := range [...]*rate.Limiter{sf.up, sf.down} {
+		l.SetBurst(burst)
+		l.SetLimit(limit)
+	}
+}
+
+// Limit 当前速率, 0 表示不限速
+func (sf *Limiter) Limit() rate.Limit {
+	if l := sf.up.Limit(); l != rate.Inf {
+		return l
+	}
+	return 0
+}
+
+// Group 按key(认证用户或源IP)区分的限速器, 同一key的连接共享, 无连接引用时回收
+type Group struct {
+	mu    sync.Mutex
+	limit rate.Limit
+	items map[string]*groupItem
+}
+
+type groupItem struct {
+	*Limiter
+	refs int
+}
+
+// NewGroup new a group, limit <= 0 表示不限速
+func NewGroup(limit rate.Limit) *Group {
+	return &Group{
+		limit: limit,
+		items: make(map[string]*groupItem),
+	}
+}
+
+// SetLimit 运行时修改速率, 已有的限速器同时生效
+func (sf *Group) SetLimit(limit rate.Limit) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	sf.limit = limit
+	for _, item := range sf.items {
+		item.SetLimit(limit)
+	}
+}
+
+// Limit 当前速率, 0 表示不限速
+func (sf *Group) Limit() rate.Limit {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if sf.limit < 0 {
+		return 0
+	}
+	return sf.limit
+}
+
+// Len 当前限速器数量
+func (sf *Group) Len() int {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	return len(sf.items)
+}
+
+// Acquire 获取key对应的限速器并增加引用, 用完需调用Release
+func (sf *Group) Acquire(key string) *Limiter {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	item, ok := sf.items[key]
+	if !ok {
+		item = &groupItem{Limiter: NewLimiter(sf.limit)}
+		sf.items[key] = item
+	}
+	item.refs++
+	return item.Limiter
+}
+
+// Release 减少key的引用, 无引用时回收
+func (sf *Group) Release(key string) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	if item, ok := sf.items[key]; ok {
+		if item.refs--; item.refs <= 0 {
+			delete(sf.items, key)
+		}
+	}
+}
+
+// limitBurst 令牌桶速率及容量, 容量为1秒的令牌数且不小于minBurst
+func limitBurst(limit rate.Limit) (rate.Limit, int) {
+	if limit <= 0 {
+		return rate.Inf, minBurst
+	}
+	burst := minBurst
+	if int(limit) > burst {
+		burst = int(limit)
+	}
+	return limit, burst
+}
+
+// waitN 从所有令牌桶获取n个令牌, 按minBurst分段以免超出桶容量
+func waitN(ctx context.Context, n int, limiters []*rate.Limiter) error {
+	for n > 0 {
+		c := n
+		if c > minBurst {
+			c = minBurst
+		}
+		for _, l := range limiters {
+			if err := l.WaitN(ctx, c); err != nil {
+				return err
+			}
+		}
+		n -= c
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/time/rate"
+)
+
+func TestParse(t *testing.T) {
+	for _, s := range []string{"", "0"} {
+		limit, err := Parse(s)
+		require.NoError(t, err)
+		assert.Equal(t, rate.Limit(0), limit)
+	}
+	limit, err := Parse("100k")
+	require.NoError(t, err)
+	assert.Equal(t, rate.Limit(100*1024), limit)
+
+	_, err = Parse("abc")
+	assert.Error(t, err)
+}
+
+func TestGroup(t *testing.T) {
+	g := NewGroup(1024)
+	l1 := g.Acquire("a")
+	l2 := g.Acquire("a")
+	assert.Same(t, l1, l2)
+	assert.Equal(t, rate.Limit(1024), l1.Limit())
+	g.Acquire("b")
+	assert.Equal(t, 2, g.Len())
+
+	g.SetLimit(0)
+	assert.Equal(t, rate.Limit(0), l1.Limit())
+	assert.Equal(t, rate.Inf, l1.up.Limit())
+
+	g.Release("a")
+	assert.Equal(t, 2, g.Len())
+	g.Release("a")
+	g.Release("b")
+	assert.Equal(t, 0, g.Len())
+}
+
+// pipe 返回限速连接, 对端丢弃收到的数据
+func pipe(t *testing.T, h *Hierarchy, key string) net.Conn {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() { c2.Close() }) // nolint: errcheck
+	go io.Copy(io.Discard, c2)       // nolint: errcheck
+	return h.Conn(c1, key)
+}
+
+func TestHierarchy(t *testing.T) {
+	const limit = 256 * 1024
+
+	t.Run("shared by user", func(t *testing.T) {
+		h := New(nil, 0, limit, 0)
+		c1, c2 := pipe(t, h, "user"), pipe(t, h, "user")
+		require.Equal(t, 1, h.user.Len())
+		require.Equal(t, 2, h.conn.Len())
+
+		// 初始令牌桶满, 共享同一用户令牌桶后超出部分需等待
+		start := time.Now()
+		_, err := c1.Write(make([]byte, limit))
+		require.NoError(t, err)
+		_, err = c2.Write(make([]byte, limit/2))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+		c1.Close() // nolint: errcheck
+		c2.Close() // nolint: errcheck
+		assert.Equal(t, 0, h.user.Len())
+		assert.Equal(t, 0, h.conn.Len())
+	})
+
+	t.Run("separate users", func(t *testing.T) {
+		h := New(nil, 0, limit, 0)
+		c1, c2 := pipe(t, h, "a"), pipe(t, h, "b")
+		defer c1.Close() // nolint: errcheck
+		defer c2.Close() // nolint: errcheck
+
+		start := time.Now()
+		_, err := c1.Write(make([]byte, limit))
+		require.NoError(t, err)
+		_, err = c2.Write(make([]byte, limit/2))
+		require.NoError(t, err)
+		assert.Less(t, time.Since(start), 200*time.Millisecond)
+	})
+
+	t.Run("global", func(t *testing.T) {
+		global := NewLimiter(limit)
+		h1, h2 := New(global, 0, 0, 0), New(global, 0, 0, 0)
+		c1, c2 := pipe(t, h1, "a"), pipe(t, h2, "b")
+		defer c1.Close() // nolint: errcheck
+		defer c2.Close() // nolint: errcheck
+
+		start := time.Now()
+		_, err := c1.Write(make([]byte, limit))
+		require.NoError(t, err)
+		_, err = c2.Write(make([]byte, limit/2))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+	})
+
+	t.Run("close cancel wait", func(t *testing.T) {
+		h := New(nil, 0, 0, 1)
+		c := pipe(t, h, "a")
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			c.Close() // nolint: errcheck
+		}()
+		_, err := c.Write(make([]byte, 2*minBurst))
+		assert.Error(t, err)
+	})
+}
+
+func TestServeHTTP(t *testing.T) {
+	h := New(NewLimiter(0), 0, 0, 0)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"?global=1m&service=512k&user=100k&conn=0", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var limits Limits
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&limits))
+	resp.Body.Close() // nolint: errcheck
+	assert.Equal(t, Limits{Global: 1024 * 1024, Service: 512 * 1024, User: 100 * 1024}, limits)
+	assert.Equal(t, limits, h.Limits())
+
+	// 解析失败时不修改
+	req, _ = http.NewRequest(http.MethodPut, srv.URL+"?service=0&user=abc", nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close() // nolint: errcheck
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, rate.Limit(512*1024), h.Limits().Service)
+}
@@ -0,0 +1,60 @@
+package tracker
+
+import (
+	"net"
+)
+
+// Filter 会话过滤条件, 返回true表示匹配
+type Filter func(st *Stat) bool
+
+// ByID 指定id
+func ByID(id uint64) Filter {
+	return func(st *Stat) bool { return st.ID == id }
+}
+
+// ByService 指定服务名
+func ByService(service string) Filter {
+	return func(st *Stat) bool { return st.Service == service }
+}
+
+// ByUser 指定认证用户
+func ByUser(user string) Filter {
+	return func(st *Stat) bool { return st.User == user }
+}
+
+// BySource 源地址, addr可为host:port或host
+func BySource(addr string) Filter {
+	return func(st *Stat) bool { return matchAddr(st.Source, addr) }
+}
+
+// ByTarget 目标地址, addr可为host:port或host
+func ByTarget(addr string) Filter {
+	return func(st *Stat) bool { return matchAddr(st.Target, addr) }
+}
+
+// ByParent 上级代理地址, addr可为host:port或host
+func ByParent(addr string) Filter {
+	return func(st *Stat) bool { return matchAddr(st.Parent, addr) }
+}
+
+// MinRate 收发速率之和不低于rate(bytes/s)
+func MinRate(rate float64) Filter {
+	return func(st *Stat) bool { return st.InRate+st.OutRate >= rate }
+}
+
+func match(st *Stat, filters []Filter) bool {
+	for _, f := range filters {
+		if !f(st) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAddr(addr, want string) bool {
+	if addr == want {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && host == want
+}
@@ -0,0 +1,64 @@
+package tracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ServeHTTP 运维接口
+// GET 列出会话, DELETE 强制关闭会话, 必须至少指定一个过滤条件.
+// 过滤条件通过query指定: id, service, user, source, target, parent, min_rate(bytes/s)
+func (sf *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filters, err := parseFilters(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sf.List(filters...)) // nolint: errcheck
+	case http.MethodDelete:
+		if len(filters) == 0 {
+			http.Error(w, "at least one filter required", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"closed": sf.Close(filters...)}) // nolint: errcheck
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func parseFilters(r *http.Request) ([]Filter, error) {
+	q := r.URL.Query()
+	filters := make([]Filter, 0, len(q))
+	if v := q.Get("id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, ByID(id))
+	}
+	if v := q.Get("min_rate"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, MinRate(rate))
+	}
+	for key, newFilter := range map[string]func(string) Filter{
+		"service": ByService,
+		"user":    ByUser,
+		"source":  BySource,
+		"target":  ByTarget,
+		"parent":  ByParent,
+	} {
+		if v := q.Get(key); v != "" {
+			filters = append(filters, newFilter(v))
+		}
+	}
+	return filters, nil
+}
@@ -0,0 +1,212 @@
+// Package tracker 活动代理会话的流量登记, 记录每对代理连接的源,目标,上级,用户,开始时间,收发字节及实时速率(EWMA)
+package tracker
+
+import (
+	"context"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	cmap "github.com/orcaman/concurrent-map"
+	"go.uber.org/atomic"
+
+	"github.com/thinkgos/jocasta/connection/cflow"
+)
+
+// DefaultWindow 默认速率EWMA时间窗口
+const DefaultWindow = 5 * time.Second
+
+// Option for Tracker
+type Option func(t *Tracker)
+
+// WithWindow 速率EWMA的时间窗口, 越大越平滑, <= 0 使用默认值
+func WithWindow(window time.Duration) Option {
+	return func(t *Tracker) {
+		if window > 0 {
+			t.window = window
+		}
+	}
+}
+
+// Tracker 活动会话登记表
+type Tracker struct {
+	seq    atomic.Uint64
+	flows  cmap.ConcurrentMap // id -> *Flow
+	window time.Duration
+}
+
+// New new a tracker
+func New(opts ...Option) *Tracker {
+	t := &Tracker{
+		flows:  cmap.New(),
+		window: DefaultWindow,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Info 会话描述信息
+type Info struct {
+	Service string `json:"service"`          // 服务名
+	Source  string `json:"source"`           // 源地址
+	Target  string `json:"target"`           // 目标地址
+	Parent  string `json:"parent,omitempty"` // 上级代理地址, 直连时为空
+	User    string `json:"user,omitempty"`   // 认证用户
+}
+
+// Stat 会话快照
+type Stat struct {
+	Info
+	ID       uint64        `json:"id"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	In       uint64        `json:"in"`       // 源端发往目标的字节
+	Out      uint64        `json:"out"`      // 目标发往源端的字节
+	InRate   float64       `json:"in_rate"`  // 源端发往目标的速率, bytes/s
+	OutRate  float64       `json:"out_rate"` // 目标发往源端的速率, bytes/s
+}
+
+// Flow 一条活动的代理会话
+type Flow struct {
+	Info
+	ID    uint64
+	Start time.Time
+
+	tracker *Tracker
+	closer  io.Closer
+	in      atomic.Uint64
+	out     atomic.Uint64
+
+	mu      sync.Mutex
+	lastAt  time.Time
+	lastIn  uint64
+	lastOut uint64
+	inRate  float64
+	outRate float64
+}
+
+// Track 登记一条会话, c为目标端(上游)连接, 返回带统计的连接, 会话结束时需调用Flow.Release
+func (sf *Tracker) Track(c net.Conn, info Info) (net.Conn, *Flow) {
+	now := time.Now()
+	f := &Flow{
+		Info:    info,
+		ID:      sf.seq.Inc(),
+		Start:   now,
+		tracker: sf,
+		closer:  c,
+		lastAt:  now,
+	}
+	sf.flows.Set(strconv.FormatUint(f.ID, 10), f)
+	return &cflow.Conn{Conn: c, Wc: &f.in, Rc: &f.out}, f
+}
+
+// Get 获取指定id的会话
+func (sf *Tracker) Get(id uint64) (*Flow, bool) {
+	v, ok := sf.flows.Get(strconv.FormatUint(id, 10))
+	if !ok {
+		return nil, false
+	}
+	return v.(*Flow), true
+}
+
+// Len 活动会话数
+func (sf *Tracker) Len() int { return sf.flows.Count() }
+
+// List 列出满足所有过滤条件的会话快照, 按id排序
+func (sf *Tracker) List(filters ...Filter) []Stat {
+	now := time.Now()
+	stats := make([]Stat, 0, sf.flows.Count())
+	for _, v := range sf.flows.Items() {
+		st := v.(*Flow).stat(now)
+		if match(&st, filters) {
+			stats = append(stats, st)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// Close 强制关闭满足所有过滤条件的会话, 无过滤条件时关闭所有会话, 返回关闭的数量
+func (sf *Tracker) Close(filters ...Filter) int {
+	now := time.Now()
+	cnt := 0
+	for _, v := range sf.flows.Items() {
+		f := v.(*Flow)
+		st := f.stat(now)
+		if match(&st, filters) {
+			f.Close() // nolint: errcheck
+			cnt++
+		}
+	}
+	return cnt
+}
+
+// Watch 按interval周期采样所有会话的速率, should run in a goroutine.
+// 不运行时速率仅在List等调用时采样
+func (sf *Tracker) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, v := range sf.flows.Items() {
+				v.(*Flow).sample(now)
+			}
+		}
+	}
+}
+
+// Stat 会话快照
+func (sf *Flow) Stat() Stat { return sf.stat(time.Now()) }
+
+// Close 强制关闭会话的目标端连接, 代理随之结束
+func (sf *Flow) Close() error { return sf.closer.Close() }
+
+// Release 会话结束, 从登记表中移除
+func (sf *Flow) Release() {
+	sf.tracker.flows.Remove(strconv.FormatUint(sf.ID, 10))
+}
+
+func (sf *Flow) stat(now time.Time) Stat {
+	sf.sample(now)
+	sf.mu.Lock()
+	inRate, outRate := sf.inRate, sf.outRate
+	sf.mu.Unlock()
+	return Stat{
+		Info:     sf.Info,
+		ID:       sf.ID,
+		Start:    sf.Start,
+		Duration: now.Sub(sf.Start),
+		In:       sf.in.Load(),
+		Out:      sf.out.Load(),
+		InRate:   inRate,
+		OutRate:  outRate,
+	}
+}
+
+// sample 采样并更新EWMA速率, 权重按采样间隔计算, 不要求等间隔采样
+func (sf *Flow) sample(now time.Time) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	dt := now.Sub(sf.lastAt)
+	if dt <= 0 {
+		return
+	}
+	in, out := sf.in.Load(), sf.out.Load()
+	alpha := 1 - math.Exp(-float64(dt)/float64(sf.tracker.window))
+	sf.inRate += alpha * (float64(in-sf.lastIn)/dt.Seconds() - sf.inRate)
+	sf.outRate += alpha * (float64(out-sf.lastOut)/dt.Seconds() - sf.outRate)
+	sf.lastAt, sf.lastIn, sf.lastOut = now, in, out
+}
@@ -0,0 +1,116 @@
+package tracker
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	tk := New()
+
+	client, server := net.Pipe()
+	defer client.Close()       // nolint: errcheck
+	go io.Copy(client, client) // nolint: errcheck
+
+	conn, flow := tk.Track(server, Info{
+		Service: "http",
+		Source:  "127.0.0.1:1234",
+		Target:  "example.com:80",
+		User:    "user",
+	})
+	require.Equal(t, 1, tk.Len())
+
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	time.Sleep(10 * time.Millisecond)
+	st := flow.Stat()
+	assert.Equal(t, uint64(5), st.In)
+	assert.Equal(t, uint64(5), st.Out)
+	assert.Greater(t, st.InRate, float64(0))
+	assert.Greater(t, st.OutRate, float64(0))
+
+	assert.Len(t, tk.List(ByService("http"), BySource("127.0.0.1"), ByUser("user")), 1)
+	assert.Len(t, tk.List(ByTarget("example.com:80")), 1)
+	assert.Len(t, tk.List(ByService("socks")), 0)
+	assert.Len(t, tk.List(ByParent("127.0.0.1")), 0)
+
+	got, ok := tk.Get(flow.ID)
+	require.True(t, ok)
+	assert.Equal(t, flow, got)
+
+	// 强制关闭
+	assert.Equal(t, 1, tk.Close(ByID(flow.ID)))
+	_, err = conn.Write([]byte("hello"))
+	assert.Error(t, err)
+
+	flow.Release()
+	assert.Equal(t, 0, tk.Len())
+	_, ok = tk.Get(flow.ID)
+	assert.False(t, ok)
+}
+
+func TestFlowSample(t *testing.T) {
+	tk := New(WithWindow(time.Second))
+	_, server := net.Pipe()
+	_, flow := tk.Track(server, Info{})
+	start := flow.lastAt
+
+	flow.in.Add(1000)
+	flow.sample(start.Add(time.Second))
+	rate := flow.inRate
+	assert.InDelta(t, 1000*(1-1/2.718281828), rate, 1)
+
+	// 无流量时速率衰减
+	flow.sample(start.Add(2 * time.Second))
+	assert.Less(t, flow.inRate, rate)
+}
+
+func TestServeHTTP(t *testing.T) {
+	tk := New()
+	_, server := net.Pipe()
+	_, flow := tk.Track(server, Info{Service: "socks", Source: "10.0.0.1:1000"})
+	defer flow.Release()
+
+	srv := httptest.NewServer(tk)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?service=socks")
+	require.NoError(t, err)
+	var stats []Stat
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	resp.Body.Close() // nolint: errcheck
+	require.Len(t, stats, 1)
+	assert.Equal(t, flow.ID, stats[0].ID)
+
+	resp, err = http.Get(srv.URL + "?id=abc")
+	require.NoError(t, err)
+	resp.Body.Close() // nolint: errcheck
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 无过滤条件不允许关闭
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL, nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close() // nolint: errcheck
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	req, _ = http.NewRequest(http.MethodDelete, srv.URL+"?source=10.0.0.1", nil)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	var closed map[string]int
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&closed))
+	resp.Body.Close() // nolint: errcheck
+	assert.Equal(t, 1, closed["closed"])
+}
@@ -33,7 +33,7 @@ func (sf *KCPClient) DialContext(_ context.Context, _, addr string) (net.Conn, e
 	conn.SetWindowSize(sf.Config.SndWnd, sf.Config.RcvWnd)
 	conn.SetACKNoDelay(sf.Config.AckNodelay)
 
-	var c net.Conn = conn
+	c := newKcpConn(conn, sf.Config)
 
 	for _, chain := range sf.AfterChains {
 		c = chain(c)
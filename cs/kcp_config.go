@@ -35,9 +35,12 @@ type KcpConfig struct {
 	// 极速模式: 1,10,2,1
 	NoDelay, Interval, Resend, NoCongestion int
 
-	SockBuf   int            // 读写缓存器, 默认 4194304 4M
-	KeepAlive int            // TODO: 未用 默认10
-	Block     kcp.BlockCrypt // block encryption
+	SockBuf int // 读写缓存器, 默认 4194304 4M
+	// 心跳和空闲超时, 单位秒, 两者均 <= 0 时不启用, 此时对端关闭本端无法感知
+	// NOTE: 启用后kcp流上会增加简单的帧, 两端需同时启用或同时不启用, 不一致时开启的一端读取返回 ErrKcpNotFramed
+	KeepAlive   int            // 心跳间隔, 默认0, 不启用
+	IdleTimeout int            // 空闲超时, 期间未收到对端任何数据(包括心跳)将关闭连接, <=0 表示 3*KeepAlive
+	Block       kcp.BlockCrypt // block encryption
 }
 
 type blockCryptInfo struct {
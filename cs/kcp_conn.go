@@ -0,0 +1,244 @@
+package cs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xtaci/kcp-go/v5"
+)
+
+// kcp 帧格式: cmd(1字节) + length(2字节,大端) + payload
+// kcp基于udp, 对端异常退出时本端无法感知, 所以在kcp流上增加简单的帧,
+// 定时发送心跳, 并在关闭时通知对端, 两端均需开启.
+// 连接建立后双方首先发送hello帧, 用于识别未开启帧的对端
+const (
+	kcpCmdData  byte = iota // 数据
+	kcpCmdPing              // 心跳
+	kcpCmdFin               // 关闭
+	kcpCmdHello             // 握手
+
+	kcpFrameHeaderSize = 3
+	kcpMaxFramePayload = 65535
+)
+
+// kcpHelloMagic hello帧的内容
+var kcpHelloMagic = []byte("JKCP")
+
+// ErrKcpIdleTimeout 在空闲超时时间内未收到对端的任何数据(包括心跳)
+var ErrKcpIdleTimeout = errors.New("kcp: idle timeout, peer may be gone")
+
+// ErrKcpNotFramed 对端未开启帧(心跳和空闲超时), 两端需同时开启或同时不开启
+var ErrKcpNotFramed = errors.New("kcp: peer not framed, keepalive and idle timeout must be the same on both ends")
+
+// kcpConn kcp 连接, 提供心跳保活和空闲超时检测
+type kcpConn struct {
+	*kcp.UDPSession
+	keepAlive   time.Duration
+	idleTimeout time.Duration
+
+	rmu     sync.Mutex
+	hello   bool  // 已收到对端的hello帧
+	remain  int   // 当前数据帧剩余未读的长度
+	readErr error // 读到fin或空闲超时后的错误
+
+	dmu          sync.Mutex
+	readDeadline time.Time // 用户设置的读超时
+
+	wmu sync.Mutex
+
+	die       chan struct{}
+	closeOnce sync.Once
+}
+
+// newKcpConn 包装kcp会话, keepAlive和idleTimeout均 <= 0 时不使用帧, 直接返回原会话
+func newKcpConn(sess *kcp.UDPSession, config KcpConfig) net.Conn {
+	keepAlive := time.Duration(config.KeepAlive) * time.Second
+	idleTimeout := time.Duration(config.IdleTimeout) * time.Second
+	if keepAlive <= 0 && idleTimeout <= 0 {
+		return sess
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = keepAlive * 3
+	}
+	c := &kcpConn{
+		UDPSession:  sess,
+		keepAlive:   keepAlive,
+		idleTimeout: idleTimeout,
+		die:         make(chan struct{}),
+	}
+	c.writeFrame(kcpCmdHello, kcpHelloMagic) // nolint: errcheck
+	if keepAlive > 0 {
+		go c.keepalive()
+	}
+	return c
+}
+
+// Read reads data from the connection.
+func (sf *kcpConn) Read(b []byte) (int, error) {
+	sf.rmu.Lock()
+	defer sf.rmu.Unlock()
+
+	if !sf.hello && sf.readErr == nil {
+		if err := sf.readHello(); err != nil {
+			return 0, err
+		}
+	}
+	for sf.remain == 0 {
+		if sf.readErr != nil {
+			return 0, sf.readErr
+		}
+		var hdr [kcpFrameHeaderSize]byte
+
+		if _, err := sf.readFull(hdr[:]); err != nil {
+			return 0, err
+		}
+		switch hdr[0] {
+		case kcpCmdData:
+			sf.remain = int(binary.BigEndian.Uint16(hdr[1:]))
+		case kcpCmdPing:
+		case kcpCmdFin:
+			sf.readErr = io.EOF
+		default:
+			sf.readErr = errors.New("kcp: invalid frame")
+			sf.UDPSession.Close() // nolint: errcheck
+		}
+	}
+	if len(b) > sf.remain {
+		b = b[:sf.remain]
+	}
+	n, err := sf.read(b)
+	sf.remain -= n
+	return n, err
+}
+
+// readHello 读取对端的hello帧, 不是hello帧时说明对端未开启帧, 关闭连接
+func (sf *kcpConn) readHello() error {
+	var hello [kcpFrameHeaderSize + 4]byte
+
+	if _, err := sf.readFull(hello[:]); err != nil {
+		return err
+	}
+	if hello[0] != kcpCmdHello ||
+		int(binary.BigEndian.Uint16(hello[1:])) != len(kcpHelloMagic) ||
+		!bytes.Equal(hello[kcpFrameHeaderSize:], kcpHelloMagic) {
+		sf.readErr = ErrKcpNotFramed
+		sf.UDPSession.Close() // nolint: errcheck
+		return sf.readErr
+	}
+	sf.hello = true
+	return nil
+}
+
+func (sf *kcpConn) readFull(b []byte) (n int, err error) {
+	for n < len(b) && err == nil {
+		var nn int
+		nn, err = sf.read(b[n:])
+		n += nn
+	}
+	return
+}
+
+// read 在用户设置的读超时和空闲超时中较早的时间内读取数据,
+// 空闲超时时关闭连接并返回 ErrKcpIdleTimeout, 用户设置的读超时返回 os.ErrDeadlineExceeded
+func (sf *kcpConn) read(b []byte) (int, error) {
+	idleDeadline := time.Now().Add(sf.idleTimeout)
+	deadline := sf.deadline(idleDeadline)
+	sf.UDPSession.SetReadDeadline(deadline) // nolint: errcheck
+	n, err := sf.UDPSession.Read(b)
+	// kcp的超时错误未实现net.Error, 只能通过时间判断
+	if err != nil && n == 0 && !time.Now().Before(deadline) {
+		if !deadline.Equal(idleDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		sf.readErr = ErrKcpIdleTimeout
+		sf.Close() // nolint: errcheck
+		return 0, ErrKcpIdleTimeout
+	}
+	return n, err
+}
+
+// Write writes data to the connection.
+func (sf *kcpConn) Write(b []byte) (n int, err error) {
+	for len(b) > 0 {
+		size := len(b)
+		if size > kcpMaxFramePayload {
+			size = kcpMaxFramePayload
+		}
+		if err = sf.writeFrame(kcpCmdData, b[:size]); err != nil {
+			return
+		}
+		n += size
+		b = b[size:]
+	}
+	return
+}
+
+func (sf *kcpConn) writeFrame(cmd byte, payload []byte) error {
+	hdr := []byte{cmd, 0, 0}
+	binary.BigEndian.PutUint16(hdr[1:], uint16(len(payload)))
+
+	sf.wmu.Lock()
+	defer sf.wmu.Unlock()
+	_, err := sf.UDPSession.WriteBuffers([][]byte{hdr, payload})
+	return err
+}
+
+// keepalive 定时发送心跳
+func (sf *kcpConn) keepalive() {
+	ticker := time.NewTicker(sf.keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sf.die:
+			return
+		case <-ticker.C:
+			if err := sf.writeFrame(kcpCmdPing, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close 通知对端并关闭连接
+func (sf *kcpConn) Close() (err error) {
+	sf.closeOnce.Do(func() {
+		close(sf.die)
+		sf.UDPSession.SetWriteDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+		sf.writeFrame(kcpCmdFin, nil)                               // nolint: errcheck
+		err = sf.UDPSession.Close()
+	})
+	return
+}
+
+// SetDeadline implements the Conn SetDeadline method.
+func (sf *kcpConn) SetDeadline(t time.Time) error {
+	if err := sf.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return sf.UDPSession.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements the Conn SetReadDeadline method.
+// 用户设置的读超时与空闲超时中较早的生效
+func (sf *kcpConn) SetReadDeadline(t time.Time) error {
+	sf.dmu.Lock()
+	sf.readDeadline = t
+	sf.dmu.Unlock()
+	return sf.UDPSession.SetReadDeadline(sf.deadline(time.Now().Add(sf.idleTimeout)))
+}
+
+// deadline 返回用户设置的读超时与idleDeadline中较早的时间
+func (sf *kcpConn) deadline(idleDeadline time.Time) time.Time {
+	sf.dmu.Lock()
+	defer sf.dmu.Unlock()
+	if !sf.readDeadline.IsZero() && sf.readDeadline.Before(idleDeadline) {
+		return sf.readDeadline
+	}
+	return idleDeadline
+}
@@ -9,8 +9,8 @@ import (
 	"github.com/thinkgos/jocasta/connection"
 )
 
-// ListenKCP 传输,可选snappy压缩
-// TODO: BUG 当对端关闭时,连接并未关闭,UDP无状态连接的原因
+// kcpListen kcp listener
+// 对端关闭或异常退出的检测见 KcpConfig.KeepAlive 和 KcpConfig.IdleTimeout
 type kcpListen struct {
 	net.Listener
 	config      KcpConfig
@@ -50,7 +50,7 @@ func (sf *kcpListen) Accept() (net.Conn, error) {
 	conn.SetWindowSize(sf.config.SndWnd, sf.config.RcvWnd)
 	conn.SetACKNoDelay(sf.config.AckNodelay)
 
-	var c net.Conn = conn
+	c := newKcpConn(conn, sf.config)
 	for _, chain := range sf.afterChains {
 		c = chain(c)
 	}
@@ -1,7 +1,10 @@
 package cs
 
 import (
+	"io"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -87,3 +90,119 @@ func TestKcp(t *testing.T) {
 		}
 	}
 }
+
+func TestKcpLiveness(t *testing.T) {
+	config := KcpConfig{
+		MTU:          1400,
+		SndWnd:       32,
+		RcvWnd:       32,
+		DataShard:    10,
+		ParityShard:  3,
+		AckNodelay:   true,
+		NoDelay:      1,
+		Interval:     10,
+		Resend:       2,
+		NoCongestion: 1,
+		SockBuf:      4194304,
+		KeepAlive:    1,
+		IdleTimeout:  3,
+	}
+	var err error
+	config.Block, err = NewKcpBlockCryptWithPbkdf2("aes", "key", "thinkgos-jocasta")
+	require.NoError(t, err)
+
+	// 每个用例使用独立的listener, 避免已关闭会话的残留包在listener上生成新的会话
+	dial := func(t *testing.T, cliConfig KcpConfig) (cli, srv net.Conn) {
+		ln, err := ListenKCP("", "127.0.0.1:0", config)
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+
+		cli, err = (&KCPClient{Config: cliConfig}).Dial("", ln.Addr().String())
+		require.NoError(t, err)
+		t.Cleanup(func() { cli.Close() })
+		_, err = cli.Write([]byte("ping"))
+		require.NoError(t, err)
+		srv, err = ln.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { srv.Close() })
+
+		b := make([]byte, 20)
+		n, err := srv.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "ping", string(b[:n]))
+		return cli, srv
+	}
+
+	t.Run("keepalive", func(t *testing.T) {
+		cli, srv := dial(t, config)
+
+		// 心跳保持连接, 超过空闲超时仍可正常读写
+		time.Sleep(4 * time.Second)
+		_, err := srv.Write([]byte("pong"))
+		require.NoError(t, err)
+		b := make([]byte, 20)
+		n, err := cli.Read(b)
+		require.NoError(t, err)
+		require.Equal(t, "pong", string(b[:n]))
+
+		// 用户设置的读超时先于空闲超时
+		require.NoError(t, cli.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+		_, err = cli.Read(b)
+		require.Error(t, err)
+		require.NotEqual(t, ErrKcpIdleTimeout, err)
+	})
+
+	t.Run("peer close", func(t *testing.T) {
+		cli, srv := dial(t, config)
+
+		require.NoError(t, cli.Close())
+		_, err := srv.Read(make([]byte, 20))
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("idle timeout", func(t *testing.T) {
+		cfg := config
+		cfg.KeepAlive = 0
+		cli, srv := dial(t, cfg)
+
+		// 模拟对端异常退出, 不发送任何数据
+		require.NoError(t, cli.(*kcpConn).UDPSession.Close())
+		_, err := srv.Read(make([]byte, 20))
+		require.Equal(t, ErrKcpIdleTimeout, err)
+	})
+
+	t.Run("framed with unframed", func(t *testing.T) {
+		unframed := config
+		unframed.KeepAlive, unframed.IdleTimeout = 0, 0
+
+		// 未开启帧的客户端连接开启帧的服务端
+		ln, err := ListenKCP("", "127.0.0.1:0", config)
+		require.NoError(t, err)
+		defer ln.Close() // nolint: errcheck
+		cli, err := (&KCPClient{Config: unframed}).Dial("", ln.Addr().String())
+		require.NoError(t, err)
+		defer cli.Close() // nolint: errcheck
+		_, err = cli.Write([]byte("hello world"))
+		require.NoError(t, err)
+		srv, err := ln.Accept()
+		require.NoError(t, err)
+		defer srv.Close() // nolint: errcheck
+		_, err = srv.Read(make([]byte, 20))
+		require.Equal(t, ErrKcpNotFramed, err)
+
+		// 开启帧的客户端连接未开启帧的服务端
+		ln2, err := ListenKCP("", "127.0.0.1:0", unframed)
+		require.NoError(t, err)
+		defer ln2.Close() // nolint: errcheck
+		cli2, err := (&KCPClient{Config: config}).Dial("", ln2.Addr().String())
+		require.NoError(t, err)
+		defer cli2.Close() // nolint: errcheck
+		srv2, err := ln2.Accept()
+		require.NoError(t, err)
+		defer srv2.Close() // nolint: errcheck
+		_, err = srv2.Write([]byte("hello world"))
+		require.NoError(t, err)
+		_, err = cli2.Read(make([]byte, 20))
+		require.Equal(t, ErrKcpNotFramed, err)
+	})
+}
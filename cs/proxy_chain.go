@@ -0,0 +1,88 @@
+package cs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyChainError 代理链中某一跳失败
+type ProxyChainError struct {
+	Hop int // 失败的跳, 从1开始
+	URL *url.URL
+	Err error
+}
+
+// Error implement error interface
+func (sf *ProxyChainError) Error() string {
+	return fmt.Sprintf("proxy chain hop %d(%s://%s), %v", sf.Hop, sf.URL.Scheme, sf.URL.Host, sf.Err)
+}
+
+// Unwrap returns the underlying error
+func (sf *ProxyChainError) Unwrap() error { return sf.Err }
+
+// NewProxyChain 按顺序创建代理链拨号器, 第一跳直连, 之后每一跳经由前一跳连接
+// 拨号失败时返回*ProxyChainError, 连接第一跳失败或第n跳握手失败
+// (包括第n跳无法连接到下一跳或目标)时, 指示为相应的跳
+func NewProxyChain(urls []*url.URL, timeout time.Duration) (proxy.Dialer, error) {
+	if len(urls) == 0 {
+		return nil, errors.New("proxy chain required at least one hop")
+	}
+	var forward proxy.Dialer
+	for i, u := range urls {
+		var d proxy.Dialer
+
+		switch u.Scheme {
+		case "socks5":
+			d = Socks5{
+				ProxyHost: u.Host,
+				Auth:      ProxyAuth(u),
+				Timeout:   timeout,
+				Forward:   forward,
+			}
+		case "http", "https":
+			d = HTTPS{
+				ProxyHost: u.Host,
+				Auth:      ProxyAuth(u),
+				Timeout:   timeout,
+				Forward:   forward,
+			}
+		case "socks4", "socks4a":
+			d = Socks4{
+				ProxyHost: u.Host,
+				UserID:    u.User.Username(),
+				Socks4a:   u.Scheme == "socks4a",
+				Timeout:   timeout,
+				Forward:   forward,
+			}
+		default:
+			return nil, fmt.Errorf("proxy chain hop %d, unknown scheme of %s", i+1, u.Redacted())
+		}
+		forward = &proxyHop{hop: i + 1, url: u, forward: d}
+	}
+	return forward, nil
+}
+
+// proxyHop 代理链中的一跳, 标记出错的跳
+type proxyHop struct {
+	hop     int
+	url     *url.URL
+	forward proxy.Dialer
+}
+
+// Dial connects to the address on the named network through the hop.
+func (sf *proxyHop) Dial(network, addr string) (net.Conn, error) {
+	conn, err := sf.forward.Dial(network, addr)
+	if err != nil {
+		var e *ProxyChainError
+		if errors.As(err, &e) { // 前面的跳已失败
+			return nil, e
+		}
+		return nil, &ProxyChainError{sf.hop, sf.url, err}
+	}
+	return conn, nil
+}
@@ -3,8 +3,12 @@ package cs
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"strconv"
 	"time"
 
 	"golang.org/x/net/proxy"
@@ -33,16 +37,23 @@ func (sf Socks5) Dial(network, addr string) (net.Conn, error) {
 	return dialSocksProxy.Dial(network, addr)
 }
 
-// HTTPS https proxy
+// HTTPS http(s) proxy, 使用CONNECT方法建立隧道
 type HTTPS struct {
 	ProxyHost string
 	Auth      *proxy.Auth
 	Timeout   time.Duration
+	Forward   proxy.Dialer
 }
 
 // Dial https dial
 func (sf HTTPS) Dial(network, addr string) (net.Conn, error) {
-	conn, err := net.DialTimeout(network, sf.ProxyHost, sf.Timeout)
+	var forward proxy.Dialer = &net.Dialer{Timeout: sf.Timeout}
+
+	if sf.Forward != nil {
+		forward = sf.Forward
+	}
+
+	conn, err := forward.Dial(network, sf.ProxyHost)
 	if err != nil {
 		return nil, err
 	}
@@ -78,3 +89,87 @@ func (sf HTTPS) Dial(network, addr string) (net.Conn, error) {
 	}
 	return conn, nil
 }
+
+// Socks4 socks4/socks4a proxy
+type Socks4 struct {
+	ProxyHost string
+	UserID    string
+	// true: socks4a, 域名由代理服务器解析
+	// false: socks4, 域名在本地解析, 仅支持ipv4
+	Socks4a bool
+	Timeout time.Duration
+	Forward proxy.Dialer
+}
+
+// socks4 reply
+const (
+	socks4Version = 0x04
+	socks4Connect = 0x01
+	socks4Granted = 0x5a
+)
+
+// Dial socks4 dial
+func (sf Socks4) Dial(network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", portStr)
+	}
+
+	req := []byte{socks4Version, socks4Connect, 0, 0}
+	binary.BigEndian.PutUint16(req[2:], uint16(port))
+	var domain string
+	if ip := net.ParseIP(host); ip != nil {
+		if ip = ip.To4(); ip == nil {
+			return nil, errors.New("socks4 only support ipv4 address")
+		}
+		req = append(req, ip...)
+	} else if sf.Socks4a {
+		domain = host
+		req = append(req, 0, 0, 0, 1)
+	} else {
+		ipAddr, err := net.ResolveIPAddr("ip4", host)
+		if err != nil {
+			return nil, err
+		}
+		req = append(req, ipAddr.IP.To4()...)
+	}
+	req = append(req, sf.UserID...)
+	req = append(req, 0)
+	if domain != "" {
+		req = append(req, domain...)
+		req = append(req, 0)
+	}
+
+	var forward proxy.Dialer = &net.Dialer{Timeout: sf.Timeout}
+
+	if sf.Forward != nil {
+		forward = sf.Forward
+	}
+
+	conn, err := forward.Dial(network, sf.ProxyHost)
+	if err != nil {
+		return nil, err
+	}
+	if sf.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(sf.Timeout)) // nolint: errcheck
+	}
+	if _, err = conn.Write(req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connecting to proxy, %v", err)
+	}
+	reply := make([]byte, 8)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("read reply from proxy, %v", err)
+	}
+	conn.SetDeadline(time.Time{}) // nolint: errcheck
+	if reply[1] != socks4Granted {
+		conn.Close()
+		return nil, fmt.Errorf("proxy rejected, reply code %#x", reply[1])
+	}
+	return conn, nil
+}
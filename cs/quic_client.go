@@ -1 +1,166 @@
 package cs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// quicSessions 设置了SessionKey的QUICClient共享的quic连接, 同一地址和SessionKey复用同一个quic连接
+var quicSessions = newQuicSessionPool()
+
+// QUICClient QUIC client dialer, 每次Dial在到目标地址的quic连接上打开一个新的流
+type QUICClient struct {
+	TLSConfig *tls.Config
+	Config    QuicConfig
+	// 连接配置的标识, 相同地址和标识的QUICClient共享同一个quic连接,
+	// 须区分TLSConfig和Config的不同(如服务端名称, ca证书, 固定公钥), 见 QUICSessionKey.
+	// 为空时仅在此QUICClient的Dial之间复用
+	SessionKey  string
+	AfterChains connection.AdornConnsChain
+
+	once     sync.Once
+	sessions *quicSessionPool
+}
+
+// QUICSessionKey 由tls配置和quic配置生成QUICClient.SessionKey, 配置相同时结果相同
+func QUICSessionKey(tlsConfig TLSConfig, config QuicConfig) string {
+	b, _ := json.Marshal(struct { // nolint: errchkjson
+		TLS  TLSConfig
+		Quic QuicConfig
+	}{tlsConfig, config})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// pool 共享的quic连接池及在池中的key
+func (sf *QUICClient) pool(addr string) (*quicSessionPool, string) {
+	if sf.SessionKey != "" {
+		return quicSessions, addr + "|" + sf.SessionKey
+	}
+	sf.once.Do(func() { sf.sessions = newQuicSessionPool() })
+	return sf.sessions, addr
+}
+
+// Dial connects to the address on the named network.
+func (sf *QUICClient) Dial(network, addr string) (net.Conn, error) {
+	return sf.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address on the named network using the provided context.
+func (sf *QUICClient) DialContext(ctx context.Context, _, addr string) (net.Conn, error) {
+	dial := func(ctx context.Context) (quic.Connection, error) {
+		return quic.DialAddr(ctx, addr, quicTLSConfig(sf.TLSConfig), sf.Config.config())
+	}
+	sessions, key := sf.pool(addr)
+	conn, err := sessions.get(ctx, key, dial)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		// 流级别的错误或ctx超时(如等待对端的流数量额度)不影响共享的连接,
+		// 只有连接已失效时才重新建立连接后再试一次
+		if !sessions.drop(key, conn) {
+			return nil, err
+		}
+		if conn, err = sessions.get(ctx, key, dial); err != nil {
+			return nil, err
+		}
+		if stream, err = conn.OpenStreamSync(ctx); err != nil {
+			sessions.drop(key, conn)
+			return nil, err
+		}
+	}
+
+	var c net.Conn = &quicConn{stream, conn.LocalAddr(), conn.RemoteAddr()}
+	if _, err = c.Write([]byte{quicStreamHeader}); err != nil {
+		c.Close() // nolint: errcheck
+		return nil, err
+	}
+	for _, chain := range sf.AfterChains {
+		c = chain(c)
+	}
+	return c, nil
+}
+
+type quicSessionPool struct {
+	mu    sync.Mutex
+	conns map[string]quic.Connection
+	group singleflight.Group // 同一key同时只建立一个连接
+}
+
+func newQuicSessionPool() *quicSessionPool {
+	return &quicSessionPool{conns: make(map[string]quic.Connection)}
+}
+
+// get 获取key对应的连接, 不存在时在锁外建立连接, 同一key的并发请求共享一次建立.
+// 建立连接不受单个调用者ctx取消的影响, 调用者ctx取消时直接返回
+func (sf *quicSessionPool) get(ctx context.Context, key string, dial func(context.Context) (quic.Connection, error)) (quic.Connection, error) {
+	if conn, ok := sf.load(key); ok {
+		return conn, nil
+	}
+	ch := sf.group.DoChan(key, func() (interface{}, error) {
+		if conn, ok := sf.load(key); ok {
+			return conn, nil
+		}
+		conn, err := dial(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+		sf.mu.Lock()
+		sf.conns[key] = conn
+		sf.mu.Unlock()
+		return conn, nil
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.(quic.Connection), nil
+	}
+}
+
+// load 获取key对应的有效连接, 已失效的连接将被移除
+func (sf *quicSessionPool) load(key string) (quic.Connection, bool) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+	conn, ok := sf.conns[key]
+	if !ok {
+		return nil, false
+	}
+	select {
+	case <-conn.Context().Done():
+		delete(sf.conns, key)
+		return nil, false
+	default:
+		return conn, true
+	}
+}
+
+// drop 连接已失效时从池中移除并返回true, 连接仍有效时返回false
+func (sf *quicSessionPool) drop(key string, conn quic.Connection) bool {
+	select {
+	case <-conn.Context().Done():
+	default:
+		return false
+	}
+	sf.mu.Lock()
+	if c, ok := sf.conns[key]; ok && c == conn {
+		delete(sf.conns, key)
+	}
+	sf.mu.Unlock()
+	return true
+}
@@ -0,0 +1,77 @@
+package cs
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// QuicNextProto quic默认使用的ALPN协议
+const QuicNextProto = "jocasta-quic"
+
+// QuicConfig quic 配置
+type QuicConfig struct {
+	// 握手超时时间, 默认5s
+	HandshakeTimeout time.Duration
+	// 连接最大空闲时间,超过将关闭连接, 默认30s
+	MaxIdleTimeout time.Duration
+	// 保活间隔,0表示不发送保活包, 默认10s
+	KeepAlivePeriod time.Duration
+	// 每个连接允许对端同时打开的最大流数量, 默认100
+	MaxIncomingStreams int64
+	// 流级别最大接收窗口, 默认6M
+	MaxStreamReceiveWindow uint64
+	// 连接级别最大接收窗口, 默认15M
+	MaxConnectionReceiveWindow uint64
+}
+
+// config 转换成quic.Config
+func (sf QuicConfig) config() *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout:       sf.HandshakeTimeout,
+		MaxIdleTimeout:             sf.MaxIdleTimeout,
+		KeepAlivePeriod:            sf.KeepAlivePeriod,
+		MaxIncomingStreams:         sf.MaxIncomingStreams,
+		MaxStreamReceiveWindow:     sf.MaxStreamReceiveWindow,
+		MaxConnectionReceiveWindow: sf.MaxConnectionReceiveWindow,
+	}
+}
+
+// quicTLSConfig quic要求必须设置ALPN,未设置时使用默认的QuicNextProto
+func quicTLSConfig(conf *tls.Config) *tls.Config {
+	if conf == nil {
+		conf = &tls.Config{} // nolint: gosec
+	} else {
+		conf = conf.Clone()
+	}
+	if len(conf.NextProtos) == 0 {
+		conf.NextProtos = []string{QuicNextProto}
+	}
+	return conf
+}
+
+// quicConn quic流实现net.Conn接口
+type quicConn struct {
+	quic.Stream
+	local  net.Addr
+	remote net.Addr
+}
+
+// LocalAddr returns the local network address.
+func (sf *quicConn) LocalAddr() net.Addr { return sf.local }
+
+// RemoteAddr returns the remote network address.
+func (sf *quicConn) RemoteAddr() net.Addr { return sf.remote }
+
+// Close 关闭读写两个方向, quic.Stream的Close仅关闭写方向
+func (sf *quicConn) Close() error {
+	sf.Stream.CancelRead(0)
+	return sf.Stream.Close()
+}
+
+// CloseWrite 仅关闭写方向
+func (sf *quicConn) CloseWrite() error {
+	return sf.Stream.Close()
+}
@@ -1 +1,131 @@
 package cs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// quic 流建立后,客户端先发送一个字节的流头,使服务端能立即感知到新流
+// (quic 的流在对端写入数据前对另一端不可见)
+const (
+	quicStreamHeader        = 0x01
+	quicStreamHeaderTimeout = 5 * time.Second
+)
+
+type quicListen struct {
+	ln          *quic.Listener
+	afterChains connection.AdornConnsChain
+	conns       chan net.Conn
+	ctx         context.Context
+	cancel      context.CancelFunc
+	closeOnce   sync.Once
+	err         error // 非Close导致监听退出的错误, 在ctx取消前设置
+}
+
+// ListenQUIC listen quic, 每个quic流对应一个net.Conn
+// tlsConf 服务端tls配置, quic 必须使用tls
+func ListenQUIC(_, addr string, tlsConf *tls.Config, config QuicConfig, afterChains ...connection.AdornConn) (net.Listener, error) {
+	ln, err := quic.ListenAddr(addr, quicTLSConfig(tlsConf), config.config())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &quicListen{
+		ln:          ln,
+		afterChains: afterChains,
+		conns:       make(chan net.Conn, 64),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	go l.acceptConnection()
+	return l, nil
+}
+
+func (sf *quicListen) acceptConnection() {
+	for {
+		conn, err := sf.ln.Accept(sf.ctx)
+		if err != nil {
+			// 非Close导致的退出, 错误交给Accept
+			sf.closeOnce.Do(func() {
+				sf.err = err
+				sf.cancel()
+				sf.ln.Close() // nolint: errcheck
+			})
+			return
+		}
+		go sf.acceptStream(conn)
+	}
+}
+
+func (sf *quicListen) acceptStream(conn quic.Connection) {
+	for {
+		stream, err := conn.AcceptStream(sf.ctx)
+		if err != nil {
+			conn.CloseWithError(0, "") // nolint: errcheck
+			return
+		}
+		go func() {
+			c := &quicConn{stream, conn.LocalAddr(), conn.RemoteAddr()}
+			if err := readQuicStreamHeader(c); err != nil {
+				c.Close() // nolint: errcheck
+				return
+			}
+			select {
+			case sf.conns <- c:
+			case <-sf.ctx.Done():
+				c.Close() // nolint: errcheck
+			}
+		}()
+	}
+}
+
+// Accept waits for and returns the next stream as a net.Conn.
+func (sf *quicListen) Accept() (net.Conn, error) {
+	select {
+	case <-sf.ctx.Done():
+		if sf.err != nil {
+			return nil, sf.err
+		}
+		return nil, &net.OpError{Op: "accept", Net: sf.Addr().Network(), Addr: sf.Addr(), Err: net.ErrClosed}
+	case c := <-sf.conns:
+		for _, chain := range sf.afterChains {
+			c = chain(c)
+		}
+		return c, nil
+	}
+}
+
+// Close closes the listener.
+func (sf *quicListen) Close() (err error) {
+	sf.closeOnce.Do(func() {
+		sf.cancel()
+		err = sf.ln.Close()
+	})
+	return
+}
+
+// Addr returns the listener's network address.
+func (sf *quicListen) Addr() net.Addr { return sf.ln.Addr() }
+
+func readQuicStreamHeader(c net.Conn) error {
+	b := make([]byte, 1)
+	c.SetReadDeadline(time.Now().Add(quicStreamHeaderTimeout)) // nolint: errcheck
+	_, err := io.ReadFull(c, b)
+	c.SetReadDeadline(time.Time{}) // nolint: errcheck
+	if err != nil {
+		return err
+	}
+	if b[0] != quicStreamHeader {
+		return errors.New("invalid quic stream header")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package cs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuicListenAcceptError(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, crt, keyPem := genCert(t, "jocasta.test", key, nil, nil)
+	srv := TLSConfig{CaCert: crt, Cert: crt, Key: keyPem, Single: true}
+	tlsConf, err := srv.ServerConfig()
+	require.NoError(t, err)
+
+	ln, err := ListenQUIC("quic", "127.0.0.1:0", tlsConf, QuicConfig{})
+	require.NoError(t, err)
+	defer ln.Close() // nolint: errcheck
+
+	// 底层监听异常退出, Accept不应一直阻塞
+	require.NoError(t, ln.(*quicListen).ln.Close())
+	done := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.Error(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("accept should return after the quic listener exits")
+	}
+}
@@ -1,10 +1,16 @@
 package cs
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/pem"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/things-go/encrypt"
 )
@@ -13,106 +19,133 @@ import (
 type StcpConfig struct {
 	Method   string
 	Password string
+	// 前向安全模式, 每个连接由Password认证的X25519密钥交换得到临时的会话密钥,
+	// 使用chacha20-poly1305加密, 忽略Method, 两端须一致, default: false
+	ForwardSecret bool
 }
 
 // Valid  valid the config
 func (sf StcpConfig) Valid() bool {
+	if sf.ForwardSecret {
+		return sf.Password != ""
+	}
 	_, err := encrypt.NewCipher(sf.Method, sf.Password)
 	return err == nil
 }
 
+// ObfsConfig 连接混淆配置, 与simple-obfs兼容, 仅tcp,stcp有效
+type ObfsConfig struct {
+	// 混淆方式, http|tls, 为空表示不混淆
+	Mode string
+	// 客户端伪装的host, http时为请求的Host, tls时为SNI, 仅客户端有效, default: cloudfront.net
+	Host string
+}
+
 // TLSConfig tcp tls config
 // Single == true,  单向认证
-//      客户端必须有提供ca证书
+//      客户端必须有提供ca证书或固定的公钥
 //      服务端必须有私钥和由ca签发的证书
 // Single == false  双向认证
-//      客户端必须有私钥和由ca签发的证书,ca证书可选(无将使用由ca签发的证书)
+//      客户端必须有私钥和由ca签发的证书,ca证书可选(无且未固定公钥时将使用由ca签发的证书)
 //      服务端必须有私钥和由ca签发的证书,ca证书可选(无将使用由ca签发的证书)
+// 私钥支持 RSA, ECDSA, Ed25519
 type TLSConfig struct {
 	CaCert []byte
 	Cert   []byte
 	Key    []byte
 	Single bool
+	// 期望的服务端名称, 同时作为SNI发送, 仅客户端有效
+	// 不为空时按标准流程校验证书链和名称, 为空时仅校验证书链,
+	// 且双向认证时以ca证书的CommonName作为SNI发送(同旧版本)
+	ServerName string
+	// ALPN协议列表, 如 h2, http/1.1
+	NextProtos []string
+	// 最小/最大tls版本, 如 tls.VersionTLS12, 0 表示使用默认值
+	MinVersion, MaxVersion uint16
+	// 加密套件, 仅对tls1.2及以下有效, 为空表示使用默认值
+	CipherSuites []uint16
+	// 固定的服务端公钥, 公钥(SPKI)的SHA-256哈希, 见 PublicKeyPin, 仅客户端有效
+	// 有ca证书时, 证书链校验通过且链中任一证书匹配其一
+	// 无ca证书时, 仅校验服务端叶子证书匹配其一, 可用于信任自签名证书
+	PublicKeyPins [][]byte
 }
 
 // ClientConfig client tls config
 func (sf *TLSConfig) ClientConfig() (*tls.Config, error) {
-	if sf.Single {
-		if len(sf.CaCert) == 0 {
-			return nil, errors.New("invalid root certificate")
-		}
+	config, err := sf.baseConfig()
+	if err != nil {
+		return nil, err
+	}
 
-		certPool := x509.NewCertPool()
-		ok := certPool.AppendCertsFromPEM(sf.CaCert)
-		if !ok {
-			return nil, errors.New("failed to parse root certificate")
-		}
-		return &tls.Config{
-			RootCAs:            certPool,
-			InsecureSkipVerify: true,
-			VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-				opts := x509.VerifyOptions{Roots: certPool}
-				for _, rawCert := range rawCerts {
-					cert, _ := x509.ParseCertificate(rawCert)
-					_, err := cert.Verify(opts)
-					if err != nil {
-						return err
-					}
-				}
-				return nil
-			},
-		}, nil
+	caBytes := sf.CaCert
+	if !sf.Single {
+		certificate, err := tls.X509KeyPair(sf.Cert, sf.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+		if caBytes == nil && len(sf.PublicKeyPins) == 0 {
+			caBytes = sf.Cert
+		}
 	}
+	config.ServerName = sf.ServerName
 
-	certificate, err := tls.X509KeyPair(sf.Cert, sf.Key)
-	if err != nil {
-		return nil, err
+	if len(caBytes) == 0 {
+		if len(sf.PublicKeyPins) == 0 {
+			return nil, errors.New("invalid root certificate")
+		}
+		// 仅固定公钥, 校验叶子证书
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("tls: no peer certificate")
+			}
+			leaf, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			return verifyPublicKeyPins(sf.PublicKeyPins, [][]*x509.Certificate{{leaf}})
+		}
+		return config, nil
 	}
 
 	certPool := x509.NewCertPool()
-	caBytes := sf.Cert
-	if sf.CaCert != nil {
-		caBytes = sf.CaCert
-	}
-	ok := certPool.AppendCertsFromPEM(caBytes)
-	if !ok {
+	if ok := certPool.AppendCertsFromPEM(caBytes); !ok {
 		return nil, errors.New("failed to parse root certificate")
 	}
-	block, _ := pem.Decode(caBytes)
-	if block == nil {
-		return nil, errors.New("failed to parse certificate PEM")
-	}
-	x509Cert, err := x509.ParseCertificate(block.Bytes)
-	if err != nil || x509Cert == nil {
-		return nil, errors.New("failed to parse block")
-	}
-
-	return &tls.Config{
-		RootCAs:            certPool,
-		Certificates:       []tls.Certificate{certificate},
-		InsecureSkipVerify: true,
-		ServerName:         x509Cert.Subject.CommonName,
-		VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			opts := x509.VerifyOptions{Roots: certPool}
-			for _, rawCert := range rawCerts {
-				cert, _ := x509.ParseCertificate(rawCert)
-				_, err := cert.Verify(opts)
-				if err != nil {
-					return err
-				}
+	config.RootCAs = certPool
+	if sf.ServerName != "" {
+		if len(sf.PublicKeyPins) > 0 {
+			config.VerifyPeerCertificate = func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+				return verifyPublicKeyPins(sf.PublicKeyPins, verifiedChains)
 			}
-			return nil
-		},
-	}, nil
+		}
+		return config, nil
+	}
+	// 未指定服务端名称, 跳过标准校验(其要求名称匹配), 仅校验证书链
+	config.InsecureSkipVerify = true
+	if !sf.Single {
+		if block, _ := pem.Decode(caBytes); block != nil {
+			if ca, err := x509.ParseCertificate(block.Bytes); err == nil {
+				config.ServerName = ca.Subject.CommonName
+			}
+		}
+	}
+	config.VerifyPeerCertificate = verifyPeerChain(certPool, sf.PublicKeyPins)
+	return config, nil
 }
 
 // ServerConfig server tls config
 func (sf *TLSConfig) ServerConfig() (*tls.Config, error) {
+	config, err := sf.baseConfig()
+	if err != nil {
+		return nil, err
+	}
 	certificate, err := tls.X509KeyPair(sf.Cert, sf.Key)
 	if err != nil {
 		return nil, err
 	}
-	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+	config.Certificates = []tls.Certificate{certificate}
 	if !sf.Single {
 		certPool := x509.NewCertPool()
 		caBytes := sf.Cert
@@ -128,3 +161,123 @@ func (sf *TLSConfig) ServerConfig() (*tls.Config, error) {
 	}
 	return config, nil
 }
+
+// baseConfig 客户端和服务端共用的配置
+func (sf *TLSConfig) baseConfig() (*tls.Config, error) {
+	if sf.MinVersion != 0 && sf.MaxVersion != 0 && sf.MinVersion > sf.MaxVersion {
+		return nil, fmt.Errorf("tls min version %s greater than max version %s",
+			tls.VersionName(sf.MinVersion), tls.VersionName(sf.MaxVersion))
+	}
+	return &tls.Config{ // nolint: gosec
+		NextProtos:   sf.NextProtos,
+		MinVersion:   sf.MinVersion,
+		MaxVersion:   sf.MaxVersion,
+		CipherSuites: sf.CipherSuites,
+	}, nil
+}
+
+// verifyPeerChain 校验对端证书链, 第一个为叶子证书, 其余为中间证书, 不校验名称
+// pins不为空时, 还需校验通过的证书链中有证书匹配固定的公钥
+func verifyPeerChain(roots *x509.CertPool, pins [][]byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("tls: no peer certificate")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		chains, err := certs[0].Verify(opts)
+		if err != nil {
+			return err
+		}
+		return verifyPublicKeyPins(pins, chains)
+	}
+}
+
+// verifyPublicKeyPins 校验证书链中是否有证书的公钥与固定的公钥匹配, pins为空时不校验
+func verifyPublicKeyPins(pins [][]byte, chains [][]*x509.Certificate) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if subtle.ConstantTimeCompare(sum[:], pin) == 1 {
+					return nil
+				}
+			}
+		}
+	}
+	return errors.New("tls: peer certificate does not match any pinned public key")
+}
+
+// PublicKeyPin 计算证书公钥的固定值, 即公钥(SPKI)SHA-256哈希的base64编码
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ParsePublicKeyPin 解析公钥的固定值, 支持base64或hex编码, 可带 sha256/ 前缀
+func ParsePublicKeyPin(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "sha256/")
+	pin, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(pin) != sha256.Size {
+		if pin, err = hex.DecodeString(s); err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid public key pin %s, should be base64 or hex encoded sha256", s)
+		}
+	}
+	return pin, nil
+}
+
+// ParseTLSVersion 解析tls版本, 支持 1.0, 1.1, 1.2, 1.3, 空字符串返回0
+func ParseTLSVersion(s string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(s), "tls") {
+	case "":
+		return 0, nil
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown tls version %s", s)
+	}
+}
+
+// ParseCipherSuites 根据名称解析加密套件, 名称见 tls.CipherSuiteName
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	suites := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		suites[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := suites[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls cipher suite %s", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,267 @@
+package cs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// genCert 生成证书, parent为nil时生成自签名的ca证书
+func genCert(t *testing.T, cn string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, []byte, []byte) {
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	if parent == nil {
+		parent, parentKey = tpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, key.Public(), parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+}
+
+// tlsHandshake 使用服务端和客户端配置完成一次握手, 返回客户端的连接状态
+func tlsHandshake(t *testing.T, srvConfig, cliConfig TLSConfig) (tls.ConnectionState, error) {
+	srvConf, err := srvConfig.ServerConfig()
+	require.NoError(t, err)
+	cliConf, err := cliConfig.ClientConfig()
+	require.NoError(t, err)
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", srvConf)
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.(*tls.Conn).Handshake() // nolint: errcheck
+	}()
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", ln.Addr().String(), cliConf)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState(), nil
+}
+
+func TestTLSConfig(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ca, caCrt, _ := genCert(t, "jocasta ca", caKey, nil, nil)
+
+	srvKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, srvCrt, srvKeyPem := genCert(t, "jocasta.test", srvKey, ca, caKey)
+
+	_, cliEdKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, cliCrt, cliKeyPem := genCert(t, "client", cliEdKey, ca, caKey)
+
+	t.Run("single ecdsa", func(t *testing.T) {
+		srv := TLSConfig{CaCert: caCrt, Cert: srvCrt, Key: srvKeyPem, Single: true}
+
+		// 未指定服务端名称, 仅校验证书链
+		_, err := tlsHandshake(t, srv, TLSConfig{CaCert: caCrt, Single: true})
+		require.NoError(t, err)
+		// 指定服务端名称
+		_, err = tlsHandshake(t, srv, TLSConfig{CaCert: caCrt, Single: true, ServerName: "jocasta.test"})
+		require.NoError(t, err)
+		_, err = tlsHandshake(t, srv, TLSConfig{CaCert: caCrt, Single: true, ServerName: "other.test"})
+		require.Error(t, err)
+		// 非信任的ca签发的证书
+		otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		_, otherCrt, _ := genCert(t, "other ca", otherKey, nil, nil)
+		_, err = tlsHandshake(t, srv, TLSConfig{CaCert: otherCrt, Single: true})
+		require.Error(t, err)
+	})
+
+	t.Run("mutual ed25519", func(t *testing.T) {
+		srv := TLSConfig{CaCert: caCrt, Cert: srvCrt, Key: srvKeyPem}
+		cli := TLSConfig{CaCert: caCrt, Cert: cliCrt, Key: cliKeyPem, ServerName: "jocasta.test"}
+		_, err := tlsHandshake(t, srv, cli)
+		require.NoError(t, err)
+
+		// 未指定服务端名称时, 以ca证书的CommonName作为SNI
+		srvConf, err := srv.ServerConfig()
+		require.NoError(t, err)
+		sni := make(chan string, 1)
+		srvConf.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			sni <- hello.ServerName
+			return nil, nil
+		}
+		cli = TLSConfig{CaCert: caCrt, Cert: cliCrt, Key: cliKeyPem}
+		cliConf, err := cli.ClientConfig()
+		require.NoError(t, err)
+		ln, err := tls.Listen("tcp", "127.0.0.1:0", srvConf)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.(*tls.Conn).Handshake() // nolint: errcheck
+		}()
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", ln.Addr().String(), cliConf)
+		require.NoError(t, err)
+		conn.Close()
+		assert.Equal(t, "jocasta ca", <-sni)
+	})
+
+	t.Run("alpn and version", func(t *testing.T) {
+		srv := TLSConfig{
+			CaCert:     caCrt,
+			Cert:       srvCrt,
+			Key:        srvKeyPem,
+			Single:     true,
+			NextProtos: []string{"h2", "http/1.1"},
+		}
+		cli := TLSConfig{
+			CaCert:       caCrt,
+			Single:       true,
+			NextProtos:   []string{"http/1.1"},
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		}
+		state, err := tlsHandshake(t, srv, cli)
+		require.NoError(t, err)
+		assert.Equal(t, "http/1.1", state.NegotiatedProtocol)
+		assert.Equal(t, uint16(tls.VersionTLS12), state.Version)
+		assert.Equal(t, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, state.CipherSuite)
+
+		srv.MinVersion = tls.VersionTLS13
+		_, err = tlsHandshake(t, srv, cli)
+		require.Error(t, err)
+
+		cli.MinVersion = tls.VersionTLS13
+		_, err = cli.ClientConfig()
+		require.Error(t, err)
+	})
+
+	t.Run("public key pin", func(t *testing.T) {
+		wrongPin := make([]byte, 32)
+
+		// 自签名证书, 仅固定公钥
+		selfKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		self, selfCrt, selfKeyPem := genCert(t, "self.test", selfKey, nil, nil)
+		selfPin, err := ParsePublicKeyPin(PublicKeyPin(self))
+		require.NoError(t, err)
+		srv := TLSConfig{Cert: selfCrt, Key: selfKeyPem, Single: true}
+
+		_, err = tlsHandshake(t, srv, TLSConfig{Single: true, PublicKeyPins: [][]byte{wrongPin, selfPin}})
+		require.NoError(t, err)
+		_, err = tlsHandshake(t, srv, TLSConfig{Single: true, PublicKeyPins: [][]byte{wrongPin}})
+		require.Error(t, err)
+
+		// ca校验的同时固定ca的公钥
+		caPin, err := ParsePublicKeyPin(PublicKeyPin(ca))
+		require.NoError(t, err)
+		srv = TLSConfig{CaCert: caCrt, Cert: srvCrt, Key: srvKeyPem, Single: true}
+		for _, serverName := range []string{"", "jocasta.test"} {
+			_, err = tlsHandshake(t, srv, TLSConfig{CaCert: caCrt, Single: true, ServerName: serverName, PublicKeyPins: [][]byte{caPin}})
+			require.NoError(t, err)
+			_, err = tlsHandshake(t, srv, TLSConfig{CaCert: caCrt, Single: true, ServerName: serverName, PublicKeyPins: [][]byte{wrongPin}})
+			require.Error(t, err)
+		}
+
+		// 双向认证, 仅固定公钥
+		srv = TLSConfig{CaCert: caCrt, Cert: srvCrt, Key: srvKeyPem}
+		srvPin := sha256.Sum256(mustParseCrt(t, srvCrt).RawSubjectPublicKeyInfo)
+		_, err = tlsHandshake(t, srv, TLSConfig{Cert: cliCrt, Key: cliKeyPem, PublicKeyPins: [][]byte{srvPin[:]}})
+		require.NoError(t, err)
+
+		_, err = (&TLSConfig{Single: true}).ClientConfig()
+		require.Error(t, err)
+	})
+}
+
+func mustParseCrt(t *testing.T, b []byte) *x509.Certificate {
+	block, _ := pem.Decode(b)
+	require.NotNil(t, block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestParsePublicKeyPin(t *testing.T) {
+	sum := sha256.Sum256([]byte("jocasta"))
+	for _, s := range []string{
+		base64.StdEncoding.EncodeToString(sum[:]),
+		"sha256/" + base64.StdEncoding.EncodeToString(sum[:]),
+		hex.EncodeToString(sum[:]),
+	} {
+		pin, err := ParsePublicKeyPin(s)
+		require.NoError(t, err)
+		assert.Equal(t, sum[:], pin)
+	}
+	for _, s := range []string{"", "invalid", base64.StdEncoding.EncodeToString(sum[:16])} {
+		_, err := ParsePublicKeyPin(s)
+		require.Error(t, err)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	for s, want := range map[string]uint16{
+		"":       0,
+		"1.0":    tls.VersionTLS10,
+		"1.1":    tls.VersionTLS11,
+		"1.2":    tls.VersionTLS12,
+		"tls1.3": tls.VersionTLS13,
+	} {
+		got, err := ParseTLSVersion(s)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParseTLSVersion("2.0")
+	require.Error(t, err)
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	got, err := ParseCipherSuites([]string{
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"tls_ecdhe_rsa_with_chacha20_poly1305_sha256",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}, got)
+
+	_, err = ParseCipherSuites([]string{"invalid"})
+	require.Error(t, err)
+}
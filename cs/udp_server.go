@@ -1,7 +1,22 @@
 package cs
 
 import (
+	"errors"
 	"net"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/jocasta/pkg/bpool"
+	"github.com/thinkgos/jocasta/pkg/logger"
+)
+
+// udp server 默认值
+const (
+	DefaultUDPWorkers    = 32   // 默认工作协程数
+	DefaultUDPQueueSize  = 128  // 默认每个工作协程的队列长度
+	DefaultUDPBufferSize = 2048 // 默认报文缓存大小
 )
 
 // Message message
@@ -10,3 +25,211 @@ type Message struct {
 	SrcAddr   *net.UDPAddr
 	Data      []byte
 }
+
+// UDPHandler 处理udp报文, msg.Data在ServeUDP返回后被回收, 需保留时应复制
+type UDPHandler interface {
+	ServeUDP(conn *net.UDPConn, msg Message)
+}
+
+// UDPHandlerFunc function implement UDPHandler interface
+type UDPHandlerFunc func(conn *net.UDPConn, msg Message)
+
+// ServeUDP implement UDPHandler interface
+func (f UDPHandlerFunc) ServeUDP(conn *net.UDPConn, msg Message) { f(conn, msg) }
+
+// UDPStats udp server 统计
+type UDPStats struct {
+	Packets uint64 // 收到的报文数
+	Bytes   uint64 // 收到的字节数
+	Drops   uint64 // 队列满丢弃的报文数
+}
+
+// UDPServerOption udp server 配置选项
+type UDPServerOption func(*UDPServer)
+
+// WithUDPWorkers 工作协程数, default: DefaultUDPWorkers
+func WithUDPWorkers(n int) UDPServerOption {
+	return func(s *UDPServer) {
+		if n > 0 {
+			s.workers = n
+		}
+	}
+}
+
+// WithUDPQueueSize 每个工作协程的队列长度, 队列满时丢弃报文, default: DefaultUDPQueueSize
+func WithUDPQueueSize(n int) UDPServerOption {
+	return func(s *UDPServer) {
+		if n > 0 {
+			s.queueSize = n
+		}
+	}
+}
+
+// WithUDPBufferPool 报文缓存池, 缓存的容量即最大的报文长度, default: 容量为DefaultUDPBufferSize的bpool.Pool
+func WithUDPBufferPool(p bpool.BufferPool) UDPServerOption {
+	return func(s *UDPServer) {
+		if p != nil {
+			s.bufPool = p
+		}
+	}
+}
+
+// WithUDPLogger 日志, default: logger.NewDiscard()
+func WithUDPLogger(log logger.Logger) UDPServerOption {
+	return func(s *UDPServer) {
+		if log != nil {
+			s.log = log
+		}
+	}
+}
+
+// UDPServer udp服务
+// 固定数量的工作协程处理报文, 同一源地址的报文由同一个工作协程按序处理,
+// 工作协程的队列满时丢弃报文, 处理报文时不应阻塞, 需建立会话时使用 UDPSession
+type UDPServer struct {
+	conn      *net.UDPConn
+	localAddr *net.UDPAddr
+	handler   UDPHandler
+	workers   int
+	queueSize int
+	bufPool   bpool.BufferPool
+	log       logger.Logger
+	queues    []chan Message
+
+	packets uint64
+	bytes   uint64
+	drops   uint64
+
+	wg        sync.WaitGroup
+	readDone  chan struct{}
+	closing   int32
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// ListenUDP 监听addr并开始服务
+func ListenUDP(network, addr string, handler UDPHandler, opts ...UDPServerOption) (*UDPServer, error) {
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP(network, udpAddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPServer(conn, handler, opts...), nil
+}
+
+// NewUDPServer 在conn上开始服务, Close时将关闭conn
+func NewUDPServer(conn *net.UDPConn, handler UDPHandler, opts ...UDPServerOption) *UDPServer {
+	sf := &UDPServer{
+		conn:      conn,
+		handler:   handler,
+		workers:   DefaultUDPWorkers,
+		queueSize: DefaultUDPQueueSize,
+		bufPool:   bpool.NewPool(DefaultUDPBufferSize),
+		log:       logger.NewDiscard(),
+		readDone:  make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(sf)
+	}
+	sf.localAddr, _ = conn.LocalAddr().(*net.UDPAddr)
+	sf.queues = make([]chan Message, sf.workers)
+	for i := range sf.queues {
+		sf.queues[i] = make(chan Message, sf.queueSize)
+		sf.wg.Add(1)
+		go sf.work(sf.queues[i])
+	}
+	go sf.serve()
+	return sf
+}
+
+// Conn 返回底层的udp连接
+func (sf *UDPServer) Conn() *net.UDPConn { return sf.conn }
+
+// LocalAddr 返回监听地址
+func (sf *UDPServer) LocalAddr() net.Addr { return sf.conn.LocalAddr() }
+
+// Stats 返回统计
+func (sf *UDPServer) Stats() UDPStats {
+	return UDPStats{
+		Packets: atomic.LoadUint64(&sf.packets),
+		Bytes:   atomic.LoadUint64(&sf.bytes),
+		Drops:   atomic.LoadUint64(&sf.drops),
+	}
+}
+
+// Close 停止接收报文, 等待已接收的报文处理完成后关闭连接
+func (sf *UDPServer) Close() error {
+	sf.closeOnce.Do(func() {
+		atomic.StoreInt32(&sf.closing, 1)
+		sf.conn.SetReadDeadline(time.Now()) // nolint: errcheck
+		<-sf.readDone
+		for _, q := range sf.queues {
+			close(q)
+		}
+		sf.wg.Wait()
+		sf.closeErr = sf.conn.Close()
+	})
+	return sf.closeErr
+}
+
+func (sf *UDPServer) serve() {
+	defer close(sf.readDone)
+	for {
+		buf := sf.bufPool.Get()
+		n, srcAddr, err := sf.conn.ReadFromUDP(buf[:cap(buf)])
+		if err != nil {
+			sf.bufPool.Put(buf)
+			if atomic.LoadInt32(&sf.closing) == 1 || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			// 其它错误(如icmp不可达引起的错误)忽略, 继续读取
+			time.Sleep(time.Millisecond * 5)
+			continue
+		}
+		atomic.AddUint64(&sf.packets, 1)
+		atomic.AddUint64(&sf.bytes, uint64(n))
+
+		msg := Message{LocalAddr: sf.localAddr, SrcAddr: srcAddr, Data: buf[:n]}
+		select {
+		case sf.queues[udpAddrHash(srcAddr)%uint32(len(sf.queues))] <- msg:
+		default:
+			atomic.AddUint64(&sf.drops, 1)
+			sf.bufPool.Put(buf)
+		}
+	}
+}
+
+func (sf *UDPServer) work(queue <-chan Message) {
+	defer sf.wg.Done()
+	for msg := range queue {
+		sf.handle(msg)
+	}
+}
+
+func (sf *UDPServer) handle(msg Message) {
+	defer func() {
+		sf.bufPool.Put(msg.Data)
+		if err := recover(); err != nil {
+			sf.log.DPanicf("udp server handler crashed: %v\n%s", err, debug.Stack())
+		}
+	}()
+	sf.handler.ServeUDP(sf.conn, msg)
+}
+
+// udpAddrHash fnv-1a
+func udpAddrHash(addr *net.UDPAddr) uint32 {
+	h := uint32(2166136261)
+	for _, b := range addr.IP {
+		h = (h ^ uint32(b)) * 16777619
+	}
+	h = (h ^ uint32(addr.Port&0xff)) * 16777619
+	h = (h ^ uint32(addr.Port>>8)) * 16777619
+	return h
+}
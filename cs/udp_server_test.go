@@ -0,0 +1,116 @@
+package cs
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPServer(t *testing.T) {
+	t.Run("echo", func(t *testing.T) {
+		srv, err := ListenUDP("udp", "127.0.0.1:0", UDPHandlerFunc(func(conn *net.UDPConn, msg Message) {
+			conn.WriteToUDP(msg.Data, msg.SrcAddr) // nolint: errcheck
+		}), WithUDPWorkers(2))
+		require.NoError(t, err)
+		defer srv.Close() // nolint: errcheck
+
+		cli, err := net.Dial("udp", srv.LocalAddr().String())
+		require.NoError(t, err)
+		defer cli.Close()                                // nolint: errcheck
+		cli.SetReadDeadline(time.Now().Add(time.Second)) // nolint: errcheck
+
+		b := make([]byte, 16)
+		for _, s := range []string{"hello", "world"} {
+			_, err = cli.Write([]byte(s))
+			require.NoError(t, err)
+			n, err := cli.Read(b)
+			require.NoError(t, err)
+			assert.Equal(t, s, string(b[:n]))
+		}
+		assert.Equal(t, UDPStats{Packets: 2, Bytes: 10}, srv.Stats())
+	})
+
+	t.Run("drop and graceful close", func(t *testing.T) {
+		var handled int32
+		block := make(chan struct{})
+		srv, err := ListenUDP("udp", "127.0.0.1:0", UDPHandlerFunc(func(conn *net.UDPConn, msg Message) {
+			<-block
+			atomic.AddInt32(&handled, 1)
+		}), WithUDPWorkers(1), WithUDPQueueSize(2))
+		require.NoError(t, err)
+
+		cli, err := net.Dial("udp", srv.LocalAddr().String())
+		require.NoError(t, err)
+		defer cli.Close() // nolint: errcheck
+
+		// 1个处理中, 2个排队, 其余丢弃
+		for i := 0; i < 10; i++ {
+			_, err = cli.Write([]byte("x"))
+			require.NoError(t, err)
+			time.Sleep(time.Millisecond * 10)
+		}
+		require.Eventually(t, func() bool { return srv.Stats().Packets == 10 }, time.Second, time.Millisecond*10)
+		assert.Equal(t, uint64(7), srv.Stats().Drops)
+
+		closed := make(chan struct{})
+		go func() {
+			srv.Close() // nolint: errcheck
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatal("close should wait for queued messages")
+		case <-time.After(time.Millisecond * 50):
+		}
+		close(block)
+		<-closed
+		assert.Equal(t, int32(3), atomic.LoadInt32(&handled))
+	})
+}
+
+func TestUDPSession(t *testing.T) {
+	t.Run("queue while dialing", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		defer c2.Close() // nolint: errcheck
+		dialing := make(chan struct{})
+		sess := NewUDPSession(2, func(*UDPSession) (net.Conn, error) {
+			<-dialing
+			return c1, nil
+		}, func(conn net.Conn, data []byte) error {
+			_, err := conn.Write(data)
+			return err
+		})
+		defer sess.Close() // nolint: errcheck
+
+		// 建立会话期间不阻塞, 队列满时丢弃
+		data := []byte("a")
+		assert.True(t, sess.Put(data))
+		data[0] = 'b' // Put已复制
+		assert.True(t, sess.Put([]byte("c")))
+		assert.False(t, sess.Put([]byte("d")))
+
+		close(dialing)
+		b := make([]byte, 1)
+		for _, want := range []string{"a", "c"} {
+			_, err := c2.Read(b)
+			require.NoError(t, err)
+			assert.Equal(t, want, string(b))
+		}
+
+		require.NoError(t, sess.Close())
+		assert.True(t, sess.IsClosed())
+		assert.False(t, sess.Put([]byte("e")))
+	})
+
+	t.Run("dial failed", func(t *testing.T) {
+		sess := NewUDPSession(0, func(*UDPSession) (net.Conn, error) {
+			return nil, net.ErrClosed
+		}, func(net.Conn, []byte) error { return nil })
+		<-sess.Done()
+		assert.False(t, sess.Put([]byte("a")))
+	})
+}
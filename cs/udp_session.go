@@ -0,0 +1,104 @@
+package cs
+
+import (
+	"net"
+	"sync"
+)
+
+// DefaultUDPSessionQueueSize 默认每个会话的报文队列长度
+const DefaultUDPSessionQueueSize = 64
+
+// UDPSession 异步建立的udp会话.
+// 建立会话(如连接上级并握手)可能阻塞, 在会话自己的协程中进行, udp server的工作协程只调用 Put 放入报文,
+// 会话建立后按序调用write发送队列中的报文, 建立失败或write返回错误时关闭会话.
+type UDPSession struct {
+	queue chan []byte
+	done  chan struct{}
+
+	mu        sync.Mutex
+	conn      net.Conn
+	closed    bool
+	closeOnce sync.Once
+}
+
+// NewUDPSession 创建会话, 在新协程中调用dial建立会话, dial的参数即此会话, queueSize <= 0 使用 DefaultUDPSessionQueueSize
+func NewUDPSession(queueSize int, dial func(sess *UDPSession) (net.Conn, error), write func(conn net.Conn, data []byte) error) *UDPSession {
+	if queueSize <= 0 {
+		queueSize = DefaultUDPSessionQueueSize
+	}
+	sf := &UDPSession{
+		queue: make(chan []byte, queueSize),
+		done:  make(chan struct{}),
+	}
+	go sf.run(dial, write)
+	return sf
+}
+
+func (sf *UDPSession) run(dial func(sess *UDPSession) (net.Conn, error), write func(conn net.Conn, data []byte) error) {
+	conn, err := dial(sf)
+	if err != nil {
+		sf.Close() // nolint: errcheck
+		return
+	}
+	sf.mu.Lock()
+	if sf.closed {
+		sf.mu.Unlock()
+		conn.Close() // nolint: errcheck
+		return
+	}
+	sf.conn = conn
+	sf.mu.Unlock()
+
+	for {
+		select {
+		case <-sf.done:
+			return
+		case data := <-sf.queue:
+			if err = write(conn, data); err != nil {
+				sf.Close() // nolint: errcheck
+				return
+			}
+		}
+	}
+}
+
+// Put 复制data放入队列, 不阻塞, 会话已关闭或队列满时丢弃并返回false
+func (sf *UDPSession) Put(data []byte) bool {
+	if sf.IsClosed() {
+		return false
+	}
+	select {
+	case sf.queue <- append([]byte(nil), data...):
+		return true
+	default:
+		return false
+	}
+}
+
+// Done 会话关闭时关闭
+func (sf *UDPSession) Done() <-chan struct{} { return sf.done }
+
+// IsClosed 会话是否已关闭, 已关闭的会话应重新建立
+func (sf *UDPSession) IsClosed() bool {
+	select {
+	case <-sf.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 关闭会话及已建立的连接, 丢弃未发送的报文
+func (sf *UDPSession) Close() (err error) {
+	sf.closeOnce.Do(func() {
+		sf.mu.Lock()
+		sf.closed = true
+		conn := sf.conn
+		sf.mu.Unlock()
+		close(sf.done)
+		if conn != nil {
+			err = conn.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,193 @@
+package cs
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+// UnixConfig unix domain socket 配置, 抽象命名空间时无效
+type UnixConfig struct {
+	// socket文件权限, 0 表示不修改
+	Mode os.FileMode
+	// socket文件属主, 格式 user[:group], 支持名称或id, 为空表示不修改
+	Owner string
+}
+
+// ListenUnix listen unix domain socket
+// path 以@开头时使用抽象命名空间, 仅linux支持
+func ListenUnix(path string, config UnixConfig, afterChains ...connection.AdornConn) (net.Listener, error) {
+	abstract := strings.HasPrefix(path, "@")
+	if abstract && runtime.GOOS != "linux" {
+		return nil, errors.New("abstract unix socket only supported on linux")
+	}
+	if !abstract {
+		if err := removeStaleUnixSocket(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if abstract || (config.Mode == 0 && config.Owner == "") {
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return connection.NewListener(&unixListener{Listener: ln}, afterChains...), nil
+	}
+
+	// 先在私有目录中创建socket并修改权限和属主, 再移动到path,
+	// 避免socket以默认权限暴露在path上
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".jocasta-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir) // nolint: errcheck
+	tmpPath := filepath.Join(dir, "s")
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err = config.apply(tmpPath); err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return connection.NewListener(&unixListener{
+		Listener: ln,
+		addr:     &net.UnixAddr{Name: path, Net: "unix"},
+		unlink:   true,
+	}, afterChains...), nil
+}
+
+// apply 修改socket文件的权限和属主
+func (sf UnixConfig) apply(path string) error {
+	if sf.Mode != 0 {
+		if err := os.Chmod(path, sf.Mode); err != nil {
+			return err
+		}
+	}
+	if sf.Owner == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(sf.Owner)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// lookupOwner 解析 user[:group], 返回uid, gid, -1表示不修改
+func lookupOwner(owner string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	userName, groupName := owner, ""
+	if i := strings.Index(owner, ":"); i >= 0 {
+		userName, groupName = owner[:i], owner[i+1:]
+	}
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return 0, 0, err
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return 0, 0, err
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// removeStaleUnixSocket 删除残留的socket文件, 如果仍有服务在监听则返回错误
+func removeStaleUnixSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exist and is not a unix socket", path)
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("%s address already in use", path)
+	}
+	return os.Remove(path)
+}
+
+// unixListener unix domain socket 的对端地址通常为空,
+// 这里为每个连接分配一个唯一的对端地址, 以便用于连接的标识
+type unixListener struct {
+	net.Listener
+	seq uint64
+	// 不为nil时为移动后的地址, unlink为true时关闭时删除该socket文件
+	addr      net.Addr
+	unlink    bool
+	closeOnce sync.Once
+}
+
+// Addr returns the listener's network address.
+func (sf *unixListener) Addr() net.Addr {
+	if sf.addr != nil {
+		return sf.addr
+	}
+	return sf.Listener.Addr()
+}
+
+// Close closes the listener.
+func (sf *unixListener) Close() error {
+	err := sf.Listener.Close()
+	if sf.unlink {
+		sf.closeOnce.Do(func() { os.Remove(sf.addr.String()) }) // nolint: errcheck
+	}
+	return err
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (sf *unixListener) Accept() (net.Conn, error) {
+	c, err := sf.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	seq := atomic.AddUint64(&sf.seq, 1)
+	return &unixConn{
+		Conn:   c,
+		remote: &net.UnixAddr{Name: sf.Addr().String() + "#" + strconv.FormatUint(seq, 10), Net: "unix"},
+	}, nil
+}
+
+type unixConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+// RemoteAddr returns the remote network address.
+func (sf *unixConn) RemoteAddr() net.Addr { return sf.remote }
+
+// CloseWrite 半关闭底层连接的写方向
+func (sf *unixConn) CloseWrite() error {
+	return enet.CloseWrite(sf.Conn)
+}
@@ -0,0 +1,41 @@
+package cs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file mode of unix socket not support on windows")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jocasta.sock")
+
+	ln, err := ListenUnix(path, UnixConfig{Mode: 0600})
+	require.NoError(t, err)
+	assert.Equal(t, path, ln.Addr().String())
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	// 创建socket的私有目录已删除
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, ln.Close())
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// 属主错误时不留下socket文件
+	_, err = ListenUnix(path, UnixConfig{Mode: 0600, Owner: "jocasta-no-such-user"})
+	require.Error(t, err)
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,84 @@
+package cs
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+// WSClient websocket client dialer
+type WSClient struct {
+	// 不为nil时使用wss
+	TLSConfig   *tls.Config
+	Config      WsConfig
+	Timeout     time.Duration
+	Forward     connection.Dialer
+	AfterChains connection.AdornConnsChain
+}
+
+// Dial connects to the address on the named network.
+func (sf *WSClient) Dial(network, addr string) (net.Conn, error) {
+	return sf.DialContext(context.Background(), network, addr)
+}
+
+// DialContext connects to the address on the named network using the provided context.
+func (sf *WSClient) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host := sf.Config.Host
+	if host == "" {
+		host = addr
+		if network == "unix" {
+			host = "localhost"
+		}
+	}
+	scheme, origin := "ws", "http"
+	d := &connection.Client{Timeout: sf.Timeout, Forward: sf.Forward}
+	if sf.TLSConfig != nil {
+		scheme, origin = "wss", "https"
+		tlsConfig := sf.TLSConfig
+		if sf.Config.Host != "" && tlsConfig.ServerName == "" {
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(host)
+			if tlsConfig.ServerName == "" {
+				tlsConfig.ServerName = host
+			}
+		}
+		d.AdornChains = connection.AdornConnsChain{connection.BaseAdornTLSClient(tlsConfig)}
+	}
+	rawConn, err := d.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := websocket.NewConfig(scheme+"://"+host+sf.Config.path(), origin+"://"+host)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	for k, v := range sf.Config.Header {
+		config.Header[k] = v
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		rawConn.SetDeadline(deadline) // nolint: errcheck
+	} else if sf.Timeout > 0 {
+		rawConn.SetDeadline(time.Now().Add(sf.Timeout)) // nolint: errcheck
+	}
+	ws, err := websocket.NewClient(config, rawConn)
+	if err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	rawConn.SetDeadline(time.Time{}) // nolint: errcheck
+	ws.PayloadType = websocket.BinaryFrame
+
+	var c net.Conn = &wsConn{ws, rawConn.LocalAddr(), rawConn.RemoteAddr()}
+	for _, chain := range sf.AfterChains {
+		c = chain(c)
+	}
+	return c, nil
+}
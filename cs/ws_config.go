@@ -0,0 +1,45 @@
+package cs
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// WsConfig websocket 配置
+type WsConfig struct {
+	// 请求路径, 默认 /
+	Path string
+	// 请求头Host, 为空时使用拨号地址, 经由CDN或反向代理时设置为相应的域名,
+	// wss 时同时作为tls的ServerName
+	Host string
+	// 额外的请求头
+	Header http.Header
+}
+
+// path 返回请求路径
+func (sf WsConfig) path() string {
+	if sf.Path == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(sf.Path, "/") {
+		return "/" + sf.Path
+	}
+	return sf.Path
+}
+
+// wsConn websocket实现net.Conn接口, websocket.Conn的LocalAddr和RemoteAddr
+// 返回的是websocket的Origin和Location, 这里替换成实际的网络地址
+type wsConn struct {
+	*websocket.Conn
+	local  net.Addr
+	remote net.Addr
+}
+
+// LocalAddr returns the local network address.
+func (sf *wsConn) LocalAddr() net.Addr { return sf.local }
+
+// RemoteAddr returns the remote network address.
+func (sf *wsConn) RemoteAddr() net.Addr { return sf.remote }
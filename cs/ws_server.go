@@ -0,0 +1,126 @@
+package cs
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+type wsListen struct {
+	ln          net.Listener
+	srv         *http.Server
+	afterChains connection.AdornConnsChain
+	conns       chan net.Conn
+	ctx         context.Context
+	cancel      context.CancelFunc
+	closeOnce   sync.Once
+}
+
+// ListenWS listen websocket, 每个websocket连接对应一个net.Conn
+// tlsConf 不为nil时使用wss
+func ListenWS(network, addr string, tlsConf *tls.Config, config WsConfig, afterChains ...connection.AdornConn) (net.Listener, error) {
+	if network == "" {
+		network = "tcp"
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewWSListener(ln, tlsConf, config, afterChains...), nil
+}
+
+// NewWSListener 在已有的listener上提供websocket服务
+// tlsConf 不为nil时使用wss
+func NewWSListener(ln net.Listener, tlsConf *tls.Config, config WsConfig, afterChains ...connection.AdornConn) net.Listener {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &wsListen{
+		ln:          ln,
+		afterChains: afterChains,
+		conns:       make(chan net.Conn, 64),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	mux := http.NewServeMux()
+	mux.Handle(config.path(), websocket.Server{Handler: l.handle})
+	l.srv = &http.Server{Handler: mux} // nolint: gosec
+
+	// 使用SO_REUSEPORT时每个socket独立服务
+	for _, sub := range connection.Listeners(ln) {
+		if tlsConf != nil {
+			sub = tls.NewListener(sub, tlsConf)
+		}
+		go l.srv.Serve(sub) // nolint: errcheck
+	}
+	return l
+}
+
+// handle websocket.Server在handler返回后将关闭连接,所以需等待连接被关闭
+func (sf *wsListen) handle(ws *websocket.Conn) {
+	ws.PayloadType = websocket.BinaryFrame
+
+	req := ws.Request()
+	local, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	var remote net.Addr = &net.UnixAddr{Name: req.RemoteAddr, Net: "unix"}
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		remote = addr
+	}
+	c := &wsServerConn{
+		wsConn: wsConn{ws, local, remote},
+		done:   make(chan struct{}),
+	}
+	select {
+	case sf.conns <- c:
+	case <-sf.ctx.Done():
+		return
+	}
+	select {
+	case <-c.done:
+	case <-sf.ctx.Done():
+	}
+}
+
+// Accept waits for and returns the next websocket connection.
+func (sf *wsListen) Accept() (net.Conn, error) {
+	select {
+	case <-sf.ctx.Done():
+		return nil, &net.OpError{Op: "accept", Net: sf.Addr().Network(), Addr: sf.Addr(), Err: net.ErrClosed}
+	case c := <-sf.conns:
+		for _, chain := range sf.afterChains {
+			c = chain(c)
+		}
+		return c, nil
+	}
+}
+
+// Close closes the listener.
+func (sf *wsListen) Close() (err error) {
+	sf.closeOnce.Do(func() {
+		sf.cancel()
+		err = sf.srv.Close()
+	})
+	return
+}
+
+// Addr returns the listener's network address.
+func (sf *wsListen) Addr() net.Addr { return sf.ln.Addr() }
+
+type wsServerConn struct {
+	wsConn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Close 关闭连接,并通知handler返回
+func (sf *wsServerConn) Close() (err error) {
+	sf.closeOnce.Do(func() {
+		err = sf.wsConn.Close()
+		close(sf.done)
+	})
+	return
+}
@@ -0,0 +1,80 @@
+package ccs
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/cs"
+	"github.com/thinkgos/jocasta/pkg/extcert"
+)
+
+func init() {
+	connection.RegisterAdorn("tls", adornTLS)
+	connection.RegisterAdorn("stcp", adornStcpSpec)
+}
+
+// adornTLS tls(ca=ca.crt,cert=a.crt,key=a.key,single,server_name=x,pin=a|b,alpn=h2|http/1.1,min_version=1.2,max_version=1.3)
+// 与 cs.TLSConfig 含义相同, 多值以 | 分隔
+func adornTLS(args *connection.AdornArgs, server bool) (connection.AdornConn, error) {
+	var c cs.TLSConfig
+	var err error
+
+	if ca := args.String("ca", ""); ca != "" {
+		if c.CaCert, err = extcert.LoadCrt(ca); err != nil {
+			return nil, err
+		}
+	}
+	if cert, key := args.String("cert", ""), args.String("key", ""); cert != "" || key != "" {
+		if c.Cert, c.Key, err = extcert.LoadPair(cert, key); err != nil {
+			return nil, err
+		}
+	}
+	if c.Single, err = args.Bool("single", false); err != nil {
+		return nil, err
+	}
+	opt := TLSOption{
+		ServerName: args.String("server_name", ""),
+		MinVersion: args.String("min_version", ""),
+		MaxVersion: args.String("max_version", ""),
+	}
+	if v := args.String("alpn", ""); v != "" {
+		opt.NextProtos = strings.Split(v, "|")
+	}
+	if v := args.String("pin", ""); v != "" {
+		opt.PublicKeyPins = strings.Split(v, "|")
+	}
+	if err = opt.Apply(&c); err != nil {
+		return nil, err
+	}
+
+	if server {
+		conf, err := c.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		return connection.BaseAdornTLSServer(conf), nil
+	}
+	conf, err := c.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return connection.BaseAdornTLSClient(conf), nil
+}
+
+// adornStcpSpec stcp(method=aes-192-cfb,password=xxx,forward_secret)
+func adornStcpSpec(args *connection.AdornArgs, _ bool) (connection.AdornConn, error) {
+	var err error
+
+	c := cs.StcpConfig{
+		Method:   args.String("method", "aes-192-cfb"),
+		Password: args.String("password", ""),
+	}
+	if c.ForwardSecret, err = args.Bool("forward_secret", false); err != nil {
+		return nil, err
+	}
+	if !c.Valid() {
+		return nil, errors.New("invalid stcp method or password")
+	}
+	return adornStcp(c), nil
+}
@@ -0,0 +1,58 @@
+package ccs
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/connection"
+)
+
+func TestAdornSpec(t *testing.T) {
+	tlsArgs := "ca=" + base64CaCrt + ",cert=" + base64Crt + ",key=" + base64Key
+
+	for _, tt := range []struct {
+		name string
+		spec string
+	}{
+		{"tls", "tls(" + tlsArgs + ")"},
+		{"tls single", "tls(" + tlsArgs + ",single,min_version=1.2)"},
+		{"stcp", "stcp(method=aes-256-cfb,password=pass)"},
+		{"stcp forward secret", "stcp(password=pass,forward_secret)"},
+		{"stcp zstd", "stcp(password=pass),zstd(level=1),flow"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, err := connection.ParseAdornChain(tt.spec, false)
+			require.NoError(t, err)
+			srv, err := connection.ParseAdornChain(tt.spec, true)
+			require.NoError(t, err)
+
+			c1, c2 := net.Pipe()
+			client, server := cli.Adorn(c1), srv.Adorn(c2)
+			defer client.Close()       // nolint: errcheck
+			defer server.Close()       // nolint: errcheck
+			go io.Copy(server, server) // nolint: errcheck
+
+			want := []byte("hello adorn spec")
+			go client.Write(want) // nolint: errcheck
+			got := make([]byte, len(want))
+			_, err = io.ReadFull(client, got)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	for _, spec := range []string{
+		"tls(ca=xxx)",
+		"tls(" + tlsArgs + ",min_version=1.5)",
+		"stcp",
+		"stcp(method=xxx,password=pass)",
+		"stcp(password=pass,forward_secret=xxx)",
+	} {
+		_, err := connection.ParseAdornChain(spec, false)
+		assert.Error(t, err, spec)
+	}
+}
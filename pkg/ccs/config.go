@@ -2,10 +2,14 @@ package ccs
 
 import (
 	"fmt"
+	"net"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/thinkgos/jocasta/connection/caead"
+	"github.com/thinkgos/jocasta/connection/ccrypt"
+	"github.com/thinkgos/jocasta/core/loadbalance"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/internal/bytesconv"
 	"github.com/thinkgos/jocasta/pkg/extssh"
@@ -46,6 +50,19 @@ type LbConfig struct {
 	Timeout    time.Duration // 负载均衡dial超时时间 default 500ms
 	RetryTime  time.Duration // 负载均衡重试时间间隔 default 1000ms
 	HashTarget bool          // hash方法时,选择hash的目标, default: false
+	// 父级预热连接池, 仅tcp,tls,stcp,kcp,quic,ws,wss有效
+	PoolMinIdle int           // 每个父级保持的最小空闲连接数 default: 0
+	PoolMaxIdle int           // 每个父级的最大空闲连接数, 0表示不使用连接池 default: 0
+	PoolIdleTTL time.Duration // 空闲连接最长存活时间, 应小于父级的空闲超时 default: 30s
+}
+
+// PoolConfig 父级预热连接池配置
+func (sf LbConfig) PoolConfig() loadbalance.PoolConfig {
+	return loadbalance.PoolConfig{
+		MinIdle: sf.PoolMinIdle,
+		MaxIdle: sf.PoolMaxIdle,
+		IdleTTL: sf.PoolIdleTTL,
+	}
 }
 
 // SSHConfig ssh config
@@ -74,6 +91,46 @@ func (sf *SSHConfig) Parse() (ssh.AuthMethod, error) {
 	return extssh.LoadPrivateKey2AuthMethod(sf.KeyFile)
 }
 
+// TLSOption tls 扩展配置
+type TLSOption struct {
+	ServerName   string   // 期望的服务端名称, 同时作为SNI, 为空时仅校验证书链 default: empty
+	NextProtos   []string // ALPN协议列表, 如 h2,http/1.1 default: empty
+	MinVersion   string   // 最小tls版本, 1.0|1.1|1.2|1.3 default: empty, 使用默认值
+	MaxVersion   string   // 最大tls版本, 1.0|1.1|1.2|1.3 default: empty, 使用默认值
+	CipherSuites []string // 加密套件名称, 仅对tls1.2及以下有效 default: empty, 使用默认值
+	// 固定的服务端公钥, 公钥(SPKI)SHA-256哈希的base64或hex编码, 可带 sha256/ 前缀 default: empty
+	PublicKeyPins []string
+}
+
+// Apply 解析并设置到 cs.TLSConfig
+func (sf *TLSOption) Apply(c *cs.TLSConfig) (err error) {
+	if c.MinVersion, err = cs.ParseTLSVersion(sf.MinVersion); err != nil {
+		return err
+	}
+	if c.MaxVersion, err = cs.ParseTLSVersion(sf.MaxVersion); err != nil {
+		return err
+	}
+	if c.MinVersion != 0 && c.MaxVersion != 0 && c.MinVersion > c.MaxVersion {
+		return fmt.Errorf("tls min version %s greater than max version %s", sf.MinVersion, sf.MaxVersion)
+	}
+	if len(sf.CipherSuites) > 0 {
+		if c.CipherSuites, err = cs.ParseCipherSuites(sf.CipherSuites); err != nil {
+			return err
+		}
+	}
+	c.PublicKeyPins = nil
+	for _, p := range sf.PublicKeyPins {
+		pin, err := cs.ParsePublicKeyPin(p)
+		if err != nil {
+			return err
+		}
+		c.PublicKeyPins = append(c.PublicKeyPins, pin)
+	}
+	c.ServerName = sf.ServerName
+	c.NextProtos = sf.NextProtos
+	return nil
+}
+
 // SKCPConfig kcp full config
 type SKCPConfig struct {
 	// 加密的方法
@@ -110,3 +167,41 @@ func SKcpMode(mode string) (noDelay int, interval int, resend int, noCongestion
 		return 0, 40, 2, 1
 	}
 }
+
+// 连接加密方法
+const (
+	KeyMethodCFB = "cfb" // aes-cfb, 兼容旧版本
+)
+
+// KeyMethods 支持的连接加密方法
+func KeyMethods() []string {
+	return []string{KeyMethodCFB, caead.MethodAes128Gcm, caead.MethodAes256Gcm, caead.MethodChacha20Poly1305}
+}
+
+// KeyCipher LocalKey, ParentKey 的连接加密, 两端的加密方法须一致.
+// cfb 使用ccrypt, 兼容旧版本; aes-128-gcm,aes-256-gcm,chacha20-poly1305 使用caead,
+// 每个连接使用随机salt, 并校验数据完整性. caead 无法与cfb互通, 也不会自动识别, 与旧版本对接时须使用cfb
+type KeyCipher struct {
+	password string
+	aead     *caead.Cipher
+}
+
+// NewKeyCipher 创建连接加密, method为空时使用cfb
+func NewKeyCipher(method, password string) (*KeyCipher, error) {
+	if method == "" || method == KeyMethodCFB {
+		return &KeyCipher{password: password}, nil
+	}
+	cip, err := caead.NewCipher(method, password)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyCipher{password, cip}, nil
+}
+
+// Adorn 加密连接, server 表示本端是否为服务端(LocalKey), 仅caead区分
+func (sf *KeyCipher) Adorn(c net.Conn, server bool) net.Conn {
+	if sf.aead != nil {
+		return caead.New(c, sf.aead, server)
+	}
+	return ccrypt.New(c, ccrypt.Config{Password: sf.password})
+}
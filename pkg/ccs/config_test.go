@@ -1,9 +1,15 @@
 package ccs
 
 import (
+	"crypto/tls"
+	"io"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/cs"
 )
 
 func TestSKcpMode(t *testing.T) {
@@ -31,3 +37,57 @@ func TestSKcpMode(t *testing.T) {
 	assert.Equal(t, 2, resend)
 	assert.Equal(t, 1, noCongestion)
 }
+
+func TestTLSOption(t *testing.T) {
+	opt := TLSOption{
+		ServerName:   "jocasta.test",
+		NextProtos:   []string{"h2"},
+		MinVersion:   "1.2",
+		MaxVersion:   "1.3",
+		CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		PublicKeyPins: []string{
+			"sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+			"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+	}
+	c := cs.TLSConfig{}
+	require.NoError(t, opt.Apply(&c))
+	assert.Equal(t, "jocasta.test", c.ServerName)
+	assert.Equal(t, []string{"h2"}, c.NextProtos)
+	assert.Equal(t, uint16(tls.VersionTLS12), c.MinVersion)
+	assert.Equal(t, uint16(tls.VersionTLS13), c.MaxVersion)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, c.CipherSuites)
+	require.Len(t, c.PublicKeyPins, 2)
+	assert.Equal(t, c.PublicKeyPins[0], c.PublicKeyPins[1])
+
+	for _, opt := range []TLSOption{
+		{MinVersion: "1.4"},
+		{MaxVersion: "invalid"},
+		{MinVersion: "1.3", MaxVersion: "1.2"},
+		{CipherSuites: []string{"invalid"}},
+		{PublicKeyPins: []string{"invalid"}},
+	} {
+		require.Error(t, opt.Apply(&cs.TLSConfig{}))
+	}
+}
+
+func TestKeyCipher(t *testing.T) {
+	for _, method := range append(KeyMethods(), "") {
+		kc, err := NewKeyCipher(method, "password")
+		require.NoError(t, err)
+
+		client, server := net.Pipe()
+		cc, sc := kc.Adorn(client, false), kc.Adorn(server, true)
+		go func() {
+			cc.Write([]byte("hello")) // nolint: errcheck
+		}()
+		b := make([]byte, 5)
+		_, err = io.ReadFull(sc, b)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		cc.Close() // nolint: errcheck
+		sc.Close() // nolint: errcheck
+	}
+	_, err := NewKeyCipher("invalid", "password")
+	require.Error(t, err)
+}
@@ -2,27 +2,63 @@ package ccs
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/things-go/x/extstr"
+
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/connection/cobfs"
+	"github.com/thinkgos/jocasta/connection/proxyproto"
 	"github.com/thinkgos/jocasta/cs"
+	"github.com/thinkgos/jocasta/pkg/enet"
 	"github.com/thinkgos/jocasta/pkg/gopool"
 )
 
 // Config config
 type Config struct {
-	// 仅tls有效
+	// 仅tls,quic,wss有效
 	TLSConfig cs.TLSConfig
 	// 仅stcp有效
 	StcpConfig cs.StcpConfig
 	// 仅KCP有效
 	KcpConfig cs.KcpConfig
-	// 不为空,使用相应代理, 支持tcp, tls, stcp
+	// 仅quic有效, quic同时使用TLSConfig
+	QuicConfig cs.QuicConfig
+	// 仅ws,wss有效
+	WsConfig cs.WsConfig
+	// 仅监听unix domain socket有效
+	UnixConfig cs.UnixConfig
+	// 连接混淆, 伪装为http或tls握手, 与simple-obfs兼容, 仅tcp,stcp有效
+	ObfsConfig cs.ObfsConfig
+	// 监听时解析 PROXY protocol v1/v2 头, 连接的RemoteAddr为原始客户端地址, 仅tcp,tls,stcp,ws,wss有效
+	ProxyProtocol bool //only server used
+	// 信任的上游网段(CIDR或IP), 不为空时只接受来自这些网段且带PROXY protocol头的连接, 为空时任何客户端均可伪造源地址
+	ProxyProtocolTrusted []string //only server used
+	// 使用SO_REUSEPORT在同一地址上打开的socket数, 每个socket有独立的Accept协程, <= 1 表示不使用, 仅linux下tcp,tls,stcp,ws,wss有效
+	ReusePort int //only server used
+	// 监听开启TCP Fast Open, 仅linux下tcp,tls,stcp,ws,wss有效
+	FastOpen bool //only server used
+	// 不为空,使用相应代理, 支持tcp, tls, stcp, ws, wss
 	ProxyURL *url.URL //only client used
+	// 不为空,按顺序经由代理链连接, 与ProxyURL同时设置时ProxyURL为第一跳, 支持tcp, tls, stcp, ws, wss
+	ProxyURLs []*url.URL //only client used
+	// 连接域名地址时使用的解析, 仅tcp,tls,stcp且不经由代理链时有效, default: net.DefaultResolver
+	Resolver connection.Resolver //only client used
+}
+
+// proxyURLs 代理链的每一跳, ProxyURL为第一跳
+func (sf Config) proxyURLs() []*url.URL {
+	if sf.ProxyURL == nil {
+		return sf.ProxyURLs
+	}
+	return append([]*url.URL{sf.ProxyURL}, sf.ProxyURLs...)
 }
 
 // Dialer Client dialer
@@ -43,22 +79,25 @@ func (sf *Dialer) DialContext(ctx context.Context, network, addr string) (net.Co
 	var d connection.ContextDialer
 	var forward connection.Dialer
 
-	if sf.ProxyURL != nil {
-		switch sf.ProxyURL.Scheme {
-		case "socks5":
-			forward = cs.Socks5{
-				ProxyHost: sf.ProxyURL.Host,
-				Timeout:   sf.Timeout,
-				Auth:      cs.ProxyAuth(sf.ProxyURL),
-			}
-		case "https":
-			forward = cs.HTTPS{
-				ProxyHost: sf.ProxyURL.Host,
-				Timeout:   sf.Timeout,
-				Auth:      cs.ProxyAuth(sf.ProxyURL),
-			}
-		default:
-			return nil, fmt.Errorf("unkown scheme of %s", sf.ProxyURL.String())
+	if path, ok := enet.UnixPath(addr); ok {
+		if !extstr.Contains([]string{"tcp", "tls", "stcp", "ws", "wss"}, sf.Protocol) {
+			return nil, fmt.Errorf("protocol %s not support unix socket", sf.Protocol)
+		}
+		if sf.ProxyURL != nil || len(sf.ProxyURLs) > 0 {
+			return nil, errors.New("proxy chain not support unix socket")
+		}
+		network, addr = "unix", path
+	}
+
+	if err := sf.validObfs(sf.Protocol); err != nil {
+		return nil, err
+	}
+
+	if proxyURLs := sf.proxyURLs(); len(proxyURLs) > 0 {
+		var err error
+
+		if forward, err = cs.NewProxyChain(proxyURLs, sf.Timeout); err != nil {
+			return nil, err
 		}
 	}
 
@@ -66,8 +105,9 @@ func (sf *Dialer) DialContext(ctx context.Context, network, addr string) (net.Co
 	case "tcp":
 		d = &connection.Client{
 			Timeout:     sf.Timeout,
-			AdornChains: sf.AdornChains,
+			AdornChains: sf.obfsClientChains(sf.AdornChains...),
 			Forward:     forward,
+			Resolver:    sf.Resolver,
 		}
 	case "tls":
 		tlsConfig, err := sf.TLSConfig.ClientConfig()
@@ -78,6 +118,7 @@ func (sf *Dialer) DialContext(ctx context.Context, network, addr string) (net.Co
 			Timeout:     sf.Timeout,
 			AdornChains: append([]connection.AdornConn{connection.BaseAdornTLSClient(tlsConfig)}, sf.AdornChains...),
 			Forward:     forward,
+			Resolver:    sf.Resolver,
 		}
 	case "stcp":
 		if ok := sf.StcpConfig.Valid(); !ok {
@@ -85,16 +126,52 @@ func (sf *Dialer) DialContext(ctx context.Context, network, addr string) (net.Co
 		}
 		d = &connection.Client{
 			Timeout:     sf.Timeout,
-			AdornChains: append([]connection.AdornConn{connection.BaseAdornStcp(sf.StcpConfig.Method, sf.StcpConfig.Password)}, sf.AdornChains...),
+			AdornChains: sf.obfsClientChains(append([]connection.AdornConn{adornStcp(sf.StcpConfig)}, sf.AdornChains...)...),
 			Forward:     forward,
+			Resolver:    sf.Resolver,
 		}
 	case "kcp":
 		d = &cs.KCPClient{
 			Config:      sf.KcpConfig,
 			AfterChains: sf.AdornChains,
 		}
+	case "quic":
+		tlsConfig, err := sf.TLSConfig.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		if sf.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, sf.Timeout)
+			defer cancel()
+		}
+		d = &cs.QUICClient{
+			TLSConfig:   tlsConfig,
+			Config:      sf.QuicConfig,
+			SessionKey:  cs.QUICSessionKey(sf.TLSConfig, sf.QuicConfig),
+			AfterChains: sf.AdornChains,
+		}
+	case "ws":
+		d = &cs.WSClient{
+			Config:      sf.WsConfig,
+			Timeout:     sf.Timeout,
+			Forward:     forward,
+			AfterChains: sf.AdornChains,
+		}
+	case "wss":
+		tlsConfig, err := sf.TLSConfig.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		d = &cs.WSClient{
+			TLSConfig:   tlsConfig,
+			Config:      sf.WsConfig,
+			Timeout:     sf.Timeout,
+			Forward:     forward,
+			AfterChains: sf.AdornChains,
+		}
 	default:
-		return nil, fmt.Errorf("protocol support one of <tcp|tls|stcp|kcp> but give <%s>", sf.Protocol)
+		return nil, fmt.Errorf("protocol support one of <tcp|tls|stcp|kcp|quic|ws|wss> but give <%s>", sf.Protocol)
 	}
 	return d.DialContext(ctx, network, addr)
 }
@@ -107,36 +184,146 @@ type Server struct {
 	GoPool      gopool.Pool
 	AdornChains connection.AdornConnsChain
 	Handler     cs.Handler
+
+	proxyTrusted []*net.IPNet
 }
 
 // RunListenAndServe run listen and server no-block, return error chan indicate server is run sucess or failed
 func (sf *Server) Listen() (net.Listener, error) {
+	if sf.ProxyProtocol && len(sf.ProxyProtocolTrusted) > 0 {
+		trusted, err := proxyproto.ParseTrusted(sf.ProxyProtocolTrusted)
+		if err != nil {
+			return nil, err
+		}
+		sf.proxyTrusted = trusted
+	}
+	if path, ok := enet.UnixPath(sf.Addr); ok {
+		return sf.listenUnix(path)
+	}
+
+	if sf.ProxyProtocol && !extstr.Contains([]string{"tcp", "tls", "stcp", "ws", "wss"}, sf.Protocol) {
+		return nil, fmt.Errorf("protocol %s not support proxy protocol", sf.Protocol)
+	}
+	if err := sf.validObfs(sf.Protocol); err != nil {
+		return nil, err
+	}
+	if (sf.ReusePort > 1 || sf.FastOpen) && !extstr.Contains([]string{"tcp", "tls", "stcp", "ws", "wss"}, sf.Protocol) {
+		return nil, fmt.Errorf("protocol %s not support reuse port or tcp fast open", sf.Protocol)
+	}
+	lc := connection.ListenConfig{ReusePort: sf.ReusePort, FastOpen: sf.FastOpen}
+
 	switch sf.Protocol {
 	case "tcp":
-		return connection.Listen("tcp", sf.Addr, sf.AdornChains...)
+		return lc.Listen("tcp", sf.Addr, sf.baseChains(sf.AdornChains...)...)
 	case "tls":
 		tlsConfig, err := sf.TLSConfig.ServerConfig()
 		if err != nil {
 			return nil, err
 		}
-		return connection.Listen("tcp", sf.Addr, append([]connection.AdornConn{connection.BaseAdornTLSServer(tlsConfig)}, sf.AdornChains...)...)
+		return lc.Listen("tcp", sf.Addr, sf.baseChains(append([]connection.AdornConn{connection.BaseAdornTLSServer(tlsConfig)}, sf.AdornChains...)...)...)
 	case "stcp":
 		if ok := sf.StcpConfig.Valid(); !ok {
 			return nil, errors.New("invalid stcp config")
 		}
-		return connection.Listen("tcp", sf.Addr, append([]connection.AdornConn{connection.BaseAdornStcp(sf.StcpConfig.Method, sf.StcpConfig.Password)}, sf.AdornChains...)...)
+		return lc.Listen("tcp", sf.Addr, sf.baseChains(append([]connection.AdornConn{adornStcp(sf.StcpConfig)}, sf.AdornChains...)...)...)
 	case "kcp":
 		return cs.ListenKCP("", sf.Addr, sf.KcpConfig, sf.AdornChains...)
+	case "quic":
+		tlsConfig, err := sf.TLSConfig.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		return cs.ListenQUIC("", sf.Addr, tlsConfig, sf.QuicConfig, sf.AdornChains...)
+	case "ws", "wss":
+		var tlsConfig *tls.Config
+
+		if sf.Protocol == "wss" {
+			var err error
+
+			if tlsConfig, err = sf.TLSConfig.ServerConfig(); err != nil {
+				return nil, err
+			}
+		}
+		ln, err := lc.Listen("tcp", sf.Addr, sf.baseChains()...)
+		if err != nil {
+			return nil, err
+		}
+		return cs.NewWSListener(ln, tlsConfig, sf.WsConfig, sf.AdornChains...), nil
 	default:
 		return nil, fmt.Errorf("not support protocol: %s", sf.Protocol)
 	}
 }
 
+// baseChains 开启PROXY protocol时, 在chains之前先解析PROXY protocol头, 开启混淆时, 然后去除混淆
+func (sf *Server) baseChains(chains ...connection.AdornConn) []connection.AdornConn {
+	if sf.ObfsConfig.Mode != "" {
+		chains = append([]connection.AdornConn{connection.AdornObfsServer(sf.ObfsConfig.Mode)}, chains...)
+	}
+	if sf.ProxyProtocol {
+		return append([]connection.AdornConn{connection.AdornProxyProtocol(proxyproto.WithTrusted(sf.proxyTrusted))}, chains...)
+	}
+	return chains
+}
+
+// listenUnix listen on unix domain socket, 仅支持基于流的协议
+func (sf *Server) listenUnix(path string) (net.Listener, error) {
+	if sf.ReusePort > 1 || sf.FastOpen {
+		return nil, errors.New("unix socket not support reuse port or tcp fast open")
+	}
+	switch sf.Protocol {
+	case "tcp":
+		return cs.ListenUnix(path, sf.UnixConfig, sf.baseChains(sf.AdornChains...)...)
+	case "tls":
+		tlsConfig, err := sf.TLSConfig.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		return cs.ListenUnix(path, sf.UnixConfig, sf.baseChains(append([]connection.AdornConn{connection.BaseAdornTLSServer(tlsConfig)}, sf.AdornChains...)...)...)
+	case "stcp":
+		if ok := sf.StcpConfig.Valid(); !ok {
+			return nil, errors.New("invalid stcp config")
+		}
+		return cs.ListenUnix(path, sf.UnixConfig, sf.baseChains(append([]connection.AdornConn{adornStcp(sf.StcpConfig)}, sf.AdornChains...)...)...)
+	case "ws", "wss":
+		var tlsConfig *tls.Config
+
+		if sf.Protocol == "wss" {
+			var err error
+
+			if tlsConfig, err = sf.TLSConfig.ServerConfig(); err != nil {
+				return nil, err
+			}
+		}
+		ln, err := cs.ListenUnix(path, sf.UnixConfig, sf.baseChains()...)
+		if err != nil {
+			return nil, err
+		}
+		return cs.NewWSListener(ln, tlsConfig, sf.WsConfig, sf.AdornChains...), nil
+	default:
+		return nil, fmt.Errorf("protocol %s not support unix socket", sf.Protocol)
+	}
+}
+
+// Server 在ln上服务, 使用SO_REUSEPORT时每个socket有独立的Accept协程, 任一socket出错时关闭ln并返回
 func (sf *Server) Server(ln net.Listener) {
 	defer ln.Close()
 	if sf.Handler == nil {
 		sf.Handler = new(cs.NopHandler)
 	}
+	listeners := connection.Listeners(ln)
+	var wg sync.WaitGroup
+	wg.Add(len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			defer wg.Done()
+			defer ln.Close() // nolint: errcheck
+			sf.serve(l)
+		}(l)
+	}
+	wg.Wait()
+}
+
+func (sf *Server) serve(ln net.Listener) {
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
@@ -145,3 +332,38 @@ func (sf *Server) Server(ln net.Listener) {
 		gopool.Go(sf.GoPool, func() { sf.Handler.ServerConn(conn) })
 	}
 }
+
+// validObfs 混淆仅支持tcp,stcp
+func (sf *Config) validObfs(protocol string) error {
+	return ValidObfs(protocol, sf.ObfsConfig.Mode)
+}
+
+// ValidObfs 校验混淆方式, mode为空表示不混淆, 混淆仅支持tcp,stcp
+func ValidObfs(protocol, mode string) error {
+	if mode == "" {
+		return nil
+	}
+	if !cobfs.HasMode(mode) {
+		return fmt.Errorf("obfs mode should be oneof <%s> but give <%s>", strings.Join(cobfs.Modes(), "|"), mode)
+	}
+	if protocol != "tcp" && protocol != "stcp" {
+		return fmt.Errorf("protocol %s not support obfs", protocol)
+	}
+	return nil
+}
+
+// obfsClientChains 开启混淆时, 在chains之前先进行混淆
+func (sf *Dialer) obfsClientChains(chains ...connection.AdornConn) []connection.AdornConn {
+	if sf.ObfsConfig.Mode != "" {
+		return append([]connection.AdornConn{connection.AdornObfsClient(sf.ObfsConfig.Mode, sf.ObfsConfig.Host)}, chains...)
+	}
+	return chains
+}
+
+// adornStcp stcp 加密
+func adornStcp(c cs.StcpConfig) connection.AdornConn {
+	if c.ForwardSecret {
+		return connection.BaseAdornStcpForwardSecret(c.Password)
+	}
+	return connection.BaseAdornStcp(c.Method, c.Password)
+}
@@ -2,6 +2,7 @@ package enet
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"strings"
 	"time"
@@ -33,6 +34,32 @@ func WrapTimeout(conn net.Conn, timeout time.Duration, f func(c net.Conn) error)
 	return err
 }
 
+// ErrCloseWriteNotSupported 连接不支持半关闭
+var ErrCloseWriteNotSupported = errors.New("enet: close write not supported")
+
+// CloseWriter 支持半关闭写方向的连接, 如*net.TCPConn, *net.UnixConn, *tls.Conn
+type CloseWriter interface {
+	CloseWrite() error
+}
+
+// CloseWrite 半关闭v的写方向, 对端读取将返回io.EOF, 本端仍可读取.
+// v未实现 CloseWriter 时返回 ErrCloseWriteNotSupported
+func CloseWrite(v interface{}) error {
+	if c, ok := v.(CloseWriter); ok {
+		return c.CloseWrite()
+	}
+	return ErrCloseWriteNotSupported
+}
+
+// Splicer 不改变数据内容的连接封装(如流量统计, 限速).
+// 转发时可绕过封装直接在底层连接间零拷贝复制, 每复制一段数据后调用
+// SpliceRead(从本连接读出n字节) 或 SpliceWrite(向本连接写入n字节) 以完成统计或限速
+type Splicer interface {
+	SpliceConn() net.Conn
+	SpliceRead(n int) error
+	SpliceWrite(n int) error
+}
+
 var httpMethod = []string{
 	"GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH",
 	"get", "head", "post", "put", "delete", "connect", "options", "trace", "patch",
@@ -1,9 +1,12 @@
 package enet
 
 import (
+	"io"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestIsHTTP(t *testing.T) {
@@ -20,3 +23,42 @@ func BenchmarkIsHTTP(b *testing.B) {
 		IsHTTP(v)
 	}
 }
+
+func TestUnixPath(t *testing.T) {
+	path, ok := UnixPath("unix:///run/jocasta.sock")
+	assert.True(t, ok)
+	assert.Equal(t, "/run/jocasta.sock", path)
+
+	path, ok = UnixPath("unix://@jocasta")
+	assert.True(t, ok)
+	assert.Equal(t, "@jocasta", path)
+
+	assert.False(t, IsUnixAddr("unix://"))
+	assert.False(t, IsUnixAddr("127.0.0.1:8080"))
+}
+
+func TestCloseWrite(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close() // nolint: errcheck
+	defer c2.Close() // nolint: errcheck
+	assert.ErrorIs(t, CloseWrite(c1), ErrCloseWriteNotSupported)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close() // nolint: errcheck
+	c, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer c.Close() // nolint: errcheck
+	s, err := ln.Accept()
+	require.NoError(t, err)
+	defer s.Close() // nolint: errcheck
+
+	require.NoError(t, CloseWrite(c))
+	_, err = s.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	// 半关闭后仍可读取
+	_, err = s.Write([]byte("a"))
+	require.NoError(t, err)
+	_, err = c.Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,23 @@
+package enet
+
+import "strings"
+
+// UnixScheme unix domain socket 地址前缀,
+// 如 unix:///run/jocasta.sock 文件路径为 /run/jocasta.sock,
+// unix://@jocasta 为抽象命名空间 @jocasta, 仅linux支持
+const UnixScheme = "unix://"
+
+// UnixPath 如果addr为unix domain socket地址,返回路径和true
+func UnixPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UnixScheme) {
+		return "", false
+	}
+	path := addr[len(UnixScheme):]
+	return path, path != ""
+}
+
+// IsUnixAddr 是否为unix domain socket地址
+func IsUnixAddr(addr string) bool {
+	_, ok := UnixPath(addr)
+	return ok
+}
@@ -0,0 +1,203 @@
+// Package muxer 多路复用会话, 支持smux和yamux
+package muxer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/xtaci/smux"
+
+	"github.com/thinkgos/jocasta/connection/chalf"
+)
+
+// 支持的多路复用协议
+const (
+	Smux  = "smux"
+	Yamux = "yamux"
+)
+
+// Muxers 支持的多路复用协议
+func Muxers() []string { return []string{Smux, Yamux} }
+
+// Config 多路复用配置, 0值使用对应协议的默认值
+type Config struct {
+	// 多路复用协议 smux|yamux, 由节点选择并与bridge协商, bridge使用节点的选择, default: smux
+	Muxer string
+	// 保活间隔, default: smux 10s, yamux 30s
+	KeepAliveInterval time.Duration
+	// 保活超时, smux为超过此时间未收到对端数据关闭会话, yamux为ping及写超时, default: smux 30s, yamux 10s
+	KeepAliveTimeout time.Duration
+	// 会话接收窗口, 所有流共享, 单位字节, smux有效, default: 4M
+	MaxReceiveBuffer int
+	// 流接收窗口, 单位字节, yamux有效, 不小于256k, default: 256k
+	MaxStreamBuffer int
+	// 最大帧长度, 单位字节, smux有效, 不大于65535, default: 32k
+	MaxFrameSize int
+}
+
+// Verify 检查配置
+func (sf Config) Verify() error {
+	switch sf.Muxer {
+	case "", Smux:
+		return smux.VerifyConfig(sf.smuxConfig())
+	case Yamux:
+		return yamux.VerifyConfig(sf.yamuxConfig())
+	default:
+		return fmt.Errorf("muxer support one of %v", Muxers())
+	}
+}
+
+// smuxConfig 转换成smux.Config, 使用协议版本1以兼容旧版本节点
+func (sf Config) smuxConfig() *smux.Config {
+	c := smux.DefaultConfig()
+	if sf.KeepAliveInterval > 0 {
+		c.KeepAliveInterval = sf.KeepAliveInterval
+	}
+	if sf.KeepAliveTimeout > 0 {
+		c.KeepAliveTimeout = sf.KeepAliveTimeout
+	}
+	if sf.MaxReceiveBuffer > 0 {
+		c.MaxReceiveBuffer = sf.MaxReceiveBuffer
+	}
+	if sf.MaxFrameSize > 0 {
+		c.MaxFrameSize = sf.MaxFrameSize
+	}
+	return c
+}
+
+// yamuxConfig 转换成yamux.Config
+func (sf Config) yamuxConfig() *yamux.Config {
+	c := yamux.DefaultConfig()
+	c.LogOutput = io.Discard
+	if sf.KeepAliveInterval > 0 {
+		c.KeepAliveInterval = sf.KeepAliveInterval
+	}
+	if sf.KeepAliveTimeout > 0 {
+		c.ConnectionWriteTimeout = sf.KeepAliveTimeout
+	}
+	if sf.MaxStreamBuffer > 0 {
+		c.MaxStreamWindowSize = uint32(sf.MaxStreamBuffer)
+	}
+	return c
+}
+
+// Stream 多路复用流
+type Stream interface {
+	net.Conn
+	ID() uint32
+}
+
+// Session 多路复用会话
+type Session interface {
+	OpenStream() (Stream, error)
+	AcceptStream() (Stream, error)
+	IsClosed() bool
+	Close() error
+}
+
+// Server 创建会话的服务端
+// halfClose: smux流是否使用 chalf.Conn 封装以支持半关闭, 需与对端一致. yamux流原生支持半关闭, 忽略此参数
+func Server(conn net.Conn, cfg Config, halfClose bool) (Session, error) {
+	return newSession(conn, cfg, halfClose, false)
+}
+
+// Client 创建会话的客户端, 见 Server
+func Client(conn net.Conn, cfg Config, halfClose bool) (Session, error) {
+	return newSession(conn, cfg, halfClose, true)
+}
+
+func newSession(conn net.Conn, cfg Config, halfClose, client bool) (Session, error) {
+	switch cfg.Muxer {
+	case "", Smux:
+		newFn := smux.Server
+		if client {
+			newFn = smux.Client
+		}
+		sess, err := newFn(conn, cfg.smuxConfig())
+		if err != nil {
+			return nil, err
+		}
+		return &smuxSession{sess, halfClose}, nil
+	case Yamux:
+		newFn := yamux.Server
+		if client {
+			newFn = yamux.Client
+		}
+		sess, err := newFn(conn, cfg.yamuxConfig())
+		if err != nil {
+			return nil, err
+		}
+		return yamuxSession{sess}, nil
+	default:
+		return nil, fmt.Errorf("muxer %s not supported", cfg.Muxer)
+	}
+}
+
+type smuxSession struct {
+	*smux.Session
+	halfClose bool
+}
+
+func (sf *smuxSession) OpenStream() (Stream, error) {
+	stream, err := sf.Session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return sf.wrap(stream), nil
+}
+
+func (sf *smuxSession) AcceptStream() (Stream, error) {
+	stream, err := sf.Session.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return sf.wrap(stream), nil
+}
+
+func (sf *smuxSession) wrap(stream *smux.Stream) Stream {
+	if sf.halfClose {
+		return &halfStream{chalf.New(stream), stream.ID()}
+	}
+	return stream
+}
+
+// halfStream 支持半关闭的smux流
+type halfStream struct {
+	*chalf.Conn
+	id uint32
+}
+
+func (sf *halfStream) ID() uint32 { return sf.id }
+
+type yamuxSession struct {
+	*yamux.Session
+}
+
+func (sf yamuxSession) OpenStream() (Stream, error) {
+	stream, err := sf.Session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return yamuxStream{stream}, nil
+}
+
+func (sf yamuxSession) AcceptStream() (Stream, error) {
+	stream, err := sf.Session.AcceptStream()
+	if err != nil {
+		return nil, err
+	}
+	return yamuxStream{stream}, nil
+}
+
+// yamuxStream yamux流, Close即为半关闭, 对端也关闭后释放
+type yamuxStream struct {
+	*yamux.Stream
+}
+
+func (sf yamuxStream) ID() uint32 { return sf.StreamID() }
+
+// CloseWrite 半关闭写方向
+func (sf yamuxStream) CloseWrite() error { return sf.Stream.Close() }
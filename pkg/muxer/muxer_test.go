@@ -0,0 +1,76 @@
+package muxer
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/thinkgos/jocasta/pkg/enet"
+)
+
+func TestConfigVerify(t *testing.T) {
+	assert.NoError(t, Config{}.Verify())
+	assert.NoError(t, Config{Muxer: Smux, MaxReceiveBuffer: 16 << 20}.Verify())
+	assert.NoError(t, Config{Muxer: Yamux, MaxStreamBuffer: 16 << 20}.Verify())
+
+	assert.Error(t, Config{Muxer: "xxx"}.Verify())
+	assert.Error(t, Config{MaxFrameSize: 1 << 20}.Verify())
+	assert.Error(t, Config{Muxer: Yamux, MaxStreamBuffer: 1024}.Verify())
+}
+
+func TestSession(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		cfg       Config
+		halfClose bool
+	}{
+		{"smux", Config{Muxer: Smux}, false},
+		{"smux half close", Config{Muxer: Smux}, true},
+		{"yamux", Config{Muxer: Yamux, MaxStreamBuffer: 1 << 20}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c1, c2 := net.Pipe()
+			client, err := Client(c1, tt.cfg, tt.halfClose)
+			require.NoError(t, err)
+			defer client.Close() // nolint: errcheck
+			server, err := Server(c2, tt.cfg, tt.halfClose)
+			require.NoError(t, err)
+			defer server.Close() // nolint: errcheck
+
+			// server读取完整请求后再响应
+			go func() {
+				stream, err := server.AcceptStream()
+				if err != nil {
+					return
+				}
+				defer stream.Close() // nolint: errcheck
+				req, err := io.ReadAll(stream)
+				if err != nil {
+					return
+				}
+				stream.Write(append([]byte("echo "), req...)) // nolint: errcheck
+				enet.CloseWrite(stream)                       // nolint: errcheck
+			}()
+
+			stream, err := client.OpenStream()
+			require.NoError(t, err)
+			defer stream.Close() // nolint: errcheck
+
+			_, err = stream.Write([]byte("hello"))
+			require.NoError(t, err)
+			if err = enet.CloseWrite(stream); !tt.halfClose && tt.cfg.Muxer == Smux {
+				// smux流不支持半关闭
+				assert.ErrorIs(t, err, enet.ErrCloseWriteNotSupported)
+				return
+			}
+			require.NoError(t, err)
+
+			resp, err := io.ReadAll(stream)
+			require.NoError(t, err)
+			assert.Equal(t, "echo hello", string(resp))
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/panjf2000/ants/v2"
 
 	"github.com/thinkgos/jocasta/core/binding"
+	"github.com/thinkgos/jocasta/core/ratelimit"
+	"github.com/thinkgos/jocasta/core/tracker"
 )
 
 // BindingSize binding buffer size
@@ -13,6 +15,12 @@ const BindingSize = 4096
 // Binding binding
 var Binding = binding.New(BindingSize, binding.WithGPool(GoPool))
 
+// Tracker 活动代理会话登记表
+var Tracker = tracker.New()
+
+// GlobalLimiter 所有服务共享的全局限速器, 默认不限速
+var GlobalLimiter = ratelimit.NewLimiter(0)
+
 // Validate validator
 var Validate = validator.New()
 
@@ -12,7 +12,31 @@ import (
 )
 
 // Version 透传协议版本
-const Version = 1
+const Version = 3
+
+// VersionHalfClose 支持半关闭的最低版本, 节点与bridge协商的版本不低于此版本时, 流使用 chalf.Conn 封装
+const VersionHalfClose = 2
+
+// NegotiateVersion 协商的版本, 取本端与对端版本的较小值
+func NegotiateVersion(peer byte) byte {
+	if peer < Version {
+		return peer
+	}
+	return Version
+}
+
+// HalfClose 协商的版本是否支持半关闭
+func HalfClose(version byte) bool {
+	return version >= VersionHalfClose
+}
+
+// VersionMuxer 支持选择多路复用协议的最低版本, 低于此版本的bridge忽略节点选择的协议, 使用smux
+const VersionMuxer = 3
+
+// SupportMuxer 协商的版本是否支持选择多路复用协议
+func SupportMuxer(version byte) bool {
+	return version >= VersionMuxer
+}
 
 // Types 透传节点类型
 type Types byte
@@ -31,6 +55,7 @@ const (
 	RepNetworkUnreachable        // 网络不可达
 	RepTypesNotSupport           // 节点类型不支持
 	RepConnectionRefused         // 连接拒绝
+	RepMuxerNotSupport           // 多路复用协议不支持
 )
 
 // NegotiateRequest negotiate request
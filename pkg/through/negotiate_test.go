@@ -17,6 +17,7 @@ func TestNegotiateRequest(t *testing.T) {
 		ddt.NegotiateRequest{
 			SecretKey: "SecretKey",
 			Id:        "Id",
+			Muxer:     "yamux",
 		},
 	}
 
@@ -30,4 +31,5 @@ func TestNegotiateRequest(t *testing.T) {
 	assert.Equal(t, nego.Version, want.Version)
 	assert.Equal(t, nego.Nego.SecretKey, want.Nego.SecretKey)
 	assert.Equal(t, nego.Nego.Id, want.Nego.Id)
+	assert.Equal(t, nego.Nego.Muxer, want.Nego.Muxer)
 }
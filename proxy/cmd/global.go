@@ -2,16 +2,25 @@ package cmd
 
 import (
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/things-go/encrypt"
 
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
+	"github.com/thinkgos/jocasta/pkg/muxer"
 )
 
 var kcpCfg ccs.SKCPConfig
 var stcpCfg cs.StcpConfig
+var quicCfg cs.QuicConfig
+var wsCfg cs.WsConfig
+var wsHeaders []string
+var unixCfg cs.UnixConfig
+var unixMode string
+var tlsOpt ccs.TLSOption
+var muxCfg muxer.Config
 
 func global(cmd *cobra.Command) {
 	persistent := cmd.PersistentFlags()
@@ -31,10 +40,42 @@ func global(cmd *cobra.Command) {
 	persistent.IntVar(&kcpCfg.Resend, "kcp-resend", 2, "be carefully!")
 	persistent.IntVar(&kcpCfg.NoCongestion, "kcp-nc", 1, "be carefully! no congestion")
 	persistent.IntVar(&kcpCfg.SockBuf, "kcp-sockbuf", 4194304, "be carefully!")
-	persistent.IntVar(&kcpCfg.KeepAlive, "kcp-keepalive", 10, "be carefully!")
+	persistent.IntVar(&kcpCfg.KeepAlive, "kcp-keepalive", 0, "kcp keepalive interval seconds, 0 means disable, enabling keepalive or idle timeout adds framing to the kcp stream, both ends must be the same, be carefully!")
+	persistent.IntVar(&kcpCfg.IdleTimeout, "kcp-idle-timeout", 0, "kcp idle timeout seconds, close the connection when nothing is received from peer, 0 means 3*kcp-keepalive, or disable when kcp-keepalive is 0")
 
 	// stcp config
 	persistent.StringVar(&stcpCfg.Method, "stcp-method", "aes-192-cfb", "method of local stcp's encrpyt/decrypt, these below are supported :\n"+strings.Join(encrypt.CipherMethods(), ","))
 	persistent.StringVar(&stcpCfg.Password, "stcp-password", "thinkgos's_jocasta", "password of local stcp's encrpyt/decrypt")
+	persistent.BoolVar(&stcpCfg.ForwardSecret, "stcp-forward-secret", false, "stcp use X25519 key exchange authenticated by stcp-password, every connection has ephemeral session keys, stcp-method is ignored")
 
+	// quic config
+	persistent.DurationVar(&quicCfg.HandshakeTimeout, "quic-handshake-timeout", 5*time.Second, "quic handshake timeout")
+	persistent.DurationVar(&quicCfg.MaxIdleTimeout, "quic-idle-timeout", 30*time.Second, "quic connection closed when no network activity in this duration")
+	persistent.DurationVar(&quicCfg.KeepAlivePeriod, "quic-keepalive", 10*time.Second, "quic keep-alive packet period, 0 means disable")
+	persistent.Int64Var(&quicCfg.MaxIncomingStreams, "quic-max-streams", 100, "maximum number of concurrent streams that peer is allowed to open")
+
+	// websocket config
+	persistent.StringVar(&wsCfg.Path, "ws-path", "/", "websocket request path")
+	persistent.StringVar(&wsCfg.Host, "ws-host", "", "websocket request Host header, also used as tls server name of wss, default use the dial address")
+	persistent.StringArrayVar(&wsHeaders, "ws-header", nil, "websocket request extra header, format \"key: value\", can be specified multiple times")
+
+	// tls config
+	persistent.StringVar(&tlsOpt.ServerName, "tls-server-name", "", "tls server name expected of the parent, also sent as SNI, default only verify the certificate chain and send the CommonName of ca as SNI in mutual mode")
+	persistent.StringSliceVar(&tlsOpt.NextProtos, "tls-alpn", nil, "tls ALPN protocols, such as: h2,http/1.1")
+	persistent.StringVar(&tlsOpt.MinVersion, "tls-min-version", "", "tls minimum version <1.0|1.1|1.2|1.3>, default use go's default")
+	persistent.StringVar(&tlsOpt.MaxVersion, "tls-max-version", "", "tls maximum version <1.0|1.1|1.2|1.3>, default use go's default")
+	persistent.StringSliceVar(&tlsOpt.PublicKeyPins, "tls-pin", nil, "pin the public key of parent, base64 or hex encoded sha256 of the certificate's SPKI, such as: sha256/base64string, without ca file only the pin is checked")
+	persistent.StringSliceVar(&tlsOpt.CipherSuites, "tls-ciphers", nil, "tls cipher suites, only for tls1.2 and below, such as: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+
+	// mux config
+	persistent.StringVar(&muxCfg.Muxer, "mux", muxer.Smux, "multiplexer of mux server and client <"+strings.Join(muxer.Muxers(), "|")+">, negotiated with bridge, bridge follows the node's choice")
+	persistent.DurationVar(&muxCfg.KeepAliveInterval, "mux-keepalive", 0, "mux keepalive interval, 0 means the muxer's default, smux 10s, yamux 30s")
+	persistent.DurationVar(&muxCfg.KeepAliveTimeout, "mux-keepalive-timeout", 0, "mux session closed when nothing received in this duration(smux) or ping timeout(yamux), 0 means the muxer's default, smux 30s, yamux 10s")
+	persistent.IntVar(&muxCfg.MaxReceiveBuffer, "mux-receive-window", 0, "smux session receive window in bytes shared by all streams, 0 means default 4M, high-BDP links may need 4M-16M")
+	persistent.IntVar(&muxCfg.MaxStreamBuffer, "mux-stream-window", 0, "yamux stream receive window in bytes, at least 256K, 0 means default 256K, high-BDP links may need 4M-16M")
+	persistent.IntVar(&muxCfg.MaxFrameSize, "mux-frame-size", 0, "smux max frame size in bytes, at most 65535, 0 means default 32K")
+
+	// unix domain socket config
+	persistent.StringVar(&unixMode, "unix-mode", "", "file mode of unix socket listened, octal format such as 0660, default not changed")
+	persistent.StringVar(&unixCfg.Owner, "unix-owner", "", "owner of unix socket listened, format user[:group], name or id, default not changed")
 }
@@ -6,6 +6,9 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	"github.com/thinkgos/jocasta/cs"
+	"github.com/thinkgos/jocasta/pkg/cert"
+	"github.com/thinkgos/jocasta/pkg/extcert"
 	"github.com/thinkgos/jocasta/services/keygen"
 )
 
@@ -22,6 +25,13 @@ var keygenCmd = &cobra.Command{
 		if err != nil {
 			log.Fatalf("run service [%s],%s", cmd.Name(), err)
 		}
+		prefix := keygenCfg.CaFilePrefix
+		if keygenCfg.Sign {
+			prefix = keygenCfg.CertFilePrefix
+		}
+		if crt, err := extcert.LoadCrtFile(prefix + cert.CertFileSuffix); err == nil {
+			zap.S().Infof("public key pin: sha256/%s", cs.PublicKeyPin(crt))
+		}
 		zap.S().Infof("success")
 	},
 }
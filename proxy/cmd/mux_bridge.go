@@ -7,6 +7,7 @@ import (
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/services/mux"
 )
 
@@ -19,6 +20,11 @@ var muxBridgeCmd = &cobra.Command{
 		if forever {
 			return
 		}
+		muxBridge.QuicConfig = quicCfg
+		muxBridge.MuxConfig = muxCfg
+		muxBridge.TLSOption = tlsOpt
+		muxBridge.STCPConfig = stcpCfg
+		muxBridge.WsConfig = wsCfg
 		muxBridge.SKCPConfig = kcpCfg
 
 		srv := mux.NewBridge(muxBridge, mux.WithBridgeLogger(zap.S()))
@@ -33,19 +39,20 @@ var muxBridgeCmd = &cobra.Command{
 func init() {
 	flags := muxBridgeCmd.Flags()
 
-	flags.StringVarP(&muxBridge.LocalType, "local-type", "t", "tcp", "local protocol type <tcp|tls|stcp|kcp>")
+	flags.StringVarP(&muxBridge.LocalType, "local-type", "t", "tcp", "local protocol type <tcp|tls|stcp|kcp|quic|ws|wss>")
 	flags.StringVarP(&muxBridge.Local, "local", "p", ":22800", "local ip:port to listen")
 	flags.BoolVar(&muxBridge.Compress, "compress", false, "compress data when <tcp|tls|stcp|kcp> mode")
 	// tls
-	flags.StringVar(&tcpCfg.CaCertFile, "ca", "proxy.crt", "ca cert file for tls")
-	flags.StringVarP(&muxBridge.CertFile, "cert", "C", "proxy.crt", "cert file for tls")
-	flags.StringVarP(&muxBridge.KeyFile, "key", "K", "proxy.key", "key file for tls")
-	// stcp
-	muxBridge.STCPConfig = stcpCfg
+	flags.StringVar(&tcpCfg.CaCertFile, "ca", "proxy.crt", "ca cert file for tls/quic/wss")
+	flags.StringVarP(&muxBridge.CertFile, "cert", "C", "proxy.crt", "cert file for tls/quic/wss")
+	flags.StringVarP(&muxBridge.KeyFile, "key", "K", "proxy.key", "key file for tls/quic/wss")
 	// kcp
 	muxBridge.SKCPConfig = kcpCfg
 	// 其它
 	flags.DurationVarP(&muxBridge.Timeout, "timeout", "e", 2*time.Second, "tcp timeout duration when connect to real server or parent proxy")
+	flags.DurationVar(&muxBridge.IdleTimeout, "idle-timeout", 0, "close both sides when no data transfer in either direction for this duration, 0 means no limit")
+	flags.DurationVar(&muxBridge.MaxLifetime, "max-lifetime", 0, "max session lifetime, close both sides when exceeded, 0 means no limit")
+	flags.DurationVar(&muxBridge.HalfCloseTimeout, "half-close-timeout", binding.DefaultHalfCloseTimeout, "close both sides when the other direction is not finished in this duration after one direction half-closed")
 
 	rootCmd.AddCommand(muxBridgeCmd)
 }
@@ -16,12 +16,19 @@ package cmd
 
 import (
 	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime/debug"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -29,9 +36,11 @@ import (
 	"github.com/things-go/x/extstr"
 	"go.uber.org/zap"
 
+	"github.com/thinkgos/jocasta/core/ratelimit"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/izap"
+	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/services"
 )
 
@@ -43,6 +52,12 @@ var (
 	daemon   bool
 	forever  bool
 	logfile  string
+	// 运维接口监听地址
+	flowsAddr string
+	// 运维接口认证token
+	apiToken string
+	// 全局限速
+	globalRateLimit string
 
 	cpuProfilingFile,
 	memProfilingFile,
@@ -81,6 +96,9 @@ func init() {
 	rootCmd.PersistentFlags().BoolVar(&daemon, "daemon", false, "run in background")
 	rootCmd.PersistentFlags().BoolVar(&forever, "forever", false, "run in forever, fail and retry")
 	rootCmd.PersistentFlags().StringVar(&logfile, "log", "", "log file path")
+	rootCmd.PersistentFlags().StringVar(&flowsAddr, "flows-addr", "", "http address of operation api, empty means disable, listen on loopback when host is empty, such as :8080. GET /flows list active flows, DELETE /flows close flows, filter by query id,service,user,source,target,parent,min_rate. GET /ratelimit get rate limits, PUT /ratelimit set rate limits by query global,service,user,conn")
+	rootCmd.PersistentFlags().StringVar(&apiToken, "api-token", "", "token of operation api, requests should carry header \"Authorization: Bearer <token>\", required when --flows-addr listen on non-loopback address")
+	rootCmd.PersistentFlags().StringVar(&globalRateLimit, "global-rate-limit", "0", "rate limit (bytes/second) shared by all connections of all services, such as: 100K 1.5M . 0 means no limitation")
 	global(rootCmd)
 	rootCmd.PersistentPreRun = preRun
 	rootCmd.PersistentPostRun = postRun
@@ -116,6 +134,50 @@ func preRun(cmd *cobra.Command, args []string) {
 	}
 	kcpCfg.Block, _ = cs.NewKcpBlockCryptWithPbkdf2(kcpCfg.Method, kcpCfg.Key, "thinkgos-goproxy")
 
+	// set unix socket config
+	if unixMode != "" {
+		mode, err := strconv.ParseUint(unixMode, 8, 32)
+		if err != nil {
+			zap.S().Fatalf("invalid unix socket mode %s, %v", unixMode, err)
+		}
+		unixCfg.Mode = os.FileMode(mode)
+	}
+
+	// set websocket config
+	wsCfg.Header = make(http.Header)
+	for _, h := range wsHeaders {
+		kv := strings.SplitN(h, ":", 2)
+		if len(kv) != 2 {
+			zap.S().Fatalf("invalid websocket header %s, format should be \"key: value\"", h)
+		}
+		wsCfg.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+
+	// 全局限速
+	limit, err := ratelimit.Parse(globalRateLimit)
+	if err != nil {
+		zap.S().Fatalf("invalid global rate limit %s, %v", globalRateLimit, err)
+	}
+	sword.GlobalLimiter.SetLimit(limit)
+
+	// 运维接口
+	if flowsAddr != "" {
+		apiAddr, err := operationAPIAddr(flowsAddr, apiToken)
+		if err != nil {
+			zap.S().Fatalf("invalid operation api address %s, %v", flowsAddr, err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/flows", sword.Tracker)
+		mux.HandleFunc("/ratelimit", serveRateLimit)
+		go sword.Tracker.Watch(context.Background(), time.Second)
+		go func() {
+			if err := http.ListenAndServe(apiAddr, withAPIToken(mux, apiToken)); err != nil {
+				zap.S().Errorf("operation api listen on %s, %v", apiAddr, err)
+			}
+		}()
+		zap.S().Infof("operation api listen on %s", apiAddr)
+	}
+
 	if hasDebug {
 		cpuProfilingFile, _ = os.Create("cpu.prof")
 		memProfilingFile, _ = os.Create("memory.prof")
@@ -218,6 +280,47 @@ func postRun(cmd *cobra.Command, args []string) {
 	}
 }
 
+// operationAPIAddr 运维接口监听地址, 未指定host时监听回环地址, 监听非回环地址时必须设置token
+func operationAPIAddr(addr, token string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if host == "" {
+		return net.JoinHostPort("127.0.0.1", port), nil
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) && token == "" {
+		return "", errors.New("listen on non-loopback address requires --api-token")
+	}
+	return addr, nil
+}
+
+// withAPIToken token不为空时校验请求头 Authorization: Bearer <token>
+func withAPIToken(h http.Handler, token string) http.Handler {
+	if token == "" {
+		return h
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serveRateLimit 当前服务的分层限速运维接口
+func serveRateLimit(w http.ResponseWriter, r *http.Request) {
+	if s, ok := server.(interface{ RateLimiter() *ratelimit.Hierarchy }); ok {
+		if h := s.RateLimiter(); h != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.Error(w, "rate limit not supported by this service", http.StatusNotFound)
+}
+
 func SaveProfiling() {
 	pprof.Lookup("goroutine").WriteTo(goroutineProfilingFile, 1)
 	pprof.Lookup("heap").WriteTo(memProfilingFile, 1)
@@ -1,12 +1,16 @@
 package cmd
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 
+	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	ssock "github.com/thinkgos/jocasta/services/socks"
 )
 
@@ -20,6 +24,11 @@ var socksCmd = &cobra.Command{
 			return
 		}
 		socksCfg.SKCPConfig = kcpCfg
+		socksCfg.QuicConfig = quicCfg
+		socksCfg.TLSOption = tlsOpt
+		socksCfg.STCPConfig = stcpCfg
+		socksCfg.UnixConfig = unixCfg
+		socksCfg.WsConfig = wsCfg
 		socksCfg.Debug = hasDebug
 
 		server = ssock.New(zap.S(), socksCfg)
@@ -34,22 +43,27 @@ func init() {
 	flags := socksCmd.Flags()
 
 	// parent
-	flags.StringVarP(&socksCfg.ParentType, "parent-type", "T", "", "parent protocol type <tcp|tls|stcp|kcp|ssh>")
-	flags.StringSliceVarP(&socksCfg.Parent, "parent", "P", nil, "parent address, such as: \"23.32.32.19:28008\"")
+	flags.StringVarP(&socksCfg.ParentType, "parent-type", "T", "", "parent protocol type <tcp|tls|stcp|kcp|quic|ws|wss|ssh>")
+	flags.StringSliceVarP(&socksCfg.Parent, "parent", "P", nil, "parent address, such as: \"23.32.32.19:28008\" or \"unix:///run/jocasta.sock\"")
 	flags.BoolVarP(&socksCfg.ParentCompress, "parent-compress", "M", false, "auto compress/decompress data on parent connection")
+	flags.StringVar(&socksCfg.ParentAdorns, "parent-adorns", "", fmt.Sprintf("adorn chain on parent connection after compress, e.g. zstd(level=3),flow, obfs and proxyproto are not allowed, adorn can be one of <%s>", strings.Join(connection.Adorns(), ", ")))
 	flags.StringVarP(&socksCfg.ParentKey, "parent-key", "Z", "", "the password for auto encrypt/decrypt parent connection data")
+	flags.StringVar(&socksCfg.ParentKeyMethod, "parent-key-method", "cfb", "the encrypt method for parent-key <cfb|aes-128-gcm|aes-256-gcm|chacha20-poly1305>, cfb for compatible with old version, aead methods can not talk to cfb peers and are not detected automatically, both sides must use the same method")
 	flags.StringVarP(&socksCfg.ParentAuth, "parent-auth", "A", "", "parent socks auth username and password, such as: -A user1:pass1")
+	flags.StringVar(&socksCfg.ParentObfs, "parent-obfs", "", "simple-obfs compatible obfuscation on parent connection <http|tls>, only worked of parent type is tcp or stcp")
+	flags.StringVar(&socksCfg.ParentObfsHost, "parent-obfs-host", "cloudfront.net", "the host disguised by parent-obfs, Host header for http, SNI for tls")
 	// local
-	flags.StringVarP(&socksCfg.LocalType, "local-type", "t", "tcp", "local protocol type <tcp|tls|stcp|kcp>")
-	flags.StringVarP(&socksCfg.Local, "local", "p", ":28080", "local ip:port to listen,multiple address use comma split,such as: 0.0.0.0:80,0.0.0.0:443")
+	flags.StringVarP(&socksCfg.LocalType, "local-type", "t", "tcp", "local protocol type <tcp|tls|stcp|kcp|quic|ws|wss>")
+	flags.StringVarP(&socksCfg.Local, "local", "p", ":28080", "local ip:port or unix:///path to listen,multiple address use comma split,such as: 0.0.0.0:80,0.0.0.0:443,unix:///run/jocasta.sock")
 	flags.BoolVarP(&socksCfg.LocalCompress, "local-compress", "m", false, "auto compress/decompress data on local connection")
+	flags.StringVar(&socksCfg.LocalAdorns, "local-adorns", "", fmt.Sprintf("adorn chain on local connection after compress, e.g. zstd(level=3),flow, obfs and proxyproto are not allowed, adorn can be one of <%s>", strings.Join(connection.Adorns(), ", ")))
 	flags.StringVarP(&socksCfg.LocalKey, "local-key", "z", "", "the password for auto encrypt/decrypt local connection data")
+	flags.StringVar(&socksCfg.LocalKeyMethod, "local-key-method", "cfb", "the encrypt method for local-key <cfb|aes-128-gcm|aes-256-gcm|chacha20-poly1305>, cfb for compatible with old version, aead methods can not talk to cfb peers and are not detected automatically, both sides must use the same method")
+	flags.StringVar(&socksCfg.LocalObfs, "local-obfs", "", "simple-obfs compatible obfuscation on local connection <http|tls>, only worked of local type is tcp or stcp")
 	// tls
-	flags.StringVarP(&socksCfg.CertFile, "cert", "C", "proxy.crt", "cert file for tls")
-	flags.StringVarP(&socksCfg.KeyFile, "key", "K", "proxy.key", "key file for tls")
-	flags.StringVar(&socksCfg.CaCertFile, "ca", "", "ca cert file for tls")
-	// stcp
-	socksCfg.STCPConfig = stcpCfg
+	flags.StringVarP(&socksCfg.CertFile, "cert", "C", "proxy.crt", "cert file for tls/quic/wss")
+	flags.StringVarP(&socksCfg.KeyFile, "key", "K", "proxy.key", "key file for tls/quic/wss")
+	flags.StringVar(&socksCfg.CaCertFile, "ca", "", "ca cert file for tls/quic/wss")
 	// ssh
 	flags.StringVarP(&socksCfg.SSHConfig.User, "ssh-user", "u", "", "user for ssh")
 	flags.StringVarP(&socksCfg.SSHConfig.KeyFile, "ssh-key", "S", "", "private key file for ssh")
@@ -57,6 +71,13 @@ func init() {
 	flags.StringVarP(&socksCfg.SSHConfig.Password, "ssh-password", "D", "", "password for ssh")
 	// 其它
 	flags.DurationVar(&socksCfg.Timeout, "timeout", 5*time.Second, "tcp timeout duration when connect to real server or parent proxy")
+	flags.DurationVar(&socksCfg.IdleTimeout, "idle-timeout", 0, "close both sides when no data transfer in either direction for this duration, 0 means no limit")
+	flags.DurationVar(&socksCfg.MaxLifetime, "max-lifetime", 0, "max session lifetime, close both sides when exceeded, 0 means no limit")
+	flags.DurationVar(&socksCfg.HalfCloseTimeout, "half-close-timeout", binding.DefaultHalfCloseTimeout, "close both sides when the other direction is not finished in this duration after one direction half-closed")
+	flags.BoolVar(&socksCfg.ProxyProtocol, "proxy-protocol", false, "parse PROXY protocol v1/v2 header on local connection to get the real client address, only for tcp|tls|stcp|ws|wss")
+	flags.StringSliceVar(&socksCfg.ProxyProtocolTrusted, "proxy-protocol-trusted", nil, "trusted upstream CIDRs or IPs, if set only accept connections with PROXY protocol header from them")
+	flags.IntVar(&socksCfg.ReusePort, "reuse-port", 0, "number of SO_REUSEPORT sockets on local address, each with its own accept loop, <= 1 means disable, only for linux tcp|tls|stcp|ws|wss")
+	flags.BoolVar(&socksCfg.FastOpen, "fast-open", false, "enable TCP Fast Open on local listener, only for linux tcp|tls|stcp|ws|wss")
 	flags.BoolVar(&socksCfg.Always, "always", false, "always use parent proxy")
 	// 代理过滤
 	flags.StringVar(&socksCfg.FilterConfig.Intelligent, "intelligent", "intelligent", "settting intelligent HTTP, SOCKS5 proxy mode, can be <intelligent|direct|parent>")
@@ -78,8 +99,13 @@ func init() {
 	flags.DurationVar(&socksCfg.LbConfig.Timeout, "lb-timeout", 500*time.Millisecond, "tcp duration timeout of connecting to parent")
 	flags.DurationVar(&socksCfg.LbConfig.RetryTime, "lb-retrytime", 1*time.Second, "sleep time duration after checking")
 	flags.BoolVar(&socksCfg.LbConfig.HashTarget, "lb-hashtarget", false, "use target address to choose parent for LB")
+	flags.IntVar(&socksCfg.LbConfig.PoolMinIdle, "lb-pool-min-idle", 0, "minimum idle pre-warmed connections kept for each parent")
+	flags.IntVar(&socksCfg.LbConfig.PoolMaxIdle, "lb-pool-max-idle", 0, "maximum idle pre-warmed connections for each parent, 0 means disable the pool")
+	flags.DurationVar(&socksCfg.LbConfig.PoolIdleTTL, "lb-pool-idle-ttl", 30*time.Second, "max idle time duration of a pre-warmed connection, should be less than the parent idle timeout")
 	// 限速器
-	flags.StringVarP(&socksCfg.RateLimit, "rate-limit", "l", "0", "rate limit (bytes/second) of each connection, such as: 100K 1.5M . 0 means no limitation")
+	flags.StringVarP(&socksCfg.RateLimit, "rate-limit", "l", "0", "rate limit (bytes/second) of each connection, both upload and download, such as: 100K 1.5M . 0 means no limitation")
+	flags.StringVar(&socksCfg.ServiceRateLimit, "service-rate-limit", "0", "rate limit (bytes/second) shared by all connections of this service, such as: 100K 1.5M . 0 means no limitation")
+	flags.StringVar(&socksCfg.UserRateLimit, "user-rate-limit", "0", "rate limit (bytes/second) shared by connections of the same authenticated user, or the same source ip without auth, such as: 100K 1.5M . 0 means no limitation")
 	flags.StringSliceVarP(&socksCfg.LocalIPS, "local-bind-ips", "g", nil, "if your host behind a nat,set your public ip here avoid dead loop")
 	flags.BoolVarP(&socksCfg.BindListen, "bind-listen", "B", false, "using listener binding IP when connect to target")
 
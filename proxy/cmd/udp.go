@@ -19,6 +19,8 @@ var udpCmd = &cobra.Command{
 		if forever {
 			return
 		}
+		udpCfg.TLSOption = tlsOpt
+		udpCfg.STCPConfig = stcpCfg
 
 		log.Println(udpCfg.SKCPConfig)
 		srv := sudp.New(udpCfg, sudp.WithLogger(zap.S()))
@@ -42,8 +44,6 @@ func init() {
 	flags.StringVarP(&udpCfg.CertFile, "cert", "C", "proxy.crt", "cert file for tls")
 	flags.StringVarP(&udpCfg.KeyFile, "key", "K", "proxy.key", "key file for tls")
 	flags.StringVar(&udpCfg.CaCertFile, "ca", "", "ca cert file for tls")
-	// stcp
-	udpCfg.STCPConfig = stcpCfg
 	// kcp
 	udpCfg.SKCPConfig = &kcpCfg
 	// 其它
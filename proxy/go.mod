@@ -1,6 +1,6 @@
 module github.com/thinkgos/jocasta/proxy
 
-go 1.14
+go 1.22
 
 require (
 	github.com/spf13/cobra v1.1.1
@@ -10,4 +10,52 @@ require (
 	go.uber.org/zap v1.16.0
 )
 
+require (
+	github.com/cenkalti/backoff/v4 v4.1.0 // indirect
+	github.com/denisbrodbeck/machineid v1.0.1 // indirect
+	github.com/go-playground/locales v0.13.0 // indirect
+	github.com/go-playground/universal-translator v0.17.0 // indirect
+	github.com/go-playground/validator/v10 v10.4.1 // indirect
+	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+	github.com/google/pprof v0.0.0-20210720184732-4bb14d4b1be1 // indirect
+	github.com/hashicorp/yamux v0.1.2 // indirect
+	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/klauspost/reedsolomon v1.9.11 // indirect
+	github.com/leodido/go-urn v1.2.1 // indirect
+	github.com/miekg/dns v1.1.40 // indirect
+	github.com/natefinch/lumberjack v2.0.0+incompatible // indirect
+	github.com/onsi/ginkgo/v2 v2.9.5 // indirect
+	github.com/orcaman/concurrent-map v0.0.0-20210106121528-16402b402231 // indirect
+	github.com/panjf2000/ants/v2 v2.4.3 // indirect
+	github.com/pierrec/lz4/v4 v4.1.31 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/quic-go/quic-go v0.48.2 // indirect
+	github.com/rs/xid v1.2.1 // indirect
+	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/templexxx/cpu v0.0.7 // indirect
+	github.com/templexxx/xorsimd v0.4.1 // indirect
+	github.com/things-go/meter v0.0.1 // indirect
+	github.com/thinkgos/go-socks5 v0.2.2 // indirect
+	github.com/tjfoc/gmsm v1.4.0 // indirect
+	github.com/xtaci/kcp-go/v5 v5.6.1 // indirect
+	github.com/xtaci/smux v1.5.15 // indirect
+	go.uber.org/atomic v1.7.0 // indirect
+	go.uber.org/mock v0.4.0 // indirect
+	go.uber.org/multierr v1.6.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
+	golang.org/x/mod v0.17.0 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/sync v0.8.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/time v0.5.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+	lukechampine.com/blake3 v1.4.1 // indirect
+)
+
 replace github.com/thinkgos/jocasta => ../
@@ -14,35 +14,51 @@ import (
 	cmap "github.com/orcaman/concurrent-map"
 	"github.com/things-go/encrypt"
 	"github.com/things-go/x/extstr"
-	"github.com/xtaci/smux"
 
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/captain"
+	"github.com/thinkgos/jocasta/core/tracker"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/extcert"
 	"github.com/thinkgos/jocasta/pkg/logger"
+	"github.com/thinkgos/jocasta/pkg/muxer"
 	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/pkg/through"
 	"github.com/thinkgos/jocasta/services"
 )
 
 type BridgeConfig struct {
-	LocalType string `validate:"required,oneof=tcp tls stcp kcp"` // tcp|tls|stcp|kcp, default: tcp
-	Local     string `validate:"required"`                        // default: :28080
+	LocalType string `validate:"required,oneof=tcp tls stcp kcp quic ws wss"` // tcp|tls|stcp|kcp|quic|ws|wss, default: tcp
+	Local     string `validate:"required"`                                    // default: :28080
 	Compress  bool   // 是否压缩传输, default: false
-	// tls有效
+	// tls,quic,wss有效
 	CaCertFile string // default: empty
 	CertFile   string // default: proxy.crt
 	KeyFile    string // default: proxy.key
+	// tls扩展配置, tls,quic,wss有效
+	TLSOption ccs.TLSOption
 	// kcp有效
 	SKCPConfig ccs.SKCPConfig
+	// quic有效
+	QuicConfig cs.QuicConfig
+	// ws,wss有效
+	WsConfig cs.WsConfig
 	// stcp有效
 	// stcp 加密方法 default: aes-192-cfb
 	// stcp 加密密钥 default: thinkgos's_jocasta
 	STCPConfig cs.StcpConfig
+	// 多路复用配置, 协议由节点选择
+	MuxConfig muxer.Config
 	// 其它
 	Timeout time.Duration `validate:"required"` // 连接超时时间 default 2s
+	// 空闲超时, 双向均无数据传输超过此时间时关闭会话, 0 表示不限制, default: 0
+	IdleTimeout time.Duration
+	// 最大会话时长, 超过此时间时关闭会话, 0 表示不限制, default: 0
+	MaxLifetime time.Duration
+	// 半关闭超时, 一个方向半关闭后另一方向超过此时间仍未结束时关闭会话, 0 使用默认值, default: 30s
+	HalfCloseTimeout time.Duration
 	// private
 	tlsConfig cs.TLSConfig
 }
@@ -67,7 +83,7 @@ func NewBridge(cfg BridgeConfig, opts ...BridgeOption) *Bridge {
 	}
 
 	b.clientSession = connection.New(time.Second*5, func(key string, value interface{}, now time.Time) bool {
-		if sess := value.(*smux.Session); sess.IsClosed() {
+		if sess := value.(muxer.Session); sess.IsClosed() {
 			sess.Close()
 			b.log.Infof("[ Bridge ] Node client released - sk< %s >", key)
 			return true
@@ -86,7 +102,7 @@ func (sf *Bridge) inspectConfig() (err error) {
 	}
 
 	// tls证书检查
-	if sf.cfg.LocalType == "tls" {
+	if extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.LocalType) {
 		if sf.cfg.CertFile == "" || sf.cfg.KeyFile == "" {
 			return fmt.Errorf("cert file and key file required")
 		}
@@ -99,14 +115,25 @@ func (sf *Bridge) inspectConfig() (err error) {
 				return fmt.Errorf("read ca file %+v", err)
 			}
 		}
+		if err = sf.cfg.TLSOption.Apply(&sf.cfg.tlsConfig); err != nil {
+			return fmt.Errorf("tls option, %+v", err)
+		}
 	}
 
 	// stcp 方法检查
 	if extstr.Contains([]string{sf.cfg.Local, sf.cfg.LocalType}, "stcp") &&
-		!extstr.Contains(encrypt.CipherMethods(), sf.cfg.STCPConfig.Method) {
+		!sf.cfg.STCPConfig.ForwardSecret && !extstr.Contains(encrypt.CipherMethods(), sf.cfg.STCPConfig.Method) {
 		return fmt.Errorf("stcp cipher method support one of %s", strings.Join(encrypt.CipherMethods(), ","))
 	}
 
+	// 多路复用协议由节点选择, 检查所有协议的配置
+	for _, m := range muxer.Muxers() {
+		cfg := sf.cfg.MuxConfig
+		cfg.Muxer = m
+		if err = cfg.Verify(); err != nil {
+			return fmt.Errorf("%s config, %+v", m, err)
+		}
+	}
 	return
 }
 
@@ -128,6 +155,8 @@ func (sf *Bridge) Start() (err error) {
 			TLSConfig:  sf.cfg.tlsConfig,
 			StcpConfig: sf.cfg.STCPConfig,
 			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
+			QuicConfig: sf.cfg.QuicConfig,
+			WsConfig:   sf.cfg.WsConfig,
 		},
 		GoPool:      sword.GoPool,
 		AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.Compress)},
@@ -153,10 +182,10 @@ func (sf *Bridge) Stop() {
 		_ = sf.channel.Close()
 	}
 	for _, sess := range sf.clientSession.Items() {
-		sess.(*smux.Session).Close() // nolint: errcheck
+		sess.(muxer.Session).Close() // nolint: errcheck
 	}
 	for _, sess := range sf.serverSession.Items() {
-		sess.(*smux.Session).Close() // nolint: errcheck
+		sess.(muxer.Session).Close() // nolint: errcheck
 	}
 	sf.log.Infof("[ Bridge ] bridge %s stopped", sf.cfg.LocalType)
 }
@@ -170,24 +199,33 @@ func (sf *Bridge) handler(inConn net.Conn) {
 	}
 	sf.log.Debugf("[ Bridge ] Node connected: type< %d >,sk< %s >,id< %s >", negos.Types, negos.Nego.SecretKey, negos.Nego.Id)
 
+	version := through.NegotiateVersion(negos.Version)
+	muxCfg := sf.cfg.MuxConfig
+	muxCfg.Muxer = negos.Nego.Muxer
+	if muxCfg.Muxer != "" && !extstr.Contains(muxer.Muxers(), muxCfg.Muxer) {
+		captain.SendReply(inConn, through.RepMuxerNotSupport, version) // nolint: errcheck
+		inConn.Close()                                                 // nolint: errcheck
+		sf.log.Errorf("[ Bridge ] Node muxer %s not supported", muxCfg.Muxer)
+		return
+	}
 	switch negos.Types {
 	case through.TypesServer:
-		session, err := smux.Server(inConn, nil)
+		session, err := muxer.Server(inConn, muxCfg, through.HalfClose(version))
 		if err != nil {
 			inConn.Write([]byte{through.RepServerFailure, through.Version}) // nolint: errcheck
 			inConn.Close()                                                  // nolint: errcheck
-			sf.log.Errorf("[ Bridge ] Node smux server session, %+v", err)
+			sf.log.Errorf("[ Bridge ] Node server session, %+v", err)
 			return
 		}
 
 		inAddr := inConn.RemoteAddr().String()
 		sf.serverSession.Upsert(inAddr, session, func(exist bool, valueInMap, newValue interface{}) interface{} {
 			if exist {
-				_ = valueInMap.(*smux.Session).Close()
+				_ = valueInMap.(muxer.Session).Close()
 			}
 			return newValue
 		})
-		captain.SendReply(inConn, through.RepSuccess, through.Version) // nolint: errcheck
+		captain.SendReply(inConn, through.RepSuccess, version) // nolint: errcheck
 
 		sf.log.Infof("[ Bridge ] Node server %s connected -- sk< %s >", negos.Nego.Id, negos.Nego.SecretKey)
 		defer func() {
@@ -203,36 +241,34 @@ func (sf *Bridge) handler(inConn net.Conn) {
 				return
 			}
 			sword.Go(func() {
-				sf.proxyStream(stream, negos.Nego.SecretKey, negos.Nego.Id)
+				sf.proxyStream(session, stream, negos.Nego.SecretKey, negos.Nego.Id)
 			})
 		}
 
 	case through.TypesClient:
-		session, err := smux.Client(inConn, nil)
+		session, err := muxer.Client(inConn, muxCfg, through.HalfClose(version))
 		if err != nil {
 			captain.SendReply(inConn, through.RepServerFailure, through.Version) // nolint: errcheck
 			inConn.Close()                                                       // nolint: errcheck
 			sf.log.Errorf("[ Bridge ] Node client session, %+v", err)
 			return
 		}
-		captain.SendReply(inConn, through.RepSuccess, through.Version) // nolint: errcheck
+		captain.SendReply(inConn, through.RepSuccess, version) // nolint: errcheck
 
-		sf.clientSession.Upsert(negos.Nego.SecretKey, session, func(exist bool, valueInMap, newValue interface{}) interface{} {
-			if exist {
-				_ = valueInMap.(*smux.Session).Close() // nolint: errcheck
-			}
-			return newValue
-		})
+		if old, ok := sf.clientSession.Replace(negos.Nego.SecretKey, session); ok {
+			_ = old.(muxer.Session).Close() // nolint: errcheck
+		}
 
-		sf.log.Infof("[ Bridge ] Node client connected -- sk< %s >", negos.Nego.SecretKey)
+		sf.log.Infof("[ Bridge ] Node client connected -- sk< %s >, muxer< %s >", negos.Nego.SecretKey, negos.Nego.Muxer)
 	default:
 		captain.SendReply(inConn, through.RepTypesNotSupport, through.Version) // nolint: errcheck
 		sf.log.Errorf("[ Bridge ] Node type unknown < %d >", negos.Types)
 	}
 }
 
-func (sf *Bridge) proxyStream(inStream *smux.Stream, sk, serverNodeId string) {
-	var targetStream *smux.Stream
+// proxyStream inStream为节点服务端会话inSession中的流
+func (sf *Bridge) proxyStream(inSession muxer.Session, inStream muxer.Stream, sk, serverNodeId string) {
+	var targetStream muxer.Stream
 
 	defer inStream.Close()
 
@@ -240,10 +276,8 @@ func (sf *Bridge) proxyStream(inStream *smux.Stream, sk, serverNodeId string) {
 	boff := backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second*3), 10)
 	boff = backoff.WithContext(boff, sf.ctx)
 	err := backoff.Retry(func() (err error) {
-		select {
-		case <-inStream.GetDieCh():
+		if inSession.IsClosed() {
 			return backoff.Permanent(io.ErrClosedPipe)
-		default:
 		}
 		conn, ok := sf.clientSession.Get(sk)
 		if !ok {
@@ -251,11 +285,12 @@ func (sf *Bridge) proxyStream(inStream *smux.Stream, sk, serverNodeId string) {
 			return errors.New("client not exists")
 		}
 
-		if conn.(*smux.Session).IsClosed() {
+		session := conn.(muxer.Session)
+		if session.IsClosed() {
 			return backoff.Permanent(io.ErrClosedPipe)
 		}
 
-		targetStream, err = conn.(*smux.Session).OpenStream()
+		targetStream, err = session.OpenStream()
 		if err != nil {
 			if errors.Is(err, io.ErrClosedPipe) {
 				return backoff.Permanent(io.ErrClosedPipe)
@@ -270,14 +305,23 @@ func (sf *Bridge) proxyStream(inStream *smux.Stream, sk, serverNodeId string) {
 		return
 	}
 
+	tracked, flow := sword.Tracker.Track(targetStream, tracker.Info{
+		Service: "bridge",
+		Source:  inStream.RemoteAddr().String(),
+		Target:  targetStream.RemoteAddr().String(),
+	})
+	defer flow.Release()
+
 	sf.log.Infof("[ Bridge ] Node client %d@sk< %s > ---> server %d@%s created", targetStream.ID(), sk, inStream.ID(), serverNodeId)
 	defer func() {
 		targetStream.Close()
-		sf.log.Infof("[ Bridge ] Node client %d@sk< %s > ---> server %d@%s released", targetStream.ID(), sk, inStream.ID(), serverNodeId)
+		sf.log.Infof("[ Bridge ] Node client %d@sk< %s > ---> server %d@%s released, %s", targetStream.ID(), sk, inStream.ID(), serverNodeId, binding.Reason(err))
 	}()
 
-	err = sword.Binding.Proxy(targetStream, inStream)
-	if err != nil && err != io.EOF {
+	err = sword.Binding.Proxy(tracked, inStream,
+		binding.WithIdleTimeout(sf.cfg.IdleTimeout), binding.WithMaxLifetime(sf.cfg.MaxLifetime),
+		binding.WithHalfCloseTimeout(sf.cfg.HalfCloseTimeout))
+	if err != nil && err != io.EOF && !binding.IsExpired(err) {
 		sf.log.Errorf("[ Bridge ] proxying, %s", err)
 	}
 }
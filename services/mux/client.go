@@ -8,19 +8,21 @@ import (
 	"net"
 	"net/url"
 	"strconv"
-	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
-	"github.com/xtaci/smux"
 
 	"github.com/things-go/x/extnet"
+	"github.com/things-go/x/extstr"
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/captain"
+	"github.com/thinkgos/jocasta/core/tracker"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/extcert"
 	"github.com/thinkgos/jocasta/pkg/logger"
+	"github.com/thinkgos/jocasta/pkg/muxer"
 	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/pkg/through"
 	"github.com/thinkgos/jocasta/pkg/through/ddt"
@@ -30,21 +32,35 @@ import (
 const MaxUDPIdleTime = 30 // 单位s
 
 type ClientConfig struct {
-	ParentType string `validate:"required,oneof=tcp tls stcp kcp"` // tcp|tls|stcp|kcp default tcp
-	Parent     string `validate:"required"`                        // 格式: addr:port default empty
+	ParentType string `validate:"required,oneof=tcp tls stcp kcp quic ws wss"` // tcp|tls|stcp|kcp|quic|ws|wss default tcp
+	Parent     string `validate:"required"`                                    // 格式: addr:port default empty
 	Compress   bool   // default false
 	SecretKey  string // default default
-	// tls有效
+	// tls,quic,wss有效
 	CertFile string // default proxy.crt
 	KeyFile  string // default proxy.key
+	// tls扩展配置, tls,quic,wss有效
+	TLSOption ccs.TLSOption
 	// kcp有效
 	SKCPConfig ccs.SKCPConfig
+	// quic有效
+	QuicConfig cs.QuicConfig
+	// ws,wss有效
+	WsConfig cs.WsConfig
 	// stcp有效
 	// stcp 加密方法 default: aes-192-cfb
 	// stcp 加密密钥 default: thinkgos's_jocasta
 	STCPConfig cs.StcpConfig
+	// 多路复用配置
+	MuxConfig muxer.Config
 	// 其它
 	Timeout time.Duration `validate:"required"` // default 2s 单位ms
+	// 空闲超时, 双向均无数据传输超过此时间时关闭会话, 0 表示不限制, default: 0
+	IdleTimeout time.Duration
+	// 最大会话时长, 超过此时间时关闭会话, 0 表示不限制, default: 0
+	MaxLifetime time.Duration
+	// 半关闭超时, 一个方向半关闭后另一方向超过此时间仍未结束时关闭会话, 0 使用默认值, default: 30s
+	HalfCloseTimeout time.Duration
 	// 跳板机
 	RawProxyURL string // default empty
 	// private
@@ -52,22 +68,21 @@ type ClientConfig struct {
 }
 
 type ClientUDPConnItem struct {
-	conn           *smux.Stream
-	lastActiveTime int64
-	srcAddr        *net.UDPAddr
-	localAddr      *net.UDPAddr
-	localConn      *net.UDPConn
-	sessId         string
+	conn      net.Conn
+	srcAddr   *net.UDPAddr
+	localAddr *net.UDPAddr
+	localConn *net.UDPConn
+	sessId    string
 }
 
 type Client struct {
-	cfg      ClientConfig
-	sessions *smux.Session
-	udpConns *connection.Manager
-	proxyURL *url.URL
-	cancel   context.CancelFunc
-	ctx      context.Context
-	log      logger.Logger
+	cfg       ClientConfig
+	sessions  muxer.Session
+	udpConns  *connection.Manager
+	proxyURLs []*url.URL
+	cancel    context.CancelFunc
+	ctx       context.Context
+	log       logger.Logger
 }
 
 var _ services.Service = (*Client)(nil)
@@ -75,15 +90,11 @@ var _ services.Service = (*Client)(nil)
 func NewClient(cfg ClientConfig, opts ...ClientOption) *Client {
 	c := &Client{cfg: cfg, log: logger.NewDiscard()}
 
-	c.udpConns = connection.New(time.Second, func(key string, value interface{}, now time.Time) bool {
+	c.udpConns = connection.NewExpiry(time.Second, MaxUDPIdleTime*time.Second, func(key string, value interface{}) {
 		item := value.(*ClientUDPConnItem)
-		if now.Unix()-atomic.LoadInt64(&item.lastActiveTime) > MaxUDPIdleTime {
-			item.conn.Close()
-			item.localConn.Close()
-			c.log.Infof("gc udp conn %s", item.sessId)
-			return true
-		}
-		return false
+		item.conn.Close()
+		item.localConn.Close()
+		c.log.Infof("gc udp conn %s", item.sessId)
 	})
 
 	for _, opt := range opts {
@@ -98,7 +109,7 @@ func (sf *Client) inspectConfig() (err error) {
 		return err
 	}
 
-	if sf.cfg.ParentType == "tls" {
+	if extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.ParentType) {
 		if sf.cfg.CertFile == "" || sf.cfg.KeyFile == "" {
 			return fmt.Errorf("cert file and key file required")
 		}
@@ -106,12 +117,18 @@ func (sf *Client) inspectConfig() (err error) {
 		if err != nil {
 			return err
 		}
+		if err = sf.cfg.TLSOption.Apply(&sf.cfg.tcpTlsConfig); err != nil {
+			return fmt.Errorf("tls option, %+v", err)
+		}
+	}
+	if err = sf.cfg.MuxConfig.Verify(); err != nil {
+		return fmt.Errorf("mux config, %+v", err)
 	}
 	if sf.cfg.RawProxyURL != "" {
-		if sf.cfg.ParentType != "tls" && sf.cfg.ParentType != "tcp" {
-			return fmt.Errorf("proxyURL only worked on tls or tcp")
+		if !extstr.Contains([]string{"tcp", "tls", "ws", "wss"}, sf.cfg.ParentType) {
+			return fmt.Errorf("proxyURL only worked on tcp, tls, ws or wss")
 		}
-		sf.proxyURL, err = cs.ParseProxyURL(sf.cfg.RawProxyURL)
+		sf.proxyURLs, err = cs.ParseProxyChain(sf.cfg.RawProxyURL)
 		if err != nil {
 			return fmt.Errorf("invalid proxyURL parameter, %s", err)
 		}
@@ -161,6 +178,7 @@ func (sf *Client) Start() (err error) {
 				Nego: ddt.NegotiateRequest{
 					SecretKey: sf.cfg.SecretKey,
 					Id:        "reserved",
+					Muxer:     sf.cfg.MuxConfig.Muxer,
 				},
 			}
 			data, err := msg.Bytes()
@@ -183,14 +201,15 @@ func (sf *Client) Start() (err error) {
 				return err
 			}
 
-			session, err := smux.Server(pConn, nil)
+			muxCfg := negotiateMuxer(sf.cfg.MuxConfig, tr.Version, sf.log)
+			session, err := muxer.Server(pConn, muxCfg, through.HalfClose(tr.Version))
 			if err != nil {
 				sf.log.Errorf("[ Client ] session %s, retrying...", err)
 				return err
 			}
 
 			sf.sessions = session
-			sf.log.Infof("[ Client ] node client sk< %s > created", sf.cfg.SecretKey)
+			sf.log.Infof("[ Client ] node client sk< %s > created, muxer< %s >", sf.cfg.SecretKey, muxCfg.Muxer)
 			for {
 				select {
 				case <-sf.ctx.Done():
@@ -235,7 +254,7 @@ func (sf *Client) Stop() {
 	sf.log.Infof("node client sk< %s > stopped", sf.cfg.SecretKey)
 }
 
-func (sf *Client) proxyUDP(inConn *smux.Stream, localAddr, sessId string) {
+func (sf *Client) proxyUDP(inConn net.Conn, localAddr, sessId string) {
 	var item *ClientUDPConnItem
 	var cacheSrcAddr string
 
@@ -286,7 +305,7 @@ func (sf *Client) proxyUDP(inConn *smux.Stream, localAddr, sessId string) {
 			})
 		}
 
-		atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
+		sf.udpConns.Touch(cacheSrcAddr)
 		sword.Go(func() {
 			item.localConn.Write(da.Data)
 		})
@@ -313,14 +332,24 @@ func (sf *Client) proxyTCP(inConn net.Conn, localAddr, sessId string) {
 		return
 	}
 
+	targetConn, flow := sword.Tracker.Track(targetConn, tracker.Info{
+		Service: "client",
+		Source:  inConn.RemoteAddr().String(),
+		Target:  localAddr,
+		Parent:  sf.cfg.Parent,
+	})
+	defer flow.Release()
+
 	sf.log.Infof("[ Client ] sk< %s > ---> sid< %s > stream binding created", sf.cfg.SecretKey, sessId)
 	defer func() {
-		sf.log.Infof("[ Client ] sk< %s > ---> sid< %s > stream binding released", sf.cfg.SecretKey, sessId)
+		sf.log.Infof("[ Client ] sk< %s > ---> sid< %s > stream binding released, %s", sf.cfg.SecretKey, sessId, binding.Reason(err))
 		targetConn.Close()
 	}()
 
-	err = sword.Binding.Proxy(inConn, targetConn)
-	if err != nil && err != io.EOF {
+	err = sword.Binding.Proxy(inConn, targetConn,
+		binding.WithIdleTimeout(sf.cfg.IdleTimeout), binding.WithMaxLifetime(sf.cfg.MaxLifetime),
+		binding.WithHalfCloseTimeout(sf.cfg.HalfCloseTimeout))
+	if err != nil && err != io.EOF && !binding.IsExpired(err) {
 		sf.log.Errorf("[ Client ] proxying, %s", err)
 	}
 }
@@ -351,7 +380,7 @@ func (sf *Client) runUdpReceive(key, id string) {
 			}
 			return
 		}
-		atomic.StoreInt64(&connItem.lastActiveTime, time.Now().Unix())
+		sf.udpConns.Touch(key)
 		sword.Go(func() {
 			defer sword.Binding.Put(buf)
 			as, err := captain.ParseAddrSpec(connItem.srcAddr.String())
@@ -391,7 +420,9 @@ func (sf *Client) dialParent(address string) (net.Conn, error) {
 			TLSConfig:  sf.cfg.tcpTlsConfig,
 			StcpConfig: sf.cfg.STCPConfig,
 			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
-			ProxyURL:   sf.proxyURL,
+			QuicConfig: sf.cfg.QuicConfig,
+			WsConfig:   sf.cfg.WsConfig,
+			ProxyURLs:  sf.proxyURLs,
 		},
 		AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.Compress)},
 	}
@@ -7,22 +7,25 @@ import (
 	"io"
 	"net"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
-	"github.com/xtaci/smux"
 
 	"github.com/things-go/x/extnet"
+	"github.com/things-go/x/extstr"
 
 	"github.com/thinkgos/jocasta/connection"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/captain"
+	"github.com/thinkgos/jocasta/core/tracker"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/extcert"
 	"github.com/thinkgos/jocasta/pkg/logger"
+	"github.com/thinkgos/jocasta/pkg/muxer"
 	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
 	"github.com/thinkgos/jocasta/pkg/through"
@@ -31,21 +34,35 @@ import (
 )
 
 type ServerConfig struct {
-	ParentType string `validate:"required,oneof=tcp tls stcp kcp"` // tcp|tls|tcps|kcp default tcp
-	Parent     string `validate:"required"`                        // 格式: addr:port default empty
+	ParentType string `validate:"required,oneof=tcp tls stcp kcp quic ws wss"` // tcp|tls|stcp|kcp|quic|ws|wss default tcp
+	Parent     string `validate:"required"`                                    // 格式: addr:port default empty
 	Compress   bool   // default false
 	SecretKey  string // default default
-	// tls有效
+	// tls,quic,wss有效
 	CertFile string // default proxy.crt
 	KeyFile  string // default proxy.key
+	// tls扩展配置, tls,quic,wss有效
+	TLSOption ccs.TLSOption
 	// kcp有效
 	SKCPConfig ccs.SKCPConfig
+	// quic有效
+	QuicConfig cs.QuicConfig
+	// ws,wss有效
+	WsConfig cs.WsConfig
 	// stcp有效
 	// stcp 加密方法 default: aes-192-cfb
 	// stcp 加密密钥 default: thinkgos's_jocasta
 	STCPConfig cs.StcpConfig
+	// 多路复用配置
+	MuxConfig muxer.Config
 	// 其它
 	Timeout time.Duration `validate:"required"` // default 2s
+	// 空闲超时, 双向均无数据传输超过此时间时关闭会话, 0 表示不限制, default: 0
+	IdleTimeout time.Duration
+	// 最大会话时长, 超过此时间时关闭会话, 0 表示不限制, default: 0
+	MaxLifetime time.Duration
+	// 半关闭超时, 一个方向半关闭后另一方向超过此时间仍未结束时关闭会话, 0 使用默认值, default: 30s
+	HalfCloseTimeout time.Duration
 	// 跳板机
 	RawProxyURL string // default empty
 
@@ -65,24 +82,22 @@ type ServerConfig struct {
 }
 
 type UDPConnItem struct {
-	conn           net.Conn
-	lastActiveTime int64
-	srcAddr        *net.UDPAddr
-	localAddr      *net.UDPAddr
-	sessId         string
+	session   *cs.UDPSession
+	srcAddr   *net.UDPAddr
+	localAddr *net.UDPAddr
 }
 
 type Server struct {
-	id       string
-	cfg      ServerConfig
-	listener interface{} //net.Listener
-	sessions *smux.Session
-	udpConns *connection.Manager // 本地udp地址 -> 远端连接 映射
-	mu       sync.Mutex
-	proxyURL *url.URL
-	cancel   context.CancelFunc
-	ctx      context.Context
-	log      logger.Logger
+	id        string
+	cfg       ServerConfig
+	listener  interface{} //net.Listener
+	sessions  muxer.Session
+	udpConns  *connection.Manager // 本地udp地址 -> 远端连接 映射
+	mu        sync.Mutex
+	proxyURLs []*url.URL
+	cancel    context.CancelFunc
+	ctx       context.Context
+	log       logger.Logger
 }
 
 var _ services.Service = (*Server)(nil)
@@ -94,14 +109,9 @@ func NewServer(cfg ServerConfig, opts ...ServerOption) *Server {
 		log: logger.NewDiscard(),
 	}
 
-	s.udpConns = connection.New(time.Second, func(key string, value interface{}, now time.Time) bool {
-		item := value.(*UDPConnItem)
-		if now.Unix()-atomic.LoadInt64(&item.lastActiveTime) > MaxUDPIdleTime {
-			item.conn.Close()
-			s.log.Infof("gc udp conn %s", item.sessId)
-			return true
-		}
-		return false
+	s.udpConns = connection.NewExpiry(time.Second, MaxUDPIdleTime*time.Second, func(key string, value interface{}) {
+		value.(*UDPConnItem).session.Close()
+		s.log.Infof("gc udp conn %s", key)
 	})
 
 	for _, opt := range opts {
@@ -116,7 +126,7 @@ func (sf *Server) inspectConfig() (err error) {
 		return err
 	}
 
-	if sf.cfg.ParentType == "tls" {
+	if extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.ParentType) {
 		if sf.cfg.CertFile == "" || sf.cfg.KeyFile == "" {
 			return fmt.Errorf("cert file and key file required")
 		}
@@ -124,13 +134,20 @@ func (sf *Server) inspectConfig() (err error) {
 		if err != nil {
 			return err
 		}
+		if err = sf.cfg.TLSOption.Apply(&sf.cfg.tcpTlsConfig); err != nil {
+			return fmt.Errorf("tls option, %+v", err)
+		}
+	}
+
+	if err = sf.cfg.MuxConfig.Verify(); err != nil {
+		return fmt.Errorf("mux config, %+v", err)
 	}
 
 	if sf.cfg.RawProxyURL != "" {
-		if sf.cfg.ParentType != "tls" && sf.cfg.ParentType != "tcp" {
-			return fmt.Errorf("proxyURL only worked on tls or tcp")
+		if !extstr.Contains([]string{"tcp", "tls", "ws", "wss"}, sf.cfg.ParentType) {
+			return fmt.Errorf("proxyURL only worked on tcp, tls, ws or wss")
 		}
-		if sf.proxyURL, err = cs.ParseProxyURL(sf.cfg.RawProxyURL); err != nil {
+		if sf.proxyURLs, err = cs.ParseProxyChain(sf.cfg.RawProxyURL); err != nil {
 			return fmt.Errorf("invalid proxyURL parameter, %s", err)
 		}
 	}
@@ -164,40 +181,15 @@ func (sf *Server) Start() (err error) {
 	var localhostAddr string
 
 	if sf.cfg.isUDP {
-		addr, err := net.ResolveUDPAddr("udp", sf.cfg.local)
+		udpServer, err := cs.ListenUDP("udp", sf.cfg.local, cs.UDPHandlerFunc(sf.handleUDP), cs.WithUDPLogger(sf.log))
 		if err != nil {
 			return err
 		}
-		var udpConn *net.UDPConn
-		udpConn, err = net.ListenUDP("udp", addr)
-		if err != nil {
-			return err
-		}
-		sword.Go(
-			func() {
-				defer udpConn.Close()
-				for {
-					buf := make([]byte, 2048)
-					n, srcAddr, err := udpConn.ReadFromUDP(buf)
-					if err != nil {
-						return
-					}
-					data := buf[0:n]
-					sword.Go(func() {
-						sf.handleUDP(udpConn, cs.Message{
-							LocalAddr: addr,
-							SrcAddr:   srcAddr,
-							Data:      data,
-						})
-					})
-				}
-			},
-		)
-		sf.listener = udpConn
+		sf.listener = udpServer
 		sword.Go(func() {
 			sf.udpConns.Watch(sf.ctx)
 		})
-		localhostAddr = udpConn.LocalAddr().String()
+		localhostAddr = udpServer.LocalAddr().String()
 	} else {
 		ln, err := connection.Listen("tcp", sf.cfg.local, connection.AdornSnappy(false))
 		if err != nil {
@@ -293,6 +285,7 @@ func (sf *Server) GetConn() (conn net.Conn, err error) {
 			Nego: ddt.NegotiateRequest{
 				SecretKey: sf.cfg.SecretKey,
 				Id:        sf.id,
+				Muxer:     sf.cfg.MuxConfig.Muxer,
 			},
 		}
 		data, err = msg.Bytes()
@@ -315,12 +308,13 @@ func (sf *Server) GetConn() (conn net.Conn, err error) {
 			return
 		}
 
-		sf.sessions, err = smux.Client(pConn, nil)
+		muxCfg := negotiateMuxer(sf.cfg.MuxConfig, tr.Version, sf.log)
+		sf.sessions, err = muxer.Client(pConn, muxCfg, through.HalfClose(tr.Version))
 		if err != nil {
 			return
 		}
 
-		sf.log.Infof("session[%s] created", sf.cfg.SecretKey)
+		sf.log.Infof("session[%s] created, muxer< %s >", sf.cfg.SecretKey, muxCfg.Muxer)
 		sword.Go(func() {
 			t := time.NewTicker(time.Second * 5)
 			defer t.Stop()
@@ -340,12 +334,13 @@ func (sf *Server) GetConn() (conn net.Conn, err error) {
 			}
 		})
 	}
-	conn, err = sf.sessions.OpenStream()
+	stream, err := sf.sessions.OpenStream()
 	if err != nil {
 		sf.sessions.Close()
 		sf.sessions = nil
+		return nil, err
 	}
-	return
+	return stream, nil
 }
 
 func (sf *Server) dialParent() (net.Conn, error) {
@@ -356,31 +351,26 @@ func (sf *Server) dialParent() (net.Conn, error) {
 			TLSConfig:  sf.cfg.tcpTlsConfig,
 			StcpConfig: sf.cfg.STCPConfig,
 			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
-			ProxyURL:   sf.proxyURL,
+			QuicConfig: sf.cfg.QuicConfig,
+			WsConfig:   sf.cfg.WsConfig,
+			ProxyURLs:  sf.proxyURLs,
 		},
 		AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.Compress)},
 	}
 	return d.Dial("tcp", sf.cfg.Parent)
 }
 
-func (sf *Server) runUDPReceive(key, id string) {
-	v, ok := sf.udpConns.Get(key)
-	if !ok {
-		sf.log.Warnf("udp conn not exists for %s, connectId : %s", key, id)
-		return
-	}
-	udpConnItem := v.(*UDPConnItem)
-
+func (sf *Server) runUDPReceive(key, id string, srcAddr *net.UDPAddr, sess *cs.UDPSession, conn net.Conn) {
 	sf.log.Infof("udp conn %s connected", id)
 	defer func() {
 		sf.udpConns.Remove(key)
-		udpConnItem.conn.Close()
+		sess.Close()
 		sf.log.Infof("udp conn %s released", id)
 	}()
 
 	for {
 		// 从远端接收数据,发送到本地
-		da, err := captain.ParseStreamDatagram(udpConnItem.conn)
+		da, err := captain.ParseStreamDatagram(conn)
 		if err != nil {
 			if strings.Contains(err.Error(), "n != int(") {
 				continue
@@ -390,62 +380,64 @@ func (sf *Server) runUDPReceive(key, id string) {
 			}
 			return
 		}
-		atomic.StoreInt64(&udpConnItem.lastActiveTime, time.Now().Unix())
+		sf.udpConns.Touch(key)
 		sword.Go(func() {
-			sf.listener.(*net.UDPConn).WriteToUDP(da.Data, udpConnItem.srcAddr)
+			sf.listener.(*cs.UDPServer).Conn().WriteToUDP(da.Data, srcAddr)
 		})
 	}
 }
 
 func (sf *Server) handleUDP(_ *net.UDPConn, msg cs.Message) {
-	var udpConnItem *UDPConnItem
-
 	srcAddr := msg.SrcAddr.String()
-	if v, ok := sf.udpConns.Get(srcAddr); ok {
-		udpConnItem = v.(*UDPConnItem)
-	} else {
-		// 不存在,建立一条与远端链接隧道
-		outConn, id, err := sf.dialThroughRemote()
-		if err != nil {
-			sf.log.Errorf("connect to %s fail, %s", sf.cfg.Parent, err)
-			return
-		}
-		udpConnItem = &UDPConnItem{
-			conn:      outConn,
+	v, ok := sf.udpConns.Get(srcAddr)
+	if !ok || v.(*UDPConnItem).session.IsClosed() {
+		item := &UDPConnItem{
 			srcAddr:   msg.SrcAddr,
 			localAddr: msg.LocalAddr,
-			sessId:    id,
 		}
-		sf.udpConns.Set(srcAddr, udpConnItem)
-		// 从远端接收数据,发送到本地
-		sword.Go(func() {
-			sf.runUDPReceive(srcAddr, id)
-		})
-	}
-	// 读取本地数据, 发送数据到远端
-	atomic.StoreInt64(&udpConnItem.lastActiveTime, time.Now().Unix())
-
-	as, err := captain.ParseAddrSpec(srcAddr)
-	if err != nil {
-		return
-	}
+		// 不存在,异步建立一条与远端链接隧道
+		item.session = cs.NewUDPSession(0, func(sess *cs.UDPSession) (net.Conn, error) {
+			outConn, id, err := sf.dialThroughRemote()
+			if err != nil {
+				sf.udpConns.Remove(srcAddr)
+				sf.log.Errorf("connect to %s fail, %s", sf.cfg.Parent, err)
+				return nil, err
+			}
+			// 从远端接收数据,发送到本地
+			sword.Go(func() {
+				sf.runUDPReceive(srcAddr, id, item.srcAddr, sess, outConn)
+			})
+			return outConn, nil
+		}, func(outConn net.Conn, data []byte) error {
+			// 读取本地数据, 发送数据到远端
+			as, err := captain.ParseAddrSpec(srcAddr)
+			if err != nil {
+				return nil
+			}
+			sData := captain.StreamDatagram{
+				Addr: as,
+				Data: data,
+			}
+			header, err := sData.Header()
+			if err != nil {
+				return nil
+			}
+			buf := sword.Binding.Get()
+			defer sword.Binding.Put(buf)
 
-	sData := captain.StreamDatagram{
-		Addr: as,
-		Data: msg.Data,
-	}
-	header, err := sData.Header()
-	if err != nil {
-		return
+			tmpBuf := append(buf, header...)
+			tmpBuf = append(tmpBuf, sData.Data...)
+			if _, err = outConn.Write(tmpBuf); err != nil {
+				sf.log.Errorf("write udp packet to %s fail, %s ", sf.cfg.Parent, err)
+			}
+			return nil
+		})
+		sf.udpConns.Set(srcAddr, item)
+		v = item
 	}
-	buf := sword.Binding.Get()
-	defer sword.Binding.Put(buf)
-
-	tmpBuf := append(buf, header...)
-	tmpBuf = append(tmpBuf, sData.Data...)
-	_, err = udpConnItem.conn.Write(tmpBuf)
-	if err != nil {
-		sf.log.Errorf("write udp packet to %s fail, %s ", sf.cfg.Parent, err)
+	sf.udpConns.Touch(srcAddr)
+	if !v.(*UDPConnItem).session.Put(msg.Data) {
+		sf.log.Warnf("udp conn %s queue full or closed, drop packet", srcAddr)
 	}
 }
 
@@ -470,13 +462,23 @@ func (sf *Server) handleTCP(inConn net.Conn) {
 		return
 	}
 
+	targetConn, flow := sword.Tracker.Track(targetConn, tracker.Info{
+		Service: "server",
+		Source:  inConn.RemoteAddr().String(),
+		Target:  net.JoinHostPort(sf.cfg.remote_host, strconv.Itoa(int(sf.cfg.remote_port))),
+		Parent:  sf.cfg.Parent,
+	})
+	defer flow.Release()
+
 	sf.log.Infof("[ Server ] sk< %s > ---> sid< %s > stream binding created", sf.cfg.SecretKey, sessId)
 	defer func() {
-		sf.log.Infof("[ Server ] sk< %s > ---> sid< %s > stream binding released", sf.cfg.SecretKey, sessId)
+		sf.log.Infof("[ Server ] sk< %s > ---> sid< %s > stream binding released, %s", sf.cfg.SecretKey, sessId, binding.Reason(err))
 		targetConn.Close()
 	}()
-	err = sword.Binding.Proxy(targetConn, inConn)
-	if err != nil && err != io.EOF {
+	err = sword.Binding.Proxy(targetConn, inConn,
+		binding.WithIdleTimeout(sf.cfg.IdleTimeout), binding.WithMaxLifetime(sf.cfg.MaxLifetime),
+		binding.WithHalfCloseTimeout(sf.cfg.HalfCloseTimeout))
+	if err != nil && err != io.EOF && !binding.IsExpired(err) {
 		sf.log.Errorf("[ Server ] proxying, %s", err)
 	}
 }
@@ -490,3 +492,15 @@ func WithServerLogger(l logger.Logger) ServerOption {
 		}
 	}
 }
+
+// negotiateMuxer 与bridge协商后的多路复用配置, bridge不支持选择协议时使用smux
+func negotiateMuxer(cfg muxer.Config, version byte, log logger.Logger) muxer.Config {
+	if !through.SupportMuxer(version) && cfg.Muxer != "" && cfg.Muxer != muxer.Smux {
+		log.Warnf("bridge not support muxer %s, fallback to smux", cfg.Muxer)
+		cfg.Muxer = muxer.Smux
+	}
+	if cfg.Muxer == "" {
+		cfg.Muxer = muxer.Smux
+	}
+	return cfg
+}
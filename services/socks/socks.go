@@ -1,13 +1,13 @@
 package socks
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
@@ -16,21 +16,20 @@ import (
 	"go.uber.org/atomic"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/net/proxy"
-	"golang.org/x/time/rate"
 
-	"github.com/things-go/meter"
 	"github.com/things-go/x/extnet"
 	"github.com/things-go/x/extstr"
 	"github.com/thinkgos/go-socks5"
 	"github.com/thinkgos/go-socks5/statute"
 
 	connection2 "github.com/thinkgos/jocasta/connection"
-	ccrypt "github.com/thinkgos/jocasta/connection/ccrypt"
-	ciol "github.com/thinkgos/jocasta/connection/ciol"
 	"github.com/thinkgos/jocasta/core/basicAuth"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/filter"
 	"github.com/thinkgos/jocasta/core/idns"
 	"github.com/thinkgos/jocasta/core/loadbalance"
+	"github.com/thinkgos/jocasta/core/ratelimit"
+	"github.com/thinkgos/jocasta/core/tracker"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/enet"
@@ -43,22 +42,48 @@ import (
 
 type Config struct {
 	// parent
-	ParentType     string   // 父级协议类型 tcp|tls|stcp|kcp|ssh, default: tcp
-	Parent         []string // 父级地址,格式addr:port, default: nil
+	ParentType     string   // 父级协议类型 tcp|tls|stcp|kcp|quic|ws|wss|ssh, default: tcp
+	Parent         []string // 父级地址,格式addr:port或unix:///path, default: nil
 	ParentCompress bool     // default false
 	ParentKey      string   // default empty
 	ParentAuth     string   // 上级socks5授权用户密码,格式username:password, default empty
+	// 父级加密方法, cfb|aes-128-gcm|aes-256-gcm|chacha20-poly1305, cfb兼容旧版本, default: cfb
+	ParentKeyMethod string
+	// 父级连接混淆, http|tls, 与simple-obfs兼容, 仅tcp,stcp有效, default: empty
+	ParentObfs string
+	// 父级连接混淆伪装的host, http时为请求的Host, tls时为SNI, default: cloudfront.net
+	ParentObfsHost string
 	// local
-	LocalType     string // 本地协议类型 tcp|tls|stcp|kcp
-	Local         string // 本地监听地址 default :28080
+	LocalType     string // 本地协议类型 tcp|tls|stcp|kcp|quic|ws|wss
+	Local         string // 本地监听地址, 格式addr:port或unix:///path, default :28080
 	LocalCompress bool   // default false
 	LocalKey      string // default empty
-	// tls有效
+	// 本地加密方法, cfb|aes-128-gcm|aes-256-gcm|chacha20-poly1305, cfb兼容旧版本, default: cfb
+	LocalKeyMethod string
+	// 本地连接混淆, http|tls, 与simple-obfs兼容, 仅tcp,stcp有效, default: empty
+	LocalObfs string
+	// tls,quic,wss有效
 	CertFile   string // cert文件 default proxy.crt
 	KeyFile    string // key文件 default proxy.key
 	CaCertFile string // ca文件 default empty
+	// tls扩展配置, tls,quic,wss有效
+	TLSOption ccs.TLSOption
 	// kcp有效
 	SKCPConfig ccs.SKCPConfig
+	// quic有效
+	QuicConfig cs.QuicConfig
+	// ws,wss有效
+	WsConfig cs.WsConfig
+	// 本地监听为unix domain socket时有效
+	UnixConfig cs.UnixConfig
+	// 本地监听解析 PROXY protocol v1/v2 头, 获取原始客户端地址, 仅tcp,tls,stcp,ws,wss有效 default: false
+	ProxyProtocol bool
+	// 信任的上游网段(CIDR或IP), 不为空时只接受来自这些网段且带PROXY protocol头的连接, 仅ProxyProtocol开启时有效
+	ProxyProtocolTrusted []string
+	// 本地监听使用SO_REUSEPORT打开的socket数, 每个socket有独立的Accept协程, <= 1 表示不使用, 仅linux下tcp,tls,stcp,ws,wss有效 default: 0
+	ReusePort int
+	// 本地监听开启TCP Fast Open, 仅linux下tcp,tls,stcp,ws,wss有效 default: false
+	FastOpen bool
 	// stcp有效
 	// stcp 加密方法 default: aes-192-cfb
 	// stcp 加密密钥 default: thinkgos's_jocasta
@@ -68,6 +93,12 @@ type Config struct {
 	// 其它
 	Timeout time.Duration // default 5000 单位ms
 	Always  bool          // 强制所有域名走代理 default false
+	// 空闲超时, 双向均无数据传输超过此时间时关闭会话, 0 表示不限制, default: 0
+	IdleTimeout time.Duration
+	// 最大会话时长, 超过此时间时关闭会话, 0 表示不限制, default: 0
+	MaxLifetime time.Duration
+	// 半关闭超时, 一个方向半关闭后另一方向超过此时间仍未结束时关闭会话, 0 使用默认值, default: 30s
+	HalfCloseTimeout time.Duration
 	// 代理过滤
 	// direct 不在blocked都直连
 	// parent 不在direct都走代理
@@ -81,16 +112,27 @@ type Config struct {
 	// 负载均衡
 	LbConfig ccs.LbConfig
 	// 限速器
-	RateLimit  string   //  限制速字节/s,可设置为2m, 100k等数值,default 0,不限速
-	LocalIPS   []string // default empty
-	BindListen bool     // default false
-	Debug      bool
+	RateLimit        string   // 每个连接限速字节/s, 上下行分别限速, 可设置为2m, 100k等数值,0表示不限速 default: 0
+	ServiceRateLimit string   // 本服务所有连接共享的限速字节/s, 0表示不限速 default: 0
+	UserRateLimit    string   // 每个认证用户或源IP共享的限速字节/s, 0表示不限速 default: 0
+	LocalIPS         []string // default empty
+	BindListen       bool     // default false
+	Debug            bool
+
+	// 本地连接的附加装饰链, 在压缩之后应用, 如 zstd(level=3),flow, 不支持 obfs, proxyproto, 见 connection.ParseUpperAdornChain, default: empty
+	LocalAdorns string
+	// 父级连接的附加装饰链, 在压缩之后应用, 格式同 LocalAdorns, default: empty
+	ParentAdorns string
 
 	// private
 	tlsConfig     cs.TLSConfig
 	sshAuthMethod ssh.AuthMethod
-	rateLimit     rate.Limit
 	parentAuth    *proxy.Auth
+	// LocalKey, ParentKey 的连接加密
+	localKeyCipher  *ccs.KeyCipher
+	parentKeyCipher *ccs.KeyCipher
+	localAdorns     connection2.AdornConnsChain
+	parentAdorns    connection2.AdornConnsChain
 }
 
 type Socks struct {
@@ -109,6 +151,7 @@ type Socks struct {
 	log                   logger.Logger
 	udpLocalKey           []byte
 	udpParentKey          []byte
+	limiter               *ratelimit.Hierarchy
 }
 
 var _ services.Service = (*Socks)(nil)
@@ -127,7 +170,8 @@ func (sf *Socks) inspectConfig() (err error) {
 		sf.cfg.Parent = []string{}
 	}
 
-	if sf.cfg.LocalType == "tls" || (sf.cfg.ParentType == "tls" && len(sf.cfg.Parent) > 0) {
+	if extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.LocalType) ||
+		(extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.ParentType) && len(sf.cfg.Parent) > 0) {
 		sf.cfg.tlsConfig.Cert, sf.cfg.tlsConfig.Key, err = extcert.LoadPair(sf.cfg.CertFile, sf.cfg.KeyFile)
 		if err != nil {
 			return err
@@ -138,14 +182,17 @@ func (sf *Socks) inspectConfig() (err error) {
 				return fmt.Errorf("read ca file, %s", err)
 			}
 		}
+		if err = sf.cfg.TLSOption.Apply(&sf.cfg.tlsConfig); err != nil {
+			return fmt.Errorf("tls option, %+v", err)
+		}
 	}
 
 	if len(sf.cfg.Parent) > 0 {
 		if sf.cfg.ParentType == "" {
 			return fmt.Errorf("parent type required for %s", sf.cfg.Parent)
 		}
-		if !extstr.Contains([]string{"tcp", "tls", "stcp", "kcp", "ssh"}, sf.cfg.ParentType) {
-			return fmt.Errorf("parent type suport <tcp|tls|stcp|kcp|ssh>")
+		if !extstr.Contains([]string{"tcp", "tls", "stcp", "kcp", "quic", "ws", "wss", "ssh"}, sf.cfg.ParentType) {
+			return fmt.Errorf("parent type suport <tcp|tls|stcp|kcp|quic|ws|wss|ssh>")
 		}
 		if sf.cfg.ParentType == "ssh" {
 			sf.cfg.sshAuthMethod, err = sf.cfg.SSHConfig.Parse()
@@ -154,12 +201,21 @@ func (sf *Socks) inspectConfig() (err error) {
 			}
 		}
 	}
-	if sf.cfg.RateLimit != "0" && sf.cfg.RateLimit != "" {
-		size, err := meter.ParseBytes(sf.cfg.RateLimit)
-		if err != nil {
-			return fmt.Errorf("parse rate limit size, %s", err)
-		}
-		sf.cfg.rateLimit = rate.Limit(size)
+	if err = ccs.ValidObfs(sf.cfg.LocalType, sf.cfg.LocalObfs); err != nil {
+		return fmt.Errorf("local obfs, %+v", err)
+	}
+	if err = ccs.ValidObfs(sf.cfg.ParentType, sf.cfg.ParentObfs); err != nil {
+		return fmt.Errorf("parent obfs, %+v", err)
+	}
+	if sf.cfg.localAdorns, err = connection2.ParseUpperAdornChain(sf.cfg.LocalAdorns, true); err != nil {
+		return fmt.Errorf("local adorns, %v", err)
+	}
+	if sf.cfg.parentAdorns, err = connection2.ParseUpperAdornChain(sf.cfg.ParentAdorns, false); err != nil {
+		return fmt.Errorf("parent adorns, %v", err)
+	}
+	sf.limiter, err = ratelimit.Build(sword.GlobalLimiter, sf.cfg.ServiceRateLimit, sf.cfg.UserRateLimit, sf.cfg.RateLimit)
+	if err != nil {
+		return err
 	}
 	if sf.cfg.ParentAuth != "" {
 		au := strings.Split(sf.cfg.ParentAuth, ":")
@@ -169,6 +225,17 @@ func (sf *Socks) inspectConfig() (err error) {
 		sf.cfg.parentAuth = &proxy.Auth{User: au[0], Password: au[1]}
 	}
 
+	if sf.cfg.LocalKey != "" {
+		if sf.cfg.localKeyCipher, err = ccs.NewKeyCipher(sf.cfg.LocalKeyMethod, sf.cfg.LocalKey); err != nil {
+			return fmt.Errorf("local key method should be oneof <%s>", strings.Join(ccs.KeyMethods(), ", "))
+		}
+	}
+	if sf.cfg.ParentKey != "" {
+		if sf.cfg.parentKeyCipher, err = ccs.NewKeyCipher(sf.cfg.ParentKeyMethod, sf.cfg.ParentKey); err != nil {
+			return fmt.Errorf("parent key method should be oneof <%s>", strings.Join(ccs.KeyMethods(), ", "))
+		}
+	}
+
 	sf.udpLocalKey = sf.localUDPKey()
 	sf.udpParentKey = sf.parentUDPKey()
 	return
@@ -229,15 +296,7 @@ func (sf *Socks) initService() (err error) {
 		configs := []loadbalance.Config{}
 
 		for _, addr := range sf.cfg.Parent {
-			_addrInfo := strings.Split(addr, "@")
-			_addr, weight := _addrInfo[0], 1
-
-			if len(_addrInfo) == 2 {
-				weight, _ = strconv.Atoi(_addrInfo[1])
-				if weight == 0 {
-					weight = 1
-				}
-			}
+			_addr, weight := loadbalance.SplitAddrWeight(addr)
 			configs = append(configs, loadbalance.Config{
 				Addr:             _addr,
 				Weight:           weight,
@@ -247,11 +306,17 @@ func (sf *Socks) initService() (err error) {
 				Period:           sf.cfg.LbConfig.RetryTime,
 			})
 		}
+		poolConfig := sf.cfg.LbConfig.PoolConfig()
+		if sf.cfg.ParentType == "ssh" {
+			poolConfig = loadbalance.PoolConfig{}
+		}
 		sf.lb = loadbalance.New(sf.cfg.LbConfig.Method, configs,
 			loadbalance.WithDNSServer(sf.domainResolver),
 			loadbalance.WithLogger(sf.log),
 			loadbalance.WithEnableDebug(sf.cfg.Debug),
 			loadbalance.WithGPool(sword.GoPool),
+			loadbalance.WithConnPool(poolConfig, sf.dialParentConn),
+			loadbalance.WithConnAdorn(sf.adornParentConn),
 		)
 	}
 	// init ssh connect
@@ -330,12 +395,20 @@ func (sf *Socks) Start() (err error) {
 		Protocol: sf.cfg.LocalType,
 		Addr:     sf.cfg.Local,
 		Config: ccs.Config{
-			TLSConfig:  sf.cfg.tlsConfig,
-			StcpConfig: sf.cfg.STCPConfig,
-			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
+			TLSConfig:            sf.cfg.tlsConfig,
+			StcpConfig:           sf.cfg.STCPConfig,
+			KcpConfig:            sf.cfg.SKCPConfig.KcpConfig,
+			QuicConfig:           sf.cfg.QuicConfig,
+			WsConfig:             sf.cfg.WsConfig,
+			UnixConfig:           sf.cfg.UnixConfig,
+			ProxyProtocol:        sf.cfg.ProxyProtocol,
+			ProxyProtocolTrusted: sf.cfg.ProxyProtocolTrusted,
+			ReusePort:            sf.cfg.ReusePort,
+			FastOpen:             sf.cfg.FastOpen,
+			ObfsConfig:           cs.ObfsConfig{Mode: sf.cfg.LocalObfs},
 		},
 		GoPool:      sword.GoPool,
-		AdornChains: connection2.AdornConnsChain{connection2.AdornSnappy(sf.cfg.LocalCompress)},
+		AdornChains: append(connection2.AdornConnsChain{connection2.AdornSnappy(sf.cfg.LocalCompress)}, sf.cfg.localAdorns...),
 		Handler:     cs.HandlerFunc(sf.handle),
 	}
 
@@ -378,9 +451,12 @@ func (sf *Socks) Stop() {
 	sf.log.Infof("[ Socks ] service stopped")
 }
 
+// RateLimiter 分层限速器, 可在运行时修改速率
+func (sf *Socks) RateLimiter() *ratelimit.Hierarchy { return sf.limiter }
+
 func (sf *Socks) handle(inConn net.Conn) {
 	if sf.cfg.LocalKey != "" {
-		inConn = ccrypt.New(inConn, ccrypt.Config{Password: sf.cfg.LocalKey})
+		inConn = sf.cfg.localKeyCipher.Adorn(inConn, true)
 	}
 
 	if err := sf.socks5Srv.ServeConn(inConn); err != nil {
@@ -404,20 +480,30 @@ func (sf *Socks) proxyTCP(ctx context.Context, writer io.Writer, request *socks5
 		}
 		return fmt.Errorf("connect to %v failed, %v", request.RawDestAddr, err)
 	}
-	defer targetConn.Close()
+	defer func() { targetConn.Close() }()
 
 	// Send success
 	if err = socks5.SendReply(writer, statute.RepSuccess, targetConn.LocalAddr()); err != nil {
 		return fmt.Errorf("failed to send reply, %v", err)
 	}
 
-	if sf.cfg.rateLimit > 0 {
-		targetConn = ciol.New(targetConn, ciol.WithReadLimiter(sf.cfg.rateLimit))
-	}
-
 	srcAddr := request.RemoteAddr.String()
 	targetAddr := request.DestAddr.String()
 
+	var user string
+	if request.AuthContext != nil {
+		user = request.AuthContext.Payload["username"]
+	}
+	targetConn = sf.limiter.Conn(targetConn, ratelimit.Key(user, srcAddr))
+	targetConn, flow := sword.Tracker.Track(targetConn, tracker.Info{
+		Service: "socks",
+		Source:  srcAddr,
+		Target:  targetAddr,
+		Parent:  lbAddr,
+		User:    user,
+	})
+	defer flow.Release()
+
 	sf.userConns.Upsert(srcAddr, writer, func(exist bool, valueInMap, newValue interface{}) interface{} {
 		if exist {
 			valueInMap.(io.Closer).Close()
@@ -430,7 +516,7 @@ func (sf *Socks) proxyTCP(ctx context.Context, writer io.Writer, request *socks5
 	sf.log.Infof("[ Socks ] tcp %s --> %s connected", srcAddr, targetAddr)
 
 	defer func() {
-		sf.log.Infof("[ Socks ] tcp %s --> %s released", srcAddr, targetAddr)
+		sf.log.Infof("[ Socks ] tcp %s --> %s released, %s", srcAddr, targetAddr, binding.Reason(err))
 		sf.userConns.Remove(srcAddr)
 		if len(sf.cfg.Parent) > 0 {
 			sf.lb.ConnsDecrease(lbAddr)
@@ -438,18 +524,42 @@ func (sf *Socks) proxyTCP(ctx context.Context, writer io.Writer, request *socks5
 	}()
 
 	// start proxying
-	eCh1 := make(chan error, 1)
-	eCh2 := make(chan error, 1)
-	sword.Go(func() { eCh1 <- sf.socks5Srv.Proxy(targetConn, request.Reader) })
-	sword.Go(func() { eCh2 <- sf.socks5Srv.Proxy(writer, targetConn) })
-	// Wait
-	select {
-	case err = <-eCh1:
-	case err = <-eCh2:
-	}
+	err = sword.Binding.Proxy(targetConn, newClientConn(request.Reader, writer),
+		binding.WithIdleTimeout(sf.cfg.IdleTimeout), binding.WithMaxLifetime(sf.cfg.MaxLifetime),
+		binding.WithHalfCloseTimeout(sf.cfg.HalfCloseTimeout))
 	return err
 }
 
+// newClientConn socks5客户端连接, request.Reader没有已缓冲的数据时直接使用客户端连接,
+// 以便两端均为tcp连接时可零拷贝转发
+func newClientConn(reader io.Reader, writer io.Writer) io.ReadWriter {
+	if br, ok := reader.(*bufio.Reader); ok && br.Buffered() == 0 {
+		if conn, ok := writer.(net.Conn); ok {
+			return conn
+		}
+	}
+	return clientConn{reader, writer}
+}
+
+// clientConn socks5客户端连接, 从request.Reader读取(可能含已缓冲的数据), 向writer写入
+type clientConn struct {
+	io.Reader
+	io.Writer
+}
+
+// Close 关闭客户端连接
+func (sf clientConn) Close() error {
+	if c, ok := sf.Writer.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// CloseWrite 半关闭客户端连接的写方向
+func (sf clientConn) CloseWrite() error {
+	return enet.CloseWrite(sf.Writer)
+}
+
 func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn net.Conn, lbAddr string, err error) {
 	srcAddr := request.RemoteAddr.String()
 	localAddr := request.LocalAddr.String()
@@ -491,14 +601,14 @@ func (sf *Socks) dialForTcp(ctx context.Context, request *socks5.Request) (conn
 			return err
 		}, boff)
 	} else {
-		conn, err = sf.dialDirect(outil.Resolve(sf.domainResolver, targetAddr), localAddr)
+		conn, err = sf.dialDirect(targetAddr, localAddr)
 	}
 	if err != nil {
 		sf.log.Warnf("[ Socks ] dial conn fail, %v", err)
 		return nil, "", err
 	}
 	if useProxy && sf.cfg.ParentKey != "" {
-		conn = ccrypt.New(conn, ccrypt.Config{Password: sf.cfg.ParentKey})
+		conn = sf.cfg.parentKeyCipher.Adorn(conn, false)
 	}
 	used := "DIRECT"
 	if useProxy {
@@ -548,20 +658,34 @@ func (sf *Socks) IsDeadLoop(inLocalAddr string, outAddr string) bool {
 	return false
 }
 
+// dialParentConn 建立到父级的连接, 未压缩, 由负载均衡的预热连接池使用
+func (sf *Socks) dialParentConn(ctx context.Context, address string) (net.Conn, error) {
+	d := ccs.Dialer{
+		Protocol: sf.cfg.ParentType,
+		Timeout:  sf.cfg.Timeout,
+		Config: ccs.Config{
+			TLSConfig:  sf.cfg.tlsConfig,
+			StcpConfig: sf.cfg.STCPConfig,
+			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
+			QuicConfig: sf.cfg.QuicConfig,
+			WsConfig:   sf.cfg.WsConfig,
+			ObfsConfig: cs.ObfsConfig{Mode: sf.cfg.ParentObfs, Host: sf.cfg.ParentObfsHost},
+			Resolver:   sf.domainResolver,
+		},
+	}
+	return d.DialContext(ctx, "tcp", address)
+}
+
+// adornParentConn 装饰到父级的连接, 由负载均衡在预热时或连接后调用.
+// ParentKey 的加密在与父级socks5握手之后, 不在此处
+func (sf *Socks) adornParentConn(conn net.Conn) net.Conn {
+	return sf.cfg.parentAdorns.Adorn(connection2.AdornSnappy(sf.cfg.ParentCompress)(conn))
+}
+
 func (sf *Socks) dialParent(targetAddr string) (outConn net.Conn, err error) {
 	switch sf.cfg.ParentType {
-	case "tcp", "tls", "stcp", "kcp":
-		d := ccs.Dialer{
-			Protocol: sf.cfg.ParentType,
-			Timeout:  sf.cfg.Timeout,
-			Config: ccs.Config{
-				TLSConfig:  sf.cfg.tlsConfig,
-				StcpConfig: sf.cfg.STCPConfig,
-				KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
-			},
-			AdornChains: connection2.AdornConnsChain{connection2.AdornSnappy(sf.cfg.ParentCompress)},
-		}
-		outConn, err = d.Dial("tcp", targetAddr)
+	case "tcp", "tls", "stcp", "kcp", "quic", "ws", "wss":
+		outConn, err = sf.lb.Dial(sf.ctx, targetAddr)
 	case "ssh":
 		t := time.NewTimer(sf.cfg.Timeout * 2)
 		defer t.Stop()
@@ -597,18 +721,14 @@ func (sf *Socks) dialParent(targetAddr string) (outConn net.Conn, err error) {
 
 // 直连
 func (sf *Socks) dialDirect(address string, localAddr string) (conn net.Conn, err error) {
+	d := connection2.HappyEyeballs{Timeout: sf.cfg.Timeout, Resolver: sf.domainResolver}
 	if sf.cfg.BindListen {
 		localIP, _, _ := net.SplitHostPort(localAddr)
 		if !extnet.IsIntranet(localIP) {
-			local, _ := net.ResolveTCPAddr("tcp", localIP+":0")
-			d := net.Dialer{
-				Timeout:   sf.cfg.Timeout,
-				LocalAddr: local,
-			}
-			return d.Dial("tcp", address)
+			d.LocalAddr, _ = net.ResolveTCPAddr("tcp", localIP+":0")
 		}
 	}
-	return net.DialTimeout("tcp", address, sf.cfg.Timeout)
+	return d.Dial("tcp", address)
 }
 
 func (sf *Socks) dialSSH(lAddr string) (*ssh.Client, error) {
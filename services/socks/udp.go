@@ -272,7 +272,7 @@ func (sf *Socks) parentUDPKey() (key []byte) {
 			v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.ParentKey)))
 			return []byte(v)[:24]
 		}
-	case "tls":
+	case "tls", "quic", "wss":
 		return sf.cfg.tlsConfig.Key[:24]
 	case "kcp":
 		v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.SKCPConfig.Key)))
@@ -288,7 +288,7 @@ func (sf *Socks) localUDPKey() (key []byte) {
 			v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.LocalKey)))
 			return []byte(v)[:24]
 		}
-	case "tls":
+	case "tls", "quic", "wss":
 		return sf.cfg.tlsConfig.Key[:24]
 	case "kcp":
 		v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.SKCPConfig.Key)))
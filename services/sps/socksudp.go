@@ -225,7 +225,7 @@ func (sf *SPS) ParentUDPKey() (key []byte) {
 			v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.ParentKey)))
 			return []byte(v)[:24]
 		}
-	case "tls":
+	case "tls", "quic", "wss":
 		if sf.cfg.tcpTlsConfig.Key != nil {
 			return sf.cfg.tcpTlsConfig.Key[:24]
 		}
@@ -242,7 +242,7 @@ func (sf *SPS) LocalUDPKey() (key []byte) {
 			v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.LocalKey)))
 			return []byte(v)[:24]
 		}
-	case "tls":
+	case "tls", "quic", "wss":
 		return sf.cfg.tcpTlsConfig.Key[:24]
 	case "kcp":
 		v := fmt.Sprintf("%x", md5.Sum([]byte(sf.cfg.SKCPConfig.Key)))
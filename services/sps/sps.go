@@ -2,9 +2,11 @@ package sps
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/url"
@@ -14,20 +16,21 @@ import (
 	"time"
 
 	cmap "github.com/orcaman/concurrent-map"
-	"github.com/things-go/meter"
+	"github.com/things-go/x/extstr"
 	"golang.org/x/net/proxy"
-	"golang.org/x/time/rate"
 
 	"github.com/thinkgos/jocasta/connection"
 	"github.com/thinkgos/jocasta/connection/cbuffered"
 	"github.com/thinkgos/jocasta/connection/ccrypt"
-	"github.com/thinkgos/jocasta/connection/ciol"
 	"github.com/thinkgos/jocasta/connection/shadowsocks"
 	"github.com/thinkgos/jocasta/connection/sni"
 	"github.com/thinkgos/jocasta/core/basicAuth"
+	"github.com/thinkgos/jocasta/core/binding"
 	"github.com/thinkgos/jocasta/core/idns"
 	"github.com/thinkgos/jocasta/core/loadbalance"
+	"github.com/thinkgos/jocasta/core/ratelimit"
 	"github.com/thinkgos/jocasta/core/socks5"
+	"github.com/thinkgos/jocasta/core/tracker"
 	"github.com/thinkgos/jocasta/cs"
 	"github.com/thinkgos/jocasta/pkg/ccs"
 	"github.com/thinkgos/jocasta/pkg/enet"
@@ -40,29 +43,57 @@ import (
 
 type Config struct {
 	// parent
-	ParentType      string   // 父级协议, tls|tcp|kcp,default empty
-	Parent          []string // 父级地址,格式addr:port, default empty
+	ParentType      string   // 父级协议, tls|tcp|stcp|kcp|quic|ws|wss|ss(即tcp传输的ss父级服务),default empty
+	Parent          []string // 父级地址,格式addr:port或unix:///path, default empty
 	ParentCompress  bool
 	ParentKey       string
 	ParentAuth      string
 	ParentTLSSingle bool
+	// 父级连接混淆, http|tls, 与simple-obfs兼容, 仅tcp,stcp有效, default: empty
+	ParentObfs string
+	// 父级连接混淆伪装的host, http时为请求的Host, tls时为SNI, default: cloudfront.net
+	ParentObfsHost string
 	// local
-	LocalType     string // 本地协议, tls|tcp|kcp, default tcp
-	Local         string // 本地监听地址, 格式addr:port,多个以','分隔 default :28080
+	LocalType     string // 本地协议, tls|tcp|stcp|kcp|quic|ws|wss, default tcp
+	Local         string // 本地监听地址, 格式addr:port或unix:///path,多个以','分隔 default :28080
 	LocalCompress bool
 	LocalKey      string
-	// tls有效
+	// 本地连接混淆, http|tls, 与simple-obfs兼容, 仅tcp,stcp有效, default: empty
+	LocalObfs string
+	// tls,quic,wss有效
 	CertFile   string // cert文件名 default proxy.crt
 	KeyFile    string // key文件名 default proxy.key
 	CaCertFile string // ca文件名 default empty
+	// tls扩展配置, tls,quic,wss有效
+	TLSOption ccs.TLSOption
 	// kcp有效
 	SKCPConfig ccs.SKCPConfig
+	// quic有效
+	QuicConfig cs.QuicConfig
+	// ws,wss有效
+	WsConfig cs.WsConfig
+	// 本地监听为unix domain socket时有效
+	UnixConfig cs.UnixConfig
+	// 本地监听解析 PROXY protocol v1/v2 头, 获取原始客户端地址, 仅tcp,tls,stcp,ws,wss有效 default: false
+	ProxyProtocol bool
+	// 信任的上游网段(CIDR或IP), 不为空时只接受来自这些网段且带PROXY protocol头的连接, 仅ProxyProtocol开启时有效
+	ProxyProtocolTrusted []string
+	// 本地监听使用SO_REUSEPORT打开的socket数, 每个socket有独立的Accept协程, <= 1 表示不使用, 仅linux下tcp,tls,stcp,ws,wss有效 default: 0
+	ReusePort int
+	// 本地监听开启TCP Fast Open, 仅linux下tcp,tls,stcp,ws,wss有效 default: false
+	FastOpen bool
 	// stcp有效
 	// stcp 加密方法 default: aes-192-cfb
 	// stcp 加密密钥 default: thinkgos's_jocasta
 	STCPConfig cs.StcpConfig
 	// 其它
 	Timeout time.Duration // tcp连接到父级或真实服务器超时时间,default 2s
+	// 空闲超时, 双向均无数据传输超过此时间时关闭会话, 0 表示不限制, default: 0
+	IdleTimeout time.Duration
+	// 最大会话时长, 超过此时间时关闭会话, 0 表示不限制, default: 0
+	MaxLifetime time.Duration
+	// 半关闭超时, 一个方向半关闭后另一方向超过此时间仍未结束时关闭会话, 0 使用默认值, default: 30s
+	HalfCloseTimeout time.Duration
 	// basic auth配置
 	AuthConfig ccs.AuthConfig
 	// dns域名解析
@@ -71,22 +102,30 @@ type Config struct {
 	LbConfig ccs.LbConfig
 
 	ParentServiceType string
-	ParentSSMethod    string
-	ParentSSKey       string
-	SSMethod          string
-	SSKey             string
+	ParentSSMethod    string // 支持流加密, AEAD 及 2022-blake3-* 方法
+	ParentSSKey       string // 2022-blake3-* 方法时为base64编码的密钥
+	SSMethod          string // 支持流加密, AEAD 及 2022-blake3-* 方法
+	SSKey             string // 2022-blake3-* 方法时为base64编码的密钥
 	DisableHTTP       bool
 	DisableSocks5     bool
 	DisableSS         bool
 
-	RateLimit   string
-	LocalIPS    []string
-	RawProxyURL string
-	Debug       bool
+	RateLimit        string // 每个连接限速字节/s, 上下行分别限速, 可设置为2m, 100k等数值,0表示不限速 default: 0
+	ServiceRateLimit string // 本服务所有连接共享的限速字节/s, 0表示不限速 default: 0
+	UserRateLimit    string // 每个认证用户或源IP共享的限速字节/s, 0表示不限速 default: 0
+	LocalIPS         []string
+	RawProxyURL      string
+	Debug            bool
+
+	// 本地连接的附加装饰链, 在压缩之后应用, 如 zstd(level=3),flow, 不支持 obfs, proxyproto, 见 connection.ParseUpperAdornChain, default: empty
+	LocalAdorns string
+	// 父级连接的附加装饰链, 在压缩之后应用, 格式同 LocalAdorns, default: empty
+	ParentAdorns string
 
 	// private
 	tcpTlsConfig cs.TLSConfig
-	rateLimit    rate.Limit
+	localAdorns  connection.AdornConnsChain
+	parentAdorns connection.AdornConnsChain
 }
 type SPS struct {
 	cfg                   Config
@@ -97,13 +136,15 @@ type SPS struct {
 	localCipher           *shadowsocks.Cipher
 	parentCipher          *shadowsocks.Cipher
 	udpRelatedPacketConns cmap.ConcurrentMap
+	ssUDPSessions         cmap.ConcurrentMap
 	lb                    *loadbalance.Balanced
 	udpLocalKey           []byte
 	udpParentKey          []byte
-	proxyURL              *url.URL
+	proxyURLs             []*url.URL
 	parentAuthData        *sync.Map
 	parentCipherData      *sync.Map
 	log                   logger.Logger
+	limiter               *ratelimit.Hierarchy
 }
 
 var _ services.Service = (*SPS)(nil)
@@ -114,6 +155,7 @@ func New(log logger.Logger, cfg Config) *SPS {
 		serverChannels:        make([]net.Listener, 0),
 		userConns:             cmap.New(),
 		udpRelatedPacketConns: cmap.New(),
+		ssUDPSessions:         cmap.New(),
 		parentAuthData:        &sync.Map{},
 		parentCipherData:      &sync.Map{},
 		log:                   log,
@@ -128,12 +170,25 @@ func (sf *SPS) InspectConfig() (err error) {
 		return fmt.Errorf("parent required for %s %s", sf.cfg.LocalType, sf.cfg.Local)
 	}
 	if sf.cfg.ParentType == "" {
-		return fmt.Errorf("parent type unkown,use -T <tls|tcp|kcp>")
+		return fmt.Errorf("parent type unkown,use -T <tls|tcp|stcp|kcp|quic|ws|wss>")
+	}
+	// ParentType ss 等同于 tcp 传输的 ss 父级服务
+	if sf.cfg.ParentType == "ss" {
+		sf.cfg.ParentType, sf.cfg.ParentServiceType = "tcp", "ss"
 	}
-	if sf.cfg.ParentType == "ss" && (sf.cfg.ParentSSKey == "" || sf.cfg.ParentSSMethod == "") {
-		return fmt.Errorf("ss parent need a ss key, set it by : -J <sskey>")
+	if sf.cfg.ParentServiceType == "ss" {
+		if sf.cfg.ParentSSKey == "" || sf.cfg.ParentSSMethod == "" {
+			return fmt.Errorf("ss parent need a ss key, set it by : -J <sskey>")
+		}
+		if !extstr.Contains(shadowsocks.CipherMethods(), sf.cfg.ParentSSMethod) {
+			return fmt.Errorf("parent ss method should be oneof <%s>", strings.Join(shadowsocks.CipherMethods(), ", "))
+		}
 	}
-	if sf.cfg.ParentType == "tls" || sf.cfg.LocalType == "tls" {
+	if !sf.cfg.DisableSS && sf.cfg.SSMethod != "" && !extstr.Contains(shadowsocks.CipherMethods(), sf.cfg.SSMethod) {
+		return fmt.Errorf("ss method should be oneof <%s>", strings.Join(shadowsocks.CipherMethods(), ", "))
+	}
+	if extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.ParentType) ||
+		extstr.Contains([]string{"tls", "quic", "wss"}, sf.cfg.LocalType) {
 		if !sf.cfg.ParentTLSSingle {
 			sf.cfg.tcpTlsConfig.Cert, sf.cfg.tcpTlsConfig.Key, err = extcert.LoadPair(sf.cfg.CertFile, sf.cfg.KeyFile)
 			if err != nil {
@@ -146,21 +201,33 @@ func (sf *SPS) InspectConfig() (err error) {
 				return fmt.Errorf("read ca file error,ERR:%s", err)
 			}
 		}
-	}
-	if sf.cfg.RateLimit != "0" && sf.cfg.RateLimit != "" {
-		size, err := meter.ParseBytes(sf.cfg.RateLimit)
-		if err != nil {
-			return fmt.Errorf("parse rate limit size, %s", err)
+		if err = sf.cfg.TLSOption.Apply(&sf.cfg.tcpTlsConfig); err != nil {
+			return fmt.Errorf("tls option, %+v", err)
 		}
-		sf.cfg.rateLimit = rate.Limit(size)
+	}
+	if err = ccs.ValidObfs(sf.cfg.LocalType, sf.cfg.LocalObfs); err != nil {
+		return fmt.Errorf("local obfs, %+v", err)
+	}
+	if err = ccs.ValidObfs(sf.cfg.ParentType, sf.cfg.ParentObfs); err != nil {
+		return fmt.Errorf("parent obfs, %+v", err)
+	}
+	if sf.cfg.localAdorns, err = connection.ParseUpperAdornChain(sf.cfg.LocalAdorns, true); err != nil {
+		return fmt.Errorf("local adorns, %v", err)
+	}
+	if sf.cfg.parentAdorns, err = connection.ParseUpperAdornChain(sf.cfg.ParentAdorns, false); err != nil {
+		return fmt.Errorf("parent adorns, %v", err)
+	}
+	sf.limiter, err = ratelimit.Build(sword.GlobalLimiter, sf.cfg.ServiceRateLimit, sf.cfg.UserRateLimit, sf.cfg.RateLimit)
+	if err != nil {
+		return err
 	}
 	sf.udpLocalKey = sf.LocalUDPKey()
 	sf.udpParentKey = sf.ParentUDPKey()
 	if sf.cfg.RawProxyURL != "" {
-		if sf.cfg.ParentType != "tls" && sf.cfg.ParentType != "tcp" {
-			return fmt.Errorf("proxyURL only worked of -T is tls or tcp")
+		if !extstr.Contains([]string{"tls", "tcp", "ws", "wss"}, sf.cfg.ParentType) {
+			return fmt.Errorf("proxyURL only worked of -T is tls, tcp, ws or wss")
 		}
-		sf.proxyURL, err = cs.ParseProxyURL(sf.cfg.RawProxyURL)
+		sf.proxyURLs, err = cs.ParseProxyChain(sf.cfg.RawProxyURL)
 		if err != nil {
 			return fmt.Errorf("new proxyURL, %s", err)
 		}
@@ -201,7 +268,9 @@ func (sf *SPS) InitService() (err error) {
 		configs := []loadbalance.Config{}
 
 		for _, addr := range sf.cfg.Parent {
-			var _addrInfo []string
+			var _addr string
+			var weight int
+
 			if strings.Contains(addr, "#") {
 				_s := addr[:strings.Index(addr, "#")]
 				b, err := base64.StdEncoding.DecodeString(_s)
@@ -210,9 +279,12 @@ func (sf *SPS) InitService() (err error) {
 					return err
 				}
 				_auth := string(b)
-				_addrInfo = strings.Split(addr[strings.Index(addr, "#")+1:], "@")
+				_addr, weight = loadbalance.SplitAddrWeight(addr[strings.Index(addr, "#")+1:])
 				if sf.cfg.ParentServiceType == "ss" {
-					_s := strings.Split(_auth, ":")
+					_s := strings.SplitN(_auth, ":", 2)
+					if len(_s) != 2 {
+						return fmt.Errorf("parent ss auth data [ %s ] should be method:key", _auth)
+					}
 					m := _s[0]
 					k := _s[1]
 					if m == "" {
@@ -226,20 +298,13 @@ func (sf *SPS) InitService() (err error) {
 						sf.log.Errorf("error generating cipher, ssMethod: %s, ssKey: %s, error : %s", m, k, err)
 						return err
 					}
-					sf.parentCipherData.Store(_addrInfo[0], cipher)
+					sf.parentCipherData.Store(_addr, cipher)
 				} else {
-					sf.parentAuthData.Store(_addrInfo[0], _auth)
+					sf.parentAuthData.Store(_addr, _auth)
 				}
 
 			} else {
-				_addrInfo = strings.Split(addr, "@")
-			}
-			_addr, weight := _addrInfo[0], 1
-			if len(_addrInfo) == 2 {
-				weight, _ = strconv.Atoi(_addrInfo[1])
-				if weight == 0 {
-					weight = 1
-				}
+				_addr, weight = loadbalance.SplitAddrWeight(addr)
 			}
 			configs = append(configs, loadbalance.Config{
 				Addr:             _addr,
@@ -255,6 +320,8 @@ func (sf *SPS) InitService() (err error) {
 			loadbalance.WithLogger(sf.log),
 			loadbalance.WithEnableDebug(sf.cfg.Debug),
 			loadbalance.WithGPool(sword.GoPool),
+			loadbalance.WithConnPool(sf.cfg.LbConfig.PoolConfig(), sf.dialParentConn),
+			loadbalance.WithConnAdorn(sf.adornParentConn),
 		)
 	}
 
@@ -290,12 +357,20 @@ func (sf *SPS) Start() (err error) {
 				Protocol: sf.cfg.LocalType,
 				Addr:     addr,
 				Config: ccs.Config{
-					TLSConfig:  sf.cfg.tcpTlsConfig,
-					StcpConfig: sf.cfg.STCPConfig,
-					KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
+					TLSConfig:            sf.cfg.tcpTlsConfig,
+					StcpConfig:           sf.cfg.STCPConfig,
+					KcpConfig:            sf.cfg.SKCPConfig.KcpConfig,
+					QuicConfig:           sf.cfg.QuicConfig,
+					WsConfig:             sf.cfg.WsConfig,
+					UnixConfig:           sf.cfg.UnixConfig,
+					ProxyProtocol:        sf.cfg.ProxyProtocol,
+					ProxyProtocolTrusted: sf.cfg.ProxyProtocolTrusted,
+					ReusePort:            sf.cfg.ReusePort,
+					FastOpen:             sf.cfg.FastOpen,
+					ObfsConfig:           cs.ObfsConfig{Mode: sf.cfg.LocalObfs},
 				},
 				GoPool:      sword.GoPool,
-				AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.LocalCompress)},
+				AdornChains: append(connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.LocalCompress)}, sf.cfg.localAdorns...),
 				Handler:     cs.HandlerFunc(sf.handle),
 			}
 
@@ -307,7 +382,9 @@ func (sf *SPS) Start() (err error) {
 			sword.Go(func() { srv.Server(sc) })
 
 			sf.serverChannels = append(sf.serverChannels, sc)
-			if sf.cfg.ParentServiceType == "socks" {
+			if enet.IsUnixAddr(addr) {
+				sf.log.Warnf("warn : udp not support on unix socket ")
+			} else if sf.cfg.ParentServiceType == "socks" {
 				err = sf.RunSSUDP(addr)
 			} else {
 				sf.log.Warnf("warn : udp only for socks parent ")
@@ -336,10 +413,14 @@ func (sf *SPS) Stop() {
 		sf.lb.Close()
 	}
 	for _, c := range sf.udpRelatedPacketConns.Items() {
-		c.(*net.UDPConn).Close()
+		c.(io.Closer).Close()
 	}
 	sf.log.Infof("service sps stopped")
 }
+
+// RateLimiter 分层限速器, 可在运行时修改速率
+func (sf *SPS) RateLimiter() *ratelimit.Hierarchy { return sf.limiter }
+
 func (sf *SPS) handle(inConn net.Conn) {
 	defer inConn.Close()
 
@@ -349,12 +430,12 @@ func (sf *SPS) handle(inConn net.Conn) {
 	var err error
 
 	switch sf.cfg.ParentType {
-	case "tcp", "stcp", "tls", "kcp":
+	case "tcp", "stcp", "tls", "kcp", "quic", "ws", "wss":
 		err = sf.proxyTCP(inConn)
 	default:
 		err = fmt.Errorf("unkown parent type %s", sf.cfg.ParentType)
 	}
-	if err != nil {
+	if err != nil && !binding.IsExpired(err) {
 		sf.log.Errorf("connect to %s fail, %s from %s", sf.cfg.ParentType, err, inConn.RemoteAddr())
 	}
 }
@@ -578,14 +659,21 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 		outConn.Write(forwardBytes)
 	}
 
-	if sf.cfg.rateLimit > 0 {
-		outConn = ciol.New(outConn, ciol.WithReadLimiter(sf.cfg.rateLimit))
-	}
-
 	//bind
 	inAddr := inConn.RemoteAddr().String()
 	outAddr := outConn.RemoteAddr().String()
 
+	outConn = sf.limiter.Conn(outConn, ratelimit.Key(auth.User, inAddr))
+
+	outConn, flow := sword.Tracker.Track(outConn, tracker.Info{
+		Service: "sps",
+		Source:  inAddr,
+		Target:  address,
+		Parent:  lbAddr,
+		User:    auth.User,
+	})
+	defer flow.Release()
+
 	sf.userConns.Upsert(inAddr, inConn, func(exist bool, valueInMap interface{}, newValue interface{}) interface{} {
 		if exist {
 			valueInMap.(net.Conn).Close()
@@ -596,11 +684,14 @@ func (sf *SPS) proxyTCP(inConn net.Conn) (err error) {
 	sf.log.Infof("conn %s - %s connected [%s]", inAddr, outAddr, address)
 
 	defer func() {
-		sf.log.Infof("conn %s - %s released [%s]", inAddr, outAddr, address)
+		sf.log.Infof("conn %s - %s released [%s], %s", inAddr, outAddr, address, binding.Reason(err))
+		outConn.Close()
 		sf.userConns.Remove(inAddr)
 		sf.lb.ConnsDecrease(lbAddr)
 	}()
-	return sword.Binding.Proxy(inConn, outConn)
+	return sword.Binding.Proxy(inConn, outConn,
+		binding.WithIdleTimeout(sf.cfg.IdleTimeout), binding.WithMaxLifetime(sf.cfg.MaxLifetime),
+		binding.WithHalfCloseTimeout(sf.cfg.HalfCloseTimeout))
 }
 
 func (sf *SPS) getParentAuth(lbAddr string) string {
@@ -656,7 +747,8 @@ func (sf *SPS) buildRequest(address string) (buf []byte, err error) {
 	return
 }
 
-func (sf *SPS) dialParent(address string) (net.Conn, error) {
+// dialParentConn 建立到父级的连接, 未压缩, 由负载均衡的预热连接池使用
+func (sf *SPS) dialParentConn(ctx context.Context, address string) (net.Conn, error) {
 	d := ccs.Dialer{
 		Protocol: sf.cfg.ParentType,
 		Timeout:  sf.cfg.Timeout,
@@ -664,19 +756,27 @@ func (sf *SPS) dialParent(address string) (net.Conn, error) {
 			TLSConfig:  sf.cfg.tcpTlsConfig,
 			StcpConfig: sf.cfg.STCPConfig,
 			KcpConfig:  sf.cfg.SKCPConfig.KcpConfig,
-			ProxyURL:   sf.proxyURL,
+			QuicConfig: sf.cfg.QuicConfig,
+			WsConfig:   sf.cfg.WsConfig,
+			ObfsConfig: cs.ObfsConfig{Mode: sf.cfg.ParentObfs, Host: sf.cfg.ParentObfsHost},
+			Resolver:   sf.domainResolver,
+			ProxyURLs:  sf.proxyURLs,
 		},
-		AdornChains: connection.AdornConnsChain{connection.AdornSnappy(sf.cfg.ParentCompress)},
-	}
-	conn, err := d.Dial("tcp", address)
-	if err != nil {
-		return nil, err
 	}
+	return d.DialContext(ctx, "tcp", address)
+}
 
+// adornParentConn 装饰到父级的连接, 由负载均衡在预热时或连接后调用
+func (sf *SPS) adornParentConn(conn net.Conn) net.Conn {
+	conn = sf.cfg.parentAdorns.Adorn(connection.AdornSnappy(sf.cfg.ParentCompress)(conn))
 	if sf.cfg.ParentKey != "" {
 		conn = ccrypt.New(conn, ccrypt.Config{Password: sf.cfg.ParentKey})
 	}
-	return conn, nil
+	return conn
+}
+
+func (sf *SPS) dialParent(address string) (net.Conn, error) {
+	return sf.lb.Dial(context.Background(), address)
 }
 func (sf *SPS) HandshakeSocksParent(parentAuth string, outconn net.Conn, network, dstAddr string, auth proxy.Auth, fromSS bool) (client *socks5.Client, err error) {
 	var realAuth *proxy.Auth
@@ -11,163 +11,179 @@ import (
 
 	"github.com/things-go/x/extnet"
 
+	"github.com/thinkgos/jocasta/connection/shadowsocks"
 	"github.com/thinkgos/jocasta/core/socks5"
+	"github.com/thinkgos/jocasta/cs"
+	"github.com/thinkgos/jocasta/pkg/bpool"
 	"github.com/thinkgos/jocasta/pkg/outil"
 	"github.com/thinkgos/jocasta/pkg/sword"
 )
 
 func (sf *SPS) RunSSUDP(addr string) (err error) {
-	a, _ := net.ResolveUDPAddr("udp", addr)
-	listener, err := net.ListenUDP("udp", a)
+	srv, err := cs.ListenUDP("udp", addr, cs.UDPHandlerFunc(sf.handleSSUDP),
+		cs.WithUDPBufferPool(bpool.NewPool(sword.BindingSize)))
 	if err != nil {
 		sf.log.Errorf("ss udp bind error %s", err)
 		return
 	}
-	sf.log.Infof("ss udp on %s", listener.LocalAddr())
-	sf.udpRelatedPacketConns.Set(addr, listener)
-	go func() {
-		defer func() {
-			if e := recover(); e != nil {
-				sf.log.DPanicf("udp local->out io copy crashed:\n%s\n%s", e, string(debug.Stack()))
+	sf.log.Infof("ss udp on %s", srv.LocalAddr())
+	sf.udpRelatedPacketConns.Set(addr, srv)
+	return
+}
+
+func (sf *SPS) handleSSUDP(listener *net.UDPConn, msg cs.Message) {
+	srcAddr := msg.SrcAddr
+	inconnRemoteAddr := srcAddr.String()
+
+	// 每个源地址一个会话, 2022方法需要会话记录双方的会话ID并检查重放
+	var session *shadowsocks.PacketSession
+	var err error
+	s, hasSession := sf.ssUDPSessions.Get(inconnRemoteAddr)
+	if hasSession {
+		session = s.(*shadowsocks.PacketSession)
+	} else if session, err = sf.localCipher.NewPacketSession(false); err != nil {
+		return
+	}
+	data, err := session.Unpack(msg.Data)
+	if err != nil {
+		return
+	}
+	if !hasSession {
+		sf.ssUDPSessions.Set(inconnRemoteAddr, session)
+	}
+	raw := bytes.NewBuffer([]byte{0x00, 0x00, 0x00})
+	raw.Write(data)
+	socksPacket := socks5.NewPacketUDP()
+	err = socksPacket.Parse(raw.Bytes())
+	raw = nil
+	if err != nil {
+		sf.log.Errorf("udp parse error %s", err)
+		return
+	}
+
+	// 连接上级并握手可能阻塞, 由会话异步建立
+	v, _ := sf.udpRelatedPacketConns.Get(inconnRemoteAddr)
+	relay, ok := v.(*cs.UDPSession)
+	if !ok || relay.IsClosed() {
+		relay = sf.newSSUDPRelay(listener, srcAddr, session, socksPacket.Addr())
+		sf.udpRelatedPacketConns.Set(inconnRemoteAddr, relay)
+	}
+	//forward to parent
+	//p is raw, now convert it to parent
+	var out []byte
+	if len(sf.udpParentKey) > 0 {
+		out, _ = outil.EncryptCFB(sf.udpParentKey, socksPacket.Bytes())
+	} else {
+		out = socksPacket.Bytes()
+	}
+	if !relay.Put(out) {
+		sf.log.Warnf("udp relay queue full or closed, drop packet from : %s", srcAddr)
+	}
+}
+
+// newSSUDPRelay 经由上级socks5的udp中继会话
+func (sf *SPS) newSSUDPRelay(listener *net.UDPConn, srcAddr *net.UDPAddr, session *shadowsocks.PacketSession, target string) *cs.UDPSession {
+	var (
+		inconnRemoteAddr = srcAddr.String()
+		outUDPConn       *net.UDPConn
+		outconn          net.Conn
+		outconnLocalAddr string
+		clean            = func(msg, err string) {
+			raddr := ""
+			if outUDPConn != nil {
+				raddr = outUDPConn.RemoteAddr().String()
+				outUDPConn.Close()
 			}
-		}()
-		buf := sword.Binding.Get()
-		defer sword.Binding.Put(buf)
-		for {
-			n, srcAddr, err := listener.ReadFrom(buf[:cap(buf)])
-			if err != nil {
-				sf.log.Errorf("read from client error %s", err)
-				if extnet.IsErrClosed(err) {
-					return
+			if msg != "" {
+				if raddr != "" {
+					sf.log.Errorf("%s , %s , %s -> %s", msg, err, inconnRemoteAddr, raddr)
+				} else {
+					sf.log.Infof("%s , %s , from : %s", msg, err, inconnRemoteAddr)
 				}
-				continue
 			}
-			var (
-				inconnRemoteAddr = srcAddr.String()
-				outUDPConn       *net.UDPConn
-				outconn          net.Conn
-				outconnLocalAddr string
-				destAddr         *net.UDPAddr
-				clean            = func(msg, err string) {
-					raddr := ""
-					if outUDPConn != nil {
-						raddr = outUDPConn.RemoteAddr().String()
-						outUDPConn.Close()
-					}
-					if msg != "" {
-						if raddr != "" {
-							sf.log.Errorf("%s , %s , %s -> %s", msg, err, inconnRemoteAddr, raddr)
-						} else {
-							sf.log.Infof("%s , %s , from : %s", msg, err, inconnRemoteAddr)
-						}
-					}
-					sf.userConns.Remove(inconnRemoteAddr)
-					if outconn != nil {
-						outconn.Close()
-					}
-					if outconnLocalAddr != "" {
-						sf.userConns.Remove(outconnLocalAddr)
-					}
-				}
-			)
-			defer clean("", "")
-
-			var data []byte
-
-			data, err = sf.localCipher.Decrypt(buf[:n])
-			if err != nil {
-				return
+			sf.userConns.Remove(inconnRemoteAddr)
+			sf.ssUDPSessions.Remove(inconnRemoteAddr)
+			if outconn != nil {
+				outconn.Close()
 			}
-			raw := bytes.NewBuffer([]byte{0x00, 0x00, 0x00})
-			raw.Write(data)
-			socksPacket := socks5.NewPacketUDP()
-			err = socksPacket.Parse(raw.Bytes())
-			raw = nil
-			if err != nil {
-				sf.log.Errorf("udp parse error %s", err)
-				return
+			if outconnLocalAddr != "" {
+				sf.userConns.Remove(outconnLocalAddr)
 			}
+		}
+	)
 
-			if v, ok := sf.udpRelatedPacketConns.Get(inconnRemoteAddr); !ok {
-				//socks client
-				lbAddr := sf.lb.Select(inconnRemoteAddr)
-				outconn, err := sf.dialParent(lbAddr)
-				if err != nil {
-					clean("connnect fail", fmt.Sprintf("%s", err))
-					return
-				}
+	return cs.NewUDPSession(0, func(relay *cs.UDPSession) (net.Conn, error) {
+		//socks client
+		lbAddr := sf.lb.Select(inconnRemoteAddr)
+		conn, err := sf.dialParent(lbAddr)
+		if err != nil {
+			clean("connnect fail", fmt.Sprintf("%s", err))
+			return nil, err
+		}
+		outconn = conn
 
-				client, err := sf.HandshakeSocksParent(sf.getParentAuth(lbAddr), outconn, "udp", socksPacket.Addr(), proxy.Auth{}, true)
-				if err != nil {
-					clean("handshake fail", fmt.Sprintf("%s", err))
-					return
-				}
+		client, err := sf.HandshakeSocksParent(sf.getParentAuth(lbAddr), outconn, "udp", target, proxy.Auth{}, true)
+		if err != nil {
+			clean("handshake fail", fmt.Sprintf("%s", err))
+			return nil, err
+		}
 
-				outconnLocalAddr = outconn.LocalAddr().String()
-				sf.userConns.Set(outconnLocalAddr, &outconn)
-				go func() {
-					defer func() {
-						if e := recover(); e != nil {
-							sf.log.DPanicf("udp related parent tcp conn read crashed:\n%s\n%s", e, string(debug.Stack()))
-						}
-					}()
-					buf := make([]byte, 1)
-					outconn.SetReadDeadline(time.Time{})
-					if _, err := outconn.Read(buf); err != nil {
-						clean("udp parent tcp conn disconnected", fmt.Sprintf("%s", err))
-					}
-				}()
-				destAddr, _ = net.ResolveUDPAddr("udp", client.UDPAddr)
-				localZeroAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
-				outUDPConn, err = net.DialUDP("udp", localZeroAddr, destAddr)
-				if err != nil {
-					sf.log.Errorf("create out udp conn fail , %s , from : %s", err, srcAddr)
-					return
+		outconnLocalAddr = outconn.LocalAddr().String()
+		sf.userConns.Set(outconnLocalAddr, &outconn)
+		destAddr, _ := net.ResolveUDPAddr("udp", client.UDPAddr)
+		localZeroAddr := &net.UDPAddr{IP: net.IPv4zero, Port: 0}
+		outUDPConn, err = net.DialUDP("udp", localZeroAddr, destAddr)
+		if err != nil {
+			clean("create out udp conn fail", fmt.Sprintf("%s", err))
+			return nil, err
+		}
+		go func() {
+			defer func() {
+				if e := recover(); e != nil {
+					sf.log.DPanicf("udp related parent tcp conn read crashed:\n%s\n%s", e, string(debug.Stack()))
 				}
-				sf.udpRelatedPacketConns.Set(srcAddr.String(), outUDPConn)
-				sword.Go(func() {
-					defer func() {
-						sf.udpRelatedPacketConns.Remove(srcAddr.String())
-					}()
-					sword.Binding.RunUDPCopy(listener, outUDPConn, srcAddr, time.Second*5, func(data []byte) []byte {
-						//forward to local
-						var v []byte
-						//convert parent data to raw
-						if len(sf.udpParentKey) > 0 {
-							v, err = outil.DecryptCFB(sf.udpParentKey, data)
-							if err != nil {
-								sf.log.Errorf("udp outconn parse packet fail, %s", err.Error())
-								return []byte{}
-							}
-						} else {
-							v = data
-						}
-						out, _ := sf.localCipher.Encrypt(v[3:])
-						return out
-
-					})
-				})
-
-			} else {
-				outUDPConn = v.(*net.UDPConn)
-			}
-			//forward to parent
-			//p is raw, now convert it to parent
-			var v []byte
-			if len(sf.udpParentKey) > 0 {
-				v, _ = outil.EncryptCFB(sf.udpParentKey, socksPacket.Bytes())
-			} else {
-				v = socksPacket.Bytes()
+			}()
+			buf := make([]byte, 1)
+			outconn.SetReadDeadline(time.Time{})
+			if _, err := outconn.Read(buf); err != nil {
+				clean("udp parent tcp conn disconnected", fmt.Sprintf("%s", err))
 			}
-			_, err = outUDPConn.Write(v)
-			socksPacket = socks5.PacketUDP{}
-			if err != nil {
-				if extnet.IsErrClosed(err) {
-					return
+		}()
+		sword.Go(func() {
+			defer func() {
+				relay.Close()
+				sf.udpRelatedPacketConns.RemoveCb(inconnRemoteAddr, func(_ string, v interface{}, exists bool) bool {
+					return exists && v == relay
+				})
+				sf.ssUDPSessions.Remove(inconnRemoteAddr)
+			}()
+			sword.Binding.RunUDPCopy(listener, outUDPConn, srcAddr, time.Second*5, func(data []byte) []byte {
+				//forward to local
+				var v []byte
+				var err error
+				//convert parent data to raw
+				if len(sf.udpParentKey) > 0 {
+					v, err = outil.DecryptCFB(sf.udpParentKey, data)
+					if err != nil {
+						sf.log.Errorf("udp outconn parse packet fail, %s", err.Error())
+						return []byte{}
+					}
+				} else {
+					v = data
 				}
-				sf.log.Errorf("send out udp data fail , %s , from : %s", err, srcAddr)
-			}
+				out, err := session.Pack(v[3:])
+				if err != nil {
+					sf.log.Errorf("udp pack packet fail, %s", err.Error())
+					return []byte{}
+				}
+				return out
+			})
+		})
+		return outUDPConn, nil
+	}, func(conn net.Conn, data []byte) error {
+		if _, err := conn.Write(data); err != nil && !extnet.IsErrClosed(err) {
+			sf.log.Errorf("send out udp data fail , %s , from : %s", err, srcAddr)
 		}
-	}()
-	return
+		return nil
+	})
 }
@@ -6,13 +6,11 @@ import (
 	"io/ioutil"
 	"net"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/things-go/encrypt"
 	"github.com/things-go/x/extnet"
 	"github.com/things-go/x/extstr"
-	"golang.org/x/sync/singleflight"
 
 	"github.com/thinkgos/jocasta/connection"
 	"github.com/thinkgos/jocasta/core/captain"
@@ -41,6 +39,8 @@ type Config struct {
 	CertFile   string // cert文件 default: proxy.crt
 	KeyFile    string // key文件 default: proxy.key
 	CaCertFile string // ca文件 default: empty
+	// tls扩展配置, tls,quic,wss有效
+	TLSOption ccs.TLSOption
 	// kcp有效
 	SKCPConfig *ccs.SKCPConfig
 	// stcp有效
@@ -54,20 +54,19 @@ type Config struct {
 }
 
 type connItem struct {
-	targetConn     net.Conn
-	srcAddr        *net.UDPAddr
-	lastActiveTime int64
+	session *cs.UDPSession
+	srcAddr *net.UDPAddr
 }
 
 type UDP struct {
-	cfg     Config
-	udpConn *net.UDPConn
+	cfg       Config
+	udpServer *cs.UDPServer
+	udpConn   *net.UDPConn
 	// parent type = "udp", udp -> udp绑定传输
 	// src地址对udp连接映射
 	// parent type != "udp", udp -> 其它的绑定传输
 	// src地址对其它连接的绑定
 	conns       *connection.Manager
-	single      singleflight.Group
 	dnsResolver *idns.Resolver
 	cancel      context.CancelFunc
 	ctx         context.Context
@@ -88,15 +87,9 @@ func New(cfg Config, opts ...Option) *UDP {
 		opt(u)
 	}
 
-	u.conns = connection.New(time.Second,
-		func(key string, value interface{}, now time.Time) bool {
-			nowSeconds := now.Unix()
-			item := value.(*connItem)
-			if nowSeconds-atomic.LoadInt64(&item.lastActiveTime) > u.udpIdleTime {
-				item.targetConn.Close()
-				return true
-			}
-			return false
+	u.conns = connection.NewExpiry(time.Second, time.Duration(u.udpIdleTime)*time.Second,
+		func(key string, value interface{}) {
+			value.(*connItem).session.Close()
 		})
 	return u
 }
@@ -116,10 +109,14 @@ func (sf *UDP) inspectConfig() (err error) {
 				return fmt.Errorf("read ca file %+v", err)
 			}
 		}
+		if err = sf.cfg.TLSOption.Apply(&sf.cfg.tcpTlsConfig); err != nil {
+			return fmt.Errorf("tls option, %+v", err)
+		}
 	}
 
 	// stcp 方法检查
-	if sf.cfg.ParentType == "stcp" && !extstr.Contains(encrypt.CipherMethods(), sf.cfg.STCPConfig.Method) {
+	if sf.cfg.ParentType == "stcp" && !sf.cfg.STCPConfig.ForwardSecret &&
+		!extstr.Contains(encrypt.CipherMethods(), sf.cfg.STCPConfig.Method) {
 		return fmt.Errorf("stcp cipher method support one of %s", strings.Join(encrypt.CipherMethods(), ","))
 	}
 	return
@@ -135,34 +132,11 @@ func (sf *UDP) Start() (err error) {
 	if err = sf.inspectConfig(); err != nil {
 		return
 	}
-	addr, err := net.ResolveUDPAddr("udp", sf.cfg.Local)
+	sf.udpServer, err = cs.ListenUDP("udp", sf.cfg.Local, cs.UDPHandlerFunc(sf.handle), cs.WithUDPLogger(sf.log))
 	if err != nil {
 		return err
 	}
-	sf.udpConn, err = net.ListenUDP("udp", addr)
-	if err != nil {
-		return err
-	}
-	sword.Go(
-		func() {
-			defer sf.udpConn.Close()
-			for {
-				buf := make([]byte, 2048)
-				n, srcAddr, err := sf.udpConn.ReadFromUDP(buf)
-				if err != nil {
-					return
-				}
-				data := buf[0:n]
-				sword.Go(func() {
-					sf.handle(sf.udpConn, cs.Message{
-						LocalAddr: addr,
-						SrcAddr:   srcAddr,
-						Data:      data,
-					})
-				})
-			}
-		},
-	)
+	sf.udpConn = sf.udpServer.Conn()
 
 	sword.Go(func() { sf.conns.Watch(sf.ctx) })
 	sf.log.Infof("[ UDP ] use parent %s< %s >", sf.cfg.Parent, sf.cfg.ParentType)
@@ -174,11 +148,13 @@ func (sf *UDP) Stop() {
 	if sf.cancel != nil {
 		sf.cancel()
 	}
-	if sf.udpConn != nil {
-		sf.udpConn.Close()
+	if sf.udpServer != nil {
+		sf.udpServer.Close()
+		stats := sf.udpServer.Stats()
+		sf.log.Infof("[ UDP ] received %d packets, dropped %d packets", stats.Packets, stats.Drops)
 	}
 	for _, c := range sf.conns.Items() {
-		c.(*connItem).targetConn.Close()
+		c.(*connItem).session.Close()
 	}
 	sf.log.Infof("[ UDP ] service stopped")
 }
@@ -197,143 +173,137 @@ func (sf *UDP) handle(ln *net.UDPConn, msg cs.Message) {
 func (sf *UDP) proxyUdp2Stream(_ *net.UDPConn, msg cs.Message) {
 	srcAddr := msg.SrcAddr.String()
 
-	itm, err, _ := sf.single.Do(srcAddr, func() (interface{}, error) {
-		if v, ok := sf.conns.Get(srcAddr); ok {
-			return v, nil
-		}
-
-		targetConn, err := sf.dialParent(outil.Resolve(sf.dnsResolver, sf.cfg.Parent))
-		if err != nil {
-			sf.log.Errorf("[ UDP ] connect to stream parent< %s > fail, %s", sf.cfg.Parent, err)
-			return nil, err
-		}
-		item := &connItem{
-			targetConn,
-			msg.SrcAddr,
-			time.Now().Unix(),
-		}
-		sf.conns.Set(srcAddr, item)
-		// src ---> parent
-		sword.Go(func() {
-			sf.log.Infof("[ UDP ] udp conn %s ---> stream %s  connected", srcAddr, targetConn.RemoteAddr().String())
-			defer func() {
+	v, ok := sf.conns.Get(srcAddr)
+	if !ok || v.(*connItem).session.IsClosed() {
+		item := &connItem{srcAddr: msg.SrcAddr}
+		item.session = cs.NewUDPSession(0, func(sess *cs.UDPSession) (net.Conn, error) {
+			targetConn, err := sf.dialParent(outil.Resolve(sf.dnsResolver, sf.cfg.Parent))
+			if err != nil {
 				sf.conns.Remove(srcAddr)
-				item.targetConn.Close()
-				sf.log.Infof("[ UDP ] udp conn %s ---> stream %s released", srcAddr, targetConn.RemoteAddr().String())
-			}()
+				sf.log.Errorf("[ UDP ] connect to stream parent< %s > fail, %s", sf.cfg.Parent, err)
+				return nil, err
+			}
+			// parent ---> src
+			sword.Go(func() {
+				sf.log.Infof("[ UDP ] udp conn %s ---> stream %s  connected", srcAddr, targetConn.RemoteAddr().String())
+				defer func() {
+					sf.conns.Remove(srcAddr)
+					sess.Close()
+					sf.log.Infof("[ UDP ] udp conn %s ---> stream %s released", srcAddr, targetConn.RemoteAddr().String())
+				}()
 
-			for {
-				da, err := captain.ParseStreamDatagram(item.targetConn)
-				if err != nil {
-					sf.log.Errorf("[ UDP ] udp conn read from stream parent conn fail, %s ", err)
-					if strings.Contains(err.Error(), "n != int(") {
-						continue
+				for {
+					da, err := captain.ParseStreamDatagram(targetConn)
+					if err != nil {
+						sf.log.Errorf("[ UDP ] udp conn read from stream parent conn fail, %s ", err)
+						if strings.Contains(err.Error(), "n != int(") {
+							continue
+						}
+						return
+					}
+					sf.conns.Touch(srcAddr)
+					_, err = sf.udpConn.WriteToUDP(da.Data, item.srcAddr)
+					if err != nil {
+						sf.log.Errorf("[ UDP ] udp conn write to local conn fail, %s ", err)
 					}
-					return
 				}
-				atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-				_, err = sf.udpConn.WriteToUDP(da.Data, item.srcAddr)
+			})
+			return targetConn, nil
+		}, func(targetConn net.Conn, data []byte) error {
+			// src ---> parent
+			err := enet.WrapWriteTimeout(targetConn, sf.cfg.Timeout, func(c net.Conn) error {
+				as, err := captain.ParseAddrSpec(srcAddr)
 				if err != nil {
-					sf.log.Errorf("[ UDP ] udp conn write to local conn fail, %s ", err)
+					return err
+				}
+				sData := captain.StreamDatagram{
+					Addr: as,
+					Data: data,
 				}
+				header, err := sData.Header()
+				if err != nil {
+					return err
+				}
+				buf := sword.Binding.Get()
+				defer sword.Binding.Put(buf)
+				tmpBuf := append(buf, header...)
+				tmpBuf = append(tmpBuf, sData.Data...)
+				c.Write(tmpBuf) // nolint: errcheck
+				return nil
+			})
+			if err != nil {
+				sf.log.Errorf("[ UDP ] udp conn write to stream parent conn fail, %s ", err)
 			}
+			return nil
 		})
-
-		return item, nil
-	})
-	if err != nil {
-		return
+		sf.conns.Set(srcAddr, item)
+		v = item
 	}
-
-	// parent ---> src
-	item := itm.(*connItem)
-	atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-	err = enet.WrapWriteTimeout(item.targetConn, sf.cfg.Timeout, func(c net.Conn) error {
-		as, err := captain.ParseAddrSpec(srcAddr)
-		if err != nil {
-			return err
-		}
-		sData := captain.StreamDatagram{
-			Addr: as,
-			Data: msg.Data,
-		}
-		header, err := sData.Header()
-		if err != nil {
-			return err
-		}
-		buf := sword.Binding.Get()
-		defer sword.Binding.Put(buf)
-		tmpBuf := append(buf, header...)
-		tmpBuf = append(tmpBuf, sData.Data...)
-		c.Write(tmpBuf) // nolint: errcheck
-		return nil
-	})
-	if err != nil {
-		sf.log.Errorf("[ UDP ] udp conn write to stream parent conn fail, %s ", err)
+	sf.conns.Touch(srcAddr)
+	if !v.(*connItem).session.Put(msg.Data) {
+		sf.log.Warnf("[ UDP ] udp conn %s queue full or closed, drop packet", srcAddr)
 	}
 }
 
 func (sf *UDP) proxyUdp2Udp(_ *net.UDPConn, msg cs.Message) {
 	srcAddr := msg.SrcAddr.String()
 
-	itm, err, _ := sf.single.Do(srcAddr, func() (interface{}, error) {
-		if v, ok := sf.conns.Get(srcAddr); ok {
-			return v, nil
-		}
-
-		targetAddr, err := net.ResolveUDPAddr("udp", sf.cfg.Parent)
-		if err != nil {
-			sf.log.Errorf("[ UDP ] resolve udp parent addr< %s > fail, %+v", sf.cfg.Parent, err)
-			return nil, err
-		}
-		targetConn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, targetAddr)
-		if err != nil {
-			sf.log.Errorf("[ UDP ] connect to udp parent addr< %s > fail, %+v", targetAddr, err)
-			return nil, err
-		}
-		item := &connItem{
-			targetConn,
-			msg.SrcAddr,
-			time.Now().Unix(),
-		}
-		sf.conns.Set(srcAddr, item)
-		// parent ---> src
-		sword.Go(func() {
-			sf.log.Infof("[ UDP ] udp conn %s ---> %s connected", srcAddr, targetAddr.String())
-			buf := sword.Binding.Get()
-			defer func() {
-				sword.Binding.Put(buf)
+	v, ok := sf.conns.Get(srcAddr)
+	if !ok || v.(*connItem).session.IsClosed() {
+		item := &connItem{srcAddr: msg.SrcAddr}
+		item.session = cs.NewUDPSession(0, func(sess *cs.UDPSession) (net.Conn, error) {
+			targetAddr, err := net.ResolveUDPAddr("udp", sf.cfg.Parent)
+			if err != nil {
 				sf.conns.Remove(srcAddr)
-				item.targetConn.Close()
-				sf.log.Infof("[ UDP ] udp conn %s ---> %s released", srcAddr, targetAddr.String())
-			}()
-			for {
-				n, err := item.targetConn.Read(buf[:cap(buf)])
-				if err != nil {
-					if !extnet.IsErrClosed(err) {
-						sf.log.Warnf("[ UDP ] udp conn read from parent conn fail, %s ", err)
+				sf.log.Errorf("[ UDP ] resolve udp parent addr< %s > fail, %+v", sf.cfg.Parent, err)
+				return nil, err
+			}
+			targetConn, err := net.DialUDP("udp", &net.UDPAddr{IP: net.IPv4zero, Port: 0}, targetAddr)
+			if err != nil {
+				sf.conns.Remove(srcAddr)
+				sf.log.Errorf("[ UDP ] connect to udp parent addr< %s > fail, %+v", targetAddr, err)
+				return nil, err
+			}
+			// parent ---> src
+			sword.Go(func() {
+				sf.log.Infof("[ UDP ] udp conn %s ---> %s connected", srcAddr, targetAddr.String())
+				buf := sword.Binding.Get()
+				defer func() {
+					sword.Binding.Put(buf)
+					sf.conns.Remove(srcAddr)
+					sess.Close()
+					sf.log.Infof("[ UDP ] udp conn %s ---> %s released", srcAddr, targetAddr.String())
+				}()
+				for {
+					n, err := targetConn.Read(buf[:cap(buf)])
+					if err != nil {
+						if !extnet.IsErrClosed(err) {
+							sf.log.Warnf("[ UDP ] udp conn read from parent conn fail, %s ", err)
+						}
+						return
+					}
+					sf.conns.Touch(srcAddr)
+					_, err = sf.udpConn.WriteToUDP(buf[:n], item.srcAddr)
+					if err != nil {
+						sf.log.Warnf("[ UDP ] udp conn write to local conn fail, %s ", err)
+						return
 					}
-					return
-				}
-				atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-				_, err = sf.udpConn.WriteToUDP(buf[:n], item.srcAddr)
-				if err != nil {
-					sf.log.Warnf("[ UDP ] udp conn write to local conn fail, %s ", err)
-					return
 				}
+			})
+			return targetConn, nil
+		}, func(targetConn net.Conn, data []byte) error {
+			// src ---> parent
+			if _, err := targetConn.Write(data); err != nil {
+				sf.log.Warnf("[ UDP ] udp conn write to parent conn fail, %s ", err)
 			}
+			return nil
 		})
-		return item, nil
-	})
-	if err != nil {
-		return
+		sf.conns.Set(srcAddr, item)
+		v = item
 	}
-	// src ---> parent
-	item := itm.(*connItem)
-	atomic.StoreInt64(&item.lastActiveTime, time.Now().Unix())
-	_, err = item.targetConn.Write(msg.Data)
-	if err != nil {
-		sf.log.Warnf("[ UDP ] udp conn write to parent conn fail, %s ", err)
+	sf.conns.Touch(srcAddr)
+	if !v.(*connItem).session.Put(msg.Data) {
+		sf.log.Warnf("[ UDP ] udp conn %s queue full or closed, drop packet", srcAddr)
 	}
 }
 